@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/access"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/store"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+// pipeline decides the swipes and PINs of the main loop. Set up in main
+var pipeline *access.Pipeline
+
+// newPipeline wires the access decisions up with the flags, the policies
+// and the doors. The policies are read on every swipe, as a reload may
+// replace them
+func newPipeline(creds store.CredentialStore) *access.Pipeline {
+	p := &access.Pipeline{
+		Store:          creds,
+		StoreKind:      storeKind(),
+		LookupTimeout:  *lookupTimeout,
+		Clock:          clk,
+		Lockout:        lockout,
+		Lockdowns:      lockdowns,
+		Alarm:          alarmInterlock,
+		Debounce:       *debounce,
+		DoubleSwipe:    *doubleSwipe,
+		Keypad:         *keypadSpec != "",
+		PINTimeout:     *pinTimeout,
+		CommandTimeout: *commandTimeout,
+		Go: func(f func()) {
+			go supervisor.Run("door", f)
+		},
+		Permit: func(cred store.Credential, action string) error {
+			return permissions.Check(cred, action)
+		},
+		Policy: func(d access.Door, cred store.Credential, event events.Access, logger *slog.Logger) error {
+			err := failPolicy.Check(cred, d.Status())
+			if err == nil {
+				err = lockdowns.Check(cred, d.Name())
+			}
+			if err == nil {
+				err = checkPassback(event, logger)
+			}
+			if err == nil {
+				err = checkAccessHook(cred, event, logger)
+			}
+			return err
+		},
+		Action: func(d access.Door, cred store.Credential, t time.Time) string {
+			return swipeAction(d.(*door), cred, t)
+		},
+		Enroll: func(d access.Door, token, reader string) bool {
+			return enroller.offer(d.(*door), token, reader)
+		},
+		Record:        recordAccess,
+		RecordLatency: recordLatency,
+	}
+	if codes != nil {
+		p.Code = handleCodeKey
+	}
+	return p
+}
+
+func (d *door) Name() string {
+	return d.name
+}
+
+// Unlock opens d for the access pipeline. Swipes go ahead of the remote
+// commands in the door's queue, and the exit button bypasses it, so remote
+// commands never keep anyone out or in
+func (d *door) Unlock(ctx context.Context, action string) error {
+	if action != "exit" {
+		return d.runLocal(ctx, action)
+	}
+	if err := d.Open(ctx); err != nil {
+		return err
+	}
+	d.autoLock.Arm()
+	return nil
+}
+
+// Lock closes d for a double swipe, ahead of the remote commands
+func (d *door) Lock(ctx context.Context) error {
+	return d.runLocal(ctx, "close")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/alarm"
+	"github.com/craftamap/wishbone/internal/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	alarmInputPin       = flag.Int("alarm-input", -1, "BCM number of the GPIO reading whether the burglar alarm is armed, e.g. from a relay output of its panel (not wired if -1)")
+	alarmInputActiveLow = flag.Bool("alarm-input-active-low", false, "read the alarm as armed while alarm-input is low")
+	alarmInputDebounce  = flag.Duration("alarm-input-debounce", 200*time.Millisecond, "how long alarm-input must read the same before a change is taken")
+	alarmStateTopic     = flag.String("alarm-state-topic", "", "MQTT topic the burglar alarm publishes its state to, armed or disarmed (not read if empty)")
+	alarmDisarmTopic    = flag.String("alarm-disarm-topic", "", "MQTT topic to ask the armed alarm to disarm on before unlocking (unlocking while armed is refused if empty)")
+	alarmDisarmPayload  = flag.String("alarm-disarm-payload", "DISARM", "message published to -alarm-disarm-topic")
+	alarmDisarmTimeout  = flag.Duration("alarm-disarm-timeout", 15*time.Second, "how long to wait for the alarm to disarm before refusing to unlock")
+
+	// alarmInterlock is nil unless the alarm state is read. Set up in main
+	alarmInterlock *alarm.Interlock
+	// alarmInput is nil unless -alarm-input is set
+	alarmInput *alarm.Input
+)
+
+// alarmEnabled reports whether the alarm state is read
+func alarmEnabled() bool {
+	return *alarmInputPin >= 0 || *alarmStateTopic != ""
+}
+
+// validateAlarm checks the alarm flags
+func validateAlarm() error {
+	switch {
+	case *alarmInputPin >= 0 && *alarmStateTopic != "":
+		return errors.New("alarm-input and alarm-state-topic are exclusive")
+	case (*alarmStateTopic != "" || *alarmDisarmTopic != "") && *mqttBroker == "":
+		return errors.New("alarm-state-topic and alarm-disarm-topic require mqtt-broker")
+	case *alarmDisarmTopic != "" && !alarmEnabled():
+		return errors.New("alarm-disarm-topic requires alarm-input or alarm-state-topic")
+	case *alarmInputDebounce < 0 || *alarmDisarmTimeout <= 0:
+		return errors.New("alarm-input-debounce must not be negative and alarm-disarm-timeout must be positive")
+	}
+	return nil
+}
+
+// newAlarmInterlock sets up the interlock, and the GPIO input unless
+// simulating. Changes of the state are logged
+func newAlarmInterlock() *alarm.Interlock {
+	if !alarmEnabled() {
+		return nil
+	}
+	i := &alarm.Interlock{Timeout: *alarmDisarmTimeout}
+	i.OnChange(func(s alarm.State) {
+		slog.Info("Alarm state changed", "state", s.String())
+	})
+	if *alarmInputPin >= 0 && !*simulate {
+		alarmInput = &alarm.Input{Pin: *alarmInputPin, ActiveLow: *alarmInputActiveLow, Debounce: *alarmInputDebounce, Interlock: i}
+	}
+	return i
+}
+
+// subscribeAlarm reads the alarm state from -alarm-state-topic. Called on
+// every connect, as the broker may have dropped the subscription
+func subscribeAlarm(client mqtt.Client) {
+	if *alarmStateTopic == "" {
+		return
+	}
+	token := client.Subscribe(*alarmStateTopic, 1, func(client mqtt.Client, msg mqtt.Message) {
+		s, err := alarm.ParseState(string(msg.Payload()))
+		if err != nil {
+			slog.Warn("Ignoring alarm state", "topic", *alarmStateTopic, "err", err)
+			return
+		}
+		alarmInterlock.Set(s)
+	})
+	if token.Wait() && token.Error() != nil {
+		slog.Warn("Could not subscribe to MQTT", "topic", *alarmStateTopic, "err", token.Error())
+	}
+}
+
+// disarmOverMQTT lets the interlock ask the alarm to disarm on
+// -alarm-disarm-topic. It is asked from the main loop, so the broker isn't
+// waited for; if the message is lost, the unlock times out
+func disarmOverMQTT(client mqtt.Client) {
+	if *alarmDisarmTopic == "" {
+		return
+	}
+	alarmInterlock.Disarm = func() error {
+		if !client.IsConnectionOpen() {
+			return errors.New("not connected to MQTT")
+		}
+		slog.Info("Alarm armed, asking it to disarm", "topic", *alarmDisarmTopic)
+		publishMQTT(client, *alarmDisarmTopic, false, *alarmDisarmPayload)
+		return nil
+	}
+}
+
+// alarmWait hands a remote unlock to the main loop, which holds it like
+// those of swipes until the alarm disarmed, replying on reply
+type alarmWait struct {
+	event events.Access
+	reply chan error
+}
+
+// alarmWaits are taken by the main loop
+var alarmWaits = make(chan alarmWait)
+
+// awaitAlarm waits for the alarm to disarm before the remote unlock of
+// event. The main loop holds the unlock, so it must not be called from there
+func awaitAlarm(ctx context.Context, event events.Access) error {
+	if alarmInterlock.State() != alarm.StateArmed {
+		return nil
+	}
+	w := alarmWait{event: event, reply: make(chan error, 1)}
+	select {
+	case alarmWaits <- w:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-w.reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w alarmWait) run() {
+	pipeline.AwaitAlarm(w.event, func(err error) { w.reply <- err })
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	appTokensPath = flag.String("app-tokens", "", "file keeping the members' app tokens, which unlock on /api/v1/unlock and the page at /app/, and are issued on /api/v1/app-tokens (disabled if empty)")
+
+	// appTokens are the members' app tokens; nil if disabled. Set up in main
+	appTokens *store.AppTokens
+)
+
+// openAppTokens opens the app tokens at -app-tokens, if set
+func openAppTokens() (*store.AppTokens, error) {
+	if *appTokensPath == "" {
+		return nil, nil
+	}
+	if *adminTokensPath == "" && *oidcIssuer == "" && *apiKeysPath == "" {
+		slog.Warn("App tokens can only be issued and revoked with -admin-tokens, -oidc-issuer or -api-keys")
+	}
+	t, err := store.OpenAppTokens(*appTokensPath)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Accepting app tokens", "path", *appTokensPath, "tokens", len(t.List("")))
+	return t, nil
+}
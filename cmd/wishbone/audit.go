@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+var (
+	auditLogPath = flag.String("audit-log", "", "file access events are appended to as JSON lines, e.g. /var/lib/wishbone/audit.log (disabled if empty)")
+	auditKey     = flag.String("audit-key", "", "key signing each line of -audit-log with an HMAC chained to the previous line, or env:NAME or secret:NAME (unsigned if empty)")
+	tokenHashKey = flag.String("token-hash-key", "", "file with the hex encoded 32 byte key token hashes are keyed with, created if missing, e.g. /var/lib/wishbone/token-hash.key (a new key on every start if empty)")
+)
+
+// auditLog appends access events to a file, separate from the operational
+// log. A nil *auditLog discards all events
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	// key signs the lines if set, mac is the MAC of the last signed line
+	key []byte
+	mac string
+}
+
+var audit *auditLog
+
+// openAuditLog opens the audit log at path. With a key, the lines appended
+// are signed, continuing the chain of the lines already there
+func openAuditLog(path string, key []byte) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{file: f, key: key}
+	if len(key) > 0 {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if _, mac, ok := splitAuditMAC(scanner.Bytes()); ok {
+				a.mac = mac
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *auditLog) Log(e events.Access) {
+	if a == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Could not write audit log", "err", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mac := ""
+	if len(a.key) > 0 {
+		mac = auditMAC(a.key, a.mac, line)
+		line = append(line[:len(line)-1], `,"mac":"`+mac+`"}`...)
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Could not write audit log", "err", err)
+		return
+	}
+	if mac != "" {
+		a.mac = mac
+	}
+}
+
+// auditMAC signs line, a JSON object without the MAC, chained to the MAC
+// of the previous signed line
+func auditMAC(key []byte, prev string, line []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(prev))
+	h.Write(line)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// splitAuditMAC splits a signed line into the JSON object it signs and its
+// MAC, which is always the last member
+func splitAuditMAC(line []byte) ([]byte, string, bool) {
+	const prefix = `,"mac":"`
+	n := len(prefix) + sha256.Size*2 + len(`"}`)
+	if len(line) < n+1 || !bytes.HasSuffix(line, []byte(`"}`)) || !bytes.Equal(line[len(line)-n:len(line)-n+len(prefix)], []byte(prefix)) {
+		return nil, "", false
+	}
+	mac := string(line[len(line)-n+len(prefix) : len(line)-2])
+	if _, err := hex.DecodeString(mac); err != nil {
+		return nil, "", false
+	}
+	body := append(append([]byte{}, line[:len(line)-n]...), '}')
+	return body, mac, true
+}
+
+// errTampered is returned for audit logs whose chain of MACs is broken
+var errTampered = errors.New("audit log tampered with")
+
+// auditVerification is the outcome of checking an audit log
+type auditVerification struct {
+	Signed int
+	// Unsigned lines precede the first signed one, e.g. from before the
+	// key was set; Torn ones after it were cut off by a crash
+	Unsigned int
+	Torn     int
+	Last     string
+}
+
+// verifyAuditLog checks the chain of MACs of the audit log read from r. It
+// fails at the first line that was altered, inserted or follows a removed
+// one
+func verifyAuditLog(r io.Reader, key []byte) (auditVerification, error) {
+	var v auditVerification
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		body, mac, ok := splitAuditMAC(line)
+		switch {
+		case !ok && v.Signed == 0:
+			v.Unsigned++
+		case !ok && !json.Valid(line):
+			v.Torn++
+		case !ok:
+			return v, fmt.Errorf("%w: line %d is not signed", errTampered, n)
+		case !hmac.Equal([]byte(mac), []byte(auditMAC(key, v.Last, body))):
+			return v, fmt.Errorf("%w: line %d doesn't match its MAC, it or the line before was altered, inserted or removed", errTampered, n)
+		default:
+			v.Signed++
+			v.Last = mac
+		}
+	}
+	return v, scanner.Err()
+}
+
+// auditCommand runs the audit subcommands
+func auditCommand(args []string) {
+	if len(args) == 0 || args[0] != "verify" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	fs.Usage = usage
+	fs.Parse(args[1:])
+	if fs.NArg() > 1 {
+		usage()
+		os.Exit(2)
+	}
+	setupCommand()
+	defer remoteLog.Close()
+	if err := resolveSecrets(); err != nil {
+		fatal("Could not resolve secrets", "err", err)
+	}
+	if *auditKey == "" {
+		fatal("Audit key missing, set -audit-key")
+	}
+	path := *auditLogPath
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	if path == "" {
+		fatal("Audit log missing, set -audit-log or pass its path")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fatal("Could not open audit log", "err", err)
+	}
+	defer f.Close()
+	v, err := verifyAuditLog(f, []byte(*auditKey))
+	if errors.Is(err, errTampered) {
+		fmt.Printf("%s: %v, after %d valid lines\n", path, err, v.Signed)
+		os.Exit(1)
+	}
+	if err != nil {
+		fatal("Could not read audit log", "path", path, "err", err)
+	}
+	fmt.Printf("%s: %d signed lines verified\n", path, v.Signed)
+	if v.Unsigned > 0 {
+		fmt.Printf("unsigned lines before them: %d\n", v.Unsigned)
+	}
+	if v.Torn > 0 {
+		fmt.Printf("lines cut off by crashes: %d\n", v.Torn)
+	}
+	if v.Last != "" {
+		// Removing lines from the end can't be detected from the file
+		// alone, only by comparing with a MAC noted before
+		fmt.Printf("last MAC: %s\n", v.Last)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// usage prints the commands and flags
+func usage() {
+	fmt.Fprint(flag.CommandLine.Output(), `usage: wishbone [flags] [command]
+
+commands:
+  serve                 run the daemon (default)
+  user add [-schedule SCHEDULE] [-expires YYYY-MM-DD] [-pin PIN] [-groups GROUPS] [-hash] TOKEN NAME
+                        add a token to the credential store
+  user enroll [-schedule SCHEDULE] [-expires YYYY-MM-DD] [-pin PIN] [-groups GROUPS] [-hash] [-door DOOR] [-url URL] NAME
+                        add the next unknown card swiped within a minute for
+                        NAME through the admin API of the running daemon, with
+                        the admin token in $WISHBONE_ADMIN_TOKEN, and print it
+  user remove TOKEN     remove a token, given as is or as stored, e.g. hashed
+  user list             list the tokens in the credential store
+  export [-format json|csv] [FILE]
+                        write all tokens of the credential store to FILE or
+                        stdout, with hashed tokens and PINs as stored
+  import [-format json|csv] [-overwrite] [FILE]
+                        add the tokens of an export from FILE or stdin,
+                        skipping or with -overwrite replacing those already
+                        in the store
+  token hash TOKEN...   print salted hashes to store in place of the tokens
+  sync keygen KEYFILE   write a private key for signing lists for -sync-url to
+                        KEYFILE and print the public key for -sync-key
+  sync sign KEYFILE LIST [SERIAL]
+                        print the headers serving a list with SERIAL, by
+                        default the current time as unix timestamp
+  reader console [-hex] [-url URL] [READER]
+                        connect stdin and stdout to a serial reader, by label
+                        or device, through the admin API of the running
+                        daemon, with the admin token in $WISHBONE_ADMIN_TOKEN.
+                        The reader reads no tokens meanwhile
+  audit verify [FILE]   check the MACs of the audit log signed with -audit-key
+                        (-audit-log if no FILE is given)
+  help                  print this help
+
+The user, export and import commands edit the store selected by -list or
+-db, or by the config file given with -config.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+// setupCommand loads the config file and sets up logging for the commands
+// other than serve
+func setupCommand() {
+	if *configPath != "" {
+		if err := loadConfig(*configPath); err != nil {
+			fatal("Could not load config", "path", *configPath, "err", err)
+		}
+	}
+	if err := setupLogging(); err != nil {
+		fatal("Invalid config", "err", err)
+	}
+}
+
+// openEditableStore opens the credential store the daemon uses, unless it
+// can't be edited from here
+func openEditableStore() (store.CredentialStore, error) {
+	if err := validateStore(); err != nil {
+		return nil, err
+	}
+	switch storeKind() {
+	case "ldap":
+		return nil, errors.New("tokens in LDAP are managed in the directory")
+	case "sqlite":
+		return store.OpenDB(*dbPath, *list)
+	case "http":
+		return newHTTPStore()
+	}
+	return store.OpenList(*list)
+}
+
+func userCommand(args []string) {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	setupCommand()
+	defer remoteLog.Close()
+	// Cards are enrolled by the daemon, which reads the readers
+	if args[0] == "enroll" {
+		userEnroll(args[1:])
+		return
+	}
+	creds, err := openEditableStore()
+	if err != nil {
+		fatal("Could not open credential store", "err", err)
+	}
+
+	switch args[0] {
+	case "add":
+		userAdd(creds, args[1:])
+	case "remove":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := creds.Remove(args[1]); err != nil {
+			fatal("Could not remove token", "err", err)
+		}
+		slog.Info("Removed token")
+	case "list":
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		userList(creds)
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// userAdd validates a token like the admin API does and adds it to creds
+func userAdd(creds store.CredentialStore, args []string) {
+	fs := flag.NewFlagSet("user add", flag.ExitOnError)
+	fs.Usage = usage
+	schedule := fs.String("schedule", "", "time windows like in list.txt, e.g. \"weekdays 08:00-20:00\" (unrestricted if empty)")
+	expiresText := fs.String("expires", "", "first day the token no longer works, as YYYY-MM-DD (never if empty)")
+	pin := fs.String("pin", "", "PIN to enter on the keypad after swiping, stored hashed")
+	groups := fs.String("groups", "", "comma separated groups of the user, e.g. members,keyholders")
+	hash := fs.Bool("hash", false, "store a salted hash of the token instead of the token")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var expires time.Time
+	if *expiresText != "" {
+		var err error
+		if expires, err = time.ParseInLocation("2006-01-02", *expiresText, time.Local); err != nil {
+			fatal("Invalid expiry date", "expires", *expiresText)
+		}
+	}
+	c, err := store.NewCredential(fs.Arg(0), strings.Join(fs.Args()[1:], " "), *schedule, expires)
+	if err != nil {
+		fatal("Invalid token", "err", err)
+	}
+	if c.Groups, err = store.ParseGroups(*groups); err != nil {
+		fatal("Invalid groups", "err", err)
+	}
+	if *hash {
+		if c.Token, err = store.NewTokenHash(c.Token); err != nil {
+			fatal("Could not hash token", "err", err)
+		}
+	}
+	if *pin != "" {
+		if c.PIN, err = store.NewPINHash(*pin); err != nil {
+			fatal("Invalid PIN", "err", err)
+		}
+	}
+	if err := creds.Add(c); err != nil {
+		fatal("Could not add token", "user", c.User, "err", err)
+	}
+	slog.Info("Added token", "user", c.User)
+}
+
+func userList(creds store.CredentialStore) {
+	list, err := creds.List()
+	if err != nil {
+		fatal("Could not list tokens", "err", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TOKEN\tUSER\tGROUPS\tSCHEDULE\tEXPIRES\tPIN\tDISABLED")
+	for _, c := range list {
+		expires := ""
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Token, c.User, strings.Join(c.Groups, ","), c.Schedule, expires, yesNo(c.PIN != ""), yesNo(c.Disabled))
+	}
+	w.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func tokenCommand(args []string) {
+	if len(args) == 0 || args[0] != "hash" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	hashCommand(args[1:])
+}
+
+// hashCommand prints salted hashes of the given tokens, normalized, to be used
+// in place of the tokens in list.txt or the database
+func hashCommand(tokens []string) {
+	if len(tokens) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: wishbone token hash <token>...")
+		os.Exit(2)
+	}
+	for _, token := range tokens {
+		hash, err := store.NewTokenHash(store.NormalizeToken(token))
+		if err != nil {
+			fatal("Could not hash token", "err", err)
+		}
+		fmt.Println(hash)
+	}
+}
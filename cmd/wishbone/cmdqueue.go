@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+var commandQueueSize = flag.Int("command-queue", 4, "most remote commands waiting for a door; further ones are refused")
+
+// commandQueue runs the commands of a door one at a time, in order. A
+// remote command equal to the last one waiting joins it instead of being
+// queued again, and remote commands beyond the limit are refused, so clients
+// sending commands in a loop can't pile them up in front of the door. The
+// commands of swipes and keys at the door go ahead of the remote ones and
+// are never refused
+type commandQueue struct {
+	door  string
+	items chan *queuedCommand
+	local chan *queuedCommand
+
+	mu sync.Mutex
+	// last is the remote command queued last, as long as it waits
+	last *queuedCommand
+}
+
+// command is what a queued command does to the door. Only equal commands
+// are joined
+type command struct {
+	// kind is open, keep-open or close
+	kind string
+	// lockAfter is how long the door stays unlocked until the auto-lock
+	// closes it; 0 if it doesn't
+	lockAfter time.Duration
+}
+
+// queuedCommand is a command waited for by one or more callers
+type queuedCommand struct {
+	cmd    command
+	action func(context.Context) error
+	// ctx is canceled once all callers gave up
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+
+	done chan struct{}
+	err  error
+}
+
+func newCommandQueue(door string, size int) *commandQueue {
+	q := &commandQueue{door: door, items: make(chan *queuedCommand, size), local: make(chan *queuedCommand, size)}
+	commandQueueDepth.WithLabelValues(door).Set(0)
+	go q.run()
+	return q
+}
+
+func (q *commandQueue) run() {
+	for {
+		c := q.next()
+		q.mu.Lock()
+		if q.last == c {
+			q.last = nil
+		}
+		q.mu.Unlock()
+		commandQueueDepth.WithLabelValues(q.door).Set(float64(len(q.items)))
+		if supervisor.Run("commands "+q.door, func() { c.err = c.action(c.ctx) }) {
+			c.err = fmt.Errorf("command of door %s panicked", q.door)
+		}
+		c.cancel()
+		close(c.done)
+	}
+}
+
+// next waits for the next command, taking those of the door first
+func (q *commandQueue) next() *queuedCommand {
+	select {
+	case c := <-q.local:
+		return c
+	default:
+	}
+	select {
+	case c := <-q.local:
+		return c
+	case c := <-q.items:
+		return c
+	}
+}
+
+func newQueuedCommand(ctx context.Context, cmd command, action func(context.Context) error) *queuedCommand {
+	c := &queuedCommand{cmd: cmd, action: action, waiters: 1, done: make(chan struct{})}
+	c.ctx, c.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	return c
+}
+
+// do queues action, which carries out the remote command cmd, and waits
+// until it ran. It fails with httpapi.ErrBusy if the queue is full. The
+// action is canceled if ctx and those of all callers who joined it are done
+// before it started
+func (q *commandQueue) do(ctx context.Context, cmd command, action func(context.Context) error) error {
+	q.mu.Lock()
+	c := q.last
+	if c != nil && c.cmd == cmd {
+		c.waiters++
+		slog.Debug("Joining queued command", "door", q.door, "command", cmd.kind, "waiters", c.waiters)
+	} else {
+		c = newQueuedCommand(ctx, cmd, action)
+		select {
+		case q.items <- c:
+			q.last = c
+		default:
+			q.mu.Unlock()
+			c.cancel()
+			return fmt.Errorf("%w: %d commands waiting for door %s", httpapi.ErrBusy, cap(q.items), q.door)
+		}
+	}
+	commandQueueDepth.WithLabelValues(q.door).Set(float64(len(q.items)))
+	q.mu.Unlock()
+	return q.wait(ctx, c)
+}
+
+// doLocal queues action, which carries out cmd for a swipe or key at the
+// door, ahead of the remote commands, and waits until it ran
+func (q *commandQueue) doLocal(ctx context.Context, cmd command, action func(context.Context) error) error {
+	c := newQueuedCommand(ctx, cmd, action)
+	select {
+	case q.local <- c:
+	case <-ctx.Done():
+		c.cancel()
+		return ctx.Err()
+	}
+	return q.wait(ctx, c)
+}
+
+// wait waits until c ran. If ctx is done first and c has no callers left,
+// c is canceled and no longer joined
+func (q *commandQueue) wait(ctx context.Context, c *queuedCommand) error {
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		q.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if q.last == c {
+				q.last = nil
+			}
+		}
+		q.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// drop fails the commands still waiting with err, leaving the one running
+func (q *commandQueue) drop(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.last = nil
+	for {
+		select {
+		case c := <-q.items:
+			c.err = err
+			c.cancel()
+			close(c.done)
+		case c := <-q.local:
+			c.err = err
+			c.cancel()
+			close(c.done)
+		default:
+			commandQueueDepth.WithLabelValues(q.door).Set(0)
+			return
+		}
+	}
+}
+
+// command returns the command of kind, open, keep-open or close, for d
+func (d *door) command(kind string) command {
+	c := command{kind: kind}
+	if kind == "open" {
+		c.lockAfter = d.autoLock.Delay()
+	}
+	return c
+}
+
+// runQueued queues the remote command of kind for d and waits until it ran
+func (d *door) runQueued(ctx context.Context, kind string) error {
+	return d.commands.do(ctx, d.command(kind), d.action(kind))
+}
+
+// runLocal queues the command of kind for a swipe or key at d, ahead of
+// the remote ones, and waits until it ran
+func (d *door) runLocal(ctx context.Context, kind string) error {
+	return d.commands.doLocal(ctx, d.command(kind), d.action(kind))
+}
+
+// action returns what the command of kind does to d. Open arms the
+// auto-lock, while keep-open and close disarm it
+func (d *door) action(kind string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		switch kind {
+		case "keep-open":
+			d.autoLock.Disarm()
+			d.heldOpen.KeepOpen()
+			return d.Open(ctx)
+		case "close":
+			d.autoLock.Disarm()
+			return d.Close(ctx)
+		}
+		if err := d.Open(ctx); err != nil {
+			return err
+		}
+		d.autoLock.Arm()
+		return nil
+	}
+}
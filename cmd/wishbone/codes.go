@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/access"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	codesEnabled    = flag.Bool("codes", false, "let members hand out one-time codes on /api/v1/codes, entered on the keypad or passed to the unlock API")
+	codeMaxValidity = flag.Duration("code-max-validity", 7*24*time.Hour, "longest validity of one-time codes")
+
+	// codes are the active one-time codes; nil if disabled. Set up in main
+	codes *store.Codes
+
+	// codeEntry is the code being typed on the keypad while no PIN is
+	// pending, and lastCodeKey when its last key was pressed. Only used by
+	// the main loop
+	codeEntry   []rune
+	lastCodeKey time.Time
+)
+
+// handleCodeKey adds a key pressed on the keypad to the code being typed.
+// '*' clears it, '#' submits it. Digits typed longer than -pin-timeout ago
+// are dropped
+func handleCodeKey(key rune) {
+	now := clk.Now()
+	if now.Sub(lastCodeKey) > *pinTimeout {
+		codeEntry = codeEntry[:0]
+	}
+	lastCodeKey = now
+	switch {
+	case key == '*':
+		codeEntry = codeEntry[:0]
+	case key == '#':
+		text := string(codeEntry)
+		codeEntry = codeEntry[:0]
+		if text != "" {
+			redeemCode(text)
+		}
+	case key >= '0' && key <= '9':
+		if len(codeEntry) < maxPINDigits {
+			codeEntry = append(codeEntry, key)
+		}
+	}
+}
+
+// redeemCode unlocks the door a code typed on the keypad was issued for,
+// on behalf of the member who issued it
+func redeemCode(text string) {
+	event := events.Access{Source: "code", Door: doors[0].name, Reader: "keypad", Action: "open", TokenHash: events.HashToken(text)}
+	// Guessed codes are blocked like unknown tokens at a reader
+	const lockoutKey = "keypad"
+	if lockout.Blocked(lockoutKey, clk.Now()) {
+		access.Logger(event).Warn("Ignoring code, too many unknown codes")
+		return
+	}
+	code, err := codes.Redeem(text, "", clk.Now())
+	if err != nil {
+		access.Logger(event).Info("Unknown code")
+		event.Result = events.ResultUnknown
+		recordAccess(event)
+		lockout.Fail(lockoutKey, clk.Now())
+		return
+	}
+	d := doorByName(code.Door)
+	event.Door = d.name
+	event.User = code.Inviter
+	// Guests are never exempt from a lockdown
+	if err := lockdowns.Check(store.Credential{}, d.name); err != nil {
+		access.Logger(event).Info("Denied", "reason", err)
+		event.Result = events.ResultDenied
+		event.Reason = err.Error()
+		recordAccess(event)
+		return
+	}
+	access.Logger(event).Info("Granted one-time code")
+	pipeline.Unlock(d, event, nil)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/access"
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+var (
+	lookupTimeout  = flag.Duration("lookup-timeout", 5*time.Second, "how long looking up a token in the credential store may take, e.g. in LDAP")
+	commandTimeout = flag.Duration("command-timeout", 30*time.Second, "how long driving a door may take, including waiting for it to move")
+)
+
+var errUnknownCommand = errors.New("unknown command")
+
+// runCommand executes a remote open, keep-open or close command for d and
+// records it as an access event. user is empty if the source doesn't identify
+// users. Opening is refused during a lockdown unless ctx is exempt, and waits
+// for the alarm to disarm. The command waits in the door's queue, and gives
+// up after -command-timeout or when ctx is done
+func runCommand(ctx context.Context, d *door, source, user, cmd string) error {
+	ctx, cancel := context.WithTimeout(ctx, *commandTimeout)
+	defer cancel()
+	cmd = strings.ToLower(strings.TrimSpace(cmd))
+	event := events.Access{Source: source, Door: d.name, User: user, Action: cmd, Result: events.ResultGranted}
+	logger := access.Logger(event)
+
+	if cmd == "open" || cmd == "keep-open" {
+		if err := lockdowns.CheckCommand(ctx, d.name); err != nil {
+			logger.Warn("Refused, door in lockdown")
+			event.Result = events.ResultDenied
+			event.Reason = err.Error()
+			recordAccess(event)
+			return err
+		}
+		if err := awaitAlarm(ctx, event); err != nil {
+			event.Result = events.ResultFailure
+			event.Reason = err.Error()
+			recordAccess(event)
+			return err
+		}
+	}
+
+	switch cmd {
+	case "open":
+		logger.Info("Opening")
+	case "keep-open":
+		logger.Info("Opening without auto-lock")
+	case "close":
+		logger.Info("Closing")
+	default:
+		return errUnknownCommand
+	}
+	err := d.runQueued(ctx, cmd)
+
+	if err != nil {
+		logger.Error("Could not "+cmd, "err", err)
+		event.Result = events.ResultFailure
+		event.Reason = err.Error()
+	}
+	recordAccess(event)
+	return err
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/feedback"
+	"github.com/craftamap/wishbone/internal/keypad"
+	"github.com/craftamap/wishbone/internal/reader"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configPath         = flag.String("config", "", "YAML config file; its keys are the names of the command line flags")
+	printDefaultConfig = flag.Bool("print-default-config", false, "print the default config and exit")
+
+	debounce       = flag.Duration("debounce", 5*time.Second, "ignore repeated reads of the same token within this interval")
+	pulseLength    = flag.Duration("pulse", 1*time.Second, "how long the open/close relays are energized")
+	openPulse      = flag.Duration("open-pulse", 0, "how long the open relay is energized (-pulse if 0)")
+	closePulse     = flag.Duration("close-pulse", 0, "how long the close relay is energized (-pulse if 0)")
+	pulseGap       = flag.Duration("pulse-gap", 0, "minimum time between the end of a relay pulse and the next one")
+	actuation      = flag.String("actuation", string(actuator.ProfilePulse), "how the relays are energized: pulse, double-pulse, hold-until-status or hold-with-timeout")
+	pulsePause     = flag.Duration("pulse-pause", 500*time.Millisecond, "pause between the two pulses of -actuation double-pulse")
+	holdTimeout    = flag.Duration("hold-timeout", 10*time.Second, "longest the relays are held with -actuation hold-until-status or hold-with-timeout")
+	relayCooldown  = flag.Duration("relay-cooldown", 0, "refuse commands energizing a relay sooner than this after the previous pulse ended, e.g. for strikes that overheat (disabled if 0)")
+	relayMaxPulses = flag.Int("relay-max-pulses", 0, "refuse commands energizing a relay more than this often within -relay-rate-window (disabled if 0)")
+	relayWindow    = flag.Duration("relay-rate-window", time.Minute, "interval relay pulses are counted in for -relay-max-pulses")
+	openPin        = flag.Int("open-pin", 22, "BCM number of the GPIO driving the open relay")
+	closePin       = flag.Int("close-pin", 27, "BCM number of the GPIO driving the close relay")
+	statusPin0     = flag.Int("status-pin0", -1, "BCM number of the GPIO reading the low bit of the sphincter status (not wired if -1)")
+	statusPin1     = flag.Int("status-pin1", -1, "BCM number of the GPIO reading the high bit of the sphincter status (not wired if -1)")
+	verifyTimeout  = flag.Duration("verify-timeout", 5*time.Second, "with status pins, report FAILURE if the sphincter hasn't moved this long after open/close, retrying once (disabled if 0)")
+	statusPoll     = flag.Duration("status-poll", 50*time.Millisecond, "how often the status pins are read")
+	statusDebounce = flag.Duration("status-debounce", 100*time.Millisecond, "how long the status pins must read the same before a change is reported")
+
+	openPinActiveLow    = flag.Bool("open-pin-active-low", false, "energize the open relay by driving its GPIO low")
+	closePinActiveLow   = flag.Bool("close-pin-active-low", false, "energize the close relay by driving its GPIO low")
+	statusPin0ActiveLow = flag.Bool("status-pin0-active-low", false, "read status-pin0 as set while it is low")
+	statusPin1ActiveLow = flag.Bool("status-pin1-active-low", false, "read status-pin1 as set while it is low")
+
+	gpioBackend = flag.String("gpio-backend", "rpio", "how the relays and status pins are accessed: rpio (/dev/gpiomem) or gpiod (the GPIO character device, e.g. on the Raspberry Pi 5)")
+	gpioChip    = flag.String("gpio-chip", "", "GPIO chip of -gpio-backend gpiod, like gpiochip0 (the one of the Raspberry Pi's pin header if empty)")
+
+	exitButton          = flag.Int("exit-button", -1, "BCM number of the GPIO reading the exit button, which opens the door unconditionally (not wired if -1)")
+	exitButtonActiveLow = flag.Bool("exit-button-active-low", false, "read exit-button as pressed while it is low, pulled up otherwise")
+	exitButtonDebounce  = flag.Duration("exit-button-debounce", 50*time.Millisecond, "how long the exit button must be held to count as pressed")
+
+	doorSensor          = flag.Int("door-sensor", -1, "BCM number of the GPIO reading the door sensor, a reed switch to ground telling whether the door is physically open (not wired if -1)")
+	doorSensorActiveLow = flag.Bool("door-sensor-active-low", false, "read the door as open while door-sensor is low, for normally closed switches")
+	doorSensorDebounce  = flag.Duration("door-sensor-debounce", 100*time.Millisecond, "how long the door sensor must read the same before a change is reported")
+	latchAlarmDelay     = flag.Duration("latch-alarm-delay", 5*time.Second, "raise an alarm if the door sensor reads the door open while it is locked for longer than this (disabled if 0)")
+)
+
+// flags that can't be set from a config file
+var configExcluded = map[string]bool{
+	"config":               true,
+	"print-default-config": true,
+}
+
+// configSections are the keys of a config file that aren't flags
+var configSections = map[string]bool{
+	"doors":          true,
+	"rules":          true,
+	"legacy-profile": true,
+}
+
+// commandLineFlags are the flags given on the command line, which override
+// the config file, also when it is reloaded
+var commandLineFlags = map[string]bool{}
+
+// loadConfig sets all flags that weren't given on the command line from the
+// YAML file at path, so command line flags override the config file
+func loadConfig(path string) error {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values, err := parseConfig(path, bytes)
+	if err != nil {
+		return err
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+
+	for key, value := range values {
+		if configSections[key] || commandLineFlags[key] {
+			continue
+		}
+		if err := flag.Set(key, configValue(value)); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+
+	// Further doors are a list, which can't be given as a flag. They are
+	// decoded last, as their defaults depend on the flags
+	if err := decodeSection(values, "doors", path, &extraDoors); err != nil {
+		return err
+	}
+	if err := decodeSection(values, "rules", path, &ruleConfigs); err != nil {
+		return err
+	}
+	return decodeSection(values, "legacy-profile", path, &legacyProfile)
+}
+
+// parseConfig parses the config file read from path into its options, by
+// name, failing on unknown ones
+func parseConfig(path string, bytes []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(bytes, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for key := range values {
+		if configSections[key] {
+			continue
+		}
+		if flag.Lookup(key) == nil || configExcluded[key] {
+			return nil, fmt.Errorf("%s: unknown option %q", path, key)
+		}
+	}
+	return values, nil
+}
+
+// configValue returns an option of the config file as flag value
+func configValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// decodeSection decodes the section key of the config file read from path
+// into v, unless the file has none
+func decodeSection(values map[string]interface{}, key, path string, v interface{}) error {
+	section, ok := values[key]
+	if !ok {
+		return nil
+	}
+	out, err := yaml.Marshal(section)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(out)))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %s: %w", path, key, err)
+	}
+	return nil
+}
+
+func validateConfig() error {
+	if *port == "" && *readersFlag == "" {
+		return errors.New("port must not be empty")
+	}
+	if err := validateStore(); err != nil {
+		return err
+	}
+	if *ldapURL != "" {
+		if *dbPath != "" {
+			return errors.New("db and ldap-url can't be used together")
+		}
+		if *ldapBaseDN == "" {
+			return errors.New("ldap-url requires ldap-base-dn")
+		}
+		if *ldapCacheTTL < 0 {
+			return errors.New("ldap-cache-ttl must not be negative")
+		}
+	}
+	if *offlineCachePath != "" {
+		if *ldapURL == "" {
+			return errors.New("offline-cache requires ldap-url")
+		}
+		if *offlineCacheKey == "" {
+			return errors.New("offline-cache requires offline-cache-key")
+		}
+		if *offlineCacheMaxAge <= 0 {
+			return errors.New("offline-cache-max-age must be positive")
+		}
+	}
+	if *serialKeepalive < 0 {
+		return errors.New("serial-keepalive must not be negative")
+	}
+	if *serialPingReply != "" && (*serialPing == "" || *serialKeepalive == 0) {
+		return errors.New("serial-ping-reply requires serial-ping and serial-keepalive")
+	}
+	if *historySize < 0 || *eventRetention < 0 {
+		return errors.New("history-size and event-retention must not be negative")
+	}
+	if *statsDays < 0 {
+		return errors.New("stats-days must not be negative")
+	}
+	if *latencyTraces < 0 {
+		return errors.New("latency-traces must not be negative")
+	}
+	if *slowUnlock < 0 {
+		return errors.New("slow-unlock must not be negative")
+	}
+	if *lookupTimeout <= 0 || *commandTimeout <= 0 {
+		return errors.New("lookup-timeout and command-timeout must be positive")
+	}
+	if *lockoutFailures < 0 {
+		return errors.New("lockout-failures must not be negative")
+	}
+	if *lockoutWindow <= 0 || *lockoutCooldown <= 0 {
+		return errors.New("lockout-window and lockout-cooldown must be positive")
+	}
+	if *debounce < 0 || *doubleSwipe < 0 {
+		return errors.New("debounce and double-swipe must not be negative")
+	}
+	if *verifyTimeout < 0 {
+		return errors.New("verify-timeout must not be negative")
+	}
+	if *selfTest && *verifyTimeout == 0 {
+		return errors.New("self-test requires verify-timeout")
+	}
+	if *gpioBackend != "rpio" && *gpioBackend != "gpiod" {
+		return fmt.Errorf("unknown gpio-backend %q, expected rpio or gpiod", *gpioBackend)
+	}
+	if *statusPoll <= 0 {
+		return errors.New("status-poll must be positive")
+	}
+	if *statusDebounce < 0 {
+		return errors.New("status-debounce must not be negative")
+	}
+	if *exitButtonDebounce < 0 {
+		return errors.New("exit-button-debounce must not be negative")
+	}
+	if *doorSensorDebounce < 0 || *latchAlarmDelay < 0 {
+		return errors.New("door-sensor-debounce and latch-alarm-delay must not be negative")
+	}
+	if *pinTimeout <= 0 {
+		return errors.New("pin-timeout must be positive")
+	}
+	if *statusLEDPin >= 0 && *statusLEDInterval <= 0 {
+		return errors.New("status-led-interval must be positive")
+	}
+	if *commandQueueSize <= 0 {
+		return errors.New("command-queue must be positive")
+	}
+	if *notifyQueueSize <= 0 {
+		return errors.New("notify-queue-size must be positive")
+	}
+	if *notifyMaxAge < 0 {
+		return errors.New("notify-max-age must not be negative")
+	}
+	if *codesEnabled && *codeMaxValidity <= 0 {
+		return errors.New("code-max-validity must be positive")
+	}
+	if err := validateSync(); err != nil {
+		return err
+	}
+	if err := validateAlarm(); err != nil {
+		return err
+	}
+
+	// Every GPIO may only be used once
+	type use struct {
+		name string
+		pin  int
+	}
+	var uses []use
+	names := map[string]bool{}
+	labels := map[string]string{}
+	for _, c := range doorConfigs() {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate door name %q", c.Name)
+		}
+		names[c.Name] = true
+
+		// Pins of the first door are named like their flags
+		prefix := ""
+		if c.Name != *doorName {
+			prefix = "door " + c.Name + " "
+		}
+		uses = append(uses, use{prefix + "open-pin", c.OpenPin}, use{prefix + "close-pin", c.ClosePin})
+		if c.hasStatusPins() {
+			uses = append(uses, use{prefix + "status-pin0", c.StatusPin0}, use{prefix + "status-pin1", c.StatusPin1})
+		}
+		if c.ExitButton >= 0 {
+			uses = append(uses, use{prefix + "exit-button", c.ExitButton})
+		}
+		if c.DoorSensor >= 0 {
+			uses = append(uses, use{prefix + "door-sensor", c.DoorSensor})
+		}
+
+		readers, err := c.parseReaders()
+		if err != nil {
+			return err
+		}
+		for _, r := range readers {
+			// Swipes are assigned to doors by the label of their reader
+			label := readerLabel(r)
+			if other, ok := labels[label]; ok {
+				return fmt.Errorf("reader label %q of door %s is already used by door %s", label, c.Name, other)
+			}
+			labels[label] = c.Name
+			switch r := r.(type) {
+			case *reader.Wiegand:
+				r.Chip = *gpioChip
+				uses = append(uses, use{"reader " + r.Label + " D0", r.D0}, use{"reader " + r.Label + " D1", r.D1})
+			case *reader.Serial:
+				if usesPinHeaderUART(r.Device) {
+					uses = append(uses, use{"reader " + r.Label + " TXD", 14}, use{"reader " + r.Label + " RXD", 15})
+				}
+			}
+		}
+
+		outputs, err := c.parseFeedback()
+		if err != nil {
+			return err
+		}
+		for _, o := range outputs {
+			switch o := o.(type) {
+			case *feedback.Buzzer:
+				uses = append(uses, use{prefix + "buzzer", o.Pin})
+			case *feedback.LED:
+				uses = append(uses, use{prefix + "green LED", o.Green}, use{prefix + "red LED", o.Red})
+			}
+		}
+	}
+	if *statusLEDPin >= 0 {
+		uses = append(uses, use{"status-led", *statusLEDPin})
+	}
+	if *alarmInputPin >= 0 {
+		uses = append(uses, use{"alarm-input", *alarmInputPin})
+	}
+	if *keypadSpec != "" {
+		kp, err := keypad.Parse(*keypadSpec)
+		if err != nil {
+			return err
+		}
+		if m, ok := kp.(*keypad.Matrix); ok {
+			for i, pin := range m.Rows {
+				uses = append(uses, use{fmt.Sprintf("keypad row %d", i+1), pin})
+			}
+			for i, pin := range m.Cols {
+				uses = append(uses, use{fmt.Sprintf("keypad column %d", i+1), pin})
+			}
+		}
+	}
+	usedBy := map[int]string{}
+	for _, u := range uses {
+		if u.pin < 0 || u.pin > 27 {
+			return fmt.Errorf("GPIO %d of %s does not exist", u.pin, u.name)
+		}
+		if other, ok := usedBy[u.pin]; ok {
+			return fmt.Errorf("GPIO %d of %s is already used by %s", u.pin, u.name, other)
+		}
+		usedBy[u.pin] = u.name
+	}
+	if *grpcListen != "" {
+		if err := grpcConfig().Validate(); err != nil {
+			return err
+		}
+	}
+	return httpConfig().Validate()
+}
+
+// writeDefaultConfig writes a config file containing the default value of
+// every option, each preceded by its description
+func writeDefaultConfig(w io.Writer) error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || configExcluded[f.Name] {
+			return
+		}
+		// Let YAML pick the type of the default, so numbers and booleans
+		// aren't quoted
+		var value interface{} = f.DefValue
+		if f.DefValue != "" {
+			if err = yaml.Unmarshal([]byte(f.DefValue), &value); err != nil {
+				return
+			}
+		}
+		var out []byte
+		out, err = yaml.Marshal(map[string]interface{}{f.Name: value})
+		if err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "# %s\n%s", f.Usage, out)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(w, "# further doors, each with a name, readers and optionally feedback, auto-lock and the GPIO options above\ndoors: []\n"+
+		"# rules running actions like webhook, feedback, alert or log on events, each with on, do and optionally name, doors, readers, sources and between\nrules: []\n")
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/decision"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	accessHookFlag     = flag.String("access-hook", "", "program or http(s) URL consulted last on every unlock by token, allowing or denying it (disabled if empty)")
+	accessHookTimeout  = flag.Duration("access-hook-timeout", 2*time.Second, "how long -access-hook may take before it has failed")
+	accessHookFailOpen = flag.Bool("access-hook-fail-open", false, "allow unlocks if -access-hook fails or times out, instead of denying them")
+)
+
+// accessHook has the final say on unlocks; nil if disabled
+var accessHook *decision.Hook
+
+func openAccessHook() (*decision.Hook, error) {
+	h, err := decision.New(*accessHookFlag, *accessHookTimeout, *accessHookFailOpen)
+	if h != nil {
+		slog.Info("Consulting access hook", "hook", h, "timeout", *accessHookTimeout, "fail_open", *accessHookFailOpen)
+	}
+	return h, err
+}
+
+// checkAccessHook asks the access hook about a swipe, logging the reason it
+// denied it for. It returns decision.ErrDenied if it did
+func checkAccessHook(cred store.Credential, event events.Access, logger *slog.Logger) error {
+	reason, err := accessHook.Check(context.Background(), decision.Request{
+		Door:      event.Door,
+		Reader:    event.Reader,
+		Source:    event.Source,
+		Action:    event.Action,
+		User:      cred.User,
+		Groups:    cred.Groups,
+		TokenHash: event.TokenHash,
+	})
+	if err != nil && reason != "" {
+		logger.Info("Access hook denied", "reason", reason)
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/craftamap/wishbone/internal/reader"
+)
+
+var (
+	desfireAID   = flag.String("desfire-aid", "", "DESFire application, as 6 hex digits, cards on PC/SC readers have to authenticate to; only the UID is read if empty")
+	desfireKey   = flag.String("desfire-key", "", "file with the hex encoded AES key of -desfire-aid")
+	desfireKeyNo = flag.Int("desfire-key-no", 0, "number of -desfire-key in the application")
+)
+
+// desfireConfig returns the DESFire application PC/SC readers authenticate
+// cards with, or nil if disabled
+func desfireConfig() (*reader.DESFire, error) {
+	if *desfireAID == "" {
+		return nil, nil
+	}
+	if *desfireKey == "" {
+		return nil, errors.New("desfire-aid requires desfire-key")
+	}
+	return reader.LoadDESFire(*desfireAID, *desfireKeyNo, *desfireKey)
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/button"
+	"github.com/craftamap/wishbone/internal/doorsensor"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/feedback"
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	doorName = flag.String("door-name", "main", "name of the door driven by the GPIO flags, used in events and HTTP paths")
+
+	// extraDoors are the doors listed under "doors" in the config file
+	extraDoors []doorConfig
+
+	// doors are all doors of the daemon; the first one is configured by the
+	// flags. Set up in main
+	doors []*door
+)
+
+// doorConfig configures a door. Its YAML keys are the names of the flags
+// configuring the first door
+type doorConfig struct {
+	Name                string        `yaml:"name"`
+	OpenPin             int           `yaml:"open-pin"`
+	ClosePin            int           `yaml:"close-pin"`
+	OpenPinActiveLow    bool          `yaml:"open-pin-active-low"`
+	ClosePinActiveLow   bool          `yaml:"close-pin-active-low"`
+	StatusPin0          int           `yaml:"status-pin0"`
+	StatusPin1          int           `yaml:"status-pin1"`
+	StatusPin0ActiveLow bool          `yaml:"status-pin0-active-low"`
+	StatusPin1ActiveLow bool          `yaml:"status-pin1-active-low"`
+	ExitButton          int           `yaml:"exit-button"`
+	ExitButtonActiveLow bool          `yaml:"exit-button-active-low"`
+	DoorSensor          int           `yaml:"door-sensor"`
+	DoorSensorActiveLow bool          `yaml:"door-sensor-active-low"`
+	Readers             string        `yaml:"readers"`
+	Feedback            string        `yaml:"feedback"`
+	AutoLock            time.Duration `yaml:"auto-lock"`
+	AutoLockCountdown   time.Duration `yaml:"auto-lock-countdown"`
+	HeldOpenAlarm       time.Duration `yaml:"held-open-alarm"`
+	Pulse               time.Duration `yaml:"pulse"`
+	OpenPulse           time.Duration `yaml:"open-pulse"`
+	ClosePulse          time.Duration `yaml:"close-pulse"`
+	PulseGap            time.Duration `yaml:"pulse-gap"`
+	Actuation           string        `yaml:"actuation"`
+	PulsePause          time.Duration `yaml:"pulse-pause"`
+	HoldTimeout         time.Duration `yaml:"hold-timeout"`
+	RelayCooldown       time.Duration `yaml:"relay-cooldown"`
+	RelayMaxPulses      int           `yaml:"relay-max-pulses"`
+	RelayRateWindow     time.Duration `yaml:"relay-rate-window"`
+	OpenHours           string        `yaml:"open-hours"`
+	SwipeKeepOpen       string        `yaml:"swipe-keep-open"`
+}
+
+// UnmarshalYAML defaults the status pins, the exit button and the door
+// sensor to not wired, and the auto-lock, its countdown, the held-open
+// alarm, the relay timing and the actuation and duty cycle limits to their
+// flags
+func (c *doorConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain doorConfig
+	p := plain{
+		StatusPin0:        -1,
+		StatusPin1:        -1,
+		ExitButton:        -1,
+		DoorSensor:        -1,
+		AutoLock:          *autoLockDelay,
+		AutoLockCountdown: *autoLockCountdown,
+		HeldOpenAlarm:     *heldOpenLimit,
+		Pulse:             *pulseLength,
+		OpenPulse:         *openPulse,
+		ClosePulse:        *closePulse,
+		PulseGap:          *pulseGap,
+		Actuation:         *actuation,
+		PulsePause:        *pulsePause,
+		HoldTimeout:       *holdTimeout,
+		RelayCooldown:     *relayCooldown,
+		RelayMaxPulses:    *relayMaxPulses,
+		RelayRateWindow:   *relayWindow,
+	}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*c = doorConfig(p)
+	return nil
+}
+
+// mainDoorConfig returns the config of the door set by the flags. Its readers
+// are parsed separately, as they may be given by -port
+func mainDoorConfig() doorConfig {
+	return doorConfig{
+		Name:                *doorName,
+		OpenPin:             *openPin,
+		ClosePin:            *closePin,
+		OpenPinActiveLow:    *openPinActiveLow,
+		ClosePinActiveLow:   *closePinActiveLow,
+		StatusPin0:          *statusPin0,
+		StatusPin1:          *statusPin1,
+		StatusPin0ActiveLow: *statusPin0ActiveLow,
+		StatusPin1ActiveLow: *statusPin1ActiveLow,
+		ExitButton:          *exitButton,
+		ExitButtonActiveLow: *exitButtonActiveLow,
+		DoorSensor:          *doorSensor,
+		DoorSensorActiveLow: *doorSensorActiveLow,
+		Feedback:            *feedbackSpec,
+		AutoLock:            *autoLockDelay,
+		AutoLockCountdown:   *autoLockCountdown,
+		HeldOpenAlarm:       *heldOpenLimit,
+		Pulse:               *pulseLength,
+		OpenPulse:           *openPulse,
+		ClosePulse:          *closePulse,
+		PulseGap:            *pulseGap,
+		Actuation:           *actuation,
+		PulsePause:          *pulsePause,
+		HoldTimeout:         *holdTimeout,
+		RelayCooldown:       *relayCooldown,
+		RelayMaxPulses:      *relayMaxPulses,
+		RelayRateWindow:     *relayWindow,
+		OpenHours:           *openHoursFlag,
+		SwipeKeepOpen:       *swipeKeepOpenFlag,
+	}
+}
+
+// doorConfigs returns the configs of all doors, the one set by the flags
+// first
+func doorConfigs() []doorConfig {
+	return append([]doorConfig{mainDoorConfig()}, extraDoors...)
+}
+
+// parseReaders returns the readers of the door
+func (c doorConfig) parseReaders() ([]reader.Reader, error) {
+	desfire, err := desfireConfig()
+	if err != nil {
+		return nil, err
+	}
+	framing, err := reader.ParseFraming(*serialFraming, *serialBaud, *serialParity)
+	if err != nil {
+		return nil, err
+	}
+	ping, err := hex.DecodeString(*serialPing)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serial-ping: %w", err)
+	}
+	var readers []reader.Reader
+	switch {
+	case c.Name == *doorName:
+		readers, err = reader.Parse(*readersFlag, *port, *serialMaxFailures, framing, desfire)
+	case c.Readers == "":
+		return nil, fmt.Errorf("door %s has no readers", c.Name)
+	default:
+		readers, err = reader.Parse(c.Readers, "", *serialMaxFailures, framing, desfire)
+	}
+	for _, r := range readers {
+		if s, ok := r.(*reader.Serial); ok {
+			s.Keepalive, s.Ping, s.PingReply = *serialKeepalive, ping, *serialPingReply
+		}
+	}
+	return readers, err
+}
+
+// gpioConfig returns the pin mapping of the door
+func (c doorConfig) gpioConfig() actuator.GPIOConfig {
+	cfg := actuator.GPIOConfig{
+		Open:   actuator.Pin{Number: c.OpenPin, ActiveLow: c.OpenPinActiveLow},
+		Close:  actuator.Pin{Number: c.ClosePin, ActiveLow: c.ClosePinActiveLow},
+		Timing: c.timing(),
+	}
+	if c.hasStatusPins() {
+		cfg.Status0 = &actuator.Pin{Number: c.StatusPin0, ActiveLow: c.StatusPin0ActiveLow}
+		cfg.Status1 = &actuator.Pin{Number: c.StatusPin1, ActiveLow: c.StatusPin1ActiveLow}
+	}
+	return cfg
+}
+
+// timing returns the relay timing of the door. The open and close pulse
+// default to the pulse
+func (c doorConfig) timing() actuator.Timing {
+	t := actuator.Timing{
+		OpenPulse:   c.OpenPulse,
+		ClosePulse:  c.ClosePulse,
+		Gap:         c.PulseGap,
+		Profile:     actuator.Profile(c.Actuation),
+		Pause:       c.PulsePause,
+		HoldTimeout: c.HoldTimeout,
+		Cooldown:    c.RelayCooldown,
+		MaxPulses:   c.RelayMaxPulses,
+		RateWindow:  c.RelayRateWindow,
+		Clock:       clk,
+	}
+	if t.OpenPulse == 0 {
+		t.OpenPulse = c.Pulse
+	}
+	if t.ClosePulse == 0 {
+		t.ClosePulse = c.Pulse
+	}
+	return t
+}
+
+func (c doorConfig) hasStatusPins() bool {
+	return c.StatusPin0 >= 0 && c.StatusPin1 >= 0
+}
+
+// validate checks the options of the door apart from its GPIOs, which are
+// checked for all doors together
+func (c doorConfig) validate() error {
+	switch {
+	case c.Name == "" || strings.ContainsAny(c.Name, "/ ?#%"):
+		return fmt.Errorf("invalid door name %q", c.Name)
+	case c.Name == "ws" || c.Name == "events":
+		return fmt.Errorf("door name %s is reserved", c.Name)
+	case (c.StatusPin0 < 0) != (c.StatusPin1 < 0):
+		return fmt.Errorf("status-pin0 and status-pin1 of door %s must be set together", c.Name)
+	case c.AutoLock < 0 || c.AutoLockCountdown < 0:
+		return fmt.Errorf("auto-lock and auto-lock-countdown of door %s must not be negative", c.Name)
+	case c.HeldOpenAlarm < 0:
+		return fmt.Errorf("held-open-alarm of door %s must not be negative", c.Name)
+	case c.Pulse <= 0:
+		return fmt.Errorf("pulse of door %s must be positive", c.Name)
+	case c.OpenPulse < 0 || c.ClosePulse < 0 || c.PulseGap < 0:
+		return fmt.Errorf("open-pulse, close-pulse and pulse-gap of door %s must not be negative", c.Name)
+	case c.PulsePause < 0 || c.HoldTimeout <= 0:
+		return fmt.Errorf("pulse-pause of door %s must not be negative and hold-timeout must be positive", c.Name)
+	case c.RelayCooldown < 0 || c.RelayMaxPulses < 0:
+		return fmt.Errorf("relay-cooldown and relay-max-pulses of door %s must not be negative", c.Name)
+	case c.RelayMaxPulses > 0 && c.RelayRateWindow <= 0:
+		return fmt.Errorf("relay-rate-window of door %s must be positive", c.Name)
+	}
+	profile, err := actuator.ParseProfile(c.Actuation)
+	if err != nil {
+		return fmt.Errorf("door %s: %w", c.Name, err)
+	}
+	if profile == actuator.ProfileHoldUntilStatus && !c.hasStatusPins() {
+		return fmt.Errorf("actuation hold-until-status of door %s requires status-pin0 and status-pin1", c.Name)
+	}
+	if _, err := store.ParseSchedule(c.OpenHours); err != nil {
+		return fmt.Errorf("open-hours of door %s: %w", c.Name, err)
+	}
+	if _, err := store.ParseSchedule(c.SwipeKeepOpen); err != nil {
+		return fmt.Errorf("swipe-keep-open of door %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// door is a sphincter and the readers next to it
+type door struct {
+	name string
+	*actuator.Watched
+	autoLock *actuator.AutoLocker
+	heldOpen *actuator.HeldOpenAlarm
+	// openHours is nil unless the door is open to the public at times, and
+	// swipeKeepOpen unless swipes keep it open at times
+	openHours     *openHours
+	swipeKeepOpen *store.Schedule
+	readers       []reader.Reader
+	feedback      []feedback.Output
+	// exitButton is nil unless an exit button is wired up
+	exitButton *button.Button
+	// sensor is nil unless a door sensor is wired up, and latch unless its
+	// alarm is enabled as well
+	sensor *doorsensor.Sensor
+	latch  *doorsensor.LatchAlarm
+	// polled is set if the status inputs are wired up and polled
+	polled bool
+	// commands queues the remote commands
+	commands *commandQueue
+}
+
+// openDoor sets up the actuator and the auto-lock of the door configured by
+// c. Its readers are parsed, but not opened
+func openDoor(c doorConfig) (*door, error) {
+	var gpio actuator.DoorActuator
+	if *simulate {
+		gpio = actuator.NewSimulated(c.timing())
+	} else if *dryRun {
+		gpio = actuator.NewDryRun(c.timing())
+	} else {
+		var err error
+		if *gpioBackend == "gpiod" {
+			gpio, err = actuator.NewGPIOD(*gpioChip, c.gpioConfig())
+		} else {
+			gpio, err = actuator.NewGPIO(c.gpioConfig())
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.hasStatusPins() && *verifyTimeout > 0 {
+			gpio = &actuator.Verified{DoorActuator: gpio, Timeout: *verifyTimeout, Clock: clk}
+		}
+	}
+
+	d := &door{name: c.Name, Watched: &actuator.Watched{DoorActuator: gpio, Debounce: *statusDebounce, Clock: clk}, polled: !*simulate && !*dryRun && c.hasStatusPins(), commands: newCommandQueue(c.Name, *commandQueueSize)}
+	setStatusMetric(d.name, d.Status())
+	d.OnChange(func(status actuator.Status) {
+		setStatusMetric(d.name, status)
+		publishState(d, status)
+	})
+
+	if c.AutoLock > 0 {
+		d.autoLock = actuator.NewAutoLocker(d, c.AutoLock, c.AutoLockCountdown, func(err error) {
+			event := events.Access{Source: "auto-lock", Door: d.name, Action: "close", Result: events.ResultGranted}
+			if err != nil {
+				event.Result = events.ResultFailure
+				event.Reason = err.Error()
+			}
+			recordAccess(event)
+		})
+		d.autoLock.Clock = clk
+		d.autoLock.OnCountdown(func(remaining time.Duration) { countDownAutoLock(d, remaining) })
+	}
+
+	// Without status pins, the status is the one last driven, so the door
+	// can't be known to be held open
+	if c.HeldOpenAlarm > 0 && c.hasStatusPins() {
+		d.heldOpen = actuator.NewHeldOpenAlarm(c.HeldOpenAlarm)
+		d.heldOpen.Clock = clk
+		d.heldOpen.OnAlarm(func(alarmed bool) { alarmHeldOpen(d, alarmed, c.HeldOpenAlarm) })
+		d.OnChange(d.heldOpen.Changed)
+		d.heldOpen.Changed(d.Status())
+	}
+
+	var err error
+	if d.openHours, err = newOpenHours(d, c.OpenHours); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(c.SwipeKeepOpen) != "" {
+		schedule, err := store.ParseSchedule(c.SwipeKeepOpen)
+		if err != nil {
+			return nil, err
+		}
+		d.swipeKeepOpen = &schedule
+	}
+
+	if c.ExitButton >= 0 {
+		d.exitButton = &button.Button{Pin: c.ExitButton, ActiveLow: c.ExitButtonActiveLow, Debounce: *exitButtonDebounce}
+	}
+
+	if c.DoorSensor >= 0 {
+		d.sensor = &doorsensor.Sensor{Pin: c.DoorSensor, ActiveLow: c.DoorSensorActiveLow, Debounce: *doorSensorDebounce}
+		if *latchAlarmDelay > 0 {
+			d.latch = doorsensor.NewLatchAlarm(*latchAlarmDelay)
+			d.latch.Clock = clk
+			d.latch.OnAlarm(func(alarmed bool) { alarmLatch(d, alarmed) })
+			d.OnChange(d.latch.Changed)
+			d.latch.Changed(d.Status())
+		}
+		d.sensor.OnChange(func(p doorsensor.Position) {
+			setPositionMetric(d.name, p)
+			publishPosition(d, p)
+			d.latch.Moved(p)
+		})
+	}
+
+	// Readers are parsed when simulating as well, so simulated swipes can be
+	// attributed to them
+	if d.readers, err = c.parseReaders(); err != nil {
+		return nil, err
+	}
+	if d.feedback, err = c.parseFeedback(); err != nil {
+		return nil, err
+	}
+	for _, o := range d.feedback {
+		if s, ok := o.(*feedback.Serial); ok {
+			s.Send = d.sendToReader
+		}
+	}
+	return d, nil
+}
+
+// countDownAutoLock publishes the seconds remaining until d is auto-locked,
+// and beeps to warn people in the door
+func countDownAutoLock(d *door, remaining time.Duration) {
+	seconds := int(remaining.Seconds())
+	e := events.Event{Type: events.TypeCountdown, Door: d.name, Remaining: seconds}
+	if seconds > 0 {
+		e.Message = fmt.Sprintf("Door %s locks in %ds", d.name, seconds)
+	}
+	hub.Publish(e)
+	if !*autoLockBeep || seconds == 0 {
+		return
+	}
+	for _, out := range d.feedback {
+		for _, r := range d.readers {
+			out.Signal(feedback.Warning, readerLabel(r))
+		}
+	}
+}
+
+// alarmHeldOpen reports that d stayed unlocked for longer than limit, or
+// that it isn't unlocked any more after the alarm
+func alarmHeldOpen(d *door, alarmed bool, limit time.Duration) {
+	if !alarmed {
+		slog.Info("Door no longer held open", "door", d.name, "status", d.Status().String())
+		hub.Publish(events.Event{Type: events.TypeAlert, Door: d.name, Message: fmt.Sprintf("Door %s is no longer held open", d.name)})
+		return
+	}
+	slog.Warn("Door held open", "door", d.name, "limit", limit)
+	hub.Publish(events.Event{Type: events.TypeAlert, Door: d.name, Message: fmt.Sprintf("Door %s held open for more than %v", d.name, limit)})
+	// Sound the error signal at every reader; buzzers shared by the readers
+	// drop the repeated signals
+	for _, out := range d.feedback {
+		for _, r := range d.readers {
+			out.Signal(feedback.Error, readerLabel(r))
+		}
+	}
+}
+
+// publishPosition publishes that d was opened or closed
+func publishPosition(d *door, p doorsensor.Position) {
+	slog.Debug("Door moved", "door", d.name, "position", p.String(), "status", d.Status().String())
+	hub.Publish(events.Event{Type: events.TypePosition, Door: d.name, Status: d.Status().String(), Position: p.String()})
+}
+
+// alarmLatch reports that d is open while locked, or that it isn't any
+// more after the alarm
+func alarmLatch(d *door, alarmed bool) {
+	if !alarmed {
+		slog.Info("Door no longer open while locked", "door", d.name, "status", d.Status().String())
+		hub.Publish(events.Event{Type: events.TypeAlert, Door: d.name, Message: fmt.Sprintf("Door %s is no longer open while locked", d.name)})
+		return
+	}
+	slog.Warn("Door open while locked, the latch may have failed", "door", d.name)
+	hub.Publish(events.Event{Type: events.TypeAlert, Door: d.name, Message: fmt.Sprintf("Door %s is open while locked, the latch may have failed", d.name)})
+	for _, out := range d.feedback {
+		for _, r := range d.readers {
+			out.Signal(feedback.Error, readerLabel(r))
+		}
+	}
+}
+
+// position returns the position of d, or "" without a door sensor
+func (d *door) position() string {
+	if d.sensor == nil {
+		return ""
+	}
+	return d.sensor.Position().String()
+}
+
+// doorByName returns the door called name, or nil
+func doorByName(name string) *door {
+	for _, d := range doors {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// doorOfReader returns the door the reader labeled label belongs to. Swipes
+// of other readers, e.g. simulated ones, are for the first door
+func doorOfReader(label string) *door {
+	for _, d := range doors {
+		for _, r := range d.readers {
+			if readerLabel(r) == label {
+				return d
+			}
+		}
+	}
+	return doors[0]
+}
+
+// readerLabel returns the label swipes of r are attributed to
+func readerLabel(r reader.Reader) string {
+	switch r := r.(type) {
+	case *reader.Serial:
+		return r.Label
+	case *reader.Wiegand:
+		return r.Label
+	case *reader.PCSC:
+		return r.Label
+	}
+	return ""
+}
+
+// health reports the GPIO and the readers of all doors for /healthz
+func health() map[string]httpapi.Check {
+	checks := map[string]httpapi.Check{}
+	switch {
+	case *simulate:
+		checks["gpio"] = httpapi.Check{OK: true, Detail: "simulated"}
+	case *dryRun:
+		checks["gpio"] = httpapi.Check{OK: true, Detail: "dry run"}
+	default:
+		checks["gpio"] = gpioHealth()
+	}
+	if syncClient != nil {
+		checks["sync"] = syncHealth()
+	}
+	if *simulate {
+		// The readers aren't opened
+		return checks
+	}
+	for _, d := range doors {
+		for _, r := range d.readers {
+			name := readerLabel(r)
+			if name == "" {
+				name = r.String()
+			}
+			c := httpapi.Check{OK: true}
+			if s, ok := r.(*reader.Serial); ok {
+				if err := s.Check(); err != nil {
+					c = httpapi.Check{Detail: err.Error()}
+				}
+			}
+			checks["reader:"+name] = c
+		}
+	}
+	return checks
+}
+
+// gpioHealth reads back the relay outputs of all doors. The GPIO is unknown
+// if no actuator can read them back
+func gpioHealth() httpapi.Check {
+	checked := false
+	for _, d := range doors {
+		c, ok := inputs(d).(interface{ Check() error })
+		if !ok {
+			continue
+		}
+		if err := c.Check(); err != nil {
+			return httpapi.Check{Detail: fmt.Sprintf("door %s: %v", d.name, err)}
+		}
+		checked = true
+	}
+	if !checked {
+		return httpapi.Check{OK: true, Detail: "unknown"}
+	}
+	return httpapi.Check{OK: true, Detail: "relays inactive"}
+}
+
+// httpDoors returns the doors as exposed by the HTTP API
+func httpDoors() []httpapi.Door {
+	var list []httpapi.Door
+	for _, d := range doors {
+		list = append(list, httpapi.Door{Name: d.name, Actuator: d.Watched, Sensor: d.sensor})
+	}
+	return list
+}
+
+// releaseDoors stops the auto-lock timers and releases the GPIO of all doors
+func releaseDoors() {
+	for _, d := range doors {
+		d.autoLock.Disarm()
+		if err := d.Release(); err != nil {
+			slog.Warn("Could not release GPIO", "door", d.name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+import "flag"
+
+var doubleSwipe = flag.Duration("double-swipe", 0, "swiping a token again within this interval after it unlocked a door locks the door instead, e.g. 3s (disabled if 0)")
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/craftamap/wishbone/internal/httpapi"
+)
+
+// captured is a token swiped while enrolling, and the reader it was swiped at
+type captured struct {
+	token, reader string
+}
+
+// enrollment hands the next unknown token swiped to an admin enrolling a
+// card, instead of rejecting it. Only one enrollment runs at a time
+type enrollment struct {
+	mu sync.Mutex
+	// waiting is nil unless an enrollment is running
+	waiting chan captured
+	// door restricts the enrollment to one door, unless it is empty
+	door string
+}
+
+var enroller enrollment
+
+// Capture waits for the next unknown token swiped at door, or at any door if
+// it is empty, until ctx is done
+func (e *enrollment) Capture(ctx context.Context, door string) (token, reader string, err error) {
+	e.mu.Lock()
+	if e.waiting != nil {
+		e.mu.Unlock()
+		return "", "", httpapi.ErrEnrollmentRunning
+	}
+	waiting := make(chan captured, 1)
+	e.waiting, e.door = waiting, door
+	e.mu.Unlock()
+
+	select {
+	case c := <-waiting:
+		return c.token, c.reader, nil
+	case <-ctx.Done():
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.waiting == waiting {
+		e.waiting = nil
+		return "", "", ctx.Err()
+	}
+	// The token was offered just now
+	c := <-waiting
+	return c.token, c.reader, nil
+}
+
+// offer hands token to the running enrollment, if any, and reports whether
+// it was taken
+func (e *enrollment) offer(d *door, token, reader string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.waiting == nil || e.door != "" && e.door != d.name {
+		return false
+	}
+	e.waiting <- captured{token: token, reader: reader}
+	e.waiting = nil
+	return true
+}
+
+// enrollFunc returns how the admin API enrolls cards, or nil with LDAP, as
+// cards can't be added to the directory from here
+func enrollFunc() func(context.Context, string) (string, string, error) {
+	if *ldapURL != "" {
+		return nil
+	}
+	return enroller.Capture
+}
+
+// adminTokenEnv holds the admin token the enroll command authenticates with
+const adminTokenEnv = "WISHBONE_ADMIN_TOKEN"
+
+// localAPI returns the URL of the HTTP API of the daemon on this host, at the
+// first address of -listen, and the client to reach it with
+func localAPI() (string, *http.Client) {
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
+	addrs := httpapi.SplitAddrs(*listen)
+	if len(addrs) == 0 {
+		return "", http.DefaultClient
+	}
+	if path, ok := httpapi.SocketPath(addrs[0]); ok {
+		dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		return scheme + "://localhost" + *pathPrefix, &http.Client{Transport: &http.Transport{DialContext: dial}}
+	}
+	host, port, err := net.SplitHostPort(addrs[0])
+	if err != nil {
+		return "", http.DefaultClient
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + *pathPrefix, http.DefaultClient
+}
+
+// userEnroll asks the running daemon to add the next unknown card swiped
+// for a user, and prints its token
+func userEnroll(args []string) {
+	fs := flag.NewFlagSet("user enroll", flag.ExitOnError)
+	fs.Usage = usage
+	schedule := fs.String("schedule", "", "time windows like in list.txt, e.g. \"weekdays 08:00-20:00\" (unrestricted if empty)")
+	expires := fs.String("expires", "", "first day the token no longer works, as YYYY-MM-DD (never if empty)")
+	pin := fs.String("pin", "", "PIN to enter on the keypad after swiping, stored hashed")
+	groups := fs.String("groups", "", "comma separated groups of the user, e.g. members,keyholders")
+	hash := fs.Bool("hash", false, "store a salted hash of the token instead of the token")
+	door := fs.String("door", "", "only take cards swiped at this door (any door if empty)")
+	url := fs.String("url", "", "URL of the daemon's HTTP API (the first address of -listen if empty)")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	token := os.Getenv(adminTokenEnv)
+	if token == "" {
+		fatal("Admin token missing", "env", adminTokenEnv)
+	}
+	client := http.DefaultClient
+	if *url == "" {
+		*url, client = localAPI()
+	}
+	if *url == "" {
+		fatal("Daemon URL missing, set -listen or pass -url")
+	}
+
+	req := map[string]interface{}{
+		"user":     strings.Join(fs.Args(), " "),
+		"schedule": *schedule,
+		"expires":  *expires,
+		"pin":      *pin,
+		"groups":   strings.Split(*groups, ","),
+		"hash":     *hash,
+		"door":     *door,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		fatal("Could not encode request", "err", err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*url, "/")+"/api/v1/enroll", bytes.NewReader(body))
+	if err != nil {
+		fatal("Invalid URL", "url", *url, "err", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	fmt.Fprintln(os.Stderr, "Swipe the card within a minute")
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		fatal("Could not reach daemon", "url", *url, "err", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		fatal("Could not enroll card", "status", resp.Status, "err", strings.TrimSpace(string(msg)))
+	}
+	var enrolled struct {
+		Token  string `json:"token"`
+		Reader string `json:"reader"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&enrolled); err != nil {
+		fatal("Invalid response", "err", err)
+	}
+	slog.Info("Enrolled card", "user", req["user"], "reader", enrolled.Reader)
+	fmt.Println(enrolled.Token)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+var (
+	eventDBPath    = flag.String("event-db", "", "SQLite database the event history is kept in across restarts, e.g. /var/lib/wishbone/events.db (in memory only, limited to -history-size, if empty)")
+	eventRetention = flag.Duration("event-retention", 90*24*time.Hour, "remove events older than this from -event-db every hour (never if 0)")
+
+	// eventDB keeps the event history; nil if disabled. Set up in main
+	eventDB *events.DB
+)
+
+// openEventDB opens -event-db and has the hub keep its events in it
+func openEventDB(hub *events.Hub) (*events.DB, error) {
+	if *eventDBPath == "" {
+		return nil, nil
+	}
+	db, err := events.OpenDB(*eventDBPath, *eventRetention)
+	if err != nil {
+		return nil, err
+	}
+	if err := hub.Persist(db); err != nil {
+		return nil, err
+	}
+	slog.Info("Keeping events", "path", *eventDBPath, "retention", *eventRetention)
+	return db, nil
+}
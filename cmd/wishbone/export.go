@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// exportedUser is a credential as exported, with the token and PIN as
+// stored, so hashes stay hashes
+type exportedUser struct {
+	Token    string   `json:"token"`
+	User     string   `json:"user"`
+	Groups   []string `json:"groups,omitempty"`
+	Schedule string   `json:"schedule,omitempty"`
+	// Expires is a date like 2027-01-01
+	Expires  string `json:"expires,omitempty"`
+	PIN      string `json:"pin,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// csvHeader names the columns of exported CSV files. Groups are separated by
+// commas within their column
+var csvHeader = []string{"token", "user", "groups", "schedule", "expires", "pin", "disabled"}
+
+// transferFormat returns the format given by -format, or by the extension of
+// path, defaulting to JSON
+func transferFormat(format, path string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	switch format {
+	case "", "json":
+		return "json", nil
+	case "csv":
+		return "csv", nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected json or csv", format)
+}
+
+// exportCommand writes all credentials of the store to a file or stdout
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = usage
+	formatFlag := fs.String("format", "", "json or csv (by the file's extension if empty, json for stdout)")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		usage()
+		os.Exit(2)
+	}
+	format, err := transferFormat(*formatFlag, fs.Arg(0))
+	if err != nil {
+		fatal("Invalid format", "err", err)
+	}
+	setupCommand()
+	defer remoteLog.Close()
+	creds, err := openEditableStore()
+	if err != nil {
+		fatal("Could not open credential store", "err", err)
+	}
+	list, err := creds.List()
+	if err != nil {
+		fatal("Could not list tokens", "err", err)
+	}
+
+	var users []exportedUser
+	for _, c := range list {
+		u := exportedUser{Token: c.Token, User: c.User, Groups: c.Groups, Schedule: c.Schedule, PIN: c.PIN, Disabled: c.Disabled}
+		if !c.Expires.IsZero() {
+			u.Expires = c.Expires.Format("2006-01-02")
+		}
+		users = append(users, u)
+	}
+
+	out := os.Stdout
+	if fs.NArg() == 1 {
+		// Credentials, so only readable by the owner
+		if out, err = os.OpenFile(fs.Arg(0), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+			fatal("Could not create export", "err", err)
+		}
+	}
+	if format == "csv" {
+		err = writeExportCSV(out, users)
+	} else {
+		err = writeExportJSON(out, users)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fatal("Could not write export", "err", err)
+	}
+	slog.Info("Exported tokens", "tokens", len(users))
+}
+
+func writeExportJSON(w io.Writer, users []exportedUser) error {
+	if users == nil {
+		users = []exportedUser{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(users)
+}
+
+func writeExportCSV(w io.Writer, users []exportedUser) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, u := range users {
+		disabled := ""
+		if u.Disabled {
+			disabled = "true"
+		}
+		cw.Write([]string{u.Token, u.User, strings.Join(u.Groups, ","), u.Schedule, u.Expires, u.PIN, disabled})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// importCommand adds the credentials of an export to the store. All of them
+// are checked before any is added
+func importCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = usage
+	formatFlag := fs.String("format", "", "json or csv (by the file's extension if empty, json for stdin)")
+	overwrite := fs.Bool("overwrite", false, "replace tokens that are already in the store instead of skipping them")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		usage()
+		os.Exit(2)
+	}
+	format, err := transferFormat(*formatFlag, fs.Arg(0))
+	if err != nil {
+		fatal("Invalid format", "err", err)
+	}
+	setupCommand()
+	defer remoteLog.Close()
+
+	in := os.Stdin
+	if fs.NArg() == 1 {
+		if in, err = os.Open(fs.Arg(0)); err != nil {
+			fatal("Could not open import", "err", err)
+		}
+		defer in.Close()
+	}
+	var users []exportedUser
+	if format == "csv" {
+		users, err = readCSV(in)
+	} else {
+		err = json.NewDecoder(in).Decode(&users)
+	}
+	if err != nil {
+		fatal("Could not read import", "err", err)
+	}
+	var list []store.Credential
+	for i, u := range users {
+		c, err := u.credential()
+		if err != nil {
+			fatal("Invalid token", "entry", i+1, "user", u.User, "err", err)
+		}
+		list = append(list, c)
+	}
+
+	creds, err := openEditableStore()
+	if err != nil {
+		fatal("Could not open credential store", "err", err)
+	}
+	var added, replaced, skipped int
+	for _, c := range list {
+		// list.txt has no way to disable a token, which would enable it
+		if c.Disabled && storeKind() == "file" {
+			slog.Warn("Skipping disabled token, the user list can't disable tokens", "user", c.User)
+			skipped++
+			continue
+		}
+		err := creds.Add(c)
+		if err == store.ErrTokenExists && *overwrite {
+			if err = creds.Remove(c.Token); err == nil {
+				if err = creds.Add(c); err == nil {
+					replaced++
+					continue
+				}
+			}
+		}
+		switch {
+		case err == store.ErrTokenExists:
+			slog.Warn("Skipping token already in the store", "user", c.User)
+			skipped++
+		case err != nil:
+			fatal("Could not add token", "user", c.User, "err", err)
+		default:
+			added++
+		}
+	}
+	slog.Info("Imported tokens", "added", added, "replaced", replaced, "skipped", skipped)
+}
+
+// readCSV reads an export in CSV. The columns are found by the header, so
+// they may be in any order and all but token and user may be missing
+func readCSV(r io.Reader) ([]exportedUser, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"token", "user"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header lacks column %s", name)
+		}
+	}
+
+	var users []exportedUser
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return users, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		u := exportedUser{Token: field("token"), User: field("user"), Schedule: field("schedule"), Expires: field("expires"), PIN: field("pin")}
+		if groups := field("groups"); groups != "" {
+			u.Groups = strings.Split(groups, ",")
+		}
+		if disabled := field("disabled"); disabled != "" {
+			if u.Disabled, err = strconv.ParseBool(disabled); err != nil {
+				line, _ := cr.FieldPos(0)
+				return nil, fmt.Errorf("line %d: invalid disabled %q", line, disabled)
+			}
+		}
+		users = append(users, u)
+	}
+}
+
+// credential validates u like the admin API does. Plain text PINs are
+// hashed, hashes are kept
+func (u exportedUser) credential() (store.Credential, error) {
+	var expires time.Time
+	if u.Expires != "" {
+		var err error
+		if expires, err = time.ParseInLocation("2006-01-02", u.Expires, time.Local); err != nil {
+			return store.Credential{}, fmt.Errorf("invalid expiry date %q", u.Expires)
+		}
+	}
+	c, err := store.NewCredential(u.Token, u.User, u.Schedule, expires)
+	if err != nil {
+		return c, err
+	}
+	if c.Groups, err = store.ParseGroups(strings.Join(u.Groups, ",")); err != nil {
+		return c, err
+	}
+	switch {
+	case store.IsTokenHash(u.PIN):
+		c.PIN = u.PIN
+	case u.PIN != "":
+		if c.PIN, err = store.NewPINHash(u.PIN); err != nil {
+			return c, err
+		}
+	}
+	c.Disabled = u.Disabled
+	return c, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/feedback"
+	"github.com/craftamap/wishbone/internal/reader"
+)
+
+var feedbackSpec = flag.String("feedback", "", "comma separated outputs signaling the outcome of swipes: buzzer:PIN, led:GREEN:RED (BCM pins) or serial:GRANTED:DENIED:ERROR with hex encoded reader commands (disabled if empty)")
+
+// parseFeedback returns the feedback outputs of the door. When simulating or
+// in a dry run, outcomes are logged instead
+func (c doorConfig) parseFeedback() ([]feedback.Output, error) {
+	if c.Feedback == "" {
+		return nil, nil
+	}
+	outputs, err := feedback.Parse(c.Feedback)
+	if err != nil || !*simulate && !*dryRun {
+		return outputs, err
+	}
+	return []feedback.Output{feedback.Log{}}, nil
+}
+
+// sendToReader writes p to the serial reader labeled label
+func (d *door) sendToReader(label string, p []byte) error {
+	for _, r := range d.readers {
+		if s, ok := r.(*reader.Serial); ok && s.Label == label {
+			return s.Send(p)
+		}
+	}
+	return fmt.Errorf("no serial reader %q at door %s", label, d.name)
+}
+
+// signalOutcome shows the result of a swipe on the feedback outputs of its
+// door, unless a rule gives feedback for it instead
+func signalOutcome(e events.Access) {
+	if e.Source != "rfid" || ruleSignals(e) {
+		return
+	}
+	d := doorByName(e.Door)
+	if d == nil {
+		return
+	}
+	o := feedback.Error
+	switch e.Result {
+	case events.ResultGranted:
+		o = feedback.Granted
+	case events.ResultDenied, events.ResultUnknown:
+		o = feedback.Denied
+	}
+	for _, out := range d.feedback {
+		out.Signal(o, e.Reader)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/craftamap/wishbone/internal/grpcapi"
+)
+
+var (
+	grpcListen      = flag.String("grpc-listen", "", "address to serve the gRPC API on, e.g. :8002 (disabled if empty)")
+	grpcTLSCert     = flag.String("grpc-tls-cert", "", "certificate to serve the gRPC API with")
+	grpcTLSKey      = flag.String("grpc-tls-key", "", "private key of -grpc-tls-cert")
+	grpcTLSClientCA = flag.String("grpc-client-ca", "", "CA signing the certificates of gRPC clients; their common name is recorded as user")
+)
+
+func grpcConfig() grpcapi.Config {
+	return grpcapi.Config{
+		Addr:        *grpcListen,
+		TLSCert:     *grpcTLSCert,
+		TLSKey:      *grpcTLSKey,
+		TLSClientCA: *grpcTLSClientCA,
+	}
+}
+
+// grpcDoors returns the doors as exposed by the gRPC API
+func grpcDoors() []grpcapi.Door {
+	var list []grpcapi.Door
+	for _, d := range doors {
+		list = append(list, grpcapi.Door{Name: d.name, Actuator: d.Watched})
+	}
+	return list
+}
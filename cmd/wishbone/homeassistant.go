@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	haDiscovery       = flag.Bool("ha-discovery", false, "publish Home Assistant MQTT discovery messages")
+	haDiscoveryPrefix = flag.String("ha-discovery-prefix", "homeassistant", "Home Assistant discovery topic prefix")
+	haNodeID          = flag.String("ha-node-id", "wishbone", "unique id of the sphincter in Home Assistant")
+)
+
+// publishHomeAssistantDiscovery announces the sphincter as a lock entity, see
+// https://www.home-assistant.io/integrations/lock.mqtt/
+func publishHomeAssistantDiscovery(client mqtt.Client) {
+	config := map[string]interface{}{
+		"name":                  nil,
+		"unique_id":             *haNodeID,
+		"state_topic":           *mqttStateTopic,
+		"command_topic":         *mqttCommandTopic,
+		"availability_topic":    *mqttAvailTopic,
+		"payload_lock":          "close",
+		"payload_unlock":        "open",
+		"payload_open":          "keep-open",
+		"state_locked":          "LOCKED",
+		"state_unlocked":        "UNLOCKED",
+		"state_jammed":          actuator.StatusFailure.String(),
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"device": map[string]interface{}{
+			"identifiers":  []string{*haNodeID},
+			"name":         "Sphincter",
+			"manufacturer": "wishbone",
+		},
+	}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		slog.Error("Could not encode Home Assistant discovery", "err", err)
+		return
+	}
+	publishMQTT(client, *haDiscoveryPrefix+"/lock/"+*haNodeID+"/config", true, payload)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/httpapi"
+)
+
+var (
+	listen          = flag.String("listen", "", "comma separated addresses to serve HTTP on, e.g. 127.0.0.1:8001, [::1]:8001, eth0:8001 for the addresses of an interface or unix:/run/wishbone/http.sock (disabled if empty)")
+	tlsCert         = flag.String("tls-cert", "", "certificate to serve HTTPS with (requires -tls-key)")
+	tlsKey          = flag.String("tls-key", "", "private key of -tls-cert")
+	tlsClientCA     = flag.String("tls-client-ca", "", "require clients to present a certificate signed by this CA")
+	adminTokensPath = flag.String("admin-tokens", "", "file with API tokens for the admin API, one \"token name\" per line (admin API disabled if empty)")
+	apiKeysPath     = flag.String("api-keys", "", "file with API keys, one \"key scopes limit name\" per line, e.g. \"s3cret state:read,events:read 60/m status display\" (disabled if empty)")
+	privateReads    = flag.Bool("private-reads", false, "require an API key with state:read or events:read for the status and event endpoints")
+	trustedProxies  = flag.String("trusted-proxies", "", "comma separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For and X-Real-IP headers name the client, e.g. 127.0.0.1,::1")
+	pathPrefix      = flag.String("path-prefix", "", "path prefix a reverse proxy serves the HTTP API under, e.g. /door")
+	legacyAPI       = flag.Bool("legacy-api", false, "serve state, open and close on /sphincter/?action= in plain text for clients of the Python sphincter, shaped by legacy-profile in the config file")
+
+	oidcIssuer       = flag.String("oidc-issuer", "", "URL of an OpenID Connect provider admins may log in to the dashboard with, e.g. https://sso.example.org/realms/space (disabled if empty)")
+	oidcClientID     = flag.String("oidc-client-id", "", "client ID of wishbone at -oidc-issuer")
+	oidcClientSecret = flag.String("oidc-client-secret", "", "client secret of wishbone at -oidc-issuer, or env:NAME or secret:NAME (a public client if empty)")
+	oidcRedirectURL  = flag.String("oidc-redirect-url", "", "URL of /dashboard/oidc/callback as browsers reach it, e.g. https://door.example.org/dashboard/oidc/callback")
+	oidcScopes       = flag.String("oidc-scopes", "profile", "comma separated scopes requested besides openid")
+	oidcNameClaim    = flag.String("oidc-name-claim", "preferred_username", "claim naming admins in the logs")
+	oidcGroupsClaim  = flag.String("oidc-groups-claim", "groups", "claim listing the groups of admins")
+	oidcAdminGroups  = flag.String("oidc-admin-groups", "", "comma separated groups at -oidc-issuer whose members are admins")
+
+	spaceAPISpace   = flag.String("spaceapi-space", "", "name of the space in the SpaceAPI document (/spaceapi.json disabled if empty)")
+	spaceAPILogo    = flag.String("spaceapi-logo", "", "URL of the space's logo")
+	spaceAPIURL     = flag.String("spaceapi-url", "", "URL of the space's website")
+	spaceAPIAddress = flag.String("spaceapi-address", "", "postal address of the space")
+	spaceAPILat     = flag.Float64("spaceapi-lat", 0, "latitude of the space")
+	spaceAPILon     = flag.Float64("spaceapi-lon", 0, "longitude of the space")
+	spaceAPIEmail   = flag.String("spaceapi-email", "", "contact email address of the space")
+
+	// legacyProfile is the legacy-profile of the config file, on top of the
+	// default one
+	legacyProfile = httpapi.DefaultLegacyProfile()
+)
+
+func httpConfig() httpapi.Config {
+	var legacy *httpapi.LegacyProfile
+	if *legacyAPI {
+		legacy = &legacyProfile
+	}
+	return httpapi.Config{
+		Addr:            *listen,
+		TLSCert:         *tlsCert,
+		TLSKey:          *tlsKey,
+		TLSClientCA:     *tlsClientCA,
+		AdminTokens:     *adminTokensPath,
+		APIKeys:         *apiKeysPath,
+		PrivateReads:    *privateReads,
+		Simulate:        *simulate,
+		CodeMaxValidity: *codeMaxValidity,
+		TrustedProxies:  *trustedProxies,
+		PathPrefix:      *pathPrefix,
+		LegacyAPI:       legacy,
+		OIDC: httpapi.OIDCConfig{
+			Issuer:       *oidcIssuer,
+			ClientID:     *oidcClientID,
+			ClientSecret: *oidcClientSecret,
+			RedirectURL:  *oidcRedirectURL,
+			Scopes:       commaList(*oidcScopes),
+			NameClaim:    *oidcNameClaim,
+			GroupsClaim:  *oidcGroupsClaim,
+			AdminGroups:  commaList(*oidcAdminGroups),
+		},
+		SpaceAPI: httpapi.SpaceAPI{
+			Space:   *spaceAPISpace,
+			Logo:    *spaceAPILogo,
+			URL:     *spaceAPIURL,
+			Address: *spaceAPIAddress,
+			Lat:     *spaceAPILat,
+			Lon:     *spaceAPILon,
+			Email:   *spaceAPIEmail,
+		},
+	}
+}
+
+// commaList splits a comma separated list, skipping empty elements. Unlike
+// store groups, the groups of an identity provider may contain spaces
+func commaList(text string) []string {
+	var list []string
+	for _, s := range strings.Split(text, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/latency"
+)
+
+var (
+	slowUnlock    = flag.Duration("slow-unlock", time.Second, "log a warning with the time of every stage for swipes taking longer from reading the token to opening (never if 0)")
+	latencyTraces = flag.Int("latency-traces", 100, "number of recent swipes whose timing /api/v1/debug/latency reports (disabled if 0)")
+)
+
+// latencies keeps the timing of the recent swipes; nil if disabled
+var latencies *latency.Recorder
+
+// recordLatency keeps the timing of a handled swipe, exports it as metrics
+// and warns if it was slow
+func recordLatency(t *latency.Trace) {
+	latencies.Record(*t)
+	observeLatency(t)
+	total := t.Stages[latency.Total]
+	if *slowUnlock <= 0 || total < *slowUnlock {
+		return
+	}
+	args := []any{"door", t.Door, "reader", t.Reader, "store", t.Store, "result", t.Result}
+	for _, stage := range latency.Stages {
+		if d, ok := t.Stages[stage]; ok {
+			args = append(args, stage.String(), d.Round(time.Millisecond))
+		}
+	}
+	slog.Warn("Slow swipe", args...)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	ldapURL          = flag.String("ldap-url", "", "LDAP server to look up tokens in, e.g. ldaps://ldap.example.org; replaces -list, which becomes the fallback, if set")
+	ldapBindDN       = flag.String("ldap-bind-dn", "", "DN to bind as (anonymous if empty)")
+	ldapBindPassword = flag.String("ldap-bind-password", "", "password of -ldap-bind-dn, or env:NAME or secret:NAME to refer to it")
+	ldapBaseDN       = flag.String("ldap-base-dn", "", "DN to search members below, e.g. ou=members,dc=example,dc=org")
+	ldapFilter       = flag.String("ldap-filter", "(objectClass=inetOrgPerson)", "LDAP filter selecting the members allowed to unlock")
+	ldapTokenAttr    = flag.String("ldap-token-attr", "rfidToken", "attribute holding the tokens of a member")
+	ldapUserAttr     = flag.String("ldap-user-attr", "cn", "attribute holding the name of a member")
+	ldapScheduleAttr = flag.String("ldap-schedule-attr", "", "attribute holding an access schedule like in list.txt (unrestricted if empty)")
+	ldapGroupsAttr   = flag.String("ldap-groups-attr", "", "attribute holding the groups of a member, as names or DNs, e.g. memberOf (none if empty)")
+	ldapCacheTTL     = flag.Duration("ldap-cache-ttl", 5*time.Minute, "how long tokens found in LDAP are used without asking the server again")
+
+	offlineCachePath   = flag.String("offline-cache", "", "encrypted file keeping the tokens found in LDAP for when the server can't be reached, e.g. offline.cache (disabled if empty)")
+	offlineCacheKey    = flag.String("offline-cache-key", "", "file with the hex encoded 32 byte key of -offline-cache")
+	offlineCacheMaxAge = flag.Duration("offline-cache-max-age", 7*24*time.Hour, "how long a token is let in from the offline cache after LDAP last confirmed it")
+)
+
+// ldapConfig returns the LDAP store options set by the flags, opening the
+// offline cache if enabled
+func ldapConfig() (store.LDAPConfig, error) {
+	var offline *store.OfflineCache
+	if *offlineCachePath != "" {
+		var err error
+		if offline, err = store.OpenOfflineCache(*offlineCachePath, *offlineCacheKey, *offlineCacheMaxAge); err != nil {
+			return store.LDAPConfig{}, err
+		}
+	}
+	return store.LDAPConfig{
+		URL:          *ldapURL,
+		BindDN:       *ldapBindDN,
+		BindPassword: *ldapBindPassword,
+		BaseDN:       *ldapBaseDN,
+		Filter:       *ldapFilter,
+		TokenAttr:    *ldapTokenAttr,
+		UserAttr:     *ldapUserAttr,
+		ScheduleAttr: *ldapScheduleAttr,
+		GroupsAttr:   *ldapGroupsAttr,
+		CacheTTL:     *ldapCacheTTL,
+		Timeout:      5 * time.Second,
+		Offline:      offline,
+	}, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/lockdown"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	lockdownGroups = flag.String("lockdown-groups", "", "comma separated groups whose members may still unlock by token during a lockdown (nobody if empty)")
+	lockdownState  = flag.String("lockdown-state", "", "file keeping the engaged lockdowns across restarts, e.g. /var/lib/wishbone/lockdown.json (in memory only if empty)")
+)
+
+// lockdowns holds the engaged lockdowns
+var lockdowns *lockdown.State
+
+var errUnknownDoor = errors.New("unknown door")
+
+func openLockdowns() (*lockdown.State, error) {
+	groups, err := store.ParseGroups(*lockdownGroups)
+	if err != nil {
+		return nil, fmt.Errorf("lockdown-groups: %w", err)
+	}
+	s, err := lockdown.Open(*lockdownState, groups)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", *lockdownState, err)
+	}
+	for _, l := range s.List() {
+		slog.Warn("Lockdown still engaged", "door", lockdownScope(l.Door), "by", l.By, "since", l.Since)
+	}
+	return s, nil
+}
+
+// lockdownScope names the doors a lockdown of door applies to
+func lockdownScope(door string) string {
+	if door == "" {
+		return "all doors"
+	}
+	return door
+}
+
+// lockdownDoors returns the doors a lockdown of name applies to
+func lockdownDoors(name string) ([]*door, error) {
+	if name == "" {
+		return doors, nil
+	}
+	d := doorByName(name)
+	if d == nil {
+		return nil, errUnknownDoor
+	}
+	return []*door{d}, nil
+}
+
+// recordLockdown writes the engaging or lifting of a lockdown to the audit
+// log and syslog, and alerts the notification channels
+func recordLockdown(e events.Access, message string) {
+	e.Time = time.Now()
+	e.Result = events.ResultGranted
+	audit.Log(e)
+	sendAccess(e)
+	hub.Publish(events.Event{Type: events.TypeAlert, Time: e.Time, Door: e.Door, Message: message})
+}
+
+// engageLockdown locks down the door called name, or all doors if it is
+// empty, and closes them. The lockdown stays engaged if a door can't be
+// closed, as unlocks are refused anyway
+func engageLockdown(ctx context.Context, name, source, by, reason string) error {
+	targets, err := lockdownDoors(name)
+	if err != nil {
+		return err
+	}
+	who := by
+	if who == "" {
+		who = source
+	}
+	l := lockdown.Lockdown{Door: name, By: who, Source: source, Reason: reason, Since: time.Now()}
+	slog.Error("LOCKDOWN ENGAGED", "door", lockdownScope(name), "by", who, "source", source, "reason", reason)
+	if err := lockdowns.Engage(l); err != nil {
+		slog.Error("Could not save lockdown, it is lifted by a restart", "path", *lockdownState, "err", err)
+	}
+	message := fmt.Sprintf("Lockdown of %s engaged by %s", lockdownScope(name), who)
+	if reason != "" {
+		message += ": " + reason
+	}
+	recordLockdown(events.Access{Source: source, Door: name, User: by, Action: "lockdown", Reason: reason}, message)
+
+	var errs []error
+	for _, d := range targets {
+		// commands waiting may be opens, and the close must not be refused
+		d.commands.drop(lockdown.ErrLockdown)
+		if err := runCommand(ctx, d, source, by, "close"); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// liftLockdown lifts the lockdown of the door called name, or the one of all
+// doors if it is empty. The doors stay closed
+func liftLockdown(name, source, by string) error {
+	if _, err := lockdownDoors(name); err != nil {
+		return err
+	}
+	l, err := lockdowns.Lift(name)
+	if err == lockdown.ErrNotEngaged {
+		return err
+	}
+	if err != nil {
+		slog.Error("Could not save lifted lockdown, it is engaged again by a restart", "path", *lockdownState, "err", err)
+	}
+	who := by
+	if who == "" {
+		who = source
+	}
+	slog.Warn("LOCKDOWN LIFTED", "door", lockdownScope(name), "by", who, "source", source, "engaged_by", l.By, "duration", time.Since(l.Since).Round(time.Second))
+	recordLockdown(events.Access{Source: source, Door: name, User: by, Action: "lift-lockdown"},
+		fmt.Sprintf("Lockdown of %s lifted by %s", lockdownScope(name), who))
+	return nil
+}
+
+// handleLockdownCommand handles "lockdown [door]" and "lift-lockdown [door]"
+// sent by remote sources without users, like MQTT. It reports whether cmd
+// was one of them
+func handleLockdownCommand(source, cmd string) bool {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 || len(fields) > 2 {
+		return false
+	}
+	name := ""
+	if len(fields) == 2 {
+		name = fields[1]
+	}
+	var err error
+	switch strings.ToLower(fields[0]) {
+	case "lockdown":
+		ctx, cancel := context.WithTimeout(context.Background(), *commandTimeout)
+		defer cancel()
+		err = engageLockdown(ctx, name, source, "", "")
+	case "lift-lockdown":
+		err = liftLockdown(name, source, "")
+	default:
+		return false
+	}
+	if err != nil {
+		slog.Warn("Could not run lockdown command", "source", source, "command", cmd, "err", err)
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/craftamap/wishbone/internal/syslog"
+)
+
+var (
+	logFormat = flag.String("log-format", "text", "log format: text or json")
+	logLevel  = flag.String("log-level", "info", "minimum level logged: debug, info, warn or error; can be changed at runtime on /api/v1/log-level")
+
+	// level is the minimum level logged, shared with the admin API
+	level = new(slog.LevelVar)
+)
+
+// setupLogging installs the logger configured by the flags as default, for
+// slog and the log package alike. Logs are sent to the syslog server as well
+// if one is set
+func setupLogging() error {
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid log-level %q", *logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch *logFormat {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log-format %q", *logFormat)
+	}
+	if *syslogServer != "" {
+		var err error
+		if remoteLog, err = syslog.New(*syslogServer, *syslogCA, "wishbone"); err != nil {
+			return err
+		}
+		h = teeHandler{h, syslog.NewHandler(remoteLog, level)}
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// teeHandler passes records on to all its handlers
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := make(teeHandler, len(t))
+	for i, h := range t {
+		derived[i] = h.WithAttrs(attrs)
+	}
+	return derived
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	derived := make(teeHandler, len(t))
+	for i, h := range t {
+		derived[i] = h.WithGroup(name)
+	}
+	return derived
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	remoteLog.Close()
+	os.Exit(1)
+}
@@ -0,0 +1,693 @@
+// Command wishbone unlocks the sphincter for RFID tokens on the access list
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/access"
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/grpcapi"
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/keypad"
+	"github.com/craftamap/wishbone/internal/latency"
+	"github.com/craftamap/wishbone/internal/outbox"
+	"github.com/craftamap/wishbone/internal/ratelimit"
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/craftamap/wishbone/internal/store"
+	"github.com/craftamap/wishbone/internal/supervisor"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	list              = flag.String("list", "list.txt", "RFID list")
+	port              = flag.String("port", "/dev/ttyUSB0", "reader device")
+	readersFlag       = flag.String("readers", "", "comma separated label=device pairs, e.g. inside=/dev/ttyUSB0,outside=wiegand:17:18 for a Wiegand reader with D0/D1 at GPIO 17/18 or outside=pcsc:ACR122 for a PC/SC reader; replaces -port if set")
+	serialMaxFailures = flag.Int("serial-max-failures", 10, "exit after this many consecutive serial errors (retry forever if 0)")
+	serialFraming     = flag.String("serial-framing", "stx", "how serial readers frame tokens: stx (ASCII between STX and ETX), line (ASCII ended by CR or LF) or binary:LENGTH (raw bytes, read as hex)")
+	serialBaud        = flag.Int("serial-baud", 9600, "baud rate of the serial readers, e.g. 115200")
+	serialParity      = flag.String("serial-parity", "none", "parity of the serial readers: none, even or odd")
+	serialKeepalive   = flag.Duration("serial-keepalive", 10*time.Second, "how often serial readers are checked for being unplugged and pinged (disabled if 0)")
+	serialPing        = flag.String("serial-ping", "", "hex encoded command sent to serial readers at every keepalive, e.g. a firmware version query (none if empty)")
+	serialPingReply   = flag.String("serial-ping-reply", "", "token a serial reader answers -serial-ping with; readers not answering until the next keepalive are reopened (not checked if empty)")
+	dbPath            = flag.String("db", "", "SQLite credential store, e.g. sphincter.db; replaces -list if set")
+	simulate          = flag.Bool("simulate", false, "use a simulated actuator and read tokens from stdin or POST /simulate/swipe instead of the serial readers")
+	dryRun            = flag.Bool("dry-run", false, "read tokens and log all decisions, but never drive the GPIO outputs")
+	historySize       = flag.Int("history-size", 1000, "number of recent events kept in memory for /api/v1/events, which serves those in -event-db instead if set")
+	lockoutFailures   = flag.Int("lockout-failures", 10, "block a reader or HTTP client after this many unknown tokens or failed logins within -lockout-window (disabled if 0)")
+	lockoutWindow     = flag.Duration("lockout-window", time.Minute, "interval failures are counted in")
+	lockoutCooldown   = flag.Duration("lockout-cooldown", 5*time.Minute, "how long a reader or HTTP client stays blocked")
+	autoLockDelay     = flag.Duration("auto-lock", 30*time.Second, "close the sphincter this long after an unlock (disabled if 0)")
+	autoLockCountdown = flag.Duration("auto-lock-countdown", 10*time.Second, "count down the seconds before an auto-lock as countdown events for this long (disabled if 0)")
+	autoLockBeep      = flag.Bool("auto-lock-beep", false, "beep the feedback outputs every second of the auto-lock countdown")
+	heldOpenLimit     = flag.Duration("held-open-alarm", 0, "raise an alarm if the status pins report UNLOCKED for longer than this without keep-open (disabled if 0)")
+
+	// clk times debouncing swipes, the open hours and the components it is
+	// passed to, so a fake clock can stand in for the real one
+	clk = clock.Real
+
+	// hub distributes state changes and access attempts to live subscribers
+	// and keeps the recent ones for the admin API. Set up in main
+	hub *events.Hub
+
+	// lockout blocks readers and HTTP clients after too many failed
+	// attempts; nil if disabled
+	lockout *ratelimit.Lockout
+)
+
+// reloadUserListOnSignal re-reads the user list whenever the daemon receives
+// SIGHUP. If the list can't be parsed, the previous one stays in use
+func reloadUserListOnSignal(users *store.ListStore) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	supervisor.Go("list reload", func() {
+		for range c {
+			if err := users.Reload(); err != nil {
+				slog.Error("Could not reload user list", "path", *list, "err", err)
+				continue
+			}
+			slog.Info("Reloaded user list", "path", *list, "users", users.Len())
+		}
+	})
+}
+
+// recordAccess writes an access attempt to the audit log, syslog and metrics,
+// publishes it to live subscribers and signals the outcome of swipes at the
+// door
+func recordAccess(e events.Access) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.DryRun = *dryRun
+	signalOutcome(e)
+	audit.Log(e)
+	sendAccess(e)
+	countAccess(e)
+	stats.Add(e)
+	antiPassback.Record(e)
+	hub.Publish(events.Event{Type: events.TypeAccess, Time: e.Time, Access: &e})
+}
+
+func publishState(d *door, status actuator.Status) {
+	hub.Publish(events.Event{Type: events.TypeState, Door: d.name, Status: status.String(), Position: d.position()})
+}
+
+// readerSuffix is appended to notifications about swipes, so they show which
+// of several readers was used
+func readerSuffix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return " at " + label
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		serve()
+		return
+	}
+	switch args[0] {
+	case "serve":
+		// Flags may follow the command as well
+		flag.CommandLine.Parse(args[1:])
+		if flag.NArg() > 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		serve()
+	case "user":
+		userCommand(args[1:])
+	case "token":
+		tokenCommand(args[1:])
+	case "export":
+		exportCommand(args[1:])
+	case "import":
+		importCommand(args[1:])
+	case "sync":
+		syncCommand(args[1:])
+	case "reader":
+		readerCommand(args[1:])
+	case "audit":
+		auditCommand(args[1:])
+	case "hash":
+		// Before there were subcommands, hash was one
+		hashCommand(args[1:])
+	case "help":
+		flag.CommandLine.SetOutput(os.Stdout)
+		flag.Usage()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// serve runs the daemon
+func serve() {
+	if *printDefaultConfig {
+		if err := writeDefaultConfig(os.Stdout); err != nil {
+			fatal("Could not write config", "err", err)
+		}
+		return
+	}
+	if *configPath != "" {
+		if err := loadConfig(*configPath); err != nil {
+			fatal("Could not load config", "path", *configPath, "err", err)
+		}
+	}
+	if err := setupLogging(); err != nil {
+		fatal("Invalid config", "err", err)
+	}
+	if err := resolveSecrets(); err != nil {
+		fatal("Could not resolve secrets", "err", err)
+	}
+	if err := validateConfig(); err != nil {
+		fatal("Invalid config", "err", err)
+	}
+
+	slog.Info("Starting sphincter rfid token")
+	if *tokenHashKey != "" {
+		if err := events.LoadHashKey(*tokenHashKey); err != nil {
+			fatal("Could not read token hash key", "path", *tokenHashKey, "err", err)
+		}
+	} else {
+		slog.Warn("Token hashes change on every start, set -token-hash-key")
+	}
+	hub = events.NewHub(*historySize)
+	var err error
+	if eventDB, err = openEventDB(hub); err != nil {
+		fatal("Could not open event database", "path", *eventDBPath, "err", err)
+	}
+	if permissions, err = permissionsConfig(); err != nil {
+		fatal("Invalid config", "err", err)
+	}
+	if failPolicy, err = failPolicyConfig(); err != nil {
+		fatal("Invalid config", "err", err)
+	}
+	if holidays, err = loadHolidays(*holidaysPath); err != nil {
+		fatal("Could not read holidays", "path", *holidaysPath, "err", err)
+	}
+	if *simulate {
+		slog.Info("Using simulated actuators")
+	} else if *dryRun {
+		slog.Info("Dry run, the GPIO outputs are never driven")
+	} else {
+		slog.Info("Opening GPIO")
+	}
+	for _, c := range doorConfigs() {
+		d, err := openDoor(c)
+		if err != nil {
+			fatal("Could not open door", "door", c.Name, "err", err)
+		}
+		if err := checkDoorGPIO(d, c); err != nil {
+			fatal("GPIO check failed", "door", c.Name, "err", err)
+		}
+		slog.Info("Opened door", "door", d.name)
+		doors = append(doors, d)
+	}
+
+	creds, err := credentialStores[storeKind()]()
+	if err != nil {
+		fatal("Could not open credential store", "store", storeKind(), "err", err)
+	}
+
+	if *statsDays > 0 {
+		stats = events.NewStats(*statsDays)
+		if *auditLogPath != "" {
+			n, err := loadStats(stats, *auditLogPath)
+			if err != nil {
+				fatal("Could not read audit log", "path", *auditLogPath, "err", err)
+			}
+			slog.Info("Read audit log for statistics", "events", n, "path", *auditLogPath)
+		}
+	}
+	if *latencyTraces > 0 {
+		latencies = latency.NewRecorder(*latencyTraces)
+	}
+	if antiPassback, enforcePassback, err = passbackConfig(); err != nil {
+		fatal("Invalid config", "err", err)
+	}
+	if siteRules, ruleHooks, err = rulesConfig(); err != nil {
+		fatal("Invalid config", "err", err)
+	}
+	if lockdowns, err = openLockdowns(); err != nil {
+		fatal("Could not read lockdowns", "err", err)
+	}
+	if accessHook, err = openAccessHook(); err != nil {
+		fatal("Invalid config", "err", fmt.Errorf("access-hook: %w", err))
+	}
+	if *auditLogPath != "" {
+		slog.Info("Opening audit log", "path", *auditLogPath, "signed", *auditKey != "")
+		audit, err = openAuditLog(*auditLogPath, []byte(*auditKey))
+		if err != nil {
+			fatal("Could not open audit log", "path", *auditLogPath, "err", err)
+		}
+	}
+
+	if *lockoutFailures > 0 {
+		lockout = ratelimit.New(*lockoutFailures, *lockoutWindow, *lockoutCooldown, func(key string) {
+			msg := fmt.Sprintf("Blocking %s for %v after %d failed attempts", key, *lockoutCooldown, *lockoutFailures)
+			slog.Warn("Blocking after failed attempts", "key", key, "cooldown", *lockoutCooldown, "failures", *lockoutFailures)
+			hub.Publish(events.Event{Type: events.TypeAlert, Message: msg})
+		})
+	}
+
+	if *codesEnabled {
+		codes = store.NewCodes()
+	}
+	if appTokens, err = openAppTokens(); err != nil {
+		fatal("Could not read app tokens", "path", *appTokensPath, "err", err)
+	}
+
+	if notifications, err = openNotifications(); err != nil {
+		fatal("Could not read notification queue", "path", *notifyQueuePath, "err", err)
+	}
+
+	alarmInterlock = newAlarmInterlock()
+	pipeline = newPipeline(creds)
+	var mqttClient mqtt.Client
+	if *mqttBroker != "" {
+		slog.Info("Connecting to MQTT", "broker", *mqttBroker)
+		mqttClient, err = connectMQTT(doors[0])
+		if err != nil {
+			fatal("Could not connect to MQTT", "err", err)
+		}
+		disarmOverMQTT(mqttClient)
+	}
+
+	if *telegramToken != "" {
+		slog.Info("Starting Telegram bot")
+		bot, err := newTelegramBot()
+		if err != nil {
+			fatal("Could not start Telegram bot", "err", err)
+		}
+		c := hub.Subscribe()
+		notifications.Handle("telegram", bot.deliver)
+		supervisor.Go("telegram", bot.Run)
+		supervisor.Go("telegram notifications", func() { bot.notifyEvents(c) })
+	}
+
+	if *matrixHomeserver != "" {
+		slog.Info("Starting Matrix bot", "homeserver", *matrixHomeserver, "room", *matrixRoom)
+		bot, err := newMatrixBot()
+		if err != nil {
+			fatal("Could not start Matrix bot", "err", err)
+		}
+		c := hub.Subscribe()
+		notifications.Handle("matrix", bot.deliver)
+		supervisor.Go("matrix", bot.Run)
+		supervisor.Go("matrix notifications", func() { bot.notifyEvents(c) })
+	}
+
+	// Only set if enabled, as a nil *slackBot isn't a nil http.Handler
+	var slackCommands http.Handler
+	if slackEnabled() {
+		slog.Info("Starting Slack integration")
+		bot, err := newSlackBot()
+		if err != nil {
+			fatal("Could not start Slack integration", "err", err)
+		}
+		if bot.notifies() {
+			c := hub.Subscribe()
+			notifications.Handle("slack", bot.deliver)
+			supervisor.Go("slack notifications", func() { bot.notifyEvents(c) })
+		}
+		if bot.signingSecret != "" {
+			slackCommands = bot
+		}
+	}
+
+	if pushEnabled() {
+		slog.Info("Sending push notifications", "pushover", *pushoverToken != "", "ntfy", *ntfyTopic)
+		p, err := newPusher()
+		if err != nil {
+			fatal("Could not set up push notifications", "err", err)
+		}
+		c := hub.Subscribe()
+		notifications.Handle("pushover", p.deliverPushover)
+		notifications.Handle("ntfy", p.deliverNtfy)
+		supervisor.Go("push notifications", func() { p.notifyEvents(c) })
+	}
+
+	if *webhooksPath != "" {
+		hooks, err := parseWebhooks(*webhooksPath)
+		if err != nil {
+			fatal("Could not read webhooks", "path", *webhooksPath, "err", err)
+		}
+		slog.Info("Calling webhooks", "webhooks", len(hooks))
+		c := hub.Subscribe()
+		supervisor.Go("webhooks", func() { runWebhooks(hooks, c) })
+	}
+
+	if len(siteRules) > 0 {
+		slog.Info("Running rules", "rules", len(siteRules))
+		c := hub.Subscribe()
+		supervisor.Go("rules", func() { runRules(c) })
+	}
+	if *webhooksPath != "" || len(ruleHooks) > 0 {
+		client := &http.Client{Timeout: 10 * time.Second}
+		notifications.Handle("webhook", func(i outbox.Item) error { return callWebhook(client, i) })
+	}
+
+	swipes := make(chan reader.Swipe)
+	keys := make(chan rune)
+	reloads := make(chan reloadRequest)
+	var simulatedKeys chan<- rune
+	if *keypadSpec != "" {
+		simulatedKeys = keys
+	}
+
+	var httpServer *httpapi.Server
+	if *listen != "" {
+		slog.Info("Serving HTTP", "addr", *listen)
+		httpServer, err = httpapi.New(httpConfig(), httpapi.Backend{
+			Doors:         httpDoors(),
+			Store:         creds,
+			LookupTimeout: *lookupTimeout,
+			Events:        hub,
+			Swipes:        swipes,
+			Keys:          simulatedKeys,
+			Command: func(ctx context.Context, name, source, user, cmd string) error {
+				return runCommand(ctx, doorByName(name), source, user, cmd)
+			},
+			Record:            recordAccess,
+			Lockout:           lockout,
+			LogLevel:          level,
+			Codes:             codes,
+			Health:            health,
+			Permissions:       currentPermissions,
+			FailPolicy:        currentFailPolicy,
+			OpenHours:         openHoursStatus,
+			OverrideOpenHours: overrideOpenHours,
+			Enroll:            enrollFunc(),
+			Stats:             stats,
+			Passback:          antiPassback,
+			Lockdown:          lockdowns,
+			AccessHook:        accessHook,
+			Latency:           latencies,
+			AppTokens:         appTokens,
+			Passthrough:       startPassthrough,
+			Slack:             slackCommands,
+			Alarm:             alarmInterlock,
+			Reload:            requestReload(reloads),
+			EngageLockdown: func(ctx context.Context, door, admin, reason string) error {
+				return engageLockdown(ctx, door, "admin", admin, reason)
+			},
+			LiftLockdown: func(door, admin string) error {
+				return liftLockdown(door, "admin", admin)
+			},
+		})
+		if err != nil {
+			fatal("Could not set up HTTP server", "err", err)
+		}
+		// Listening right away fails early if the address is taken, but
+		// requests get 503 until everything below is set up
+		go func() {
+			err := httpServer.ListenAndServe()
+			if err != http.ErrServerClosed {
+				fatal("Could not serve HTTP", "err", err)
+			}
+		}()
+	}
+
+	var grpcServer *grpcapi.Server
+	if *grpcListen != "" {
+		slog.Info("Serving gRPC", "addr", *grpcListen)
+		grpcServer, err = grpcapi.New(grpcConfig(), grpcapi.Backend{
+			Doors:  grpcDoors(),
+			Events: hub,
+			Command: func(ctx context.Context, name, source, user, cmd string) error {
+				return runCommand(ctx, doorByName(name), source, user, cmd)
+			},
+		})
+		if err != nil {
+			fatal("Could not set up gRPC server", "err", err)
+		}
+		go func() {
+			if err := grpcServer.ListenAndServe(); err != nil {
+				fatal("Could not serve gRPC", "err", err)
+			}
+		}()
+	}
+
+	var readers []reader.Reader
+	if *simulate {
+		slog.Info("Reading simulated swipes from stdin")
+		supervisor.Go("reader stdin", func() { reader.ReadLines(os.Stdin, "stdin", swipes) })
+	} else {
+		slog.Info("Opening readers")
+		for _, d := range doors {
+			for _, r := range d.readers {
+				if err := r.Open(); err != nil {
+					fatal("Could not open reader", "door", d.name, "reader", r.String(), "err", err)
+				}
+				readers = append(readers, r)
+			}
+		}
+		for _, d := range doors {
+			if d.exitButton == nil {
+				continue
+			}
+			if err := d.exitButton.Open(); err != nil {
+				fatal("Could not open exit button", "door", d.name, "err", err)
+			}
+		}
+		for _, d := range doors {
+			if d.sensor == nil {
+				continue
+			}
+			if err := d.sensor.Open(); err != nil {
+				fatal("Could not open door sensor", "door", d.name, "err", err)
+			}
+		}
+		if alarmInput != nil {
+			if err := alarmInput.Open(); err != nil {
+				fatal("Could not open alarm input", "err", err)
+			}
+		}
+	}
+	for _, d := range doors {
+		for _, o := range d.feedback {
+			if err := o.Open(); err != nil {
+				fatal("Could not open feedback output", "door", d.name, "err", err)
+			}
+		}
+	}
+	if statusLED, err = openStatusLED(); err != nil {
+		fatal("Could not open status LED", "err", err)
+	}
+	var kp keypad.Keypad
+	if *keypadSpec != "" {
+		kp, err = keypad.Parse(*keypadSpec)
+		if err != nil {
+			fatal("Invalid keypad", "err", err)
+		}
+		// Scanning a matrix keypad drives its rows
+		if _, ok := kp.(*keypad.Matrix); ok && (*simulate || *dryRun) {
+			kp = &keypad.Simulated{Keypad: kp}
+		}
+		slog.Info("Reading PINs", "keypad", kp.String())
+		if err := kp.Open(); err != nil {
+			fatal("Could not open keypad", "err", err)
+		}
+	}
+	done := make(chan struct{})
+	supervisor.Go("notifications", func() { notifications.Run(done) })
+	if eventDB != nil {
+		supervisor.Go("event pruning", func() { eventDB.Run(done) })
+	}
+	for _, d := range doors {
+		if d.polled {
+			supervisor.Go("status poll "+d.name, func() { d.Poll(*statusPoll, done) })
+		}
+	}
+	readerErrs := make(chan error, len(readers))
+	for _, r := range readers {
+		r.Run(swipes, readerErrs, done)
+	}
+	if kp != nil {
+		kp.Run(keys, done)
+	}
+	if !*simulate {
+		for _, d := range doors {
+			if d.exitButton != nil {
+				runExitButton(d, done)
+			}
+			if d.sensor != nil {
+				d.sensor.Run(done)
+			}
+		}
+		if alarmInput != nil {
+			alarmInput.Run(done)
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	for _, d := range doors {
+		d.openHours.Run(done)
+	}
+	if syncClient != nil {
+		slog.Info("Syncing user list", "url", *syncURL, "interval", *syncInterval)
+		syncClient.Run(done)
+	}
+	supervisor.Go("credential watch", func() {
+		creds.Watch(done, func() { slog.Info("Credentials changed", "store", storeKind()) })
+	})
+	// The LED is kept alive from the main loop, so it goes dark if that
+	// hangs
+	var heartbeat <-chan time.Time
+	if statusLED != nil {
+		supervisor.Go("health", func() { watchHealth(creds, done) })
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	// The main loop handles the swipes and commands from here on
+	httpServer.SetReady()
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("Could not notify systemd", "err", err)
+	}
+	// The watchdog is fed from the main loop, so systemd restarts the daemon
+	// if it hangs
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		slog.Info("Feeding systemd watchdog", "interval", interval/2)
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	slog.Info("Initialized")
+
+	// The handlers run under the supervisor, so a panic while handling one
+	// swipe doesn't lock everyone out
+	for {
+		select {
+		case sw := <-swipes:
+			supervisor.Run("main loop", func() { pipeline.Swipe(doorOfReader(sw.Reader), sw) })
+		case key := <-keys:
+			supervisor.Run("main loop", func() { pipeline.Key(key) })
+		case <-pipeline.PINExpired():
+			supervisor.Run("main loop", pipeline.ExpirePIN)
+		case <-pipeline.AlarmChanged():
+			supervisor.Run("main loop", pipeline.RetryUnlock)
+		case <-pipeline.UnlockExpired():
+			supervisor.Run("main loop", pipeline.ExpireUnlock)
+		case w := <-alarmWaits:
+			supervisor.Run("main loop", w.run)
+		case req := <-reloads:
+			supervisor.Run("main loop", func() { req.run(creds) })
+		case <-watchdog:
+			sdNotify("WATCHDOG=1")
+		case now := <-heartbeat:
+			statusLED.Alive(now)
+		case err := <-readerErrs:
+			slog.Error("Shutting down", "err", err)
+			sdNotify("STOPPING=1")
+			close(done)
+			shutdown(httpServer, grpcServer, mqttClient, readers, kp)
+			// Exit with an error, so the daemon is restarted
+			os.Exit(1)
+		case sig := <-sigs:
+			slog.Info("Shutting down", "signal", sig.String())
+			sdNotify("STOPPING=1")
+			close(done)
+			shutdown(httpServer, grpcServer, mqttClient, readers, kp)
+			return
+		}
+	}
+}
+
+// shutdown stops all subsystems that could still trigger the actuator
+// before releasing the GPIO, so the relays are never left energized. Readers,
+// the keypad and the exit buttons are closed before as well, as they may use
+// the GPIO, too
+func shutdown(httpServer *httpapi.Server, grpcServer *grpcapi.Server, mqttClient mqtt.Client, readers []reader.Reader, kp keypad.Keypad) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("Could not stop HTTP server", "err", err)
+		}
+	}
+	if grpcServer != nil {
+		grpcServer.Shutdown(ctx)
+	}
+	if mqttClient != nil {
+		disconnectMQTT(mqttClient)
+	}
+	for _, r := range readers {
+		if err := r.Close(); err != nil {
+			slog.Warn("Could not close reader", "reader", r.String(), "err", err)
+		}
+	}
+	if kp != nil {
+		if err := kp.Close(); err != nil {
+			slog.Warn("Could not close keypad", "err", err)
+		}
+	}
+	for _, d := range doors {
+		if d.exitButton != nil {
+			d.exitButton.Close()
+		}
+		if d.sensor != nil {
+			d.sensor.Close()
+		}
+		for _, o := range d.feedback {
+			if err := o.Close(); err != nil {
+				slog.Warn("Could not close feedback output", "door", d.name, "err", err)
+			}
+		}
+	}
+	if alarmInput != nil {
+		alarmInput.Close()
+	}
+	if statusLED != nil {
+		statusLED.Close()
+	}
+	releaseDoors()
+	if err := remoteLog.Close(); err != nil {
+		slog.Warn("Could not flush syslog", "err", err)
+	}
+}
+
+// runExitButton opens d whenever its exit button is pressed. Presses are
+// handled apart from the main loop, so leaving never waits for a swipe being
+// handled
+func runExitButton(d *door, done <-chan struct{}) {
+	presses := make(chan struct{})
+	d.exitButton.Run(presses, done)
+	supervisor.Go("exit button "+d.name, func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-presses:
+				requestToExit(d)
+			}
+		}
+	})
+}
+
+// requestToExit opens d for someone leaving. Neither permissions, the fail
+// policy, the open hours nor the alarm apply, as the way out must never be
+// blocked
+func requestToExit(d *door) {
+	event := events.Access{Source: "exit-button", Door: d.name, Action: "exit"}
+	access.Logger(event).Info("Exit button pressed")
+	pipeline.Unlock(d, event, nil)
+}
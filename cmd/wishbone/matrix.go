@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/outbox"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	matrixHomeserver = flag.String("matrix-homeserver", "", "URL of the Matrix homeserver, or of pantalaimon for encrypted rooms (bot disabled if empty)")
+	matrixToken      = flag.String("matrix-token", "", "access token of the bot's Matrix account, or env:NAME or secret:NAME to refer to it")
+	matrixRoom       = flag.String("matrix-room", "", "id or alias of the Matrix room receiving notifications and commands, e.g. #door:example.org")
+	matrixUsers      = flag.String("matrix-users", "", "comma separated Matrix users allowed to control the doors, e.g. @alice:example.org")
+	matrixAfterHours = flag.String("matrix-after-hours", "22:00-06:00", "notify about unlocks in this time range (disabled if empty)")
+)
+
+// matrixBot answers !door state, open, keep-open and close from whitelisted
+// users in a room and notifies it like the Telegram bot. It speaks the
+// client-server API without end-to-end encryption; encrypted rooms are
+// joined through pantalaimon, which decrypts and encrypts for it
+type matrixBot struct {
+	homeserver string
+	token      string
+	room       string
+	users      map[string]bool
+	afterHours *store.TimeWindow
+	client     *http.Client
+
+	// roomID is the id of room, known once joined
+	roomID atomic.Value
+	// txn numbers the messages sent, as the API requires unique
+	// transaction ids
+	txnPrefix string
+	txn       atomic.Int64
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+func newMatrixBot() (*matrixBot, error) {
+	b := &matrixBot{
+		homeserver: strings.TrimSuffix(*matrixHomeserver, "/"),
+		token:      *matrixToken,
+		room:       *matrixRoom,
+		users:      map[string]bool{},
+		client:     &http.Client{Timeout: 60 * time.Second},
+		txnPrefix:  fmt.Sprintf("wishbone%d", time.Now().UnixNano()),
+	}
+	if b.token == "" || b.room == "" {
+		return nil, errors.New("matrix-token and matrix-room must be set")
+	}
+	for _, user := range strings.Split(*matrixUsers, ",") {
+		if user = strings.TrimSpace(user); user == "" {
+			continue
+		}
+		if !strings.HasPrefix(user, "@") || !strings.Contains(user, ":") {
+			return nil, fmt.Errorf("invalid Matrix user %q, expected @name:server", user)
+		}
+		b.users[user] = true
+	}
+	if len(b.users) == 0 {
+		return nil, errors.New("matrix-users must not be empty")
+	}
+	if *matrixAfterHours != "" {
+		w, err := store.ParseTimeWindow("daily " + *matrixAfterHours)
+		if err != nil {
+			return nil, err
+		}
+		b.afterHours = &w
+	}
+	return b, nil
+}
+
+// matrixError is an error replied by the homeserver
+type matrixError struct {
+	endpoint string
+	status   int
+	text     string
+}
+
+func (e *matrixError) Error() string {
+	return e.endpoint + ": " + e.text
+}
+
+// call invokes an endpoint of the client-server API, sending body and
+// decoding the response into result unless they are nil
+func (b *matrixBot) call(method, endpoint string, query url.Values, body, result interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	u := b.homeserver + "/_matrix/client/v3" + endpoint
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return &matrixError{endpoint: endpoint, status: resp.StatusCode, text: fmt.Sprintf("%s %s %s", resp.Status, e.ErrCode, e.Error)}
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("%s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// join joins the room, unless the bot is in it already, and remembers its id
+func (b *matrixBot) join() error {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := b.call(http.MethodPost, "/join/"+url.PathEscape(b.room), nil, struct{}{}, &resp); err != nil {
+		return err
+	}
+	b.roomID.Store(resp.RoomID)
+	return nil
+}
+
+// joinedRoom returns the id of the room, or "" before it was joined
+func (b *matrixBot) joinedRoom() string {
+	id, _ := b.roomID.Load().(string)
+	return id
+}
+
+// send posts text to the room as a notice, which other bots don't answer
+func (b *matrixBot) send(text string) {
+	if err := b.sendNotice(b.nextTxn(), text); err != nil {
+		slog.Warn("Could not send Matrix message", "err", err)
+	}
+}
+
+func (b *matrixBot) nextTxn() string {
+	return fmt.Sprintf("%s.%d", b.txnPrefix, b.txn.Add(1))
+}
+
+// sendNotice sends text to the room. Sending again with the same txn
+// doesn't post it twice
+func (b *matrixBot) sendNotice(txn, text string) error {
+	room := b.joinedRoom()
+	if room == "" {
+		return errors.New("room not joined yet")
+	}
+	return b.call(http.MethodPut, "/rooms/"+url.PathEscape(room)+"/send/m.room.message/"+url.PathEscape(txn), nil,
+		map[string]string{"msgtype": "m.notice", "body": text}, nil)
+}
+
+// deliver sends a queued message. Its transaction id is kept across
+// retries, so one that got through before the reply was lost isn't posted
+// twice. Messages the homeserver rejects aren't retried unless it asks to
+func (b *matrixBot) deliver(i outbox.Item) error {
+	err := b.sendNotice(i.Meta["txn"], i.Payload)
+	var apiErr *matrixError
+	if errors.As(err, &apiErr) && apiErr.status >= 400 && apiErr.status < 500 && apiErr.status != http.StatusTooManyRequests {
+		return outbox.Permanent(err)
+	}
+	return err
+}
+
+// filter limits syncs to the messages of the room
+func (b *matrixBot) filter() string {
+	none := map[string][]string{"types": {}}
+	f, _ := json.Marshal(map[string]interface{}{
+		"presence":     none,
+		"account_data": none,
+		"room": map[string]interface{}{
+			"rooms":        []string{b.joinedRoom()},
+			"timeline":     map[string]interface{}{"types": []string{"m.room.message", "m.room.encrypted"}},
+			"state":        none,
+			"ephemeral":    none,
+			"account_data": none,
+		},
+	})
+	return string(f)
+}
+
+// Run joins the room and syncs for commands; it doesn't return
+func (b *matrixBot) Run() {
+	since := ""
+	for {
+		if b.joinedRoom() == "" {
+			if err := b.join(); err != nil {
+				slog.Warn("Could not join Matrix room", "room", b.room, "err", err)
+				time.Sleep(30 * time.Second)
+				continue
+			}
+			slog.Info("Joined Matrix room", "room", b.room)
+		}
+		query := url.Values{"timeout": {"30000"}, "filter": {b.filter()}}
+		if since != "" {
+			query.Set("since", since)
+		}
+		var resp matrixSync
+		if err := b.call(http.MethodGet, "/sync", query, nil, &resp); err != nil {
+			slog.Warn("Could not sync with Matrix", "err", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		// The first sync returns the recent messages, which were answered
+		// before
+		if since != "" {
+			for _, e := range resp.Rooms.Join[b.joinedRoom()].Timeline.Events {
+				b.handle(e)
+			}
+		}
+		since = resp.NextBatch
+	}
+}
+
+func (b *matrixBot) handle(e matrixEvent) {
+	if e.Type == "m.room.encrypted" {
+		if b.users[e.Sender] {
+			slog.Warn("Could not read encrypted Matrix message, use pantalaimon for encrypted rooms", "sender", e.Sender)
+		}
+		return
+	}
+	fields := strings.Fields(e.Content.Body)
+	if e.Content.MsgType != "m.text" || len(fields) == 0 || fields[0] != "!door" {
+		return
+	}
+	if !b.users[e.Sender] {
+		slog.Info("Ignoring Matrix command", "sender", e.Sender)
+		return
+	}
+	cmd := ""
+	if len(fields) > 1 {
+		cmd = fields[1]
+	}
+	d := doors[0]
+	if len(fields) > 2 {
+		if d = doorByName(fields[2]); d == nil {
+			b.send("Unknown door " + fields[2])
+			return
+		}
+	}
+
+	switch cmd {
+	case "state":
+		if len(fields) == 2 {
+			for _, d := range doors {
+				b.send(doorState(d.name, d.Status()))
+			}
+			return
+		}
+		b.send(doorState(d.name, d.Status()))
+	case "open", "keep-open", "close":
+		if err := runCommand(context.Background(), d, "matrix", e.Sender, cmd); err != nil {
+			b.send("Could not " + cmd + ": " + err.Error())
+			return
+		}
+		b.send(doorState(d.name, d.Status()))
+	default:
+		b.send("Commands: !door state, !door open, !door keep-open, !door close, each optionally followed by a door")
+	}
+}
+
+// notifyEvents queues notices about the events of c. Replies to commands are
+// sent right away instead, as they are of no use later
+func (b *matrixBot) notifyEvents(c <-chan events.Event) {
+	for e := range c {
+		if text, ok := eventNotice(e, b.afterHours); ok {
+			notifications.Add(outbox.Item{Channel: "matrix", Payload: text, Meta: map[string]string{"txn": b.nextTxn()}})
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/doorsensor"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/latency"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	unlocksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wishbone_unlocks_total",
+		Help: "Successful unlocks by source.",
+	}, []string{"source"})
+	rejectedTokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wishbone_rejected_tokens_total",
+		Help: "Rejected access attempts by result.",
+	}, []string{"result"})
+	actuatorFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wishbone_actuator_failures_total",
+		Help: "Access attempts that failed in the actuator or credential store.",
+	})
+	sphincterStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wishbone_sphincter_status",
+		Help: "Current sphincter status by door; 1 for the active status, 0 otherwise.",
+	}, []string{"door", "status"})
+	doorPosition = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wishbone_door_position",
+		Help: "Current position of the doors with a door sensor; 1 for the active position, 0 otherwise.",
+	}, []string{"door", "position"})
+	commandQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wishbone_command_queue_depth",
+		Help: "Remote commands waiting by door.",
+	}, []string{"door"})
+	unlockStageSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wishbone_unlock_stage_seconds",
+		Help:    "Time taken by the stages of handling a swiped token, from reading it to opening, by door and stage.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 13),
+	}, []string{"door", "stage"})
+)
+
+func countAccess(e events.Access) {
+	switch e.Result {
+	case events.ResultGranted:
+		if e.Action != "close" {
+			unlocksTotal.WithLabelValues(e.Source).Inc()
+		}
+	case events.ResultDenied, events.ResultUnknown:
+		rejectedTokensTotal.WithLabelValues(e.Result).Inc()
+	case events.ResultFailure:
+		actuatorFailuresTotal.Inc()
+	}
+}
+
+func setStatusMetric(door string, status actuator.Status) {
+	for _, s := range []actuator.Status{actuator.StatusUnknown, actuator.StatusLocked, actuator.StatusUnlocked, actuator.StatusFailure} {
+		v := 0.0
+		if s == status {
+			v = 1
+		}
+		sphincterStatus.WithLabelValues(door, s.String()).Set(v)
+	}
+}
+
+func setPositionMetric(door string, position doorsensor.Position) {
+	for _, p := range []doorsensor.Position{doorsensor.PositionOpen, doorsensor.PositionClosed} {
+		v := 0.0
+		if p == position {
+			v = 1
+		}
+		doorPosition.WithLabelValues(door, p.String()).Set(v)
+	}
+}
+
+func observeLatency(t *latency.Trace) {
+	for stage, d := range t.Stages {
+		unlockStageSeconds.WithLabelValues(t.Door, stage.String()).Observe(d.Seconds())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/outbox"
+	"github.com/craftamap/wishbone/internal/supervisor"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	mqttBroker       = flag.String("mqtt-broker", "", "MQTT broker, e.g. tcp://localhost:1883 (disabled if empty)")
+	mqttClientID     = flag.String("mqtt-client-id", "wishbone", "MQTT client id")
+	mqttUsername     = flag.String("mqtt-username", "", "MQTT username")
+	mqttPassword     = flag.String("mqtt-password", "", "MQTT password, or env:NAME or secret:NAME to refer to it")
+	mqttStateTopic   = flag.String("mqtt-state-topic", "sphincter/state", "MQTT topic the sphincter status is published to (retained)")
+	mqttCommandTopic = flag.String("mqtt-command-topic", "sphincter/command", "MQTT topic to receive open/keep-open/close commands from")
+	mqttAvailTopic   = flag.String("mqtt-availability-topic", "sphincter/availability", "MQTT topic wishbone publishes online/offline to (retained)")
+	mqttHeldOpen     = flag.String("mqtt-held-open-topic", "sphincter/held-open", "MQTT topic the held-open alarm is published to as ON/OFF, if enabled (retained)")
+	mqttAutoLock     = flag.String("mqtt-auto-lock-topic", "sphincter/auto-lock", "MQTT topic the seconds remaining until an auto-lock are published to during its countdown, and 0 when it ends")
+)
+
+func publishMQTT(client mqtt.Client, topic string, retained bool, payload interface{}) {
+	token := client.Publish(topic, 1, retained, payload)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			slog.Warn("Could not publish to MQTT", "topic", topic, "err", token.Error())
+		}
+	}()
+}
+
+// queueMQTT queues a retained message. Only the latest one of each topic is
+// kept, so the broker isn't sent outdated values after an outage
+func queueMQTT(topic, payload string) {
+	notifications.Put(outbox.Item{Channel: "mqtt", Dest: topic, Payload: payload})
+}
+
+// connectMQTT connects to the configured broker, subscribes to the command
+// topic and publishes every status change of the door
+func connectMQTT(d *door) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(*mqttBroker).
+		SetClientID(*mqttClientID).
+		SetUsername(*mqttUsername).
+		SetPassword(*mqttPassword).
+		SetAutoReconnect(true).
+		SetWill(*mqttAvailTopic, "offline", 1, true)
+
+	// (Re-)subscribe on every connect, as the broker may have dropped our
+	// session while we were disconnected
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		token := client.Subscribe(*mqttCommandTopic, 1, func(client mqtt.Client, msg mqtt.Message) {
+			// Commands may wait for the door and the alarm, whose state is
+			// delivered by this client as well, so they don't run on its
+			// callback
+			go supervisor.Run("mqtt", func() { handleMQTTCommand(d, string(msg.Payload())) })
+		})
+		if token.Wait() && token.Error() != nil {
+			slog.Warn("Could not subscribe to MQTT", "topic", *mqttCommandTopic, "err", token.Error())
+		}
+		subscribeAlarm(client)
+		publishMQTT(client, *mqttAvailTopic, true, "online")
+		// Replaces the values queued while disconnected
+		queueMQTT(*mqttStateTopic, d.Status().String())
+		if d.heldOpen != nil {
+			queueMQTT(*mqttHeldOpen, onOff(d.heldOpen.Alarmed()))
+		}
+		if *haDiscovery {
+			publishHomeAssistantDiscovery(client)
+		}
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		slog.Warn("Lost MQTT connection", "err", err)
+	})
+
+	client := mqtt.NewClient(opts)
+	notifications.Handle("mqtt", func(i outbox.Item) error {
+		token := client.Publish(i.Dest, 1, true, i.Payload)
+		if !token.WaitTimeout(10 * time.Second) {
+			return errors.New("timed out publishing to MQTT")
+		}
+		return token.Error()
+	})
+	d.OnChange(func(status actuator.Status) {
+		queueMQTT(*mqttStateTopic, status.String())
+	})
+	d.heldOpen.OnAlarm(func(alarmed bool) {
+		queueMQTT(*mqttHeldOpen, onOff(alarmed))
+	})
+	d.autoLock.OnCountdown(func(remaining time.Duration) {
+		publishMQTT(client, *mqttAutoLock, false, strconv.Itoa(int(remaining.Seconds())))
+	})
+	token := client.Connect()
+	if token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	return client, nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// disconnectMQTT marks wishbone offline, which the broker only does by
+// itself if the connection drops
+func disconnectMQTT(client mqtt.Client) {
+	token := client.Publish(*mqttAvailTopic, 1, true, "offline")
+	token.WaitTimeout(time.Second)
+	client.Disconnect(250)
+}
+
+func handleMQTTCommand(d *door, cmd string) {
+	if handleLockdownCommand("mqtt", cmd) {
+		return
+	}
+	if err := runCommand(context.Background(), d, "mqtt", "", cmd); err == errUnknownCommand {
+		slog.Warn("Unknown MQTT command", "command", cmd)
+	}
+}
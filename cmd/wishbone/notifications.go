@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/outbox"
+)
+
+var (
+	notifyQueuePath = flag.String("notify-queue", "", "file keeping webhook calls, chat messages and MQTT messages not delivered yet across restarts, e.g. /var/lib/wishbone/notify-queue.jsonl (in memory only if empty)")
+	notifyQueueSize = flag.Int("notify-queue-size", 1000, "most notifications kept for retrying, dropping the oldest beyond")
+	notifyMaxAge    = flag.Duration("notify-max-age", 24*time.Hour, "give up on notifications not delivered within this long (never if 0)")
+
+	// notifications are the webhook calls and messages to deliver. Set up
+	// in main
+	notifications *outbox.Queue
+)
+
+// openNotifications opens the queue of notifications at -notify-queue
+func openNotifications() (*outbox.Queue, error) {
+	q, err := outbox.Open(*notifyQueuePath, *notifyQueueSize, *notifyMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	if n := q.Len(); n > 0 {
+		slog.Info("Retrying notifications not delivered before", "notifications", n, "path", *notifyQueuePath)
+	}
+	return q, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/store"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+// openHoursInterval is how often the open hours are checked
+const openHoursInterval = 15 * time.Second
+
+var (
+	openHoursFlag = flag.String("open-hours", "", "time windows like in list.txt the door is unlocked for the public, e.g. \"weekdays 10:00-18:00; sat 12:00-16:00\" (disabled if empty)")
+	holidaysPath  = flag.String("holidays", "", "file with dates the door isn't unlocked during open hours, one YYYY-MM-DD per line")
+
+	swipeKeepOpenFlag = flag.String("swipe-keep-open", "", "time windows like in list.txt swipes keep the door unlocked without auto-lock, e.g. while the space is open to the public, except on -holidays (disabled if empty)")
+
+	// holidays holds the dates read from -holidays, as YYYY-MM-DD
+	holidays map[string]bool
+)
+
+// loadHolidays reads dates, one per line, each optionally followed by a
+// description. Lines starting with # are skipped
+func loadHolidays(path string) (map[string]bool, error) {
+	dates := map[string]bool{}
+	if path == "" {
+		return dates, nil
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(bytes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid date %q", path, i+1, fields[0])
+		}
+		dates[fields[0]] = true
+	}
+	return dates, nil
+}
+
+// Overrides of the open hours
+const (
+	overrideOpen   = "open"
+	overrideClosed = "closed"
+)
+
+// openHours keeps a door unlocked while it is open to the public. The door
+// is only driven when the open hours start or end, so it can still be
+// locked or unlocked by hand in between. A nil *openHours does nothing
+type openHours struct {
+	door     *door
+	text     string
+	schedule store.Schedule
+
+	mu sync.Mutex
+	// override is overrideOpen or overrideClosed while the schedule is
+	// suspended
+	override string
+	// open is whether the schedule wants the door open, as last checked
+	open bool
+	// opened is set if the schedule unlocked the door, so it is only
+	// locked at closing time if it did
+	opened bool
+}
+
+func newOpenHours(d *door, text string) (*openHours, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	schedule, err := store.ParseSchedule(text)
+	if err != nil {
+		return nil, err
+	}
+	return &openHours{door: d, text: text, schedule: schedule}, nil
+}
+
+// wantOpen reports whether the door is open to the public at t
+func (o *openHours) wantOpen(t time.Time) bool {
+	return o.schedule.Allows(t) && !holidays[t.Format("2006-01-02")]
+}
+
+// Run checks the open hours until done is closed. A door within its open
+// hours at startup is unlocked right away
+func (o *openHours) Run(done <-chan struct{}) {
+	if o == nil {
+		return
+	}
+	slog.Info("Following open hours", "door", o.door.name, "hours", o.text)
+	supervisor.Go("open hours "+o.door.name, func() {
+		ticker := clk.NewTicker(openHoursInterval)
+		defer ticker.Stop()
+		for {
+			o.check(clk.Now())
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+			}
+		}
+	})
+}
+
+// check drives the door if the open hours started or ended since the last
+// check
+func (o *openHours) check(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	want := o.wantOpen(now)
+	if want == o.open || o.override != "" {
+		o.open = want
+		return
+	}
+	o.open = want
+	o.apply(context.Background(), want)
+}
+
+// apply unlocks the door without auto-lock, or locks it if the schedule
+// unlocked it. Must be called with o.mu held
+func (o *openHours) apply(ctx context.Context, open bool) {
+	switch {
+	case open:
+		slog.Info("Open hours started", "door", o.door.name)
+		if runCommand(ctx, o.door, "open-hours", "", "keep-open") == nil {
+			o.opened = true
+		}
+	case o.opened:
+		slog.Info("Open hours ended", "door", o.door.name)
+		runCommand(ctx, o.door, "open-hours", "", "close")
+		o.opened = false
+	}
+}
+
+var errInvalidOverride = errors.New("invalid override, expected open, closed or empty")
+
+// Override suspends the schedule, keeping the door open or closed, until it
+// is cleared with an empty override. Clearing it applies the schedule again
+func (o *openHours) Override(ctx context.Context, override, admin string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch override {
+	case overrideOpen:
+		slog.Info("Overriding open hours", "door", o.door.name, "override", override, "admin", admin)
+		o.override = override
+		o.opened = false
+		return runCommand(ctx, o.door, "open-hours", admin, "keep-open")
+	case overrideClosed:
+		slog.Info("Overriding open hours", "door", o.door.name, "override", override, "admin", admin)
+		o.override = override
+		o.opened = false
+		return runCommand(ctx, o.door, "open-hours", admin, "close")
+	case "":
+		if o.override == "" {
+			return nil
+		}
+		slog.Info("Resuming open hours", "door", o.door.name, "admin", admin)
+		previous := o.override
+		o.override = ""
+		o.open = o.wantOpen(clk.Now())
+		switch {
+		case o.open && previous == overrideOpen:
+			// Already open, but to be locked at closing time
+			o.opened = true
+		case o.open:
+			o.apply(ctx, true)
+		case previous == overrideOpen:
+			return runCommand(ctx, o.door, "open-hours", admin, "close")
+		}
+		return nil
+	}
+	return errInvalidOverride
+}
+
+func (o *openHours) status() httpapi.OpenHoursStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := clk.Now()
+	return httpapi.OpenHoursStatus{
+		Door:     o.door.name,
+		Hours:    o.text,
+		Open:     o.wantOpen(now),
+		Holiday:  holidays[now.Format("2006-01-02")],
+		Override: o.override,
+	}
+}
+
+// swipeAction returns the action a granted swipe of cred at d takes at t:
+// keep-open within the door's swipe-keep-open hours if cred may keep it
+// open, and open with auto-lock otherwise
+func swipeAction(d *door, cred store.Credential, t time.Time) string {
+	if d.swipeKeepOpen == nil || !d.swipeKeepOpen.Allows(t) || holidays[t.Format("2006-01-02")] {
+		return "open"
+	}
+	if permissions.Check(cred, store.ActionKeepOpen) != nil {
+		return "open"
+	}
+	return "keep-open"
+}
+
+// openHoursStatus reports the open hours of the doors having them
+func openHoursStatus() []httpapi.OpenHoursStatus {
+	var list []httpapi.OpenHoursStatus
+	for _, d := range doors {
+		if d.openHours != nil {
+			list = append(list, d.openHours.status())
+		}
+	}
+	return list
+}
+
+// overrideOpenHours overrides the open hours of the door named name on
+// behalf of admin
+func overrideOpenHours(ctx context.Context, name, override, admin string) error {
+	d := doorByName(name)
+	if d == nil || d.openHours == nil {
+		return httpapi.ErrNoOpenHours
+	}
+	return d.openHours.Override(ctx, override, admin)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/passback"
+)
+
+var (
+	antiPassbackMode = flag.String("anti-passback", "off", "what to do with entries of tokens that never exited: off, log or deny")
+	entryReaders     = flag.String("entry-readers", "", "comma separated labels of the readers outside, swiped to enter")
+	exitReaders      = flag.String("exit-readers", "", "comma separated labels of the readers inside, swiped to exit")
+)
+
+var (
+	// antiPassback tracks the tokens inside; nil if disabled
+	antiPassback *passback.Tracker
+	// enforcePassback is set if entries of tokens inside are denied, not
+	// just logged
+	enforcePassback bool
+)
+
+// passbackConfig returns the tracker set up by the flags, nil if disabled,
+// and whether it is enforced. The readers must belong to the doors
+func passbackConfig() (*passback.Tracker, bool, error) {
+	switch *antiPassbackMode {
+	case "off":
+		return nil, false, nil
+	case "log", "deny":
+	default:
+		return nil, false, fmt.Errorf("anti-passback: invalid mode %q, expected off, log or deny", *antiPassbackMode)
+	}
+	known := map[string]bool{}
+	for _, d := range doors {
+		for _, r := range d.readers {
+			known[readerLabel(r)] = true
+		}
+	}
+	var lists [2][]string
+	for i, text := range []string{*entryReaders, *exitReaders} {
+		for _, label := range strings.Split(text, ",") {
+			if label = strings.TrimSpace(label); label == "" {
+				continue
+			}
+			if !known[label] {
+				return nil, false, fmt.Errorf("anti-passback: unknown reader %q", label)
+			}
+			if i == 1 && slices.Contains(lists[0], label) {
+				return nil, false, fmt.Errorf("anti-passback: reader %q is both an entry and an exit reader", label)
+			}
+			lists[i] = append(lists[i], label)
+		}
+	}
+	if len(lists[0]) == 0 || len(lists[1]) == 0 {
+		return nil, false, errors.New("anti-passback requires entry-readers and exit-readers")
+	}
+	return passback.New(lists[0], lists[1]), *antiPassbackMode == "deny", nil
+}
+
+// checkPassback reports the entry of a token that never exited as an alert.
+// It returns passback.ErrNotExited if anti-passback is enforced
+func checkPassback(event events.Access, logger *slog.Logger) error {
+	err := antiPassback.Check(event)
+	if err == nil {
+		return nil
+	}
+	who := event.User
+	if who == "" {
+		who = "a token"
+	}
+	hub.Publish(events.Event{Type: events.TypeAlert, Door: event.Door, Message: fmt.Sprintf("%s entered again at %s without exiting", who, event.Reader)})
+	if enforcePassback {
+		return err
+	}
+	logger.Warn("Entry without exit, the card may be shared")
+	return nil
+}
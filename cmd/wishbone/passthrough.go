@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/gorilla/websocket"
+)
+
+// startPassthrough puts the serial reader labeled name, or at the device
+// name, in maintenance mode. Without a name, it picks the only serial
+// reader
+func startPassthrough(name string, received io.Writer) (io.WriteCloser, error) {
+	if *simulate {
+		// The readers aren't opened
+		return nil, httpapi.ErrUnknownReader
+	}
+	var found []*reader.Serial
+	for _, d := range doors {
+		for _, r := range d.readers {
+			s, ok := r.(*reader.Serial)
+			if ok && (name == "" || name == s.Label || name == s.Device) {
+				found = append(found, s)
+			}
+		}
+	}
+	if len(found) != 1 {
+		return nil, httpapi.ErrUnknownReader
+	}
+	return found[0].StartPassthrough(received)
+}
+
+// readerCommand runs the reader subcommands
+func readerCommand(args []string) {
+	if len(args) == 0 || args[0] != "console" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	setupCommand()
+	defer remoteLog.Close()
+	readerConsole(args[1:])
+}
+
+// readerConsole connects the terminal to a serial reader through the
+// passthrough of the running daemon, until stdin is closed
+func readerConsole(args []string) {
+	fs := flag.NewFlagSet("reader console", flag.ExitOnError)
+	fs.Usage = usage
+	hexMode := fs.Bool("hex", false, "read lines of hex encoded bytes from stdin, and print what the reader sends hex encoded")
+	apiURL := fs.String("url", "", "URL of the daemon's HTTP API (the first address of -listen if empty)")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		usage()
+		os.Exit(2)
+	}
+	token := os.Getenv(adminTokenEnv)
+	if token == "" {
+		fatal("Admin token missing", "env", adminTokenEnv)
+	}
+	client := http.DefaultClient
+	if *apiURL == "" {
+		*apiURL, client = localAPI()
+	}
+	if *apiURL == "" {
+		fatal("Daemon URL missing, set -listen or pass -url")
+	}
+	u, err := url.Parse(strings.TrimSuffix(*apiURL, "/") + "/api/v1/passthrough")
+	if err != nil {
+		fatal("Invalid URL", "url", *apiURL, "err", err)
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.RawQuery = url.Values{"reader": {fs.Arg(0)}}.Encode()
+
+	dialer := *websocket.DefaultDialer
+	if t, ok := client.Transport.(*http.Transport); ok {
+		// Over the daemon's unix socket
+		dialer.NetDialContext = t.DialContext
+	}
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, resp, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		if resp != nil {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			fatal("Could not start passthrough", "status", resp.Status, "err", strings.TrimSpace(string(msg)))
+		}
+		fatal("Could not reach daemon", "url", *apiURL, "err", err)
+	}
+	defer conn.Close()
+	fmt.Fprintln(os.Stderr, "Connected, the reader reads no tokens until stdin is closed")
+
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseNormalClosure {
+					fatal("Passthrough ended", "err", closeErr.Text)
+				}
+				os.Exit(0)
+			}
+			if *hexMode {
+				fmt.Println(hex.EncodeToString(data))
+			} else {
+				os.Stdout.Write(data)
+			}
+		}
+	}()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		var data []byte
+		if *hexMode {
+			line, err := in.ReadString('\n')
+			if err != nil && line == "" {
+				break
+			}
+			if data, err = hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(line), " ", "")); err != nil {
+				fmt.Fprintln(os.Stderr, "Invalid hex:", err)
+				continue
+			}
+		} else {
+			buf := make([]byte, 256)
+			n, err := in.Read(buf)
+			if err != nil {
+				break
+			}
+			data = buf[:n]
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			fatal("Could not send to reader", "err", err)
+		}
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
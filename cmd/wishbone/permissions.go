@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	unlockGroups   = flag.String("unlock-groups", "", "comma separated groups allowed to unlock, e.g. members,keyholders (any valid token if empty)")
+	lockGroups     = flag.String("lock-groups", "", "comma separated groups allowed to lock through the unlock API (any valid token if empty)")
+	keepOpenGroups = flag.String("keep-open-groups", "", "comma separated groups allowed to keep the door open, disabling auto-lock (any valid token if empty)")
+	adminGroups    = flag.String("admin-groups", "", "comma separated groups the owners of admin tokens need to be in, by the name of their tokens in the store (any admin token if empty)")
+
+	failPolicyFlag  = flag.String("fail-policy", store.FailOpen, "who may unlock while the status is UNKNOWN or FAILURE: allow, keyholders or deny")
+	keyholderGroups = flag.String("keyholder-groups", "keyholders", "comma separated groups that may unlock with -fail-policy keyholders")
+)
+
+var (
+	// permissions holds the groups actions are restricted to
+	permissions store.Permissions
+	// failPolicy restricts unlocking doors whose status is UNKNOWN or FAILURE
+	failPolicy store.FailPolicy
+	// policyMu guards permissions and failPolicy. Only reloads on the main
+	// loop change them, so the main loop reads them without it
+	policyMu sync.RWMutex
+)
+
+// currentPermissions returns permissions, for use outside the main loop
+func currentPermissions() store.Permissions {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return permissions
+}
+
+// currentFailPolicy returns failPolicy, for use outside the main loop
+func currentFailPolicy() store.FailPolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return failPolicy
+}
+
+// permissionsConfig returns the groups the flags restrict actions to
+func permissionsConfig() (store.Permissions, error) {
+	p := store.Permissions{}
+	for action, text := range map[string]string{
+		store.ActionUnlock:   *unlockGroups,
+		store.ActionLock:     *lockGroups,
+		store.ActionKeepOpen: *keepOpenGroups,
+		store.ActionAdmin:    *adminGroups,
+	} {
+		groups, err := store.ParseGroups(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s-groups: %w", action, err)
+		}
+		if len(groups) > 0 {
+			p[action] = groups
+		}
+	}
+	return p, nil
+}
+
+// failPolicyConfig returns the fail policy set by the flags
+func failPolicyConfig() (store.FailPolicy, error) {
+	p, err := store.ParseFailPolicy(*failPolicyFlag, *keyholderGroups)
+	if err != nil {
+		return p, fmt.Errorf("fail-policy: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+// maxPINDigits caps the digits buffered while a PIN is typed
+const maxPINDigits = 32
+
+var (
+	keypadSpec = flag.String("keypad", "", "keypad for PINs: a serial device, or matrix:ROWS/COLS with BCM pins, e.g. matrix:5,6,13,19/26,16,20 (disabled if empty)")
+	pinTimeout = flag.Duration("pin-timeout", 15*time.Second, "how long to wait for the PIN after a token requiring one")
+)
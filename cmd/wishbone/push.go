@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/outbox"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	pushoverToken  = flag.String("pushover-token", "", "API token of the Pushover application sending push notifications, or env:NAME or secret:NAME to refer to it (disabled if empty)")
+	pushoverUser   = flag.String("pushover-user", "", "Pushover user or group key receiving the push notifications, or env:NAME or secret:NAME")
+	ntfyTopic      = flag.String("ntfy-topic", "", "URL of the ntfy topic push notifications are published to, e.g. https://ntfy.sh/door-alerts (disabled if empty)")
+	ntfyToken      = flag.String("ntfy-token", "", "access token for -ntfy-topic, or env:NAME or secret:NAME (none if empty)")
+	pushAfterHours = flag.String("push-after-hours", "22:00-06:00", "push notifications about unknown tokens in this time range (never if empty)")
+	pushoverAPI    = "https://api.pushover.net/1/messages.json"
+)
+
+// pusher sends push notifications to the phones of keyholders through
+// Pushover and ntfy, for failures, alerts like lockdowns, and unknown tokens
+// after hours
+type pusher struct {
+	afterHours *store.TimeWindow
+	client     *http.Client
+}
+
+func pushEnabled() bool {
+	return *pushoverToken != "" || *ntfyTopic != ""
+}
+
+func newPusher() (*pusher, error) {
+	if *pushoverToken != "" && *pushoverUser == "" {
+		return nil, errors.New("pushover-token requires pushover-user")
+	}
+	if *ntfyTopic != "" {
+		u, err := url.Parse(*ntfyTopic)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.Trim(u.Path, "/") == "" {
+			return nil, fmt.Errorf("invalid ntfy topic URL %q", *ntfyTopic)
+		}
+	}
+	p := &pusher{client: &http.Client{Timeout: 30 * time.Second}}
+	if *pushAfterHours != "" {
+		w, err := store.ParseTimeWindow("daily " + *pushAfterHours)
+		if err != nil {
+			return nil, err
+		}
+		p.afterHours = &w
+	}
+	return p, nil
+}
+
+// pushNotice returns the push notification about e like eventNotice, but
+// only about unknown tokens within afterHours and not about unlocks. Urgent
+// notifications are sent with a high priority
+func pushNotice(e events.Event, afterHours *store.TimeWindow) (text string, urgent, ok bool) {
+	if e.Type == events.TypeAccess && e.Access.Result == events.ResultUnknown {
+		if afterHours == nil || !afterHours.Allows(e.Time) {
+			return "", false, false
+		}
+		text, ok = eventNotice(e, nil)
+		return text, false, ok
+	}
+	text, ok = eventNotice(e, nil)
+	return text, true, ok
+}
+
+// notifyEvents queues push notifications about the events of c
+func (p *pusher) notifyEvents(c <-chan events.Event) {
+	for e := range c {
+		text, urgent, ok := pushNotice(e, p.afterHours)
+		if !ok {
+			continue
+		}
+		var meta map[string]string
+		if urgent {
+			meta = map[string]string{"urgent": "1"}
+		}
+		if *pushoverToken != "" {
+			notifications.Add(outbox.Item{Channel: "pushover", Payload: text, Meta: meta})
+		}
+		if *ntfyTopic != "" {
+			notifications.Add(outbox.Item{Channel: "ntfy", Payload: text, Meta: meta})
+		}
+	}
+}
+
+// deliverPushover sends a queued notification through Pushover
+func (p *pusher) deliverPushover(i outbox.Item) error {
+	form := url.Values{
+		"token":   {*pushoverToken},
+		"user":    {*pushoverUser},
+		"title":   {"wishbone"},
+		"message": {i.Payload},
+	}
+	if i.Meta["urgent"] != "" {
+		form.Set("priority", "1")
+	}
+	resp, err := p.client.PostForm(pushoverAPI, form)
+	if err != nil {
+		return fmt.Errorf("pushover: %w", err)
+	}
+	return pushResult("pushover", resp)
+}
+
+// deliverNtfy publishes a queued notification to the ntfy topic
+func (p *pusher) deliverNtfy(i outbox.Item) error {
+	req, err := http.NewRequest(http.MethodPost, *ntfyTopic, strings.NewReader(i.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", "wishbone")
+	if i.Meta["urgent"] != "" {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	}
+	if *ntfyToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*ntfyToken)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ntfy: %w", err)
+	}
+	return pushResult("ntfy", resp)
+}
+
+// pushResult turns the reply of a push service into an error. Notifications
+// it rejects aren't retried unless it asks to
+func pushResult(service string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err := fmt.Errorf("%s replied %s %s", service, resp.Status, strings.TrimSpace(string(text)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return outbox.Permanent(err)
+	}
+	return err
+}
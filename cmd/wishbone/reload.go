@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/rules"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// reloadable are the options a reload applies. The others were used to set
+// up the daemon and only take effect when it restarts. These are only read
+// on the main loop, where reloads run, or through policyMu
+var reloadable = map[string]bool{
+	"log-level":        true,
+	"debounce":         true,
+	"double-swipe":     true,
+	"unlock-groups":    true,
+	"lock-groups":      true,
+	"keep-open-groups": true,
+	"admin-groups":     true,
+	"fail-policy":      true,
+	"keyholder-groups": true,
+}
+
+// reloadRequest asks the main loop to reload on behalf of admin, replying
+// the outcome on reply
+type reloadRequest struct {
+	admin string
+	reply chan reloadResult
+}
+
+type reloadResult struct {
+	report httpapi.ReloadReport
+	err    error
+}
+
+// requestReload hands a reload to the main loop through reloads and waits
+// for it. Once the main loop took it, it is finished even if ctx is done
+func requestReload(reloads chan<- reloadRequest) func(ctx context.Context, admin string) (httpapi.ReloadReport, error) {
+	return func(ctx context.Context, admin string) (httpapi.ReloadReport, error) {
+		req := reloadRequest{admin: admin, reply: make(chan reloadResult, 1)}
+		select {
+		case reloads <- req:
+		case <-ctx.Done():
+			return httpapi.ReloadReport{}, ctx.Err()
+		}
+		res := <-req.reply
+		return res.report, res.err
+	}
+}
+
+// run reloads and replies, also if reloading panics
+func (req reloadRequest) run(creds store.CredentialStore) {
+	res := reloadResult{err: errors.New("reload failed")}
+	defer func() { req.reply <- res }()
+	res.report, res.err = reload(creds, req.admin)
+}
+
+// reload re-reads the config file and the credentials and applies them, or
+// nothing if either is invalid or the config changes options that aren't
+// reloadable
+func reload(creds store.CredentialStore, admin string) (httpapi.ReloadReport, error) {
+	report := httpapi.ReloadReport{Changed: map[string]string{}}
+	changed, err := configChanges()
+	if err != nil {
+		return report, err
+	}
+	var names, restart []string
+	for name := range changed {
+		names = append(names, name)
+		if !reloadable[name] {
+			restart = append(restart, name)
+		}
+	}
+	sort.Strings(names)
+	if len(restart) > 0 {
+		sort.Strings(restart)
+		return report, fmt.Errorf("%w: %s", httpapi.ErrRestartRequired, strings.Join(restart, ", "))
+	}
+
+	// The flags are set to check them and build what they configure, and
+	// set back unless everything can be applied
+	previous := map[string]string{}
+	for name, value := range changed {
+		previous[name] = flag.Lookup(name).Value.String()
+		flag.Set(name, value)
+	}
+	applied := false
+	defer func() {
+		if !applied {
+			for name, value := range previous {
+				flag.Set(name, value)
+			}
+		}
+	}()
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(*logLevel)); err != nil {
+		return report, fmt.Errorf("%w: invalid log-level %q", httpapi.ErrInvalidConfig, *logLevel)
+	}
+	if err := validateConfig(); err != nil {
+		return report, fmt.Errorf("%w: %v", httpapi.ErrInvalidConfig, err)
+	}
+	p, err := permissionsConfig()
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", httpapi.ErrInvalidConfig, err)
+	}
+	fp, err := failPolicyConfig()
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", httpapi.ErrInvalidConfig, err)
+	}
+
+	// Stores reloading keep the previous credentials if that fails
+	before, listErr := creds.List()
+	if err := reloadCredentials(creds); err != nil {
+		return report, fmt.Errorf("could not reload credentials: %w", err)
+	}
+	if after, err := creds.List(); listErr == nil && err == nil {
+		report.Credentials = credentialChanges(before, after)
+	}
+
+	applied = true
+	policyMu.Lock()
+	permissions, failPolicy = p, fp
+	policyMu.Unlock()
+	if _, ok := changed["log-level"]; ok {
+		level.Set(l)
+	}
+	report.Changed = changed
+	args := []any{"admin", admin, "changed", names}
+	if c := report.Credentials; c != nil {
+		args = append(args, "added", c.Added, "removed", c.Removed, "updated", c.Changed)
+	}
+	slog.Info("Reloaded config", args...)
+	return report, nil
+}
+
+// configChanges reads the config file again and returns the options whose
+// values it changes, with their new values. Options given on the command
+// line keep their values, and those no longer in the file go back to their
+// defaults
+func configChanges() (map[string]string, error) {
+	changed := map[string]string{}
+	if *configPath == "" {
+		return changed, nil
+	}
+	bytes, err := os.ReadFile(*configPath)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfig(*configPath, bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", httpapi.ErrInvalidConfig, err)
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || configExcluded[f.Name] || commandLineFlags[f.Name] {
+			return
+		}
+		text := f.DefValue
+		if value, ok := values[f.Name]; ok {
+			text = configValue(value)
+		}
+		if isSecretFlag(f.Name) {
+			if text, err = configSecrets.Resolve(text); err != nil {
+				err = fmt.Errorf("%w: %s: %v", httpapi.ErrInvalidConfig, f.Name, err)
+				return
+			}
+		}
+		// A new value of the flag's type, so values are compared as the
+		// flag prints them, e.g. 1m and 60s alike
+		v := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+		if setErr := v.Set(text); setErr != nil {
+			err = fmt.Errorf("%w: %s: %s: %v", httpapi.ErrInvalidConfig, *configPath, f.Name, setErr)
+			return
+		}
+		if v.String() != f.Value.String() {
+			changed[f.Name] = v.String()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The sections aren't reloadable, so their new values aren't reported
+	var doors []doorConfig
+	var ruleList []rules.Config
+	legacy := httpapi.DefaultLegacyProfile()
+	for key, v := range map[string]interface{}{"doors": &doors, "rules": &ruleList, "legacy-profile": &legacy} {
+		if err := decodeSection(values, key, *configPath, v); err != nil {
+			return nil, fmt.Errorf("%w: %v", httpapi.ErrInvalidConfig, err)
+		}
+	}
+	if !reflect.DeepEqual(doors, extraDoors) {
+		changed["doors"] = ""
+	}
+	if !reflect.DeepEqual(ruleList, ruleConfigs) {
+		changed["rules"] = ""
+	}
+	if !reflect.DeepEqual(legacy, legacyProfile) {
+		changed["legacy-profile"] = ""
+	}
+	return changed, nil
+}
+
+// isSecretFlag reports whether name is one of secretFlags
+func isSecretFlag(name string) bool {
+	for _, s := range secretFlags {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadCredentials re-reads the credentials of stores keeping them in
+// memory. The others are read on every lookup
+func reloadCredentials(creds store.CredentialStore) error {
+	switch s := creds.(type) {
+	case interface{ Reload() error }:
+		return s.Reload()
+	case interface{ Refresh() (bool, error) }:
+		_, err := s.Refresh()
+		return err
+	}
+	return nil
+}
+
+// credentialChanges counts the credentials added, removed and changed from
+// before to after, by token
+func credentialChanges(before, after []store.Credential) *httpapi.CredentialChanges {
+	c := &httpapi.CredentialChanges{}
+	old := map[string]store.Credential{}
+	for _, cred := range before {
+		old[cred.Token] = cred
+	}
+	for _, cred := range after {
+		prev, ok := old[cred.Token]
+		switch {
+		case !ok:
+			c.Added++
+		case !reflect.DeepEqual(prev, cred):
+			c.Changed++
+		}
+		delete(old, cred.Token)
+	}
+	c.Removed = len(old)
+	return c
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/feedback"
+	"github.com/craftamap/wishbone/internal/rules"
+)
+
+var (
+	// ruleConfigs are the rules listed under "rules" in the config file
+	ruleConfigs []rules.Config
+
+	// siteRules are the rules set up from ruleConfigs; nil if there are none
+	siteRules []rules.Rule
+	// ruleHooks are the webhooks of the rules by the argument of their
+	// action
+	ruleHooks map[string]webhook
+)
+
+// rulesConfig returns the rules of the config file and their webhooks. The
+// doors and readers must exist
+func rulesConfig() ([]rules.Rule, map[string]webhook, error) {
+	list, err := rules.Parse(ruleConfigs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rules: %w", err)
+	}
+	known := map[string]bool{}
+	for _, d := range doors {
+		for _, r := range d.readers {
+			known[readerLabel(r)] = true
+		}
+	}
+	hooks := map[string]webhook{}
+	for _, r := range list {
+		for name := range r.Doors {
+			if doorByName(name) == nil {
+				return nil, nil, fmt.Errorf("rules: %s: unknown door %q", r.Name, name)
+			}
+		}
+		for label := range r.Readers {
+			if !known[label] {
+				return nil, nil, fmt.Errorf("rules: %s: unknown reader %q", r.Name, label)
+			}
+		}
+		for _, a := range r.Actions {
+			if a.Verb != rules.Webhook {
+				continue
+			}
+			fields := strings.Fields(a.Arg)
+			if len(fields) > 2 {
+				return nil, nil, fmt.Errorf("rules: %s: expected webhook url and optionally secret", r.Name)
+			}
+			h := webhook{URL: fields[0], Secret: "-"}
+			if len(fields) == 2 {
+				if h.Secret, err = configSecrets.Resolve(fields[1]); err != nil {
+					return nil, nil, fmt.Errorf("rules: %s: %w", r.Name, err)
+				}
+			}
+			hooks[a.Arg] = h
+		}
+	}
+	return list, hooks, nil
+}
+
+// ruleSignals reports whether a rule gives feedback for e, instead of the
+// usual signal
+func ruleSignals(e events.Access) bool {
+	for _, r := range rules.Matching(siteRules, events.Event{Type: events.TypeAccess, Time: e.Time, Access: &e}) {
+		if r.Signals() {
+			return true
+		}
+	}
+	return false
+}
+
+// runRules runs the actions of the rules triggered by the events of c
+func runRules(c <-chan events.Event) {
+	for e := range c {
+		for _, r := range rules.Matching(siteRules, e) {
+			slog.Debug("Rule triggered", "rule", r.Name, "event", rules.Kind(e))
+			for _, a := range r.Actions {
+				runAction(r, a, e)
+			}
+		}
+	}
+}
+
+func runAction(r rules.Rule, a rules.Action, e events.Event) {
+	name, reader := e.Door, ""
+	if e.Access != nil {
+		name, reader = e.Access.Door, e.Access.Reader
+	}
+	switch a.Verb {
+	case rules.Webhook:
+		body, err := json.Marshal(webhookPayload{Kind: rules.Kind(e), Rule: r.Name, Event: e})
+		if err != nil {
+			slog.Error("Could not encode webhook payload", "rule", r.Name, "err", err)
+			return
+		}
+		ruleHooks[a.Arg].queue(body)
+	case rules.Feedback:
+		o, _ := feedback.ParseOutcome(a.Arg)
+		// Events of no door, like most alerts, are signaled at all of them
+		targets := doors
+		if d := doorByName(name); d != nil {
+			targets = []*door{d}
+		}
+		for _, d := range targets {
+			for _, out := range d.feedback {
+				out.Signal(o, reader)
+			}
+		}
+	case rules.Alert:
+		hub.Publish(events.Event{Type: events.TypeAlert, Door: name, Message: a.Arg})
+	case rules.Log:
+		slog.Warn(a.Arg, "rule", r.Name, "event", rules.Kind(e), "door", name)
+	}
+}
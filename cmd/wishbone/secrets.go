@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/craftamap/wishbone/internal/secrets"
+)
+
+var (
+	secretsPath     = flag.String("secrets", "", "YAML file of named secrets for secret:NAME references, in plaintext or encrypted with age or sops (none if empty)")
+	secretsIdentity = flag.String("secrets-identity", "", "age identity file decrypting -secrets, also passed to sops")
+)
+
+// secretFlags are the flags that may refer to a secret as env:NAME or
+// secret:NAME instead of holding it
+var secretFlags = []string{"telegram-token", "matrix-token", "mqtt-password", "ldap-bind-password", "store-token", "oidc-client-secret", "audit-key", "slack-webhook", "slack-token", "slack-signing-secret", "pushover-token", "pushover-user", "ntfy-token"}
+
+// configSecrets are the secrets loaded from -secrets; nil without
+var configSecrets secrets.Secrets
+
+// resolveSecrets loads the secrets file and replaces the references in the
+// secret flags by the secrets, so a missing one fails at startup rather
+// than when it is first used
+func resolveSecrets() error {
+	if *secretsPath != "" {
+		s, err := secrets.Load(*secretsPath, *secretsIdentity)
+		if err != nil {
+			return fmt.Errorf("secrets %s: %w", *secretsPath, err)
+		}
+		configSecrets = s
+	}
+	for _, name := range secretFlags {
+		f := flag.Lookup(name)
+		value, err := configSecrets.Resolve(f.Value.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		f.Value.Set(value)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+)
+
+var selfTest = flag.Bool("self-test", false, "on startup, pulse the close relay of each door with status pins and exit unless they then read LOCKED")
+
+const (
+	// statusSampleWindow is how long the status pins are read on startup
+	// to tell whether they are plausible, every statusSampleInterval
+	statusSampleWindow   = 500 * time.Millisecond
+	statusSampleInterval = 10 * time.Millisecond
+	// maxStatusChanges is how often they may change meanwhile, as the
+	// sphincter may pass through other codes while it moves
+	maxStatusChanges = 2
+)
+
+// uartDevice is the UART on GPIO 14 (TXD) and 15 (RXD) of the Raspberry
+// Pi's pin header, whichever of its UARTs that is
+const uartDevice = "/dev/serial0"
+
+// usesPinHeaderUART reports whether device is the UART on GPIO 14 and 15,
+// so readers on it conflict with other uses of those pins
+func usesPinHeaderUART(device string) bool {
+	uart, err := os.Stat(uartDevice)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(device)
+	return err == nil && os.SameFile(uart, fi)
+}
+
+// checkDoorGPIO checks that the status pins of d, opened from c, read
+// plausibly and, with -self-test, that pulsing its close relay locks it.
+// Doors without status pins, simulated or in dry run aren't checked
+func checkDoorGPIO(d *door, c doorConfig) error {
+	if !d.polled {
+		if *selfTest && !*simulate && !*dryRun {
+			slog.Warn("Skipping self-test of door without status pins", "door", d.name)
+		}
+		return nil
+	}
+
+	status, changes := actuator.SampleStatus(inputs(d), statusSampleWindow, statusSampleInterval)
+	if changes > maxStatusChanges {
+		return fmt.Errorf("status pins %d and %d changed %d times within %v, they may be floating or not be wired to the sphincter", c.StatusPin0, c.StatusPin1, changes, statusSampleWindow)
+	}
+	switch status {
+	case actuator.StatusUnknown:
+		slog.Warn("Status pins read UNKNOWN, check that the sphincter is powered and wired to them", "door", d.name, "status-pin0", c.StatusPin0, "status-pin1", c.StatusPin1)
+	case actuator.StatusFailure:
+		slog.Warn("Sphincter reports FAILURE", "door", d.name)
+	}
+	if !*selfTest {
+		return nil
+	}
+
+	slog.Info("Testing close relay", "door", d.name, "close-pin", c.ClosePin, "status", status.String())
+	ctx, cancel := context.WithTimeout(context.Background(), *commandTimeout)
+	defer cancel()
+	err := d.Close(ctx)
+	after := inputs(d).Status()
+	switch {
+	case after == actuator.StatusLocked:
+		if err != nil {
+			slog.Warn("Sphincter locked only after the close command failed", "door", d.name, "err", err)
+		}
+		slog.Info("Self-test passed", "door", d.name)
+		return nil
+	case after == actuator.StatusUnlocked && status != actuator.StatusUnlocked:
+		return fmt.Errorf("pulsing the close relay on GPIO %d unlocked the sphincter, are open-pin and close-pin swapped?", c.ClosePin)
+	case err != nil && !errors.Is(err, actuator.ErrNotMoved):
+		return fmt.Errorf("could not pulse the close relay on GPIO %d: %w", c.ClosePin, err)
+	}
+	return fmt.Errorf("status pins %d and %d read %v instead of LOCKED after pulsing the close relay on GPIO %d; check the wiring, close-pin and whether the pins are active low", c.StatusPin0, c.StatusPin1, after, c.ClosePin)
+}
+
+// inputs returns the actuator of d reading the status pins as they are,
+// without a failure to move reported as FAILURE
+func inputs(d *door) actuator.DoorActuator {
+	if v, ok := d.Watched.DoorActuator.(*actuator.Verified); ok {
+		return v.DoorActuator
+	}
+	return d.Watched.DoorActuator
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/outbox"
+	"github.com/craftamap/wishbone/internal/store"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+var (
+	slackWebhook       = flag.String("slack-webhook", "", "Slack incoming webhook URL notifications are posted to, or env:NAME or secret:NAME to refer to it")
+	slackToken         = flag.String("slack-token", "", "Slack bot token posting notifications to -slack-channel through the Web API instead of -slack-webhook, or env:NAME or secret:NAME")
+	slackChannel       = flag.String("slack-channel", "", "id or name of the Slack channel -slack-token posts to, e.g. #door")
+	slackSigningSecret = flag.String("slack-signing-secret", "", "signing secret of the Slack app, enabling its slash command on POST /slack/command, or env:NAME or secret:NAME (disabled if empty)")
+	slackUsers         = flag.String("slack-users", "", "comma separated ids of the Slack users allowed to control the doors by slash command, e.g. U0123ABCD")
+	slackAfterHours    = flag.String("slack-after-hours", "22:00-06:00", "notify about unlocks in this time range (disabled if empty)")
+	slackAPI           = "https://slack.com/api"
+)
+
+// slackMaxSkew is how old the timestamp of a slash command may be, so
+// captured requests can't be replayed later
+const slackMaxSkew = 5 * time.Minute
+
+// slackBot posts notifications to a Slack channel like the Telegram bot,
+// and runs the slash command of a Slack app for whitelisted users
+type slackBot struct {
+	webhook       string
+	token         string
+	channel       string
+	signingSecret string
+	users         map[string]bool
+	afterHours    *store.TimeWindow
+	client        *http.Client
+}
+
+func slackEnabled() bool {
+	return *slackWebhook != "" || *slackToken != "" || *slackSigningSecret != ""
+}
+
+func newSlackBot() (*slackBot, error) {
+	b := &slackBot{
+		webhook:       *slackWebhook,
+		token:         *slackToken,
+		channel:       *slackChannel,
+		signingSecret: *slackSigningSecret,
+		users:         map[string]bool{},
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+	if b.token != "" && b.channel == "" {
+		return nil, errors.New("slack-token requires slack-channel")
+	}
+	for _, user := range strings.Split(*slackUsers, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			b.users[user] = true
+		}
+	}
+	if b.signingSecret != "" {
+		if len(b.users) == 0 {
+			return nil, errors.New("slack-signing-secret requires slack-users")
+		}
+		if *listen == "" {
+			return nil, errors.New("slack-signing-secret requires listen, as Slack sends slash commands over HTTP")
+		}
+	}
+	if *slackAfterHours != "" {
+		w, err := store.ParseTimeWindow("daily " + *slackAfterHours)
+		if err != nil {
+			return nil, err
+		}
+		b.afterHours = &w
+	}
+	return b, nil
+}
+
+// notifies reports whether the bot posts notifications
+func (b *slackBot) notifies() bool {
+	return b.webhook != "" || b.token != ""
+}
+
+// deliver posts a queued notification. Messages Slack rejects aren't
+// retried unless it asks to
+func (b *slackBot) deliver(i outbox.Item) error {
+	if b.token != "" {
+		return b.postMessage(i.Payload)
+	}
+	body, _ := json.Marshal(map[string]string{"text": i.Payload})
+	resp, err := b.client.Post(b.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL is the credential, so it mustn't end up in the log
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("slack webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("slack webhook replied %s %s", resp.Status, strings.TrimSpace(string(text)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return outbox.Permanent(err)
+	}
+	return err
+}
+
+// postMessage posts text to the channel through chat.postMessage
+func (b *slackBot) postMessage(text string) error {
+	body, _ := json.Marshal(map[string]string{"channel": b.channel, "text": text})
+	req, err := http.NewRequest(http.MethodPost, slackAPI+"/chat.postMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("chat.postMessage: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("chat.postMessage: %s", resp.Status)
+	}
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("chat.postMessage: %w", err)
+	}
+	if !result.OK {
+		return outbox.Permanent(fmt.Errorf("chat.postMessage: %s", result.Error))
+	}
+	return nil
+}
+
+// notifyEvents queues notifications about the events of c
+func (b *slackBot) notifyEvents(c <-chan events.Event) {
+	for e := range c {
+		if text, ok := eventNotice(e, b.afterHours); ok {
+			notifications.Add(outbox.Item{Channel: "slack", Payload: text})
+		}
+	}
+}
+
+// verify checks the signature Slack puts on its requests with the signing
+// secret
+func (b *slackBot) verify(r *http.Request, body []byte, now time.Time) error {
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("missing timestamp")
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return errors.New("stale timestamp")
+	}
+	mac := hmac.New(sha256.New, []byte(b.signingSecret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// ServeHTTP answers the slash command, e.g. /door open, sent by Slack as a
+// signed form. Slack waits only 3 seconds for the reply, so commands are
+// acknowledged right away and their outcome is posted to the response URL
+func (b *slackBot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := b.verify(r, body, time.Now()); err != nil {
+		slog.Warn("Rejected Slack request", "client", r.RemoteAddr, "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	user, name := form.Get("user_id"), form.Get("user_name")
+	reply := func(text string) {
+		writeSlackReply(w, "ephemeral", text)
+	}
+	if !b.users[user] {
+		slog.Info("Ignoring Slack command", "user", user, "name", name)
+		reply("You may not control the door")
+		return
+	}
+	fields := strings.Fields(form.Get("text"))
+	cmd := ""
+	if len(fields) > 0 {
+		cmd = fields[0]
+	}
+	d := doors[0]
+	if len(fields) > 1 {
+		if d = doorByName(fields[1]); d == nil {
+			reply("Unknown door " + fields[1])
+			return
+		}
+	}
+
+	switch cmd {
+	case "state":
+		if len(fields) == 1 {
+			var states []string
+			for _, d := range doors {
+				states = append(states, doorState(d.name, d.Status()))
+			}
+			reply(strings.Join(states, "\n"))
+			return
+		}
+		reply(doorState(d.name, d.Status()))
+	case "open", "keep-open", "close":
+		responseURL := form.Get("response_url")
+		// Run rather than Go, so a command that panicked isn't sent again
+		go supervisor.Run("slack command", func() {
+			text := ""
+			if err := runCommand(context.Background(), d, "slack", name, cmd); err != nil {
+				text = "Could not " + cmd + ": " + err.Error()
+			} else {
+				text = fmt.Sprintf("%s (%s by %s)", doorState(d.name, d.Status()), cmd, name)
+			}
+			if err := b.respond(responseURL, text); err != nil {
+				slog.Warn("Could not answer Slack command", "err", err)
+			}
+		})
+		w.WriteHeader(http.StatusOK)
+	default:
+		reply("Commands: state, open, keep-open, close, each optionally followed by a door")
+	}
+}
+
+// respond posts the outcome of a command to its response URL, visible to
+// the channel
+func (b *slackBot) respond(responseURL, text string) error {
+	u, err := url.Parse(responseURL)
+	if err != nil || u.Scheme != "https" || (u.Hostname() != "slack.com" && !strings.HasSuffix(u.Hostname(), ".slack.com")) {
+		return fmt.Errorf("invalid response URL %q", responseURL)
+	}
+	body, _ := json.Marshal(map[string]string{"response_type": "in_channel", "text": text})
+	resp, err := b.client.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response URL replied %s", resp.Status)
+	}
+	return nil
+}
+
+func writeSlackReply(w http.ResponseWriter, responseType, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"response_type": responseType, "text": text})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+var statsDays = flag.Int("stats-days", 366, "days unlocks and locks are counted for /api/v1/stats (disabled if 0)")
+
+// stats counts the unlocks and locks; nil if disabled
+var stats *events.Stats
+
+// loadStats counts the access events in the audit log at path, so the
+// statistics survive restarts. A missing log is no error
+func loadStats(s *events.Stats, path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e events.Access
+		// Lines torn by a crash are skipped
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		s.Add(e)
+		n++
+	}
+	return n, scanner.Err()
+}
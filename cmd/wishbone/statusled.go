@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/feedback"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	statusLEDPin      = flag.Int("status-led", -1, "BCM number of the GPIO driving an LED that shows the daemon's health: lit if healthy, blinking if degraded, blinking fast on FAILURE and dark if it hangs (not wired if -1)")
+	statusLEDInterval = flag.Duration("status-led-interval", 10*time.Second, "how often the health shown by -status-led is checked")
+
+	// statusLED is nil without -status-led. Set up in main
+	statusLED *feedback.StatusLED
+)
+
+// openStatusLED opens the LED at -status-led. When simulating or in a dry
+// run, it isn't driven, but changes of the health are still logged
+func openStatusLED() (*feedback.StatusLED, error) {
+	if *statusLEDPin < 0 {
+		return nil, nil
+	}
+	led := &feedback.StatusLED{Pin: *statusLEDPin}
+	if *simulate || *dryRun {
+		return led, nil
+	}
+	return led, led.Open()
+}
+
+// watchHealth shows the health on the status LED every -status-led-interval
+// until done is closed
+func watchHealth(creds store.CredentialStore, done <-chan struct{}) {
+	ticker := time.NewTicker(*statusLEDInterval)
+	defer ticker.Stop()
+	for {
+		statusLED.Set(healthLevel(creds))
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthLevel sums up the health reported on /healthz: FAILURE if a door
+// reports it, degraded if any other component is unhealthy
+func healthLevel(creds store.CredentialStore) feedback.Health {
+	for _, d := range doors {
+		if d.Status() == actuator.StatusFailure {
+			return feedback.HealthFailure
+		}
+	}
+	if err := creds.Check(); err != nil {
+		return feedback.HealthDegraded
+	}
+	for _, c := range health() {
+		if !c.OK {
+			return feedback.HealthDegraded
+		}
+	}
+	return feedback.HealthOK
+}
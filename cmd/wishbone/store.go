@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	credentialStore = flag.String("store", "", "credential store: file (-list), sqlite (-db), ldap (-ldap-url) or http (-store-url); chosen by which of them is set if empty")
+	storeURL        = flag.String("store-url", "", "base URL of another wishbone whose admin API holds the credentials, e.g. https://door.example.org")
+	storeToken      = flag.String("store-token", "", "admin token for -store-url, or env:NAME or secret:NAME to refer to it")
+	storeInterval   = flag.Duration("store-interval", time.Minute, "how often the credentials are fetched from -store-url")
+)
+
+// credentialStores open the credential stores by their name in -store for
+// the daemon. A new backend only needs an entry here
+var credentialStores = map[string]func() (store.CredentialStore, error){
+	"file":   openFileStore,
+	"sqlite": openSQLiteStore,
+	"ldap":   openLDAPStore,
+	"http":   openHTTPStore,
+}
+
+// storeKind returns the credential store selected by -store, or by which
+// of -ldap-url, -db and -store-url is set
+func storeKind() string {
+	switch {
+	case *credentialStore != "":
+		return *credentialStore
+	case *ldapURL != "":
+		return "ldap"
+	case *dbPath != "":
+		return "sqlite"
+	case *storeURL != "":
+		return "http"
+	}
+	return "file"
+}
+
+func validateStore() error {
+	switch storeKind() {
+	case "file":
+		if *list == "" {
+			return errors.New("list must not be empty")
+		}
+	case "sqlite":
+		if *dbPath == "" {
+			return errors.New("store sqlite requires db")
+		}
+	case "ldap":
+		if *ldapURL == "" {
+			return errors.New("store ldap requires ldap-url")
+		}
+	case "http":
+		if *storeURL == "" || *storeToken == "" {
+			return errors.New("store http requires store-url and store-token")
+		}
+		if *storeInterval <= 0 {
+			return errors.New("store-interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown store %q", *credentialStore)
+	}
+	return nil
+}
+
+// openUserList reads the user list, reloading it on SIGHUP and syncing it
+// if enabled
+func openUserList() (*store.ListStore, error) {
+	slog.Info("Reading user list", "path", *list)
+	users, err := store.OpenList(*list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", *list, err)
+	}
+	users.Clock = clk
+	reloadUserListOnSignal(users)
+	slog.Info("Found users", "users", users.Len())
+	if *syncURL != "" {
+		if syncClient, err = newSyncClient(users); err != nil {
+			return nil, fmt.Errorf("invalid sync config: %w", err)
+		}
+	}
+	return users, nil
+}
+
+func openFileStore() (store.CredentialStore, error) {
+	return openUserList()
+}
+
+func openSQLiteStore() (store.CredentialStore, error) {
+	slog.Info("Opening database", "path", *dbPath)
+	db, err := store.OpenDB(*dbPath, *list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", *dbPath, err)
+	}
+	db.Clock = clk
+	count, err := db.Count()
+	if err != nil {
+		return nil, fmt.Errorf("could not count tokens: %w", err)
+	}
+	slog.Info("Found tokens", "tokens", count)
+	return db, nil
+}
+
+// openLDAPStore falls back to the user list, if set
+func openLDAPStore() (store.CredentialStore, error) {
+	// A nil *ListStore must not end up in the interface
+	var fallback store.CredentialStore
+	if *list != "" {
+		users, err := openUserList()
+		if err != nil {
+			return nil, err
+		}
+		fallback = users
+	}
+	slog.Info("Looking up tokens in LDAP", "url", *ldapURL)
+	cfg, err := ldapConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not open offline cache: %w", err)
+	}
+	if cfg.Offline != nil {
+		slog.Info("Keeping offline cache", "path", *offlineCachePath)
+	}
+	s, err := store.NewLDAP(cfg, fallback)
+	if err != nil {
+		return nil, err
+	}
+	s.Clock = clk
+	return s, nil
+}
+
+// newHTTPStore returns the store of -store-url, without fetching from it
+func newHTTPStore() (*store.HTTP, error) {
+	s, err := store.NewHTTP(store.HTTPConfig{
+		URL:      *storeURL,
+		Token:    *storeToken,
+		Interval: *storeInterval,
+		Timeout:  10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Clock = clk
+	return s, nil
+}
+
+// openHTTPStore starts even if the server can't be reached, denying every
+// token until the credentials are fetched
+func openHTTPStore() (store.CredentialStore, error) {
+	s, err := newHTTPStore()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Fetching credentials", "url", *storeURL)
+	if _, err := s.Refresh(); err != nil {
+		slog.Error("Could not fetch credentials, retrying every interval", "url", *storeURL, "interval", *storeInterval, "err", err)
+		return s, nil
+	}
+	slog.Info("Found users", "users", s.Len())
+	return s, nil
+}
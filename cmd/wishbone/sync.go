@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/credsync"
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	syncURL      = flag.String("sync-url", "", "HTTPS endpoint of a membership system serving the user list in the format of -list, which it replaces (disabled if empty)")
+	syncInterval = flag.Duration("sync-interval", 5*time.Minute, "how often the user list is fetched from -sync-url")
+	syncKey      = flag.String("sync-key", "", "file with the hex encoded Ed25519 public key the lists from -sync-url are signed with")
+	syncToken    = flag.String("sync-token", "", "file with a bearer token to authenticate to -sync-url (none if empty)")
+
+	// syncClient is nil unless syncing is enabled
+	syncClient *credsync.Client
+)
+
+func validateSync() error {
+	switch {
+	case *syncURL == "":
+		return nil
+	case storeKind() != "file" && storeKind() != "ldap":
+		return errors.New("sync-url replaces the list and requires store file or ldap")
+	case *list == "":
+		return errors.New("sync-url requires list")
+	case *syncKey == "":
+		return errors.New("sync-url requires sync-key")
+	case !strings.HasPrefix(*syncURL, "https://"):
+		return errors.New("sync-url must be an https:// URL")
+	case *syncInterval <= 0:
+		return errors.New("sync-interval must be positive")
+	}
+	return nil
+}
+
+// newSyncClient returns a client syncing users with the membership system.
+// The serial of the list synced last is kept next to -list
+func newSyncClient(users *store.ListStore) (*credsync.Client, error) {
+	key, err := credsync.LoadPublicKey(*syncKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg := credsync.Config{URL: *syncURL, Interval: *syncInterval, PublicKey: key, StatePath: *list + ".serial"}
+	if *syncToken != "" {
+		token, err := os.ReadFile(*syncToken)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Token = strings.TrimSpace(string(token))
+	}
+	return credsync.New(cfg, users)
+}
+
+// syncHealth reports the sync as failing once the list wasn't fetched for
+// three intervals
+func syncHealth() httpapi.Check {
+	last, err := syncClient.Status()
+	if err == nil || time.Since(last) < 3**syncInterval {
+		return httpapi.Check{OK: true}
+	}
+	return httpapi.Check{Detail: err.Error()}
+}
+
+// syncCommand creates keys for and signs lists served to -sync-url. Lists
+// are signed with the current time as serial, or the one given
+func syncCommand(args []string) {
+	switch {
+	case len(args) == 2 && args[0] == "keygen":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fatal("Could not generate key", "err", err)
+		}
+		if err := os.WriteFile(args[1], []byte(hex.EncodeToString(priv)+"\n"), 0600); err != nil {
+			fatal("Could not write key", "err", err)
+		}
+		fmt.Println(hex.EncodeToString(pub))
+	case (len(args) == 3 || len(args) == 4) && args[0] == "sign":
+		issued := time.Now().UTC().Truncate(time.Second)
+		serial := uint64(issued.Unix())
+		if len(args) == 4 {
+			var err error
+			if serial, err = strconv.ParseUint(args[3], 10, 64); err != nil {
+				fatal("Invalid serial", "serial", args[3])
+			}
+		}
+		text, err := os.ReadFile(args[1])
+		if err != nil {
+			fatal("Could not read key", "err", err)
+		}
+		priv, err := hex.DecodeString(strings.TrimSpace(string(text)))
+		if err != nil || len(priv) != ed25519.PrivateKeySize {
+			fatal("Invalid key", "path", args[1])
+		}
+		list, err := os.ReadFile(args[2])
+		if err != nil {
+			fatal("Could not read list", "err", err)
+		}
+		fmt.Printf("%s: %d\n", credsync.SerialHeader, serial)
+		fmt.Printf("%s: %s\n", credsync.IssuedHeader, issued.Format(time.RFC3339))
+		fmt.Printf("%s: %s\n", credsync.SignatureHeader, credsync.Sign(ed25519.PrivateKey(priv), serial, issued, list))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/syslog"
+)
+
+var (
+	syslogServer = flag.String("syslog", "", "remote syslog server logs and access events are sent to, as udp://, tcp:// or tls://host:port (disabled if empty)")
+	syslogCA     = flag.String("syslog-ca", "", "CA certificate verifying a tls:// syslog server (system roots if empty)")
+
+	// remoteLog is the syslog server, nil if disabled
+	remoteLog *syslog.Writer
+)
+
+// sendAccess sends an access attempt to the syslog server, as JSON like in
+// the audit log
+func sendAccess(e events.Access) {
+	if remoteLog == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Could not encode access event", "err", err)
+		return
+	}
+	severity := syslog.Notice
+	if e.Result != events.ResultGranted {
+		severity = syslog.Warning
+	}
+	remoteLog.Send(syslog.AuthPriv, severity, e.Time, "access", string(data))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/outbox"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	telegramToken      = flag.String("telegram-token", "", "Telegram bot token, or env:NAME or secret:NAME to refer to it (bot disabled if empty)")
+	telegramChats      = flag.String("telegram-chats", "", "comma separated ids of the Telegram chats allowed to control the bot and receiving notifications")
+	telegramAfterHours = flag.String("telegram-after-hours", "22:00-06:00", "notify about unlocks in this time range (disabled if empty)")
+	telegramAPI        = "https://api.telegram.org"
+)
+
+// telegramBot answers /state, /open, /keepopen and /close in whitelisted
+// chats and notifies them about failures, unknown tokens and after-hours
+// unlocks
+type telegramBot struct {
+	token      string
+	chats      map[int64]bool
+	afterHours *store.TimeWindow
+	client     *http.Client
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		From struct {
+			Username  string `json:"username"`
+			FirstName string `json:"first_name"`
+		} `json:"from"`
+	} `json:"message"`
+}
+
+func newTelegramBot() (*telegramBot, error) {
+	b := &telegramBot{
+		token:  *telegramToken,
+		chats:  map[int64]bool{},
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+	for _, id := range strings.Split(*telegramChats, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		chat, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Telegram chat id %q", id)
+		}
+		b.chats[chat] = true
+	}
+	if len(b.chats) == 0 {
+		return nil, errors.New("telegram-chats must not be empty")
+	}
+	if *telegramAfterHours != "" {
+		w, err := store.ParseTimeWindow("daily " + *telegramAfterHours)
+		if err != nil {
+			return nil, err
+		}
+		b.afterHours = &w
+	}
+	return b, nil
+}
+
+// telegramError is an error replied by the Bot API
+type telegramError struct {
+	method      string
+	code        int
+	description string
+}
+
+func (e *telegramError) Error() string {
+	return e.method + ": " + e.description
+}
+
+// call invokes a Bot API method and decodes its result into result
+func (b *telegramBot) call(method string, params url.Values, result interface{}) error {
+	resp, err := b.client.PostForm(telegramAPI+"/bot"+b.token+"/"+method, params)
+	if err != nil {
+		// Don't log the URL, it contains the token
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		OK          bool            `json:"ok"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if !body.OK {
+		return &telegramError{method: method, code: body.ErrorCode, description: body.Description}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body.Result, result)
+}
+
+func (b *telegramBot) send(chat int64, text string) {
+	err := b.call("sendMessage", url.Values{
+		"chat_id": {strconv.FormatInt(chat, 10)},
+		"text":    {text},
+	}, nil)
+	if err != nil {
+		slog.Warn("Could not send Telegram message", "err", err)
+	}
+}
+
+// notify queues text for all chats. Replies to commands are sent right
+// away instead, as they are of no use later
+func (b *telegramBot) notify(text string) {
+	for chat := range b.chats {
+		notifications.Add(outbox.Item{Channel: "telegram", Dest: strconv.FormatInt(chat, 10), Payload: text})
+	}
+}
+
+// deliver sends a queued message. Messages the API rejects, e.g. as the bot
+// was removed from the chat, aren't retried unless it asks to
+func (b *telegramBot) deliver(i outbox.Item) error {
+	err := b.call("sendMessage", url.Values{"chat_id": {i.Dest}, "text": {i.Payload}}, nil)
+	var apiErr *telegramError
+	if errors.As(err, &apiErr) && apiErr.code >= 400 && apiErr.code < 500 && apiErr.code != http.StatusTooManyRequests {
+		return outbox.Permanent(err)
+	}
+	return err
+}
+
+// Run polls for commands; it doesn't return
+func (b *telegramBot) Run() {
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		err := b.call("getUpdates", url.Values{
+			"offset":  {strconv.FormatInt(offset, 10)},
+			"timeout": {"30"},
+		}, &updates)
+		if err != nil {
+			slog.Warn("Could not get Telegram updates", "err", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				b.handle(u.Message.Chat.ID, telegramUser(u), u.Message.Text)
+			}
+		}
+	}
+}
+
+func telegramUser(u telegramUpdate) string {
+	if u.Message.From.Username != "" {
+		return "@" + u.Message.From.Username
+	}
+	return u.Message.From.FirstName
+}
+
+func (b *telegramBot) handle(chat int64, user, text string) {
+	if !b.chats[chat] {
+		slog.Info("Ignoring Telegram message", "chat", chat)
+		return
+	}
+	// Commands may be addressed like /open@sphincter_bot, and be followed
+	// by the name of a door other than the first one
+	fields := strings.Fields(text + " ")
+	cmd := strings.TrimPrefix(strings.SplitN(fields[0], "@", 2)[0], "/")
+	d := doors[0]
+	if len(fields) > 1 {
+		if d = doorByName(fields[1]); d == nil {
+			b.send(chat, "Unknown door "+fields[1])
+			return
+		}
+	}
+
+	switch cmd {
+	case "state":
+		if len(fields) == 1 {
+			// Report all doors
+			for _, d := range doors {
+				b.send(chat, doorState(d.name, d.Status()))
+			}
+			return
+		}
+		b.send(chat, doorState(d.name, d.Status()))
+	case "open", "keepopen", "close":
+		if cmd == "keepopen" {
+			cmd = "keep-open"
+		}
+		if err := runCommand(context.Background(), d, "telegram", user, cmd); err != nil {
+			b.send(chat, "Could not "+cmd+": "+err.Error())
+			return
+		}
+		b.send(chat, doorState(d.name, d.Status()))
+	default:
+		b.send(chat, "Commands: /state, /open, /keepopen, /close, each optionally followed by a door")
+	}
+}
+
+// doorState describes the status of a door, naming it if there are several
+func doorState(name string, status actuator.Status) string {
+	if len(doors) == 1 {
+		return "Sphincter is " + status.String()
+	}
+	return "Door " + name + " is " + status.String()
+}
+
+func (b *telegramBot) notifyEvents(c <-chan events.Event) {
+	for e := range c {
+		if text, ok := eventNotice(e, b.afterHours); ok {
+			b.notify(text)
+		}
+	}
+}
+
+// eventNotice returns the message chat bots send about e: failures, alerts,
+// unknown tokens and unlocks within afterHours, which may be nil, other than
+// by the exit button
+func eventNotice(e events.Event, afterHours *store.TimeWindow) (string, bool) {
+	switch {
+	case e.Type == events.TypeState && e.Status == actuator.StatusFailure.String():
+		if len(doors) == 1 {
+			return "Sphincter reports FAILURE", true
+		}
+		return "Door " + e.Door + " reports FAILURE", true
+	case e.Type == events.TypeAlert:
+		return e.Message, true
+	case e.Type == events.TypeAccess && e.Access.Result == events.ResultUnknown:
+		return "Unknown token swiped" + readerSuffix(e.Access.Reader), true
+	case e.Type == events.TypeAccess && e.Access.Result == events.ResultGranted && e.Access.Action != "close" && e.Access.Action != "exit" &&
+		afterHours != nil && afterHours.Allows(e.Time):
+		who := e.Access.User
+		if who == "" {
+			who = e.Access.Source
+		}
+		return fmt.Sprintf("After-hours unlock by %s at %s", who, e.Time.Format("15:04")), true
+	}
+	return "", false
+}
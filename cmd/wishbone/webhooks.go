@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/outbox"
+	"github.com/craftamap/wishbone/internal/rules"
+)
+
+var webhooksPath = flag.String("webhooks", "", "file with webhooks, one \"url secret [event,...]\" per line, the secret may be env:NAME or secret:NAME (disabled if empty)")
+
+// Kinds of events webhooks can be subscribed to
+var webhookKinds = map[string]bool{
+	"unlock":        true,
+	"lock":          true,
+	"failure":       true,
+	"unknown_token": true,
+	"alert":         true,
+}
+
+// webhook is called with a JSON payload for the kinds of events it is
+// subscribed to. If secret isn't "-", the payload is signed with it
+type webhook struct {
+	URL    string
+	Secret string
+	// Kinds is empty for all kinds
+	Kinds map[string]bool
+}
+
+// webhookPayload is an event with the kind that triggered the webhook, and
+// the rule calling it, if any
+type webhookPayload struct {
+	Kind string `json:"event"`
+	Rule string `json:"rule,omitempty"`
+	events.Event
+}
+
+func parseWebhooks(path string) ([]webhook, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []webhook
+	for i, line := range strings.Split(string(bytes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected url, secret and optionally events", path, i+1)
+		}
+		secret, err := configSecrets.Resolve(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		h := webhook{URL: fields[0], Secret: secret, Kinds: map[string]bool{}}
+		if len(fields) == 3 {
+			for _, kind := range strings.Split(fields[2], ",") {
+				if !webhookKinds[kind] {
+					return nil, fmt.Errorf("%s:%d: unknown event %q", path, i+1, kind)
+				}
+				h.Kinds[kind] = true
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// webhookKind returns the kind of e, or "" if webhooks aren't called for it
+func webhookKind(e events.Event) string {
+	if kind := rules.Kind(e); webhookKinds[kind] {
+		return kind
+	}
+	return ""
+}
+
+// runWebhooks queues calls of the webhooks for every matching event of c
+func runWebhooks(hooks []webhook, c <-chan events.Event) {
+	for e := range c {
+		kind := webhookKind(e)
+		if kind == "" {
+			continue
+		}
+		body, err := json.Marshal(webhookPayload{Kind: kind, Event: e})
+		if err != nil {
+			slog.Error("Could not encode webhook payload", "err", err)
+			continue
+		}
+		for _, h := range hooks {
+			if len(h.Kinds) == 0 || h.Kinds[kind] {
+				h.queue(body)
+			}
+		}
+	}
+}
+
+// queue queues a call of the webhook with body. The signature is the hex
+// encoded HMAC-SHA256 of the body, like GitHub's X-Hub-Signature-256. It is
+// queued along with the body, so the secret isn't saved
+func (h webhook) queue(body []byte) {
+	i := outbox.Item{Channel: "webhook", Dest: h.URL, Payload: string(body)}
+	if h.Secret != "-" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		i.Meta = map[string]string{"signature": "sha256=" + hex.EncodeToString(mac.Sum(nil))}
+	}
+	notifications.Add(i)
+}
+
+// callWebhook posts a queued call. Client errors other than 408 and 429
+// aren't retried
+func callWebhook(client *http.Client, i outbox.Item) error {
+	req, err := http.NewRequest(http.MethodPost, i.Dest, strings.NewReader(i.Payload))
+	if err != nil {
+		return outbox.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sig := i.Meta["signature"]; sig != "" {
+		req.Header.Set("X-Wishbone-Signature", sig)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook replied %s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return outbox.Permanent(fmt.Errorf("webhook replied %s", resp.Status))
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("webhook replied %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Package access decides the tokens swiped and the PINs typed at the doors,
+// and unlocks them. The doors, the clock and the policies are passed in, so
+// the decisions don't depend on how the daemon is wired up
+package access
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/alarm"
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/latency"
+	"github.com/craftamap/wishbone/internal/lockdown"
+	"github.com/craftamap/wishbone/internal/ratelimit"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// Door is a door tokens are swiped at
+type Door interface {
+	Name() string
+	// Status is the status the policies are checked against
+	Status() actuator.Status
+	// Unlock opens the door for an attempt taking action: open, exit, or
+	// keep-open, which keeps it unlocked without auto-lock
+	Unlock(ctx context.Context, action string) error
+	// Lock closes the door and disarms its auto-lock
+	Lock(ctx context.Context) error
+}
+
+// Pipeline handles the swipes and keys read at the doors. Its methods must
+// be called from a single goroutine, the main loop, except for Unlock with
+// exit button presses, which neither wait for the alarm nor tell double
+// swipes. The doors are driven with Go, so the main loop goes on while
+// they move
+type Pipeline struct {
+	// Store looks up the tokens, taking at most LookupTimeout. StoreKind
+	// names it in latency traces
+	Store         store.CredentialStore
+	StoreKind     string
+	LookupTimeout time.Duration
+	// Clock times the debouncing, double swipes and PIN entry; the real
+	// clock if nil
+	Clock clock.Clock
+	// Lockout blocks readers after unknown tokens and wrong PINs; nil if
+	// disabled
+	Lockout *ratelimit.Lockout
+	// Lockdowns are checked again once a PIN was typed; nil if disabled
+	Lockdowns *lockdown.State
+	// Alarm holds unlocks while the burglar alarm is armed; nil if it isn't
+	// read
+	Alarm *alarm.Interlock
+	// Debounce is how long repeated reads of a token are ignored
+	Debounce time.Duration
+	// DoubleSwipe is how soon swiping a token again after it unlocked a door
+	// locks the door instead; disabled if 0
+	DoubleSwipe time.Duration
+	// Keypad is set if PINs can be typed. They are waited for PINTimeout
+	Keypad     bool
+	PINTimeout time.Duration
+	// CommandTimeout is how long opening or closing a door may take
+	CommandTimeout time.Duration
+	// Go runs f, opening or closing a door for a granted attempt and
+	// recording it, off the main loop; f is called right away if nil
+	Go func(f func())
+
+	// Permit checks whether cred may take action, e.g. unlock
+	Permit func(cred store.Credential, action string) error
+	// Policy checks an unlock permitted to cred against the policies of d,
+	// like the fail policy, lockdowns and anti-passback; nil allows all
+	Policy func(d Door, cred store.Credential, event events.Access, logger *slog.Logger) error
+	// Action returns the action of a granted swipe of cred at d at t, open
+	// or keep-open; open if nil
+	Action func(d Door, cred store.Credential, t time.Time) string
+	// Enroll offers an unknown token to a running enrollment, reporting
+	// whether it was taken; nil without enrollment
+	Enroll func(d Door, token, reader string) bool
+	// Code handles the keys typed while no PIN is waited for; nil if they
+	// are ignored
+	Code func(key rune)
+	// Record records an access attempt, and RecordLatency the timing of a
+	// handled swipe
+	Record        func(events.Access)
+	RecordLatency func(*latency.Trace)
+
+	// lastSwipes holds when each token was last read
+	lastSwipes map[string]time.Time
+	// unlockSwipes holds the door and time of the last unlock of each
+	// token hash. Guarded by mu, as unlocks are noted once the door opened
+	mu           sync.Mutex
+	unlockSwipes map[string]unlockSwipe
+	// pending is the swipe waiting for its PIN, if any
+	pending *pinEntry
+	// held is the unlock waiting for the alarm to disarm, if any
+	held *heldUnlock
+}
+
+type unlockSwipe struct {
+	door string
+	time time.Time
+}
+
+func (p *Pipeline) clock() clock.Clock {
+	return clock.Or(p.Clock)
+}
+
+// run runs f with Go
+func (p *Pipeline) run(f func()) {
+	if p.Go == nil {
+		f()
+		return
+	}
+	p.Go(f)
+}
+
+// Logger returns a logger adding the context of an access attempt to every
+// line. Tokens are only logged hashed
+func Logger(e events.Access) *slog.Logger {
+	args := []any{"door", e.Door, "source", e.Source}
+	if e.Reader != "" {
+		args = append(args, "reader", e.Reader)
+	}
+	if e.TokenHash != "" {
+		args = append(args, "token_hash", e.TokenHash)
+	}
+	if e.User != "" {
+		args = append(args, "user", e.User)
+	}
+	return slog.With(args...)
+}
+
+// isValid reports whether token is a valid token. Tokens of only 0 and F
+// are read from broken or missing cards
+func isValid(token string) bool {
+	token = strings.ReplaceAll(token, "F", "")
+	token = strings.ReplaceAll(token, "0", "")
+	return len(token) > 0
+}
@@ -0,0 +1,397 @@
+package access
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/alarm"
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/latency"
+	"github.com/craftamap/wishbone/internal/ratelimit"
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// fakeDoor records how it was driven
+type fakeDoor struct {
+	unlocks, keepOpens, locks int
+}
+
+func (d *fakeDoor) Name() string            { return "front" }
+func (d *fakeDoor) Status() actuator.Status { return actuator.StatusLocked }
+
+func (d *fakeDoor) Unlock(ctx context.Context, action string) error {
+	d.unlocks++
+	if action == "keep-open" {
+		d.keepOpens++
+	}
+	return nil
+}
+
+func (d *fakeDoor) Lock(ctx context.Context) error {
+	d.locks++
+	return nil
+}
+
+// fakeStore knows the credentials in creds by token
+type fakeStore struct {
+	store.CredentialStore
+	creds map[string]store.Credential
+}
+
+func (s fakeStore) Lookup(ctx context.Context, token string) (store.Credential, error) {
+	c, ok := s.creds[token]
+	if !ok {
+		return store.Credential{}, store.ErrUnknownToken
+	}
+	return c, nil
+}
+
+type fixture struct {
+	*Pipeline
+	door    *fakeDoor
+	clock   *clock.Fake
+	records []events.Access
+}
+
+func newFixture() *fixture {
+	f := &fixture{door: &fakeDoor{}, clock: clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))}
+	f.Pipeline = &Pipeline{
+		Store: fakeStore{creds: map[string]store.Credential{
+			"AABBCCDD": {User: "alice"},
+			"11223344": {User: "bob", PIN: "1234"},
+		}},
+		LookupTimeout:  time.Second,
+		Clock:          f.clock,
+		Lockout:        ratelimit.New(3, time.Minute, 5*time.Minute, func(string) {}),
+		Debounce:       2 * time.Second,
+		DoubleSwipe:    5 * time.Second,
+		Keypad:         true,
+		PINTimeout:     15 * time.Second,
+		CommandTimeout: time.Second,
+		Permit:         func(store.Credential, string) error { return nil },
+		Record:         func(e events.Access) { f.records = append(f.records, e) },
+		RecordLatency:  func(*latency.Trace) {},
+	}
+	return f
+}
+
+func (f *fixture) swipe(token string) {
+	f.Swipe(f.door, reader.Swipe{Reader: "outside", Token: token, Time: f.clock.Now()})
+}
+
+// last returns the access attempt recorded last
+func (f *fixture) last(t *testing.T) events.Access {
+	t.Helper()
+	if len(f.records) == 0 {
+		t.Fatal("no access attempt recorded")
+	}
+	return f.records[len(f.records)-1]
+}
+
+func TestSwipeUnlocks(t *testing.T) {
+	f := newFixture()
+	f.swipe("AABBCCDD")
+	if f.door.unlocks != 1 {
+		t.Fatalf("door unlocked %d times, want 1", f.door.unlocks)
+	}
+	if e := f.last(t); e.Result != events.ResultGranted || e.User != "alice" || e.Action != "open" {
+		t.Fatalf("recorded %+v", e)
+	}
+}
+
+func TestSwipeDebounced(t *testing.T) {
+	f := newFixture()
+	f.DoubleSwipe = 0
+	f.swipe("AABBCCDD")
+	f.clock.Advance(time.Second)
+	f.swipe("AABBCCDD")
+	if f.door.unlocks != 1 || len(f.records) != 1 {
+		t.Fatalf("repeated read unlocked %d times and recorded %d attempts", f.door.unlocks, len(f.records))
+	}
+
+	f.clock.Advance(2 * time.Second)
+	f.swipe("AABBCCDD")
+	if f.door.unlocks != 2 {
+		t.Fatalf("swipe after the debounce unlocked %d times in total, want 2", f.door.unlocks)
+	}
+}
+
+func TestDoubleSwipeLocks(t *testing.T) {
+	f := newFixture()
+	f.swipe("AABBCCDD")
+	f.clock.Advance(3 * time.Second)
+	f.swipe("AABBCCDD")
+	if f.door.locks != 1 {
+		t.Fatalf("double swipe locked %d times, want 1", f.door.locks)
+	}
+	if e := f.last(t); e.Action != "close" || e.Result != events.ResultGranted {
+		t.Fatalf("recorded %+v", e)
+	}
+
+	// Too late for a double swipe
+	f.clock.Advance(3 * time.Second)
+	f.swipe("AABBCCDD")
+	f.clock.Advance(6 * time.Second)
+	f.swipe("AABBCCDD")
+	if f.door.locks != 1 || f.door.unlocks != 3 {
+		t.Fatalf("slow swipes locked %d and unlocked %d times", f.door.locks, f.door.unlocks)
+	}
+}
+
+func TestUnknownTokensLockOut(t *testing.T) {
+	f := newFixture()
+	for _, token := range []string{"DEAD0001", "DEAD0002", "DEAD0003"} {
+		f.swipe(token)
+		if e := f.last(t); e.Result != events.ResultUnknown {
+			t.Fatalf("recorded %+v for unknown token", e)
+		}
+	}
+	f.swipe("AABBCCDD")
+	if f.door.unlocks != 0 || len(f.records) != 3 {
+		t.Fatal("blocked reader unlocked")
+	}
+
+	f.clock.Advance(5 * time.Minute)
+	f.swipe("AABBCCDD")
+	if f.door.unlocks != 1 {
+		t.Fatal("reader still blocked after the cooldown")
+	}
+}
+
+func TestPIN(t *testing.T) {
+	f := newFixture()
+	f.swipe("11223344")
+	if f.door.unlocks != 0 || f.PINExpired() == nil {
+		t.Fatal("unlocked without waiting for the PIN")
+	}
+	for _, key := range "9*1234#" {
+		f.Key(key)
+	}
+	if f.door.unlocks != 1 || f.PINExpired() != nil {
+		t.Fatalf("PIN unlocked %d times", f.door.unlocks)
+	}
+	if e := f.last(t); e.Result != events.ResultGranted || e.User != "bob" {
+		t.Fatalf("recorded %+v", e)
+	}
+
+	f.clock.Advance(time.Minute)
+	f.swipe("11223344")
+	for _, key := range "4321#" {
+		f.Key(key)
+	}
+	if e := f.last(t); e.Result != events.ResultDenied || e.Reason != store.ErrWrongPIN.Error() {
+		t.Fatalf("recorded %+v for wrong PIN", e)
+	}
+	if f.door.unlocks != 1 {
+		t.Fatal("wrong PIN unlocked")
+	}
+}
+
+func TestPINTimeout(t *testing.T) {
+	f := newFixture()
+	f.swipe("11223344")
+	expired := f.PINExpired()
+	f.Key('1')
+
+	f.clock.Advance(14 * time.Second)
+	select {
+	case <-expired:
+		t.Fatal("PIN expired early")
+	default:
+	}
+	f.clock.Advance(time.Second)
+	select {
+	case <-expired:
+	default:
+		t.Fatal("PIN didn't expire after the timeout")
+	}
+	f.ExpirePIN()
+	if e := f.last(t); e.Result != events.ResultDenied || e.Reason != "PIN timeout" {
+		t.Fatalf("recorded %+v", e)
+	}
+
+	// Keys typed afterwards don't unlock
+	for _, key := range "234#" {
+		f.Key(key)
+	}
+	if f.door.unlocks != 0 {
+		t.Fatal("unlocked after the PIN timed out")
+	}
+}
+
+func TestKeepOpenAction(t *testing.T) {
+	f := newFixture()
+	f.Action = func(Door, store.Credential, time.Time) string { return "keep-open" }
+	f.swipe("AABBCCDD")
+	if f.door.keepOpens != 1 {
+		t.Fatal("keep-open action didn't keep the door open")
+	}
+}
+
+func TestDoorDrivenWithGo(t *testing.T) {
+	f := newFixture()
+	var driving []func()
+	f.Go = func(fn func()) { driving = append(driving, fn) }
+
+	f.swipe("AABBCCDD")
+	if len(driving) != 1 || f.door.unlocks != 0 || len(f.records) != 0 {
+		t.Fatal("swipe didn't leave opening the door to Go")
+	}
+	// Swipes are handled while the door moves
+	f.swipe("DEAD0001")
+	if e := f.last(t); e.Result != events.ResultUnknown {
+		t.Fatalf("recorded %+v while the door moved", e)
+	}
+
+	driving[0]()
+	if f.door.unlocks != 1 {
+		t.Fatalf("door unlocked %d times, want 1", f.door.unlocks)
+	}
+	if e := f.last(t); e.Result != events.ResultGranted || e.User != "alice" {
+		t.Fatalf("recorded %+v", e)
+	}
+	f.clock.Advance(3 * time.Second)
+	f.swipe("AABBCCDD")
+	if len(driving) != 2 {
+		t.Fatal("double swipe didn't leave locking the door to Go")
+	}
+	driving[1]()
+	if f.door.locks != 1 {
+		t.Fatalf("double swipe locked %d times, want 1", f.door.locks)
+	}
+}
+
+// armed returns an armed alarm counting the requests to disarm it
+func armed(asked *int) *alarm.Interlock {
+	i := &alarm.Interlock{Timeout: 15 * time.Second, Disarm: func() error {
+		*asked++
+		return nil
+	}}
+	i.Set(alarm.StateArmed)
+	return i
+}
+
+func TestUnlockWaitsForAlarm(t *testing.T) {
+	f := newFixture()
+	var asked int
+	f.Alarm = armed(&asked)
+
+	f.swipe("AABBCCDD")
+	if asked != 1 || f.door.unlocks != 0 || len(f.records) != 0 {
+		t.Fatalf("swipe while armed asked to disarm %d times, unlocked %d times", asked, f.door.unlocks)
+	}
+	changed := f.AlarmChanged()
+	if changed == nil || f.UnlockExpired() == nil {
+		t.Fatal("no unlock waiting for the alarm")
+	}
+
+	// Swipes and keys are still handled meanwhile
+	f.swipe("DEAD0001")
+	if e := f.last(t); e.Result != events.ResultUnknown {
+		t.Fatalf("recorded %+v while waiting for the alarm", e)
+	}
+	f.swipe("11223344")
+	if f.PINExpired() == nil {
+		t.Fatal("not waiting for the PIN while waiting for the alarm")
+	}
+
+	f.Alarm.Set(alarm.StateDisarmed)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("AlarmChanged not closed after disarming")
+	}
+	f.RetryUnlock()
+	if f.door.unlocks != 1 || f.AlarmChanged() != nil || f.UnlockExpired() != nil {
+		t.Fatalf("disarming unlocked %d times", f.door.unlocks)
+	}
+	if e := f.last(t); e.Result != events.ResultGranted || e.User != "alice" {
+		t.Fatalf("recorded %+v", e)
+	}
+}
+
+func TestUnlockWaitsForAlarmUntilTimeout(t *testing.T) {
+	f := newFixture()
+	var asked int
+	f.Alarm = armed(&asked)
+
+	f.swipe("AABBCCDD")
+	changed, expired := f.AlarmChanged(), f.UnlockExpired()
+	// Changes to other armed states keep waiting
+	f.Alarm.Set(alarm.StateUnknown)
+	f.Alarm.Set(alarm.StateArmed)
+	<-changed
+	f.RetryUnlock()
+	if f.door.unlocks != 0 || f.AlarmChanged() == nil {
+		t.Fatal("stopped waiting while still armed")
+	}
+
+	f.clock.Advance(15 * time.Second)
+	select {
+	case <-expired:
+	default:
+		t.Fatal("unlock didn't expire after the alarm's timeout")
+	}
+	f.ExpireUnlock()
+	if e := f.last(t); e.Result != events.ResultFailure || !strings.Contains(e.Reason, "not disarmed") {
+		t.Fatalf("recorded %+v", e)
+	}
+	if f.door.unlocks != 0 || f.AlarmChanged() != nil {
+		t.Fatal("unlocked although the alarm stayed armed")
+	}
+}
+
+func TestUnlockRefusedWhileArmed(t *testing.T) {
+	f := newFixture()
+	var asked int
+	f.Alarm = armed(&asked)
+	f.Alarm.Disarm = nil
+
+	f.swipe("AABBCCDD")
+	if e := f.last(t); e.Result != events.ResultFailure || e.Reason != alarm.ErrArmed.Error() {
+		t.Fatalf("recorded %+v", e)
+	}
+	if f.AlarmChanged() != nil {
+		t.Fatal("waiting for an alarm that can't be asked to disarm")
+	}
+
+	// The way out is never blocked
+	f.Unlock(f.door, events.Access{Source: "exit-button", Door: "front", Action: "exit"}, nil)
+	if f.door.unlocks != 1 {
+		t.Fatal("exit button didn't unlock while armed")
+	}
+}
+
+func TestAwaitAlarm(t *testing.T) {
+	f := newFixture()
+	var asked int
+	f.Alarm = armed(&asked)
+
+	var results []error
+	ready := func(err error) { results = append(results, err) }
+	f.AwaitAlarm(events.Access{Source: "mqtt", Door: "front", Action: "open"}, ready)
+	if asked != 1 || len(results) != 0 || f.AlarmChanged() == nil {
+		t.Fatalf("remote unlock while armed asked to disarm %d times and returned %v", asked, results)
+	}
+
+	// A swipe takes the place of the remote unlock
+	f.swipe("AABBCCDD")
+	if len(results) != 1 || results[0] != errSuperseded {
+		t.Fatalf("superseded remote unlock returned %v", results)
+	}
+	f.Alarm.Set(alarm.StateDisarmed)
+	f.RetryUnlock()
+	if f.door.unlocks != 1 {
+		t.Fatalf("disarming unlocked %d times", f.door.unlocks)
+	}
+
+	f.AwaitAlarm(events.Access{Source: "mqtt", Door: "front", Action: "open"}, ready)
+	if len(results) != 2 || results[1] != nil {
+		t.Fatalf("remote unlock while disarmed returned %v", results)
+	}
+}
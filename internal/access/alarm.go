@@ -0,0 +1,114 @@
+package access
+
+import (
+	"errors"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/alarm"
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+// errSuperseded fails an unlock waiting for the alarm when another one
+// takes its place
+var errSuperseded = errors.New("alarm armed, superseded by another unlock")
+
+// heldUnlock is a granted unlock waiting for the alarm to disarm
+type heldUnlock struct {
+	event events.Access
+	// ready is called once the alarm disarmed, or with the error if it
+	// didn't
+	ready func(error)
+	// changed is closed when the alarm changes
+	changed <-chan struct{}
+	timer   clock.Timer
+}
+
+// AwaitAlarm calls ready once the alarm isn't armed, right away unless it
+// is. The armed alarm is asked to disarm, and the unlock of event held
+// until it did, see AlarmChanged. ready is called with the error if the
+// alarm can't be asked or doesn't disarm within its timeout. Remote
+// unlocks are held like swipes this way, by the main loop
+func (p *Pipeline) AwaitAlarm(event events.Access, ready func(error)) {
+	if p.Alarm.State() != alarm.StateArmed {
+		ready(nil)
+		return
+	}
+	p.hold(event, ready)
+}
+
+// hold asks the armed alarm to disarm and keeps the unlock of event until
+// it did. An unlock already waiting fails
+func (p *Pipeline) hold(event events.Access, ready func(error)) {
+	if err := p.Alarm.AskDisarm(); err != nil {
+		refuse(event, ready, err)
+		return
+	}
+	if h := p.held; h != nil {
+		h.timer.Stop()
+		p.held = nil
+		refuse(h.event, h.ready, errSuperseded)
+	}
+	Logger(event).Info("Waiting for the alarm to disarm", "timeout", p.Alarm.Timeout)
+	p.held = &heldUnlock{
+		event:   event,
+		ready:   ready,
+		changed: p.Alarm.Changed(),
+		timer:   p.clock().NewTimer(p.Alarm.Timeout),
+	}
+	// The alarm may have disarmed in between
+	if p.held.changed == nil {
+		p.RetryUnlock()
+	}
+}
+
+// refuse fails the unlock of event with err, as the alarm is armed
+func refuse(event events.Access, ready func(error), err error) {
+	Logger(event).Warn("Not unlocking while the alarm is armed", "err", err)
+	ready(err)
+}
+
+// AlarmChanged is closed when the alarm changes while an unlock waits for
+// it to disarm; nil if none waits. RetryUnlock must be called then
+func (p *Pipeline) AlarmChanged() <-chan struct{} {
+	if p.held == nil {
+		return nil
+	}
+	return p.held.changed
+}
+
+// RetryUnlock goes on with the unlock waiting for the alarm if it
+// disarmed, and keeps waiting otherwise
+func (p *Pipeline) RetryUnlock() {
+	h := p.held
+	if h == nil {
+		return
+	}
+	if p.Alarm.State() == alarm.StateArmed {
+		h.changed = p.Alarm.Changed()
+		return
+	}
+	h.timer.Stop()
+	p.held = nil
+	h.ready(nil)
+}
+
+// UnlockExpired fires when the unlock waiting for the alarm times out; nil
+// if none waits
+func (p *Pipeline) UnlockExpired() <-chan time.Time {
+	if p.held == nil {
+		return nil
+	}
+	return p.held.timer.C()
+}
+
+// ExpireUnlock fails the unlock waiting for the alarm, as it didn't disarm
+// in time
+func (p *Pipeline) ExpireUnlock() {
+	h := p.held
+	if h == nil {
+		return
+	}
+	p.held = nil
+	refuse(h.event, h.ready, p.Alarm.TimedOut())
+}
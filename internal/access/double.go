@@ -0,0 +1,119 @@
+package access
+
+import (
+	"context"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/latency"
+)
+
+// isDuplicate reports whether token was already read within Debounce, and
+// remembers it was read now. Other tokens aren't affected, so a lingering
+// card doesn't block the next user
+func (p *Pipeline) isDuplicate(token string, now time.Time) bool {
+	if p.lastSwipes == nil {
+		p.lastSwipes = map[string]time.Time{}
+	}
+	for t, last := range p.lastSwipes {
+		if now.Sub(last) >= p.Debounce {
+			delete(p.lastSwipes, t)
+		}
+	}
+	_, duplicate := p.lastSwipes[token]
+	p.lastSwipes[token] = now
+	return duplicate
+}
+
+// noteUnlockSwipe remembers the swipe of event unlocked its door at now
+func (p *Pipeline) noteUnlockSwipe(event events.Access, now time.Time) {
+	if p.DoubleSwipe <= 0 || event.Source != "rfid" || event.TokenHash == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.unlockSwipes == nil {
+		p.unlockSwipes = map[string]unlockSwipe{}
+	}
+	p.unlockSwipes[event.TokenHash] = unlockSwipe{door: event.Door, time: now}
+}
+
+// isDoubleSwipe reports whether the token of event unlocked d within
+// DoubleSwipe before now. The unlock is forgotten, so swiping a third time
+// doesn't lock again
+func (p *Pipeline) isDoubleSwipe(d Door, event events.Access, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for hash, s := range p.unlockSwipes {
+		if now.Sub(s.time) >= p.DoubleSwipe {
+			delete(p.unlockSwipes, hash)
+		}
+	}
+	s, ok := p.unlockSwipes[event.TokenHash]
+	delete(p.unlockSwipes, event.TokenHash)
+	return ok && s.door == d.Name()
+}
+
+// lock locks d for a granted double swipe and records it, with Go. The
+// time until the relay is energized is noted in trace, which may be nil
+func (p *Pipeline) lock(d Door, event events.Access, trace *latency.Trace) {
+	p.run(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), p.CommandTimeout)
+		defer cancel()
+		event.Result = events.ResultGranted
+		if err := d.Lock(trace.TimeActuator(ctx)); err != nil {
+			Logger(event).Error("Could not close", "err", err)
+			event.Result = events.ResultFailure
+			event.Reason = err.Error()
+		}
+		p.finish(event, trace)
+	})
+}
+
+// Unlock opens d for a granted access attempt and records it. While the
+// alarm is armed, it is asked to disarm and d is opened once it did, see
+// AlarmChanged. The exit button doesn't wait for the alarm, as the way out
+// must never be blocked. With the action keep-open, d stays unlocked
+// without auto-lock. The time until the relay is energized is noted in
+// trace, which may be nil
+func (p *Pipeline) Unlock(d Door, event events.Access, trace *latency.Trace) {
+	if event.Source == "exit-button" {
+		p.open(d, event, trace, nil)
+		return
+	}
+	p.AwaitAlarm(event, func(err error) { p.open(d, event, trace, err) })
+}
+
+// open opens d for a granted access attempt with Go, unless err is set,
+// and records it
+func (p *Pipeline) open(d Door, event events.Access, trace *latency.Trace, err error) {
+	event.Result = events.ResultGranted
+	if err != nil {
+		Logger(event).Error("Could not open", "err", err)
+		event.Result = events.ResultFailure
+		event.Reason = err.Error()
+		p.finish(event, trace)
+		return
+	}
+	p.run(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), p.CommandTimeout)
+		defer cancel()
+		if err := d.Unlock(trace.TimeActuator(ctx), event.Action); err != nil {
+			Logger(event).Error("Could not open", "err", err)
+			event.Result = events.ResultFailure
+			event.Reason = err.Error()
+		} else {
+			p.noteUnlockSwipe(event, p.clock().Now())
+		}
+		p.finish(event, trace)
+	})
+}
+
+// finish records event, and the timing of its swipe if trace isn't nil
+func (p *Pipeline) finish(event events.Access, trace *latency.Trace) {
+	trace.Finish(event.Result)
+	p.Record(event)
+	if trace != nil {
+		p.RecordLatency(trace)
+	}
+}
@@ -0,0 +1,115 @@
+package access
+
+import (
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// maxPINDigits caps the digits buffered while a PIN is typed
+const maxPINDigits = 32
+
+// pinEntry is a granted swipe of a credential having a PIN, which unlocks
+// once the PIN has been typed and confirmed with '#'
+type pinEntry struct {
+	door       Door
+	cred       store.Credential
+	event      events.Access
+	lockoutKey string
+	digits     []rune
+	timer      clock.Timer
+}
+
+// requestPIN waits for the PIN of cred before unlocking. A pending entry of
+// another swipe is dropped
+func (p *Pipeline) requestPIN(d Door, cred store.Credential, event events.Access, lockoutKey string) {
+	if !p.Keypad {
+		Logger(event).Warn("Denied, PIN required but no keypad configured")
+		event.Result = events.ResultDenied
+		event.Reason = "PIN required, but no keypad configured"
+		p.Record(event)
+		return
+	}
+	p.cancelPIN()
+	Logger(event).Info("Waiting for PIN")
+	p.pending = &pinEntry{
+		door:       d,
+		cred:       cred,
+		event:      event,
+		lockoutKey: lockoutKey,
+		timer:      p.clock().NewTimer(p.PINTimeout),
+	}
+}
+
+// PINExpired fires when the swipe waiting for its PIN times out; nil if
+// there is none
+func (p *Pipeline) PINExpired() <-chan time.Time {
+	if p.pending == nil {
+		return nil
+	}
+	return p.pending.timer.C()
+}
+
+func (p *Pipeline) cancelPIN() {
+	if p.pending != nil {
+		p.pending.timer.Stop()
+		p.pending = nil
+	}
+}
+
+// ExpirePIN denies the swipe waiting for its PIN after none was typed in
+// time
+func (p *Pipeline) ExpirePIN() {
+	e := p.pending
+	if e == nil {
+		return
+	}
+	p.pending = nil
+	Logger(e.event).Info("Denied, no PIN entered")
+	e.event.Result = events.ResultDenied
+	e.event.Reason = "PIN timeout"
+	p.Record(e.event)
+}
+
+// Key adds a key pressed on the keypad to the PIN waited for. '*' clears
+// the digits typed so far, '#' submits them. Without a swipe waiting for
+// its PIN, the keys are passed to Code
+func (p *Pipeline) Key(key rune) {
+	e := p.pending
+	if e == nil {
+		if p.Code != nil {
+			p.Code(key)
+		}
+		return
+	}
+	switch {
+	case key == '*':
+		e.digits = e.digits[:0]
+	case key == '#':
+		p.cancelPIN()
+		if !e.cred.CheckPIN(string(e.digits)) {
+			Logger(e.event).Info("Denied", "reason", store.ErrWrongPIN)
+			e.event.Result = events.ResultDenied
+			e.event.Reason = store.ErrWrongPIN.Error()
+			p.Record(e.event)
+			p.Lockout.Fail(e.lockoutKey, p.clock().Now())
+			return
+		}
+		// The door may have been locked down while the PIN was entered
+		if err := p.Lockdowns.Check(e.cred, e.door.Name()); err != nil {
+			Logger(e.event).Info("Denied", "reason", err)
+			e.event.Result = events.ResultDenied
+			e.event.Reason = err.Error()
+			p.Record(e.event)
+			return
+		}
+		Logger(e.event).Info("PIN accepted")
+		p.Unlock(e.door, e.event, nil)
+	case key >= '0' && key <= '9':
+		if len(e.digits) < maxPINDigits {
+			e.digits = append(e.digits, key)
+		}
+	}
+}
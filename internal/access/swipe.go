@@ -0,0 +1,131 @@
+package access
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/decision"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/latency"
+	"github.com/craftamap/wishbone/internal/lockdown"
+	"github.com/craftamap/wishbone/internal/passback"
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// Swipe decides whether a token swiped at d may unlock it and opens it
+func (p *Pipeline) Swipe(d Door, sw reader.Swipe) {
+	if sw.Time.IsZero() {
+		sw.Time = p.clock().Now()
+	}
+	trace := latency.NewTrace(sw.Time)
+	trace.Door, trace.Reader, trace.Store = d.Name(), sw.Reader, p.StoreKind
+	trace.Measure(latency.Queue, sw.Time)
+	msg := store.NormalizeToken(sw.Token)
+	event := events.Access{
+		Source:    "rfid",
+		Door:      d.Name(),
+		Reader:    sw.Reader,
+		Action:    "open",
+		TokenHash: events.HashToken(msg),
+	}
+
+	// A double swipe locks instead, although the second read would be
+	// skipped as duplicate
+	double := p.DoubleSwipe > 0 && p.isDoubleSwipe(d, event, p.clock().Now())
+	if double {
+		event.Action = "close"
+	}
+	logger := Logger(event)
+	if p.isDuplicate(msg, p.clock().Now()) && !double {
+		logger.Debug("Triggered too fast; skipped unlock")
+		return
+	}
+	// Brute forcing uses a different token for every swipe, so block the
+	// reader rather than the token
+	lockoutKey := strings.TrimSpace("reader " + sw.Reader)
+	if p.Lockout.Blocked(lockoutKey, p.clock().Now()) {
+		logger.Warn("Ignoring token, too many unknown tokens")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.LookupTimeout)
+	start := time.Now()
+	cred, err := p.Store.Lookup(ctx, msg)
+	trace.Measure(latency.Lookup, start)
+	cancel()
+	username := cred.User
+	event.User = username
+	if username != "" {
+		logger = logger.With("user", username)
+	}
+	if err == nil && double {
+		// Like locking over the API, locking needs neither the PIN nor the
+		// fail policy
+		err = p.Permit(cred, store.ActionLock)
+	} else if err == nil {
+		start = time.Now()
+		err = p.Permit(cred, store.ActionUnlock)
+		if err == nil && p.Policy != nil {
+			err = p.Policy(d, cred, event, logger)
+		}
+		trace.Measure(latency.Policy, start)
+	}
+	switch err {
+	case nil:
+		if double {
+			logger.Info("Granted double swipe, locking")
+			p.lock(d, event, trace)
+			return
+		}
+		event.Action = "open"
+		if p.Action != nil {
+			event.Action = p.Action(d, cred, sw.Time)
+		}
+		if _, ok := p.Lockdowns.Active(d.Name()); ok {
+			logger.Warn("Granted during lockdown", "action", event.Action)
+		} else {
+			logger.Info("Granted", "action", event.Action)
+		}
+		if cred.PIN != "" {
+			// The time taken to enter the PIN isn't the door's
+			trace.Finish("pin")
+			p.RecordLatency(trace)
+			p.requestPIN(d, cred, event, lockoutKey)
+			return
+		}
+		// The timing is recorded once the door opened
+		p.Unlock(d, event, trace)
+		return
+	case store.ErrUnknownToken:
+		if isValid(msg) {
+			if p.Enroll != nil && p.Enroll(d, msg, sw.Reader) {
+				logger.Info("Captured token for enrollment", "token", msg)
+				return
+			}
+			// The token itself is logged, so new ones can be added to the
+			// list
+			logger.Info("Unknown token", "token", msg)
+			event.Result = events.ResultUnknown
+			trace.Finish(event.Result)
+			p.Record(event)
+			p.Lockout.Fail(lockoutKey, p.clock().Now())
+		}
+	case store.ErrDisabled, store.ErrNotYetValid, store.ErrExpired, store.ErrOutsideSchedule, store.ErrNotPermitted, store.ErrDoorDegraded, passback.ErrNotExited, lockdown.ErrLockdown, decision.ErrDenied:
+		logger.Info("Denied", "reason", err)
+		event.Result = events.ResultDenied
+		event.Reason = err.Error()
+		trace.Finish(event.Result)
+		p.Record(event)
+	default:
+		logger.Error("Could not look up token", "err", err)
+		event.Result = events.ResultFailure
+		event.Reason = err.Error()
+		trace.Finish(event.Result)
+		p.Record(event)
+	}
+	if trace.Result != "" {
+		p.RecordLatency(trace)
+	}
+}
@@ -0,0 +1,203 @@
+// Package actuator drives the sphincter and reports its status
+package actuator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+// Status is the state of the sphincter as reported by a DoorActuator. The
+// values match the 2 bit code on the sphincter's status outputs
+type Status int
+
+const (
+	StatusUnknown Status = iota
+	StatusLocked
+	StatusUnlocked
+	StatusFailure
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusLocked:
+		return "LOCKED"
+	case StatusUnlocked:
+		return "UNLOCKED"
+	case StatusFailure:
+		return "FAILURE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// DoorActuator drives the sphincter. The GPIO implementation lives behind a
+// build tag, so the daemon can be built without GPIO access by passing
+// -tags nogpio. Open and Close give up when ctx is done, but a relay once
+// energized is always pulsed to the end
+type DoorActuator interface {
+	Open(ctx context.Context) error
+	Close(ctx context.Context) error
+	Status() Status
+	// Release drives all outputs inactive and frees the hardware. Open and
+	// Close fail afterwards
+	Release() error
+}
+
+// ErrReleased is returned by Open and Close after Release
+var ErrReleased = errors.New("actuator already released")
+
+// Pin is a GPIO in BCM numbering. Active low pins are energized by driving
+// them low, or read as set while low
+type Pin struct {
+	Number    int
+	ActiveLow bool
+}
+
+// GPIOConfig maps the relays and status inputs of the sphincter to GPIOs
+type GPIOConfig struct {
+	Open  Pin
+	Close Pin
+	// Status0 and Status1 are the low and high bit of the status reported by
+	// the sphincter; nil if they aren't wired up
+	Status0 *Pin
+	Status1 *Pin
+	Timing  Timing
+}
+
+// Watched wraps a DoorActuator and notifies its listeners whenever the
+// reported status changed after Open or Close, or while polling. Listeners
+// must be registered before the actuator is used
+type Watched struct {
+	DoorActuator
+	// Debounce is how long a status read while polling must stay the same
+	// before it is taken, so bouncing contacts and the sphincter passing
+	// through other codes while moving aren't reported. Set before Poll
+	Debounce time.Duration
+	// Clock times the debouncing; the real clock if nil
+	Clock     clock.Clock
+	listeners []func(Status)
+
+	// checkMu serializes checks, so listeners see changes in order
+	checkMu sync.Mutex
+	// pending is the status last read while polling, since pendingSince
+	pending      Status
+	pendingSince time.Time
+
+	mu        sync.Mutex
+	last      Status
+	changedAt time.Time
+	// polling is set while Poll runs; Status then returns last
+	polling bool
+}
+
+// OnChange registers f to be called with the new status after every change
+func (a *Watched) OnChange(f func(Status)) {
+	a.listeners = append(a.listeners, f)
+}
+
+// Status returns the debounced status while polling, without reading the
+// inputs, and else the status reported by the wrapped actuator
+func (a *Watched) Status() Status {
+	a.mu.Lock()
+	if a.polling {
+		defer a.mu.Unlock()
+		return a.last
+	}
+	a.mu.Unlock()
+	return a.DoorActuator.Status()
+}
+
+// check takes the status right away, e.g. after Open or Close
+func (a *Watched) check() {
+	a.checkMu.Lock()
+	defer a.checkMu.Unlock()
+
+	status := a.DoorActuator.Status()
+	a.pending, a.pendingSince = status, clock.Or(a.Clock).Now()
+	a.report(status)
+}
+
+// sample takes the status once it was read the same for Debounce
+func (a *Watched) sample() {
+	a.checkMu.Lock()
+	defer a.checkMu.Unlock()
+
+	status := a.DoorActuator.Status()
+	now := clock.Or(a.Clock).Now()
+	if status != a.pending {
+		a.pending, a.pendingSince = status, now
+	}
+	if now.Sub(a.pendingSince) >= a.Debounce {
+		a.report(status)
+	}
+}
+
+// report notifies the listeners if status differs from the last one taken.
+// checkMu must be held
+func (a *Watched) report(status Status) {
+	a.mu.Lock()
+	changed := status != a.last
+	if changed {
+		a.last = status
+		a.changedAt = clock.Or(a.Clock).Now()
+	}
+	a.mu.Unlock()
+
+	if changed {
+		for _, f := range a.listeners {
+			f(status)
+		}
+	}
+}
+
+// Poll reads the status every interval until done is closed, to notice
+// changes not caused by Open or Close, e.g. a door locked by hand. Meanwhile
+// Status returns the status last taken
+func (a *Watched) Poll(interval time.Duration, done <-chan struct{}) {
+	a.check()
+	a.mu.Lock()
+	a.polling = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.polling = false
+		a.mu.Unlock()
+	}()
+
+	ticker := clock.Or(a.Clock).NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			a.sample()
+		}
+	}
+}
+
+func (a *Watched) do(ctx context.Context, action func(context.Context) error) error {
+	err := action(ctx)
+	a.check()
+	return err
+}
+
+// LastChange returns when the status last changed; zero if it didn't since
+// startup
+func (a *Watched) LastChange() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.changedAt
+}
+
+func (a *Watched) Open(ctx context.Context) error {
+	return a.do(ctx, a.DoorActuator.Open)
+}
+
+func (a *Watched) Close(ctx context.Context) error {
+	return a.do(ctx, a.DoorActuator.Close)
+}
@@ -0,0 +1,149 @@
+package actuator
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+// AutoLocker closes the sphincter some time after it was unlocked, unless
+// it has been locked in the meantime. A nil *AutoLocker does nothing
+type AutoLocker struct {
+	// Clock times the auto-lock and the countdown; the real clock if nil.
+	// Set before Arm
+	Clock clock.Clock
+
+	mu        sync.Mutex
+	timer     clock.Timer
+	delay     time.Duration
+	countdown time.Duration
+	actuator  DoorActuator
+	closed    func(error)
+	listeners []func(remaining time.Duration)
+	// stop ends the countdown of the running timer
+	stop chan struct{}
+}
+
+// NewAutoLocker returns an AutoLocker closing actuator delay after being
+// armed. During the last countdown before closing, the countdown listeners
+// are called every second. closed is called with the result of every
+// automatic Close
+func NewAutoLocker(actuator DoorActuator, delay, countdown time.Duration, closed func(error)) *AutoLocker {
+	return &AutoLocker{actuator: actuator, delay: delay, countdown: countdown, closed: closed}
+}
+
+// Delay returns how long after being armed the sphincter is closed; 0 for a
+// nil AutoLocker
+func (l *AutoLocker) Delay() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.delay
+}
+
+// OnCountdown registers f to be called with the time remaining every second
+// of the countdown, and with 0 when it ends, either because the sphincter
+// is being closed or because the timer was stopped
+func (l *AutoLocker) OnCountdown(f func(remaining time.Duration)) {
+	if l == nil {
+		return
+	}
+	l.listeners = append(l.listeners, f)
+}
+
+// Arm (re)starts the timer
+func (l *AutoLocker) Arm() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopTimer()
+	c := clock.Or(l.Clock)
+	l.timer = c.AfterFunc(l.delay, func() { supervisor.Run("auto-lock", l.fire) })
+	if l.countdown > 0 && len(l.listeners) > 0 {
+		deadline := c.Now().Add(l.delay)
+		stop := make(chan struct{})
+		l.stop = stop
+		go supervisor.Run("auto-lock countdown", func() { l.count(deadline, stop) })
+	}
+}
+
+// Disarm stops a running timer, e.g. to keep the sphincter open
+func (l *AutoLocker) Disarm() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopTimer()
+}
+
+// stopTimer stops the timer and its countdown. The caller must hold mu
+func (l *AutoLocker) stopTimer() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+}
+
+// count calls the countdown listeners every second from the start of the
+// countdown until deadline, unless stop is closed before
+func (l *AutoLocker) count(deadline time.Time, stop <-chan struct{}) {
+	c := clock.Or(l.Clock)
+	start := c.NewTimer(deadline.Add(-l.countdown).Sub(c.Now()))
+	defer start.Stop()
+	select {
+	case <-stop:
+		return
+	case <-start.C():
+	}
+
+	ticker := c.NewTicker(time.Second)
+	defer ticker.Stop()
+	defer l.notify(0)
+	for {
+		remaining := deadline.Sub(c.Now()).Round(time.Second)
+		if remaining <= 0 {
+			return
+		}
+		l.notify(remaining)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (l *AutoLocker) notify(remaining time.Duration) {
+	for _, f := range l.listeners {
+		f(remaining)
+	}
+}
+
+func (l *AutoLocker) fire() {
+	l.mu.Lock()
+	l.timer = nil
+	l.mu.Unlock()
+
+	if status := l.actuator.Status(); status != StatusUnlocked {
+		slog.Info("Skipping auto-lock", "status", status.String())
+		return
+	}
+
+	slog.Info("Auto-locking")
+	err := l.actuator.Close(context.Background())
+	if err != nil {
+		slog.Error("Could not auto-lock", "err", err)
+	}
+	l.closed(err)
+}
@@ -0,0 +1,55 @@
+package actuator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+// Level is a level driven on a relay pin of a fake actuator
+type Level struct {
+	Time time.Time
+	// Relay is open or close
+	Relay  string
+	Pin    int
+	Active bool
+}
+
+// fakePins drive no GPIO, but keep the levels of the pins and pass each one
+// driven to record
+type fakePins struct {
+	cfg    GPIOConfig
+	record func(Level)
+
+	mu     sync.Mutex
+	levels map[int]bool
+}
+
+// NewFake returns an actuator like the GPIO ones, whose pins are fakes
+// passing every level driven to record, for tests driving the actuator
+// in-process. Its status pins read inactive
+func NewFake(cfg GPIOConfig, record func(Level)) DoorActuator {
+	return newGPIOActuator(cfg, &fakePins{cfg: cfg, record: record, levels: map[int]bool{}})
+}
+
+func (p *fakePins) write(pin Pin, active bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.levels[pin.Number] = active
+	relay := "close"
+	if pin == p.cfg.Open {
+		relay = "open"
+	}
+	p.record(Level{Time: clock.Or(p.cfg.Timing.Clock).Now(), Relay: relay, Pin: pin.Number, Active: active})
+}
+
+func (p *fakePins) read(pin Pin) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.levels[pin.Number]
+}
+
+func (p *fakePins) release() error {
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build !nogpio
+// +build !nogpio
+
+package actuator
+
+import (
+	"sync"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// gpioUsers counts the actuators using the GPIO, which is unmapped once
+// the last one is released
+var (
+	gpioMu    sync.Mutex
+	gpioUsers int
+)
+
+// NewGPIO opens the GPIO through /dev/gpiomem and configures the pins given
+// in cfg
+func NewGPIO(cfg GPIOConfig) (DoorActuator, error) {
+	gpioMu.Lock()
+	defer gpioMu.Unlock()
+	if gpioUsers == 0 {
+		if err := rpio.Open(); err != nil {
+			return nil, err
+		}
+	}
+	gpioUsers++
+	p := rpioPins{}
+	for _, pin := range []Pin{cfg.Open, cfg.Close} {
+		rpio.Pin(pin.Number).Output()
+		p.write(pin, false)
+	}
+	for _, pin := range []*Pin{cfg.Status0, cfg.Status1} {
+		if pin != nil {
+			rpio.Pin(pin.Number).Input()
+		}
+	}
+	return newGPIOActuator(cfg, p), nil
+}
+
+// rpioPins accesses the GPIO registers mapped by go-rpio
+type rpioPins struct{}
+
+func (rpioPins) write(pin Pin, active bool) {
+	if active != pin.ActiveLow {
+		rpio.Pin(pin.Number).High()
+	} else {
+		rpio.Pin(pin.Number).Low()
+	}
+}
+
+func (rpioPins) read(pin Pin) bool {
+	return (rpio.Pin(pin.Number).Read() == rpio.High) != pin.ActiveLow
+}
+
+// release unmaps the GPIO once the last actuator is released
+func (rpioPins) release() error {
+	gpioMu.Lock()
+	defer gpioMu.Unlock()
+	gpioUsers--
+	if gpioUsers > 0 {
+		return nil
+	}
+	return rpio.Close()
+}
@@ -0,0 +1,14 @@
+//go:build nogpio
+// +build nogpio
+
+package actuator
+
+// NewGPIO returns a simulated actuator, as GPIO support wasn't built in
+func NewGPIO(cfg GPIOConfig) (DoorActuator, error) {
+	return NewSimulated(cfg.Timing), nil
+}
+
+// NewGPIOD returns a simulated actuator, as GPIO support wasn't built in
+func NewGPIOD(chip string, cfg GPIOConfig) (DoorActuator, error) {
+	return NewSimulated(cfg.Timing), nil
+}
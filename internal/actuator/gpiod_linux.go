@@ -0,0 +1,85 @@
+//go:build linux && !nogpio
+// +build linux,!nogpio
+
+package actuator
+
+import (
+	"log/slog"
+
+	"github.com/craftamap/wishbone/internal/gpiod"
+)
+
+// gpiodPins are the lines of an actuator, requested from the GPIO character
+// device. They are requested as active low as configured, so the kernel
+// inverts them
+type gpiodPins struct {
+	lines map[int]*gpiod.Line
+}
+
+// NewGPIOD requests the pins given in cfg from the GPIO chip called chip,
+// like gpiochip0, or the one of the Raspberry Pi's pin header if it is
+// empty. Unlike NewGPIO, it works on the Raspberry Pi 5 and without root
+func NewGPIOD(chip string, cfg GPIOConfig) (DoorActuator, error) {
+	if chip == "" {
+		var err error
+		if chip, err = gpiod.Find(); err != nil {
+			return nil, err
+		}
+	}
+	c, err := gpiod.Open(chip)
+	if err != nil {
+		return nil, err
+	}
+	// The lines stay requested after the chip is closed
+	defer c.Close()
+
+	p := gpiodPins{lines: map[int]*gpiod.Line{}}
+	request := func(pin Pin, output bool) error {
+		l, err := c.RequestLine(pin.Number, "wishbone", gpiod.LineConfig{Output: output, ActiveLow: pin.ActiveLow})
+		if err != nil {
+			return err
+		}
+		p.lines[pin.Number] = l
+		return nil
+	}
+	for _, pin := range []Pin{cfg.Open, cfg.Close} {
+		if err := request(pin, true); err != nil {
+			p.release()
+			return nil, err
+		}
+	}
+	for _, pin := range []*Pin{cfg.Status0, cfg.Status1} {
+		if pin == nil {
+			continue
+		}
+		if err := request(*pin, false); err != nil {
+			p.release()
+			return nil, err
+		}
+	}
+	return newGPIOActuator(cfg, p), nil
+}
+
+func (p gpiodPins) write(pin Pin, active bool) {
+	if err := p.lines[pin.Number].Set(active); err != nil {
+		slog.Error("Could not drive GPIO", "pin", pin.Number, "err", err)
+	}
+}
+
+func (p gpiodPins) read(pin Pin) bool {
+	active, err := p.lines[pin.Number].Get()
+	if err != nil {
+		slog.Error("Could not read GPIO", "pin", pin.Number, "err", err)
+	}
+	return active
+}
+
+func (p gpiodPins) release() error {
+	var first error
+	for _, l := range p.lines {
+		if err := l.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
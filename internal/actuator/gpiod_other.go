@@ -0,0 +1,11 @@
+//go:build !linux && !nogpio
+// +build !linux,!nogpio
+
+package actuator
+
+import "errors"
+
+// NewGPIOD fails, as the GPIO character device only exists on Linux
+func NewGPIOD(chip string, cfg GPIOConfig) (DoorActuator, error) {
+	return nil, errors.New("the gpiod GPIO backend requires Linux")
+}
@@ -0,0 +1,114 @@
+package actuator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+// HeldOpenAlarm raises an alarm if the sphincter reports UNLOCKED for longer
+// than a limit without being meant to be kept open, e.g. when it was
+// propped open. A nil *HeldOpenAlarm does nothing
+type HeldOpenAlarm struct {
+	// Clock times the limit; the real clock if nil. Set before Changed
+	Clock clock.Clock
+
+	limit     time.Duration
+	listeners []func(alarmed bool)
+
+	mu       sync.Mutex
+	timer    clock.Timer
+	keepOpen bool
+	alarmed  bool
+}
+
+// NewHeldOpenAlarm returns an alarm raised after the sphincter was unlocked
+// for limit. Status changes must be passed to Changed
+func NewHeldOpenAlarm(limit time.Duration) *HeldOpenAlarm {
+	return &HeldOpenAlarm{limit: limit}
+}
+
+// OnAlarm registers f to be called with true when the alarm is raised, and
+// with false when the sphincter isn't unlocked any more afterwards
+func (a *HeldOpenAlarm) OnAlarm(f func(alarmed bool)) {
+	if a == nil {
+		return
+	}
+	a.listeners = append(a.listeners, f)
+}
+
+// Alarmed reports whether the alarm is raised
+func (a *HeldOpenAlarm) Alarmed() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.alarmed
+}
+
+// KeepOpen stops the timer until the sphincter is no longer unlocked, as it
+// is meant to stay open
+func (a *HeldOpenAlarm) KeepOpen() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keepOpen = true
+	a.stop()
+}
+
+// Changed starts the timer when the sphincter becomes unlocked, and stops
+// it and clears the alarm otherwise
+func (a *HeldOpenAlarm) Changed(status Status) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stop()
+	if status == StatusUnlocked {
+		if !a.keepOpen {
+			var t clock.Timer
+			t = clock.Or(a.Clock).AfterFunc(a.limit, func() { supervisor.Run("held-open alarm", func() { a.fire(t) }) })
+			a.timer = t
+		}
+		return
+	}
+	a.keepOpen = false
+	if a.alarmed {
+		a.alarmed = false
+		a.notify(false)
+	}
+}
+
+// stop stops the timer. The caller must hold mu
+func (a *HeldOpenAlarm) stop() {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+}
+
+func (a *HeldOpenAlarm) fire(t clock.Timer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// The timer may have been replaced while it fired
+	if a.timer != t {
+		return
+	}
+	a.timer = nil
+	a.alarmed = true
+	a.notify(true)
+}
+
+// notify calls the listeners. The caller must hold mu, so they see the
+// alarm raised and cleared in order
+func (a *HeldOpenAlarm) notify(alarmed bool) {
+	for _, f := range a.listeners {
+		f(alarmed)
+	}
+}
@@ -0,0 +1,113 @@
+package actuator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// pins drives and reads the pins of a gpioActuator through a GPIO backend
+type pins interface {
+	write(pin Pin, active bool)
+	read(pin Pin) bool
+	release() error
+}
+
+// gpioActuator pulses a relay on one GPIO to open and on another to close.
+// If the status outputs of the sphincter are wired up, they are read as the
+// status
+type gpioActuator struct {
+	cfg  GPIOConfig
+	pins pins
+
+	mu       sync.Mutex
+	pulser   pulser
+	status   Status
+	released bool
+}
+
+func newGPIOActuator(cfg GPIOConfig, p pins) *gpioActuator {
+	return &gpioActuator{cfg: cfg, pins: p, pulser: pulser{Timing: cfg.Timing}}
+}
+
+func (a *gpioActuator) energize(ctx context.Context, open bool, status Status) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.released {
+		return ErrReleased
+	}
+	pin := a.cfg.Close
+	if open {
+		pin = a.cfg.Open
+	}
+	var reached func() bool
+	if a.cfg.Status0 != nil && a.cfg.Status1 != nil {
+		reached = func() bool { return a.readStatus() == status }
+	}
+	if err := a.pulser.pulse(ctx, open, func(active bool) { a.pins.write(pin, active) }, reached); err != nil {
+		return err
+	}
+	a.status = status
+	return nil
+}
+
+func (a *gpioActuator) Open(ctx context.Context) error {
+	return a.energize(ctx, true, StatusUnlocked)
+}
+
+func (a *gpioActuator) Close(ctx context.Context) error {
+	return a.energize(ctx, false, StatusLocked)
+}
+
+// Status returns the status reported by the sphincter. Without status
+// inputs, it is the state last driven by this actuator
+func (a *gpioActuator) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.Status0 == nil || a.cfg.Status1 == nil || a.released {
+		return a.status
+	}
+	return a.readStatus()
+}
+
+// readStatus reads the status inputs. The caller must hold mu
+func (a *gpioActuator) readStatus() Status {
+	status := StatusUnknown
+	if a.pins.read(*a.cfg.Status0) {
+		status |= 1
+	}
+	if a.pins.read(*a.cfg.Status1) {
+		status |= 2
+	}
+	return status
+}
+
+// Check reads back the relay outputs, which must be inactive unless a relay
+// is being pulsed. While one is, the outputs aren't read
+func (a *gpioActuator) Check() error {
+	if !a.mu.TryLock() {
+		return nil
+	}
+	defer a.mu.Unlock()
+	if a.released {
+		return ErrReleased
+	}
+	for _, pin := range []Pin{a.cfg.Open, a.cfg.Close} {
+		if a.pins.read(pin) {
+			return fmt.Errorf("relay on GPIO %d reads active while no relay is pulsed", pin.Number)
+		}
+	}
+	return nil
+}
+
+func (a *gpioActuator) Release() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.released {
+		return nil
+	}
+	a.pins.write(a.cfg.Open, false)
+	a.pins.write(a.cfg.Close, false)
+	a.released = true
+	return a.pins.release()
+}
@@ -0,0 +1,20 @@
+package actuator
+
+import "time"
+
+// SampleStatus reads the status of a every interval for window and returns
+// the status read last and how often it changed meanwhile. Status inputs
+// that change more than the sphincter moving would explain are likely
+// floating or wired to the wrong GPIO
+func SampleStatus(a DoorActuator, window, interval time.Duration) (Status, int) {
+	status := a.Status()
+	changes := 0
+	for deadline := time.Now().Add(window); time.Now().Before(deadline); {
+		time.Sleep(interval)
+		if s := a.Status(); s != status {
+			status = s
+			changes++
+		}
+	}
+	return status, changes
+}
@@ -0,0 +1,79 @@
+package actuator
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Simulated only logs what it would do and simulates the resulting status,
+// for running on hardware without GPIO
+type Simulated struct {
+	// label names the actuator in the log lines
+	label string
+
+	mu       sync.Mutex
+	pulser   pulser
+	status   Status
+	released bool
+}
+
+// NewSimulated returns a simulated actuator that takes as long to open or
+// close as the relays are pulsed. Held relays are released once it did
+func NewSimulated(t Timing) *Simulated {
+	return &Simulated{label: "simulated actuator", pulser: pulser{Timing: t}}
+}
+
+// NewDryRun returns a simulated actuator standing in for the GPIO while
+// trying out a configuration on the real readers
+func NewDryRun(t Timing) *Simulated {
+	return &Simulated{label: "dry run", pulser: pulser{Timing: t}}
+}
+
+func (a *Simulated) energize(ctx context.Context, open bool, status Status) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.released {
+		return ErrReleased
+	}
+	relay, pulse := "close", a.pulser.ClosePulse
+	if open {
+		relay, pulse = "open", a.pulser.OpenPulse
+	}
+	var energized time.Time
+	err := a.pulser.pulse(ctx, open, func(active bool) {
+		if active {
+			energized = time.Now()
+			slog.Info("Pulsing relay", "actuator", a.label, "relay", relay, "pulse", pulse, "profile", a.pulser.Profile)
+		}
+	}, func() bool { return time.Since(energized) >= pulse })
+	if err != nil {
+		return err
+	}
+	slog.Info("Status changed", "actuator", a.label, "from", a.status.String(), "to", status.String())
+	a.status = status
+	return nil
+}
+
+func (a *Simulated) Open(ctx context.Context) error {
+	return a.energize(ctx, true, StatusUnlocked)
+}
+
+func (a *Simulated) Close(ctx context.Context) error {
+	return a.energize(ctx, false, StatusLocked)
+}
+
+func (a *Simulated) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+func (a *Simulated) Release() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	slog.Info("Released", "actuator", a.label)
+	a.released = true
+	return nil
+}
@@ -0,0 +1,191 @@
+package actuator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+// ErrRelayResting is returned by Open and Close if energizing a relay now
+// would exceed its Cooldown or MaxPulses, protecting strikes that overheat
+// when pulsed in a loop
+var ErrRelayResting = errors.New("relay is resting")
+
+// Profile is how a relay is energized, for motorized locks that need more
+// than a single pulse
+type Profile string
+
+const (
+	// ProfilePulse energizes the relay for its pulse
+	ProfilePulse Profile = "pulse"
+	// ProfileDoublePulse energizes it for its pulse twice, with Pause in
+	// between
+	ProfileDoublePulse Profile = "double-pulse"
+	// ProfileHoldUntilStatus holds it until the status inputs report the
+	// requested status, failing with ErrNotMoved after HoldTimeout
+	ProfileHoldUntilStatus Profile = "hold-until-status"
+	// ProfileHoldWithTimeout holds it until the status inputs, if any,
+	// report the requested status, or for HoldTimeout
+	ProfileHoldWithTimeout Profile = "hold-with-timeout"
+)
+
+// ParseProfile parses the name of a Profile
+func ParseProfile(name string) (Profile, error) {
+	switch p := Profile(name); p {
+	case ProfilePulse, ProfileDoublePulse, ProfileHoldUntilStatus, ProfileHoldWithTimeout:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid actuation %q, expected pulse, double-pulse, hold-until-status or hold-with-timeout", name)
+}
+
+// holdPoll is how often the status is read while a relay is held
+const holdPoll = 10 * time.Millisecond
+
+// Timing is how the relays of the sphincter are pulsed
+type Timing struct {
+	// OpenPulse and ClosePulse are how long the open and the close relay are
+	// energized
+	OpenPulse  time.Duration
+	ClosePulse time.Duration
+	// Gap is the minimum time between the end of a pulse and the start of
+	// the next one, so the sphincter isn't sent pulses it can't tell apart
+	Gap time.Duration
+	// Profile is how the relays are energized, ProfilePulse if empty
+	Profile Profile
+	// Pause is the pause between the pulses of ProfileDoublePulse
+	Pause time.Duration
+	// HoldTimeout is the longest the hold profiles energize a relay
+	HoldTimeout time.Duration
+	// Cooldown is the minimum time between the end of a pulse and the start
+	// of the next one like Gap, but pulses requested sooner fail with
+	// ErrRelayResting instead of waiting
+	Cooldown time.Duration
+	// MaxPulses is how many pulses may start within RateWindow; further
+	// ones fail with ErrRelayResting. Unlimited if 0
+	MaxPulses  int
+	RateWindow time.Duration
+	// Clock times the pulses; the real clock if nil
+	Clock clock.Clock
+}
+
+type energizedKey struct{}
+
+// OnEnergized returns a ctx making Open and Close call f whenever they
+// energize a relay, e.g. to time how long it took until the sphincter was
+// sent the pulse
+func OnEnergized(ctx context.Context, f func()) context.Context {
+	return context.WithValue(ctx, energizedKey{}, f)
+}
+
+// pulser energizes the relays as configured by its Timing. The caller must
+// serialize calls
+type pulser struct {
+	Timing
+	// last is when the previous pulse ended
+	last time.Time
+	// starts are when the pulses within the RateWindow started, oldest
+	// first
+	starts []time.Time
+}
+
+// rest returns ErrRelayResting, along with when to retry, if starting a
+// pulse now exceeded the duty cycle limits
+func (p *pulser) rest(now time.Time) error {
+	var wait time.Duration
+	if !p.last.IsZero() {
+		wait = p.Cooldown - now.Sub(p.last)
+	}
+	if p.MaxPulses > 0 {
+		for len(p.starts) > 0 && now.Sub(p.starts[0]) >= p.RateWindow {
+			p.starts = p.starts[1:]
+		}
+		if len(p.starts) >= p.MaxPulses {
+			wait = max(wait, p.RateWindow-now.Sub(p.starts[0]))
+		}
+	}
+	if wait > 0 {
+		return fmt.Errorf("%w, retry in %v", ErrRelayResting, wait.Round(100*time.Millisecond))
+	}
+	return nil
+}
+
+// pulse waits out the gap after the previous pulse, then energizes a relay
+// by calling set with true and, as its profile says, with false. reached
+// reports whether the sphincter reached the requested status, nil without
+// status inputs. It gives up if ctx is done before the relay is energized,
+// and fails right away if the relay has to rest
+func (p *pulser) pulse(ctx context.Context, open bool, set func(active bool), reached func() bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c := clock.Or(p.Clock)
+	if err := p.rest(c.Now()); err != nil {
+		return err
+	}
+	if !p.last.IsZero() {
+		if wait := p.Gap - c.Now().Sub(p.last); wait > 0 {
+			t := c.NewTimer(wait)
+			defer t.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-t.C():
+			}
+		}
+	}
+	d := p.ClosePulse
+	if open {
+		d = p.OpenPulse
+	}
+	if p.MaxPulses > 0 {
+		p.starts = append(p.starts, c.Now())
+	}
+	set(true)
+	if f, ok := ctx.Value(energizedKey{}).(func()); ok {
+		f()
+	}
+	var err error
+	switch p.Profile {
+	case ProfileDoublePulse:
+		sleep(c, d)
+		set(false)
+		sleep(c, p.Pause)
+		set(true)
+		sleep(c, d)
+	case ProfileHoldUntilStatus, ProfileHoldWithTimeout:
+		err = p.hold(c, reached)
+	default:
+		sleep(c, d)
+	}
+	set(false)
+	p.last = c.Now()
+	return err
+}
+
+// hold waits until reached reports the requested status, at most for
+// HoldTimeout
+func (p *pulser) hold(c clock.Clock, reached func() bool) error {
+	deadline := c.Now().Add(p.HoldTimeout)
+	for c.Now().Before(deadline) {
+		if reached != nil && reached() {
+			return nil
+		}
+		sleep(c, holdPoll)
+	}
+	if p.Profile == ProfileHoldUntilStatus {
+		return ErrNotMoved
+	}
+	return nil
+}
+
+// sleep waits for d on c
+func sleep(c clock.Clock, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := c.NewTimer(d)
+	<-t.C()
+}
@@ -0,0 +1,123 @@
+package actuator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// edge is a relay level set at an offset from epoch
+type edge struct {
+	at     time.Duration
+	active bool
+}
+
+// runPulse pulses p in the background and advances its fake clock by step
+// whenever the pulse waits for a timer, until it returns. It returns the
+// relay levels set and the error of the pulse
+func runPulse(t *testing.T, p *pulser, fake *clock.Fake, step time.Duration) ([]edge, error) {
+	t.Helper()
+	var edges []edge
+	set := func(active bool) { edges = append(edges, edge{fake.Now().Sub(epoch), active}) }
+	errc := make(chan error, 1)
+	go func() { errc <- p.pulse(context.Background(), true, set, nil) }()
+	for {
+		select {
+		case err := <-errc:
+			return edges, err
+		case <-time.After(time.Millisecond):
+		}
+		if fake.Pending() > 0 {
+			fake.Advance(step)
+		}
+	}
+}
+
+func TestPulseWaitsOutGap(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	p := &pulser{Timing: Timing{OpenPulse: 50 * time.Millisecond, Gap: 100 * time.Millisecond, Clock: fake}}
+
+	if _, err := runPulse(t, p, fake, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	edges, err := runPulse(t, p, fake, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []edge{{150 * time.Millisecond, true}, {200 * time.Millisecond, false}}
+	if len(edges) != 2 || edges[0] != want[0] || edges[1] != want[1] {
+		t.Fatalf("second pulse set %v, want %v", edges, want)
+	}
+}
+
+func TestDoublePulse(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	p := &pulser{Timing: Timing{OpenPulse: 50 * time.Millisecond, Profile: ProfileDoublePulse, Pause: 200 * time.Millisecond, Clock: fake}}
+
+	edges, err := runPulse(t, p, fake, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []edge{
+		{0, true},
+		{50 * time.Millisecond, false},
+		{250 * time.Millisecond, true},
+		{300 * time.Millisecond, false},
+	}
+	if len(edges) != len(want) {
+		t.Fatalf("set %v, want %v", edges, want)
+	}
+	for i := range want {
+		if edges[i] != want[i] {
+			t.Fatalf("set %v, want %v", edges, want)
+		}
+	}
+}
+
+func TestHoldWithTimeout(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	p := &pulser{Timing: Timing{Profile: ProfileHoldUntilStatus, HoldTimeout: time.Second, Clock: fake}}
+
+	edges, err := runPulse(t, p, fake, holdPoll)
+	if !errors.Is(err, ErrNotMoved) {
+		t.Fatalf("hold until status without status = %v, want ErrNotMoved", err)
+	}
+	if last := edges[len(edges)-1]; last != (edge{time.Second, false}) {
+		t.Fatalf("released relay at %v, want after the hold timeout", last)
+	}
+}
+
+func TestRelayRests(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	p := &pulser{Timing: Timing{
+		OpenPulse:  10 * time.Millisecond,
+		Cooldown:   time.Second,
+		MaxPulses:  2,
+		RateWindow: time.Minute,
+		Clock:      fake,
+	}}
+
+	if _, err := runPulse(t, p, fake, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runPulse(t, p, fake, 10*time.Millisecond); !errors.Is(err, ErrRelayResting) {
+		t.Fatalf("pulse within cooldown = %v, want ErrRelayResting", err)
+	}
+	fake.Advance(time.Second)
+	if _, err := runPulse(t, p, fake, 10*time.Millisecond); err != nil {
+		t.Fatalf("pulse after cooldown: %v", err)
+	}
+	fake.Advance(time.Second)
+	if _, err := runPulse(t, p, fake, 10*time.Millisecond); !errors.Is(err, ErrRelayResting) {
+		t.Fatalf("third pulse within rate window = %v, want ErrRelayResting", err)
+	}
+	fake.Advance(time.Minute)
+	if _, err := runPulse(t, p, fake, 10*time.Millisecond); err != nil {
+		t.Fatalf("pulse after rate window: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package actuator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+// verifyInterval is how often the status is read while waiting for the
+// sphincter to move
+const verifyInterval = 100 * time.Millisecond
+
+// ErrNotMoved is returned by Verified if the sphincter didn't reach the
+// requested status
+var ErrNotMoved = errors.New("sphincter did not move")
+
+// Verified wraps a DoorActuator with status inputs and checks that Open and
+// Close actually moved the sphincter. If it didn't reach the requested status
+// within Timeout, the command is sent once more; if that fails as well, the
+// status is reported as FAILURE until the sphincter reports another one
+type Verified struct {
+	DoorActuator
+	Timeout time.Duration
+	// Clock times the wait for the status; the real clock if nil
+	Clock clock.Clock
+
+	mu sync.Mutex
+	// failed is set when the sphincter didn't move; stuck is the status it
+	// reported then
+	failed bool
+	stuck  Status
+}
+
+func (a *Verified) Open(ctx context.Context) error {
+	return a.verify(ctx, a.DoorActuator.Open, StatusUnlocked)
+}
+
+func (a *Verified) Close(ctx context.Context) error {
+	return a.verify(ctx, a.DoorActuator.Close, StatusLocked)
+}
+
+// verify runs action until the sphincter reaches target. If ctx is done
+// while waiting, it gives up without marking the sphincter as failed
+func (a *Verified) verify(ctx context.Context, action func(context.Context) error, target Status) error {
+	for attempt := 1; ; attempt++ {
+		if err := action(ctx); err != nil {
+			return err
+		}
+		reached, err := a.await(ctx, target)
+		if err != nil {
+			return err
+		}
+		if reached {
+			a.mu.Lock()
+			a.failed = false
+			a.mu.Unlock()
+			return nil
+		}
+		if attempt == 2 {
+			break
+		}
+		slog.Warn("Sphincter did not move, retrying", "target", target.String(), "timeout", a.Timeout)
+	}
+
+	a.mu.Lock()
+	a.failed = true
+	a.stuck = a.DoorActuator.Status()
+	a.mu.Unlock()
+	return fmt.Errorf("%w: not %v after 2 attempts", ErrNotMoved, target)
+}
+
+// await reports whether the sphincter reaches target within the timeout
+func (a *Verified) await(ctx context.Context, target Status) (bool, error) {
+	c := clock.Or(a.Clock)
+	deadline := c.Now().Add(a.Timeout)
+	ticker := c.NewTicker(verifyInterval)
+	defer ticker.Stop()
+	for {
+		if a.DoorActuator.Status() == target {
+			return true, nil
+		}
+		if c.Now().After(deadline) {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ticker.C():
+		}
+	}
+}
+
+// Status returns FAILURE after the sphincter failed to move, until it reports
+// a different status than it did then
+func (a *Verified) Status() Status {
+	status := a.DoorActuator.Status()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.failed && status == a.stuck {
+		return StatusFailure
+	}
+	a.failed = false
+	return status
+}
@@ -0,0 +1,135 @@
+// Package alarm interlocks unlocking with a burglar alarm: while it is armed,
+// doors are only unlocked once it was asked to disarm and did so
+package alarm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrArmed is returned when unlocking while the alarm stays armed
+var ErrArmed = errors.New("alarm armed")
+
+// State of the alarm
+type State int
+
+const (
+	StateUnknown State = iota
+	StateDisarmed
+	StateArmed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisarmed:
+		return "DISARMED"
+	case StateArmed:
+		return "ARMED"
+	}
+	return "UNKNOWN"
+}
+
+// ParseState parses a state as published by alarm panels, in any case:
+// disarmed or OFF, or armed or ON. The states of Home Assistant's alarm
+// panels are understood as well; those between armed and disarmed, like
+// arming, pending or disarming, count as armed, since opening the door may
+// trip the alarm
+func ParseState(s string) (State, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "disarmed" || s == "off":
+		return StateDisarmed, nil
+	case s == "on" || strings.HasPrefix(s, "armed") || s == "arming" || s == "pending" || s == "triggered" || s == "disarming":
+		return StateArmed, nil
+	}
+	return StateUnknown, fmt.Errorf("unknown alarm state %q, expected armed or disarmed", s)
+}
+
+// Interlock holds unlocks while the alarm is armed. A nil *Interlock never
+// holds them
+type Interlock struct {
+	// Disarm asks the alarm to disarm. Unlocking while armed is refused
+	// right away if it is nil
+	Disarm func() error
+	// Timeout is how long to wait for the alarm to disarm
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	state State
+	// changed is closed and replaced whenever the state changes
+	changed   chan struct{}
+	listeners []func(State)
+}
+
+// State returns the state last read
+func (i *Interlock) State() State {
+	if i == nil {
+		return StateUnknown
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.state
+}
+
+// OnChange registers f to be called with the state whenever it changes
+func (i *Interlock) OnChange(f func(State)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.listeners = append(i.listeners, f)
+}
+
+// Set changes the state, as read from the alarm
+func (i *Interlock) Set(s State) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if s == i.state {
+		return
+	}
+	i.state = s
+	if i.changed != nil {
+		close(i.changed)
+		i.changed = nil
+	}
+	// Listeners are called with mu held, so they see the changes in order
+	for _, f := range i.listeners {
+		f(s)
+	}
+}
+
+// Changed returns a channel closed on the next change, or nil if the alarm
+// isn't armed
+func (i *Interlock) Changed() <-chan struct{} {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.state != StateArmed {
+		return nil
+	}
+	if i.changed == nil {
+		i.changed = make(chan struct{})
+	}
+	return i.changed
+}
+
+// AskDisarm asks the armed alarm to disarm, returning ErrArmed if it can't
+// be asked. Changed tells when it did
+func (i *Interlock) AskDisarm() error {
+	if i.Disarm == nil {
+		return ErrArmed
+	}
+	if err := i.Disarm(); err != nil {
+		return fmt.Errorf("%w, could not ask it to disarm: %v", ErrArmed, err)
+	}
+	return nil
+}
+
+// TimedOut returns the error of an unlock the alarm wasn't disarmed for
+// within Timeout
+func (i *Interlock) TimedOut() error {
+	return fmt.Errorf("%w, not disarmed within %v", ErrArmed, i.Timeout)
+}
@@ -0,0 +1,40 @@
+package alarm
+
+import (
+	"fmt"
+	"time"
+)
+
+// pollInterval is how often an Input is read
+const pollInterval = 50 * time.Millisecond
+
+// Input reads the state of the alarm from an output of its panel, like a
+// relay contact to ground. The GPIO is pulled up and read as armed while
+// high, or while low if ActiveLow is set. A change is taken once the GPIO
+// read the same for Debounce, and passed to Interlock
+type Input struct {
+	Pin       int
+	ActiveLow bool
+	Debounce  time.Duration
+	Interlock *Interlock
+
+	stopped chan struct{}
+	// reading is the state last read and since when it is read
+	reading State
+	since   time.Time
+}
+
+func (in *Input) String() string {
+	return fmt.Sprintf("alarm input on GPIO %d", in.Pin)
+}
+
+// read takes the state read at now, once it was read for Debounce. The
+// first reading is taken at once
+func (in *Input) read(s State, now time.Time) {
+	if s != in.reading {
+		in.reading, in.since = s, now
+	}
+	if in.Interlock.State() == StateUnknown || now.Sub(in.since) >= in.Debounce {
+		in.Interlock.Set(s)
+	}
+}
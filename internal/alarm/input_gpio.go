@@ -0,0 +1,57 @@
+//go:build !nogpio
+// +build !nogpio
+
+package alarm
+
+import (
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// Open sets up the GPIO as input, pulled up
+func (in *Input) Open() error {
+	if err := rpio.Open(); err != nil {
+		return err
+	}
+	pin := rpio.Pin(in.Pin)
+	pin.Input()
+	pin.PullUp()
+	return nil
+}
+
+// Close waits for Run to stop, so its done channel must be closed before
+func (in *Input) Close() error {
+	if in.stopped != nil {
+		<-in.stopped
+	}
+	return nil
+}
+
+// Run reads the input until done is closed
+func (in *Input) Run(done <-chan struct{}) {
+	in.stopped = make(chan struct{})
+	go func() {
+		defer close(in.stopped)
+		supervisor.Restart(in.String(), func() { in.run(done) })
+	}()
+}
+
+func (in *Input) run(done <-chan struct{}) {
+	pin := rpio.Pin(in.Pin)
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	for {
+		s := StateDisarmed
+		if (pin.Read() == rpio.High) != in.ActiveLow {
+			s = StateArmed
+		}
+		in.read(s, time.Now())
+		select {
+		case <-done:
+			return
+		case <-poll.C:
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build nogpio
+// +build nogpio
+
+package alarm
+
+import "errors"
+
+func (in *Input) Open() error {
+	return errors.New("alarm inputs need GPIO support, which was not built in")
+}
+
+func (in *Input) Close() error {
+	return nil
+}
+
+func (in *Input) Run(done <-chan struct{}) {}
@@ -0,0 +1,45 @@
+// Package button reads push buttons wired to GPIOs, like the exit button
+// inside a door
+package button
+
+import (
+	"fmt"
+	"time"
+)
+
+// pollInterval is how often the button is read
+const pollInterval = 10 * time.Millisecond
+
+// Button is a push button between a GPIO and ground if ActiveLow is set, or
+// else 3.3V. A press is taken once the button was held for Debounce, and
+// the next one only after it was released
+type Button struct {
+	Pin       int
+	ActiveLow bool
+	Debounce  time.Duration
+
+	stopped   chan struct{}
+	heldSince time.Time
+	taken     bool
+}
+
+func (b *Button) String() string {
+	return fmt.Sprintf("button on GPIO %d", b.Pin)
+}
+
+// press reports whether the button, read as held or not at now, was just
+// pressed
+func (b *Button) press(held bool, now time.Time) bool {
+	if !held {
+		b.heldSince, b.taken = time.Time{}, false
+		return false
+	}
+	if b.heldSince.IsZero() {
+		b.heldSince = now
+	}
+	if b.taken || now.Sub(b.heldSince) < b.Debounce {
+		return false
+	}
+	b.taken = true
+	return true
+}
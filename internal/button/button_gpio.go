@@ -0,0 +1,65 @@
+//go:build !nogpio
+// +build !nogpio
+
+package button
+
+import (
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// Open sets up the GPIO as input, pulled to its inactive level
+func (b *Button) Open() error {
+	if err := rpio.Open(); err != nil {
+		return err
+	}
+	pin := rpio.Pin(b.Pin)
+	pin.Input()
+	if b.ActiveLow {
+		pin.PullUp()
+	} else {
+		pin.PullDown()
+	}
+	return nil
+}
+
+// Close waits for Run to stop, so its done channel must be closed before
+func (b *Button) Close() error {
+	if b.stopped != nil {
+		<-b.stopped
+	}
+	return nil
+}
+
+// Run sends on c once per press, not while the button is held
+func (b *Button) Run(c chan<- struct{}, done <-chan struct{}) {
+	b.stopped = make(chan struct{})
+	go func() {
+		defer close(b.stopped)
+		supervisor.Restart(b.String(), func() { b.run(c, done) })
+	}()
+}
+
+func (b *Button) run(c chan<- struct{}, done <-chan struct{}) {
+	pin := rpio.Pin(b.Pin)
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-poll.C:
+		}
+		held := (pin.Read() == rpio.High) != b.ActiveLow
+		if !b.press(held, time.Now()) {
+			continue
+		}
+		select {
+		case c <- struct{}{}:
+		case <-done:
+			return
+		}
+	}
+}
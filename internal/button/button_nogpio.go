@@ -0,0 +1,16 @@
+//go:build nogpio
+// +build nogpio
+
+package button
+
+import "errors"
+
+func (b *Button) Open() error {
+	return errors.New("buttons need GPIO support, which was not built in")
+}
+
+func (b *Button) Close() error {
+	return nil
+}
+
+func (b *Button) Run(c chan<- struct{}, done <-chan struct{}) {}
@@ -0,0 +1,84 @@
+// Package clock abstracts reading the time and waiting, so the debouncing,
+// auto-lock and schedules can be driven by a fake clock instead of sleeping
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the time and starts timers, like the time package
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d, unless the timer is
+	// stopped before. The timer's channel is nil
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a *time.Timer of a Clock
+type Timer interface {
+	C() <-chan time.Time
+	// Stop reports whether the timer was stopped before it fired
+	Stop() bool
+}
+
+// Ticker is a *time.Ticker of a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock of the time package
+var Real Clock = realClock{}
+
+// Or returns c, or Real if c is nil, so components can leave their clock
+// unset
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{t: time.AfterFunc(d, f)}
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{t: time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{t: time.NewTicker(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r realTimer) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTimer) Stop() bool {
+	return r.t.Stop()
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r realTicker) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTicker) Stop() {
+	r.t.Stop()
+}
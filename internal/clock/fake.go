@@ -0,0 +1,136 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when told to, for tests. Its timers fire
+// while Advance moves the time past them: AfterFunc functions are called
+// by Advance itself, and timers and tickers send on their channel without
+// blocking, dropping ticks nobody received like the time package does
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns a fake clock set to now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+type fakeTimer struct {
+	fake *Fake
+	when time.Time
+	// period is set for tickers, which are due again period after firing
+	period time.Duration
+	f      func()
+	c      chan time.Time
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(&fakeTimer{fake: f, f: fn}, d)
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(&fakeTimer{fake: f, c: make(chan time.Time, 1)}, d)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(&fakeTimer{fake: f, period: d, c: make(chan time.Time, 1)}, d)}
+}
+
+func (f *Fake) add(t *fakeTimer, d time.Duration) *fakeTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.when = f.now.Add(d)
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers due on the way in
+// the order they are due
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	f.mu.Unlock()
+	for {
+		f.mu.Lock()
+		var next *fakeTimer
+		for _, t := range f.timers {
+			if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			f.now = end
+			f.mu.Unlock()
+			return
+		}
+		f.now = next.when
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			f.remove(next)
+		}
+		now := f.now
+		f.mu.Unlock()
+
+		if next.f != nil {
+			next.f()
+			continue
+		}
+		select {
+		case next.c <- now:
+		default:
+		}
+	}
+}
+
+// Pending returns the number of timers and tickers not stopped or fired
+// yet, so tests can wait for a component to have started one
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// remove removes t from the pending timers, reporting whether it was. The
+// caller must hold mu
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, p := range f.timers {
+		if p == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.fake.mu.Lock()
+	defer t.fake.mu.Unlock()
+	return t.fake.remove(t)
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
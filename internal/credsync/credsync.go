@@ -0,0 +1,289 @@
+// Package credsync keeps the user list in sync with a membership system,
+// fetching it periodically over HTTPS
+package credsync
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Headers of the lists served. The signature, as ed25519=<base64>, covers
+// the serial and the issue time as well as the list, so an older list
+// can't be served again
+const (
+	SignatureHeader = "X-Wishbone-Signature"
+	SerialHeader    = "X-Wishbone-Serial"
+	IssuedHeader    = "X-Wishbone-Issued"
+)
+
+// maxListSize limits the lists fetched, so a misbehaving server can't fill
+// the memory
+const maxListSize = 10 << 20
+
+var (
+	syncFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wishbone_sync_failures_total",
+		Help: "Failed attempts to fetch the user list from the membership system.",
+	})
+	lastSyncTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wishbone_sync_last_success_timestamp_seconds",
+		Help: "When the user list was last fetched successfully, as a unix timestamp.",
+	})
+)
+
+// Target is where fetched lists are swapped in, e.g. a *store.ListStore
+type Target interface {
+	Replace(data []byte) error
+}
+
+// Config configures where the list is fetched from
+type Config struct {
+	URL      string
+	Interval time.Duration
+	// PublicKey verifies the signature every list must carry
+	PublicKey ed25519.PublicKey
+	// Token is sent as bearer token, unless empty
+	Token string
+	// StatePath keeps the serial of the list accepted last across restarts,
+	// unless empty
+	StatePath string
+}
+
+// Client fetches the list every interval and swaps it in if it changed and
+// its signature is valid. A failed sync leaves the previous list in place
+type Client struct {
+	cfg    Config
+	target Target
+	http   *http.Client
+
+	mu       sync.Mutex
+	etag     string
+	lastSync time.Time
+	lastErr  error
+	accepted state
+}
+
+// state is the list accepted last, by its serial and SHA-256
+type state struct {
+	Serial uint64 `json:"serial"`
+	Digest string `json:"digest"`
+}
+
+// New returns a client swapping the lists fetched as configured by cfg into
+// target. Only lists with a serial above the one in cfg.StatePath are taken
+func New(cfg Config, target Target) (*Client, error) {
+	c := &Client{cfg: cfg, target: target, http: &http.Client{Timeout: 30 * time.Second}}
+	data, err := os.ReadFile(cfg.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.accepted); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.StatePath, err)
+	}
+	return c, nil
+}
+
+// save writes the accepted state to cfg.StatePath atomically, if set
+func (c *Client) save() error {
+	if c.cfg.StatePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.accepted)
+	if err != nil {
+		return err
+	}
+	tmp := c.cfg.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.cfg.StatePath)
+}
+
+// LoadPublicKey reads a hex encoded Ed25519 public key
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s: expected %d hex encoded bytes", path, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Sign returns the value of SignatureHeader for list with serial, issued
+// at issued, for the membership system to send along. Serials must grow
+// with every list, e.g. the issue time as unix timestamp
+func Sign(key ed25519.PrivateKey, serial uint64, issued time.Time, list []byte) string {
+	sig := ed25519.Sign(key, signed(serial, issued.UTC().Format(time.RFC3339), list))
+	return "ed25519=" + base64.StdEncoding.EncodeToString(sig)
+}
+
+// signed is what the signature of list covers
+func signed(serial uint64, issued string, list []byte) []byte {
+	return append([]byte(fmt.Sprintf("wishbone-list\n%d\n%s\n", serial, issued)), list...)
+}
+
+// verify checks the signature of list against the headers h and returns its
+// serial and issue time
+func verify(key ed25519.PublicKey, list []byte, h http.Header) (uint64, time.Time, error) {
+	serial, err := strconv.ParseUint(h.Get(SerialHeader), 10, 64)
+	if err != nil {
+		return 0, time.Time{}, errors.New("missing or invalid serial")
+	}
+	issued, err := time.Parse(time.RFC3339, h.Get(IssuedHeader))
+	if err != nil {
+		return 0, time.Time{}, errors.New("missing or invalid issue time")
+	}
+	header := h.Get(SignatureHeader)
+	encoded := strings.TrimPrefix(header, "ed25519=")
+	if encoded == header {
+		return 0, time.Time{}, errors.New("missing or unsupported signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || !ed25519.Verify(key, signed(serial, h.Get(IssuedHeader), list), sig) {
+		return 0, time.Time{}, errors.New("invalid signature")
+	}
+	return serial, issued, nil
+}
+
+// Run syncs right away and then every interval until done is closed
+func (c *Client) Run(done <-chan struct{}) {
+	supervisor.Go("credential sync", func() {
+		ticker := time.NewTicker(c.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			if err := c.Sync(); err != nil {
+				slog.Error("Could not sync user list", "url", c.cfg.URL, "err", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// Sync fetches the list once, unless it is unchanged since the last sync
+func (c *Client) Sync() error {
+	err := c.fetch()
+	now := time.Now()
+
+	c.mu.Lock()
+	c.lastErr = err
+	if err == nil {
+		c.lastSync = now
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		syncFailuresTotal.Inc()
+		return err
+	}
+	lastSyncTimestamp.Set(float64(now.Unix()))
+	return nil
+}
+
+func (c *Client) fetch() error {
+	req, err := http.NewRequest(http.MethodGet, c.cfg.URL, nil)
+	if err != nil {
+		return err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	c.mu.Lock()
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	c.mu.Unlock()
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	list, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return err
+	}
+	if len(list) > maxListSize {
+		return fmt.Errorf("list larger than %d bytes", maxListSize)
+	}
+	serial, issued, err := verify(c.cfg.PublicKey, list, resp.Header)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(list)
+	digest := hex.EncodeToString(sum[:])
+	c.mu.Lock()
+	accepted := c.accepted
+	c.mu.Unlock()
+	switch {
+	case serial == accepted.Serial && digest == accepted.Digest:
+		// The list accepted last, e.g. fetched again after a restart
+		c.mu.Lock()
+		c.etag = resp.Header.Get("ETag")
+		c.mu.Unlock()
+		return nil
+	case serial <= accepted.Serial:
+		return fmt.Errorf("refusing list with serial %d issued at %v, not newer than %d", serial, issued, accepted.Serial)
+	}
+	// An empty list would lock everyone out, which is more likely a bug in
+	// the membership system than intended
+	if len(bytes.TrimSpace(list)) == 0 {
+		return errors.New("refusing empty list")
+	}
+	if err := c.target.Replace(list); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.etag = resp.Header.Get("ETag")
+	c.accepted = state{Serial: serial, Digest: digest}
+	if err := c.save(); err != nil {
+		return fmt.Errorf("list swapped in, but its serial couldn't be saved: %w", err)
+	}
+	slog.Info("Synced user list", "url", c.cfg.URL, "serial", serial, "issued", issued)
+	return nil
+}
+
+// Status returns when the list was last synced successfully, and the error
+// of the last attempt, if it failed
+func (c *Client) Status() (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSync, c.lastErr
+}
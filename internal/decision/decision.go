@@ -0,0 +1,163 @@
+// Package decision asks an external program or HTTP endpoint for the final
+// say on access attempts that passed all other checks, e.g. to deny members
+// with unpaid dues
+package decision
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrDenied is returned for access attempts the hook denied, or couldn't
+// decide on while failing closed
+var ErrDenied = errors.New("denied by access hook")
+
+// Request describes an access attempt to the hook, as JSON
+type Request struct {
+	Door   string   `json:"door"`
+	Reader string   `json:"reader,omitempty"`
+	Source string   `json:"source"`
+	Action string   `json:"action"`
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	// TokenHash identifies the token without revealing it
+	TokenHash string `json:"token_hash,omitempty"`
+}
+
+// Response is the verdict of an HTTP hook
+type Response struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Hook is the program or endpoint consulted. A nil Hook allows everything
+type Hook struct {
+	// target is an http or https URL, which requests are POSTed to, or
+	// the path of a program, which gets them on stdin
+	target   string
+	timeout  time.Duration
+	failOpen bool
+	client   *http.Client
+}
+
+// New returns the hook at target, an http or https URL or the path of a
+// program, or nil if target is empty. A hook not answering within timeout
+// has failed; failures allow access if failOpen is set
+func New(target string, timeout time.Duration, failOpen bool) (*Hook, error) {
+	if target == "" {
+		return nil, nil
+	}
+	if timeout <= 0 {
+		return nil, errors.New("timeout must be positive")
+	}
+	h := &Hook{target: target, timeout: timeout, failOpen: failOpen}
+	if h.isHTTP() {
+		h.client = &http.Client{}
+	} else if _, err := exec.LookPath(target); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Hook) isHTTP() bool {
+	return strings.HasPrefix(h.target, "http://") || strings.HasPrefix(h.target, "https://")
+}
+
+func (h *Hook) String() string {
+	return h.target
+}
+
+// Check asks the hook about req. It returns ErrDenied if the hook denied
+// it, along with the reason the hook gave, or if the hook failed and fails
+// closed. Failures are logged
+func (h *Hook) Check(ctx context.Context, req Request) (string, error) {
+	if h == nil {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	var resp Response
+	var err error
+	if h.isHTTP() {
+		resp, err = h.post(ctx, req)
+	} else {
+		resp, err = h.run(ctx, req)
+	}
+	if err != nil {
+		if h.failOpen {
+			slog.Warn("Access hook failed, allowing", "hook", h.target, "user", req.User, "err", err)
+			return "", nil
+		}
+		slog.Warn("Access hook failed, denying", "hook", h.target, "user", req.User, "err", err)
+		return "", ErrDenied
+	}
+	if !resp.Allow {
+		return resp.Reason, ErrDenied
+	}
+	return "", nil
+}
+
+// post sends req to the endpoint, which answers with a Response
+func (h *Hook) post(ctx context.Context, req Request) (Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, h.target, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(r)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Response{}, fmt.Errorf("hook replied %s", resp.Status)
+	}
+	var verdict Response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&verdict); err != nil {
+		return Response{}, fmt.Errorf("invalid reply: %w", err)
+	}
+	return verdict, nil
+}
+
+// run runs the program with req on stdin. Exiting with 0 allows, with 1
+// denies for the reason on the first line of stdout
+func (h *Hook) run(ctx context.Context, req Request) (Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	cmd := exec.CommandContext(ctx, h.target)
+	cmd.Stdin = bytes.NewReader(body)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	// Children keeping stdout open mustn't hold up the swipe
+	cmd.WaitDelay = 100 * time.Millisecond
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return Response{}, ctx.Err()
+	}
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return Response{Allow: true}, nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		reason, _ := bufio.NewReader(&stdout).ReadString('\n')
+		return Response{Reason: strings.TrimSpace(reason)}, nil
+	default:
+		return Response{}, err
+	}
+}
@@ -0,0 +1,111 @@
+// Package doorsensor reads the position of a door from a reed switch, apart
+// from the status of the sphincter locking it. Together they tell an
+// unlocked but closed door from one that is physically open
+package doorsensor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often the switch is read
+const pollInterval = 20 * time.Millisecond
+
+// Position of the door
+type Position int
+
+const (
+	PositionUnknown Position = iota
+	PositionClosed
+	PositionOpen
+)
+
+func (p Position) String() string {
+	switch p {
+	case PositionClosed:
+		return "CLOSED"
+	case PositionOpen:
+		return "OPEN"
+	}
+	return "UNKNOWN"
+}
+
+// ParsePosition parses "open" or "closed", in any case
+func ParsePosition(s string) (Position, error) {
+	switch strings.ToLower(s) {
+	case "open":
+		return PositionOpen, nil
+	case "closed":
+		return PositionClosed, nil
+	}
+	return PositionUnknown, fmt.Errorf("unknown door position %q, expected open or closed", s)
+}
+
+// Sensor is a reed switch connecting a GPIO to ground, which is pulled up.
+// The door is read as open while the GPIO is high, as normally open
+// switches close next to the magnet, or while it is low if ActiveLow is set.
+// A change is taken once the GPIO read the same for Debounce. A nil *Sensor
+// reads PositionUnknown
+type Sensor struct {
+	Pin       int
+	ActiveLow bool
+	Debounce  time.Duration
+
+	stopped chan struct{}
+	// reading is the position last read and since when it is read
+	reading Position
+	since   time.Time
+
+	mu        sync.Mutex
+	position  Position
+	listeners []func(Position)
+}
+
+func (s *Sensor) String() string {
+	return fmt.Sprintf("door sensor on GPIO %d", s.Pin)
+}
+
+// Position returns the debounced position of the door
+func (s *Sensor) Position() Position {
+	if s == nil {
+		return PositionUnknown
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.position
+}
+
+// OnChange registers f to be called with the position whenever it changes
+func (s *Sensor) OnChange(f func(Position)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, f)
+}
+
+// Set changes the position, as if read from the switch. It is used when
+// simulating
+func (s *Sensor) Set(p Position) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p == s.position {
+		return
+	}
+	s.position = p
+	// Listeners are called with mu held, so they see the changes in order
+	for _, f := range s.listeners {
+		f(p)
+	}
+}
+
+// read takes the position read at now, once it was read for Debounce. The
+// first reading is taken at once
+func (s *Sensor) read(p Position, now time.Time) {
+	if p != s.reading {
+		s.reading, s.since = p, now
+	}
+	if s.Position() == PositionUnknown || now.Sub(s.since) >= s.Debounce {
+		s.Set(p)
+	}
+}
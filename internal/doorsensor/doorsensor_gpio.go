@@ -0,0 +1,57 @@
+//go:build !nogpio
+// +build !nogpio
+
+package doorsensor
+
+import (
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// Open sets up the GPIO as input, pulled up
+func (s *Sensor) Open() error {
+	if err := rpio.Open(); err != nil {
+		return err
+	}
+	pin := rpio.Pin(s.Pin)
+	pin.Input()
+	pin.PullUp()
+	return nil
+}
+
+// Close waits for Run to stop, so its done channel must be closed before
+func (s *Sensor) Close() error {
+	if s.stopped != nil {
+		<-s.stopped
+	}
+	return nil
+}
+
+// Run reads the switch until done is closed
+func (s *Sensor) Run(done <-chan struct{}) {
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		supervisor.Restart(s.String(), func() { s.run(done) })
+	}()
+}
+
+func (s *Sensor) run(done <-chan struct{}) {
+	pin := rpio.Pin(s.Pin)
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	for {
+		p := PositionClosed
+		if (pin.Read() == rpio.High) != s.ActiveLow {
+			p = PositionOpen
+		}
+		s.read(p, time.Now())
+		select {
+		case <-done:
+			return
+		case <-poll.C:
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build nogpio
+// +build nogpio
+
+package doorsensor
+
+import "errors"
+
+func (s *Sensor) Open() error {
+	return errors.New("door sensors need GPIO support, which was not built in")
+}
+
+func (s *Sensor) Close() error {
+	return nil
+}
+
+func (s *Sensor) Run(done <-chan struct{}) {}
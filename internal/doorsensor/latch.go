@@ -0,0 +1,118 @@
+package doorsensor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+// LatchAlarm is raised if the door is open while the sphincter is locked
+// for longer than a delay. Either the bolt was thrown while the door stood
+// open, or the door was opened without unlocking it, so the latch failed.
+// A nil *LatchAlarm does nothing
+type LatchAlarm struct {
+	// Clock times the delay; the real clock if nil. Set before Changed and
+	// Moved
+	Clock clock.Clock
+
+	delay     time.Duration
+	listeners []func(alarmed bool)
+
+	mu       sync.Mutex
+	locked   bool
+	position Position
+	timer    clock.Timer
+	alarmed  bool
+}
+
+// NewLatchAlarm returns an alarm raised once the door was open while locked
+// for delay. Status changes must be passed to Changed, and position changes
+// to Moved
+func NewLatchAlarm(delay time.Duration) *LatchAlarm {
+	return &LatchAlarm{delay: delay}
+}
+
+// OnAlarm registers f to be called with true when the alarm is raised, and
+// with false when the door is closed or unlocked afterwards
+func (a *LatchAlarm) OnAlarm(f func(alarmed bool)) {
+	if a == nil {
+		return
+	}
+	a.listeners = append(a.listeners, f)
+}
+
+// Alarmed reports whether the alarm is raised
+func (a *LatchAlarm) Alarmed() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.alarmed
+}
+
+// Changed notes the status of the sphincter
+func (a *LatchAlarm) Changed(status actuator.Status) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.locked = status == actuator.StatusLocked
+	a.update()
+}
+
+// Moved notes the position of the door
+func (a *LatchAlarm) Moved(p Position) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.position = p
+	a.update()
+}
+
+// update starts the timer when the door became open while locked, and
+// stops it and clears the alarm otherwise. The caller must hold mu
+func (a *LatchAlarm) update() {
+	if a.locked && a.position == PositionOpen {
+		if a.timer == nil && !a.alarmed {
+			var t clock.Timer
+			t = clock.Or(a.Clock).AfterFunc(a.delay, func() { supervisor.Run("latch alarm", func() { a.fire(t) }) })
+			a.timer = t
+		}
+		return
+	}
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	if a.alarmed {
+		a.alarmed = false
+		a.notify(false)
+	}
+}
+
+func (a *LatchAlarm) fire(t clock.Timer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// The timer may have been replaced while it fired
+	if a.timer != t {
+		return
+	}
+	a.timer = nil
+	a.alarmed = true
+	a.notify(true)
+}
+
+// notify calls the listeners. The caller must hold mu, so they see the
+// alarm raised and cleared in order
+func (a *LatchAlarm) notify(alarmed bool) {
+	for _, f := range a.listeners {
+		f(alarmed)
+	}
+}
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// dbMigrations are applied in order; PRAGMA user_version holds the number
+// of migrations already applied
+var dbMigrations = []string{`
+CREATE TABLE events (
+	id   INTEGER PRIMARY KEY,
+	time INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX events_time ON events(time);
+`}
+
+// DB keeps events in a SQLite database, so the history survives restarts.
+// Events are stored as JSON with their time as unix nanoseconds, and removed
+// once older than the retention window
+type DB struct {
+	db        *sql.DB
+	retention time.Duration
+}
+
+// OpenDB opens the database at path, creating or upgrading the schema if
+// necessary. Events older than retention are pruned by Run; they are kept
+// forever if it is 0
+func OpenDB(path string, retention time.Duration) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// The hub and pruning write concurrently, which SQLite only allows on
+	// one connection
+	db.SetMaxOpenConns(1)
+	d := &DB{db: db, retention: retention}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version < len(dbMigrations) {
+		if err := d.migrate(version); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (d *DB) migrate(version int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, migration := range dbMigrations[version:] {
+		if _, err := tx.Exec(migration); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(dbMigrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Add stores e under its ID
+func (d *DB) Add(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec("INSERT OR REPLACE INTO events (id, time, type, data) VALUES (?, ?, ?, ?)", e.ID, e.Time.UnixNano(), e.Type, data)
+	return err
+}
+
+// LastID returns the highest ID stored, or 0 if there are no events
+func (d *DB) LastID() (uint64, error) {
+	var id sql.NullInt64
+	err := d.db.QueryRow("SELECT MAX(id) FROM events").Scan(&id)
+	return uint64(id.Int64), err
+}
+
+// History returns up to limit events with an ID below before, newest first.
+// If before is 0, it starts at the newest event
+func (d *DB) History(before uint64, limit int) ([]Event, error) {
+	rows, err := d.db.Query("SELECT data FROM events WHERE ? = 0 OR id < ? ORDER BY id DESC LIMIT ?", before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Event{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// Prune removes the events older than the retention window at now,
+// returning how many
+func (d *DB) Prune(now time.Time) (int64, error) {
+	if d.retention <= 0 {
+		return 0, nil
+	}
+	res, err := d.db.Exec("DELETE FROM events WHERE time < ?", now.Add(-d.retention).UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// pruneInterval is how often Run prunes events
+const pruneInterval = time.Hour
+
+// Run prunes events now and every hour until done is closed
+func (d *DB) Run(done <-chan struct{}) {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
+	for {
+		n, err := d.Prune(time.Now())
+		if err != nil {
+			slog.Error("Could not prune events", "err", err)
+		} else if n > 0 {
+			slog.Info("Pruned events", "events", n, "retention", d.retention)
+		}
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
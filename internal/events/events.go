@@ -0,0 +1,251 @@
+// Package events distributes state changes and access attempts to live
+// subscribers
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Results of an access attempt
+const (
+	ResultGranted = "granted"
+	ResultDenied  = "denied"
+	ResultUnknown = "unknown_token"
+	ResultFailure = "failure"
+)
+
+// Access is an attempt to open or close the sphincter, as written to the
+// audit log
+type Access struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	Door      string    `json:"door,omitempty"`
+	Reader    string    `json:"reader,omitempty"`
+	Action    string    `json:"action"`
+	TokenHash string    `json:"token_hash,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Result    string    `json:"result"`
+	Reason    string    `json:"reason,omitempty"`
+	// DryRun is set if the sphincter wasn't actually driven
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// hashKey keys HashToken. Until LoadHashKey is called it is random, so the
+// hashes change on every start
+var (
+	hashMu  sync.RWMutex
+	hashKey = newHashKey()
+)
+
+func newHashKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// LoadHashKey keys HashToken with the key in the file at path, 32 random
+// bytes hex encoded. If the file doesn't exist, it is created with a new key
+func LoadHashKey(path string) error {
+	text, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := newHashKey()
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+			return err
+		}
+		text = []byte(hex.EncodeToString(key))
+	} else if err != nil {
+		return err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil || len(key) != 32 {
+		return fmt.Errorf("%s: expected 32 hex encoded bytes", path)
+	}
+	hashMu.Lock()
+	hashKey = key
+	hashMu.Unlock()
+	return nil
+}
+
+// HashToken returns a hex encoded HMAC-SHA256 of token for Access.TokenHash,
+// keyed with the key of the installation. Swipes of a token can be told
+// apart by it, but as tokens are short, anyone knowing the key can find
+// them by trying all of them
+func HashToken(token string) string {
+	hashMu.RLock()
+	mac := hmac.New(sha256.New, hashKey)
+	hashMu.RUnlock()
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Event is pushed to live subscribers, e.g. WebSocket clients
+type Event struct {
+	// ID numbers the events published by a hub, starting at 1
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Door is the door whose status changed, for state events
+	Door   string `json:"door,omitempty"`
+	Status string `json:"status,omitempty"`
+	// Position is OPEN or CLOSED for state and position events of doors
+	// with a door sensor
+	Position string  `json:"position,omitempty"`
+	Access   *Access `json:"access,omitempty"`
+	// Message describes an alert or a countdown
+	Message string `json:"message,omitempty"`
+	// Remaining is the number of seconds until the door is auto-locked, for
+	// countdown events. It is 0 once the countdown ended
+	Remaining int `json:"remaining,omitempty"`
+}
+
+// Concerns reports whether e concerns the door called name. Alerts concern
+// all doors
+func (e Event) Concerns(door string) bool {
+	switch {
+	case e.Access != nil:
+		return e.Access.Door == door
+	case e.Type == TypeState || e.Type == TypePosition || e.Type == TypeCountdown:
+		return e.Door == door
+	}
+	return true
+}
+
+// Anonymized returns e without the user and the token hash of its access
+// attempt, for clients that aren't authenticated
+func (e Event) Anonymized() Event {
+	if e.Access != nil {
+		a := *e.Access
+		a.User, a.TokenHash = "", ""
+		e.Access = &a
+	}
+	return e
+}
+
+// Event types
+const (
+	TypeState  = "state"
+	TypeAccess = "access"
+	TypeAlert  = "alert"
+	// TypePosition reports that a door was opened or closed, as read by its
+	// door sensor
+	TypePosition = "position"
+	// TypeCountdown counts down the seconds until a door is auto-locked.
+	// Countdown events aren't kept for History
+	TypeCountdown = "countdown"
+)
+
+// Hub fans events out to all subscribers. Subscribers that don't keep up
+// miss events rather than blocking the sender. The most recent events are
+// kept for History, and all of them in a database if it persists them
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	lastID uint64
+	// history is a ring buffer; the oldest event is overwritten next
+	history []Event
+	next    int
+	// db is nil unless events are persisted
+	db *DB
+}
+
+// NewHub returns a hub keeping the last historySize events
+func NewHub(historySize int) *Hub {
+	return &Hub{subs: map[chan Event]struct{}{}, history: make([]Event, 0, historySize)}
+}
+
+// Persist stores all events kept for History in db, which History reads
+// from then on. IDs continue after the last event stored, so they stay
+// unique across restarts. It must be called before events are published
+func (h *Hub) Persist(db *DB) error {
+	last, err := db.LastID()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.db = db
+	h.lastID = max(h.lastID, last)
+	return nil
+}
+
+func (h *Hub) Subscribe() chan Event {
+	c := make(chan Event, 16)
+	h.mu.Lock()
+	h.subs[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+func (h *Hub) Unsubscribe(c chan Event) {
+	h.mu.Lock()
+	delete(h.subs, c)
+	h.mu.Unlock()
+}
+
+func (h *Hub) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	e.ID = h.lastID
+	if h.db != nil && e.Type != TypeCountdown {
+		if err := h.db.Add(e); err != nil {
+			slog.Error("Could not store event", "id", e.ID, "err", err)
+		}
+	}
+	switch {
+	case e.Type == TypeCountdown:
+		// Countdowns would push the events worth keeping out of the history
+	case len(h.history) < cap(h.history):
+		h.history = append(h.history, e)
+	case len(h.history) > 0:
+		h.history[h.next] = e
+		h.next = (h.next + 1) % len(h.history)
+	}
+	for c := range h.subs {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// History returns up to limit of the kept events with an ID below before,
+// newest first. If before is 0, it starts at the newest event. If the
+// database can't be read, only the events kept in memory are returned
+func (h *Hub) History(before uint64, limit int) []Event {
+	h.mu.Lock()
+	db := h.db
+	h.mu.Unlock()
+	if db != nil {
+		list, err := db.History(before, limit)
+		if err == nil {
+			return list
+		}
+		slog.Error("Could not read events", "err", err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := []Event{}
+	for i := len(h.history) - 1; i >= 0 && len(list) < limit; i-- {
+		e := h.history[(h.next+i)%len(h.history)]
+		if before == 0 || e.ID < before {
+			list = append(list, e)
+		}
+	}
+	return list
+}
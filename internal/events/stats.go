@@ -0,0 +1,125 @@
+package events
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions counted by Stats
+const (
+	StatsUnlock = "unlock"
+	StatsLock   = "lock"
+)
+
+// StatsRow is the number of unlocks or locks of a door by a user through a
+// source within an hour
+type StatsRow struct {
+	// Date is the local day, like 2027-01-01, and Hour the local hour
+	Date   string `json:"date"`
+	Hour   int    `json:"hour"`
+	Door   string `json:"door,omitempty"`
+	User   string `json:"user,omitempty"`
+	Source string `json:"source"`
+	// Action is StatsUnlock or StatsLock
+	Action string `json:"action"`
+	Count  int    `json:"count"`
+}
+
+type statsKey struct {
+	date                       string
+	hour                       int
+	door, user, source, action string
+}
+
+// Stats counts the granted unlocks and locks by hour, door, user and source
+// for the last days. A nil *Stats counts nothing
+type Stats struct {
+	days int
+
+	mu     sync.Mutex
+	counts map[statsKey]int
+	// oldest is the first day kept
+	oldest string
+}
+
+// NewStats returns statistics kept for days days
+func NewStats(days int) *Stats {
+	return &Stats{days: days, counts: map[statsKey]int{}}
+}
+
+// statsAction returns whether a counts as an unlock or a lock, or "" if it
+// doesn't count
+func statsAction(a Access) string {
+	if a.Result != ResultGranted || a.DryRun {
+		return ""
+	}
+	switch a.Action {
+	case "open", "keep-open":
+		return StatsUnlock
+	case "close":
+		return StatsLock
+	}
+	return ""
+}
+
+// Add counts a, if it is a granted unlock or lock
+func (s *Stats) Add(a Access) {
+	action := statsAction(a)
+	if s == nil || action == "" {
+		return
+	}
+	t := a.Time.Local()
+	key := statsKey{date: t.Format("2006-01-02"), hour: t.Hour(), door: a.Door, user: a.User, source: a.Source, action: action}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldest := time.Now().AddDate(0, 0, -s.days+1).Format("2006-01-02")
+	if key.date < oldest {
+		return
+	}
+	s.counts[key]++
+	// Drop days no longer kept, once a day
+	if oldest != s.oldest {
+		for k := range s.counts {
+			if k.date < oldest {
+				delete(s.counts, k)
+			}
+		}
+		s.oldest = oldest
+	}
+}
+
+// Rows returns the counts for the days from from through to, like
+// 2027-01-01, ordered by time, door, user, source and action
+func (s *Stats) Rows(from, to string) []StatsRow {
+	rows := []StatsRow{}
+	if s == nil {
+		return rows
+	}
+	s.mu.Lock()
+	for k, n := range s.counts {
+		if k.date >= from && k.date <= to {
+			rows = append(rows, StatsRow{Date: k.date, Hour: k.hour, Door: k.door, User: k.user, Source: k.source, Action: k.action, Count: n})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case a.Date != b.Date:
+			return a.Date < b.Date
+		case a.Hour != b.Hour:
+			return a.Hour < b.Hour
+		case a.Door != b.Door:
+			return a.Door < b.Door
+		case a.User != b.User:
+			return a.User < b.User
+		case a.Source != b.Source:
+			return a.Source < b.Source
+		}
+		return a.Action < b.Action
+	})
+	return rows
+}
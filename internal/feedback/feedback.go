@@ -0,0 +1,228 @@
+// Package feedback signals the outcome of a swipe to the person at the door
+package feedback
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+// Outcome is the result of a swipe as signaled
+type Outcome int
+
+const (
+	Granted Outcome = iota
+	Denied
+	Error
+	// Warning is a short signal for the people at the door, e.g. every
+	// second before it is auto-locked. Serial outputs don't show it
+	Warning
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Granted:
+		return "granted"
+	case Denied:
+		return "denied"
+	case Warning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// ParseOutcome returns the outcome named like by String
+func ParseOutcome(s string) (Outcome, error) {
+	for _, o := range []Outcome{Granted, Denied, Error, Warning} {
+		if o.String() == s {
+			return o, nil
+		}
+	}
+	return Error, fmt.Errorf("unknown outcome %q, expected granted, denied, error or warning", s)
+}
+
+// Output signals outcomes, e.g. by beeping
+type Output interface {
+	Open() error
+	// Close stops signaling and drives the outputs inactive
+	Close() error
+	// Signal shows o to the person at the reader labeled reader. It doesn't
+	// block; while a signal is still shown, further ones are dropped
+	Signal(o Outcome, reader string)
+}
+
+// Parse returns the outputs given as a comma separated list of
+//
+//	buzzer:PIN                      a buzzer at the BCM pin
+//	led:GREEN:RED                   a bi-color LED at the BCM pins
+//	serial:GRANTED:DENIED:ERROR     hex encoded commands sent to the reader
+func Parse(spec string) ([]Output, error) {
+	var outputs []Output
+	for _, s := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(s), ":")
+		var (
+			o   Output
+			err error
+		)
+		switch {
+		case parts[0] == "buzzer" && len(parts) == 2:
+			b := &Buzzer{}
+			b.Pin, err = strconv.Atoi(parts[1])
+			o = b
+		case parts[0] == "led" && len(parts) == 3:
+			l := &LED{}
+			if l.Green, err = strconv.Atoi(parts[1]); err == nil {
+				l.Red, err = strconv.Atoi(parts[2])
+			}
+			o = l
+		case parts[0] == "serial" && len(parts) == 4:
+			r := &Serial{}
+			for i := range r.Commands {
+				if r.Commands[i], err = hex.DecodeString(parts[i+1]); err != nil {
+					break
+				}
+			}
+			o = r
+		default:
+			return nil, fmt.Errorf("invalid feedback output %q", s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid feedback output %q: %v", s, err)
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs, nil
+}
+
+// step drives an output for on and then pauses for off
+type step struct {
+	on, off time.Duration
+}
+
+// Buzzer beeps once when access is granted, twice when it is denied, long
+// on errors and briefly for warnings
+type Buzzer struct {
+	Pin int
+
+	player
+}
+
+var buzzerPatterns = map[Outcome][]step{
+	Granted: {{150 * time.Millisecond, 0}},
+	Denied:  {{100 * time.Millisecond, 100 * time.Millisecond}, {100 * time.Millisecond, 0}},
+	Error:   {{time.Second, 0}},
+	Warning: {{50 * time.Millisecond, 0}},
+}
+
+func (b *Buzzer) Signal(o Outcome, reader string) {
+	b.play(func() {
+		for _, s := range buzzerPatterns[o] {
+			setPin(b.Pin, true)
+			time.Sleep(s.on)
+			setPin(b.Pin, false)
+			time.Sleep(s.off)
+		}
+	})
+}
+
+// LED lights green when access is granted, red when it is denied, blinks
+// red on errors and flashes red for warnings
+type LED struct {
+	Green int
+	Red   int
+
+	player
+}
+
+func (l *LED) Signal(o Outcome, reader string) {
+	pin, pattern := l.Red, []step{{2 * time.Second, 0}}
+	switch o {
+	case Granted:
+		pin = l.Green
+	case Warning:
+		pattern = []step{{100 * time.Millisecond, 0}}
+	case Error:
+		pattern = []step{}
+		for i := 0; i < 5; i++ {
+			pattern = append(pattern, step{200 * time.Millisecond, 200 * time.Millisecond})
+		}
+	}
+	l.play(func() {
+		for _, s := range pattern {
+			setPin(pin, true)
+			time.Sleep(s.on)
+			setPin(pin, false)
+			time.Sleep(s.off)
+		}
+	})
+}
+
+// Serial sends a command to serial readers that beep or light up on request.
+// Commands holds the bytes sent for each outcome
+type Serial struct {
+	Commands [3][]byte
+	// Send writes to the reader labeled reader. It is set by the caller, as
+	// the readers are opened elsewhere
+	Send func(reader string, p []byte) error
+}
+
+func (s *Serial) Open() error  { return nil }
+func (s *Serial) Close() error { return nil }
+
+func (s *Serial) Signal(o Outcome, reader string) {
+	if s.Send == nil || int(o) >= len(s.Commands) || len(s.Commands[o]) == 0 {
+		return
+	}
+	if err := s.Send(reader, s.Commands[o]); err != nil {
+		slog.Warn("Could not send feedback", "reader", reader, "err", err)
+	}
+}
+
+// Log only logs outcomes, for simulating
+type Log struct{}
+
+func (Log) Open() error  { return nil }
+func (Log) Close() error { return nil }
+
+func (Log) Signal(o Outcome, reader string) {
+	slog.Info("Feedback", "outcome", o.String(), "reader", reader)
+}
+
+// player shows one signal at a time on a goroutine, so signaling doesn't
+// block the caller
+type player struct {
+	c       chan func()
+	stopped chan struct{}
+}
+
+func (p *player) start() {
+	p.c = make(chan func())
+	p.stopped = make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		for f := range p.c {
+			supervisor.Run("feedback", f)
+		}
+	}()
+}
+
+func (p *player) play(f func()) {
+	select {
+	case p.c <- f:
+	default:
+	}
+}
+
+// stop waits for the current signal to end
+func (p *player) stop() {
+	if p.c != nil {
+		close(p.c)
+		<-p.stopped
+	}
+}
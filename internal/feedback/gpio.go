@@ -0,0 +1,73 @@
+//go:build !nogpio
+// +build !nogpio
+
+package feedback
+
+import "github.com/stianeikeland/go-rpio/v4"
+
+// openPins configures pins as outputs, driven low
+func openPins(pins ...int) error {
+	if err := rpio.Open(); err != nil {
+		return err
+	}
+	for _, pin := range pins {
+		rpio.Pin(pin).Output()
+		rpio.Pin(pin).Low()
+	}
+	return nil
+}
+
+func setPin(pin int, on bool) {
+	if on {
+		rpio.Pin(pin).High()
+	} else {
+		rpio.Pin(pin).Low()
+	}
+}
+
+func (b *Buzzer) Open() error {
+	if err := openPins(b.Pin); err != nil {
+		return err
+	}
+	b.start()
+	return nil
+}
+
+func (b *Buzzer) Close() error {
+	b.stop()
+	setPin(b.Pin, false)
+	return nil
+}
+
+func (l *LED) Open() error {
+	if err := openPins(l.Green, l.Red); err != nil {
+		return err
+	}
+	l.start()
+	return nil
+}
+
+func (l *LED) Close() error {
+	l.stop()
+	setPin(l.Green, false)
+	setPin(l.Red, false)
+	return nil
+}
+
+func (l *StatusLED) Open() error {
+	if err := openPins(l.Pin); err != nil {
+		return err
+	}
+	l.start()
+	return nil
+}
+
+func (l *StatusLED) Close() error {
+	if l.done == nil {
+		// Never opened
+		return nil
+	}
+	l.stop()
+	setPin(l.Pin, false)
+	return nil
+}
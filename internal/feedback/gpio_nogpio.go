@@ -0,0 +1,34 @@
+//go:build nogpio
+// +build nogpio
+
+package feedback
+
+import "errors"
+
+var errNoGPIO = errors.New("buzzers and LEDs need GPIO support, which was not built in")
+
+func setPin(pin int, on bool) {}
+
+func (b *Buzzer) Open() error {
+	return errNoGPIO
+}
+
+func (b *Buzzer) Close() error {
+	return nil
+}
+
+func (l *LED) Open() error {
+	return errNoGPIO
+}
+
+func (l *LED) Close() error {
+	return nil
+}
+
+func (l *StatusLED) Open() error {
+	return errNoGPIO
+}
+
+func (l *StatusLED) Close() error {
+	return nil
+}
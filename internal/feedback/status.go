@@ -0,0 +1,114 @@
+package feedback
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Health is the state of the daemon as shown by a StatusLED
+type Health int
+
+const (
+	HealthOK Health = iota
+	// HealthDegraded means a component like a reader or the credential
+	// store is unhealthy
+	HealthDegraded
+	// HealthFailure means a door reports FAILURE
+	HealthFailure
+)
+
+func (h Health) String() string {
+	switch h {
+	case HealthOK:
+		return "ok"
+	case HealthDegraded:
+		return "degraded"
+	default:
+		return "failure"
+	}
+}
+
+// statusStale is how long after the last Alive a StatusLED goes dark
+const statusStale = 5 * time.Second
+
+// StatusLED shows the health of the daemon at the door box: it is lit while
+// healthy, blinks while degraded and blinks fast on FAILURE. It goes dark if
+// Alive isn't called for a few seconds, e.g. because the main loop hangs
+type StatusLED struct {
+	Pin int
+
+	mu     sync.Mutex
+	health Health
+	alive  time.Time
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Set changes the health shown
+func (l *StatusLED) Set(h Health) {
+	l.mu.Lock()
+	changed := h != l.health
+	l.health = h
+	l.mu.Unlock()
+	if changed {
+		slog.Info("Health changed", "health", h.String())
+	}
+}
+
+// Alive keeps the LED showing the health for a few more seconds
+func (l *StatusLED) Alive(now time.Time) {
+	l.mu.Lock()
+	l.alive = now
+	l.mu.Unlock()
+}
+
+// lit reports whether the LED is on at now
+func (l *StatusLED) lit(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.alive) > statusStale {
+		return false
+	}
+	switch l.health {
+	case HealthOK:
+		return true
+	case HealthDegraded:
+		return now.UnixMilli()/500%2 == 0
+	default:
+		return now.UnixMilli()/100%2 == 0
+	}
+}
+
+// start drives the LED until stop
+func (l *StatusLED) start() {
+	l.Alive(time.Now())
+	l.done = make(chan struct{})
+	l.stopped = make(chan struct{})
+	go func() {
+		defer close(l.stopped)
+		ticker := time.NewTicker(25 * time.Millisecond)
+		defer ticker.Stop()
+		on := false
+		setPin(l.Pin, false)
+		for {
+			select {
+			case <-l.done:
+				return
+			case now := <-ticker.C:
+				if lit := l.lit(now); lit != on {
+					on = lit
+					setPin(l.Pin, on)
+				}
+			}
+		}
+	}()
+}
+
+func (l *StatusLED) stop() {
+	if l.done != nil {
+		close(l.done)
+		<-l.stopped
+	}
+}
@@ -0,0 +1,325 @@
+//go:build linux
+// +build linux
+
+// Package gpiod drives GPIOs through the Linux GPIO character device
+// (/dev/gpiochipN) using the v2 uAPI. Unlike /dev/mem, it works on the
+// Raspberry Pi 5 and without root, given access to the device
+package gpiod
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Layout of the structs and ioctls of linux/gpio.h
+const (
+	maxNameSize = 32
+	linesMax    = 64
+	numAttrsMax = 10
+
+	flagActiveLow = 1 << 1
+	flagInput     = 1 << 2
+	flagOutput    = 1 << 3
+	flagRising    = 1 << 4
+	flagFalling   = 1 << 5
+	flagPullUp    = 1 << 8
+
+	attrOutputValues = 2
+)
+
+type chipInfo struct {
+	name  [maxNameSize]byte
+	label [maxNameSize]byte
+	lines uint32
+}
+
+type lineAttribute struct {
+	id      uint32
+	padding uint32
+	// value holds the flags, the output values or the debounce period,
+	// depending on id
+	value uint64
+}
+
+type lineConfigAttribute struct {
+	attr lineAttribute
+	mask uint64
+}
+
+type lineConfig struct {
+	flags    uint64
+	numAttrs uint32
+	padding  [5]uint32
+	attrs    [numAttrsMax]lineConfigAttribute
+}
+
+type lineRequest struct {
+	offsets         [linesMax]uint32
+	consumer        [maxNameSize]byte
+	config          lineConfig
+	numLines        uint32
+	eventBufferSize uint32
+	padding         [5]uint32
+	fd              int32
+}
+
+type lineEvent struct {
+	timestamp uint64
+	id        uint32
+	offset    uint32
+	seqno     uint32
+	lineSeqno uint32
+	padding   [6]uint32
+}
+
+type lineValues struct {
+	bits uint64
+	mask uint64
+}
+
+// ioctl numbers as built by _IOR and _IOWR
+func ior(nr, size uintptr) uintptr  { return 2<<30 | size<<16 | 0xB4<<8 | nr }
+func iowr(nr, size uintptr) uintptr { return 3<<30 | size<<16 | 0xB4<<8 | nr }
+
+var (
+	getChipInfo   = ior(0x01, unsafe.Sizeof(chipInfo{}))
+	getLine       = iowr(0x07, unsafe.Sizeof(lineRequest{}))
+	getLineValues = iowr(0x0E, unsafe.Sizeof(lineValues{}))
+	setLineValues = iowr(0x0F, unsafe.Sizeof(lineValues{}))
+)
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// cString returns the NUL terminated string in b
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Chip is a GPIO controller
+type Chip struct {
+	f *os.File
+	// Name is the name of the device, like gpiochip0, and Label the name of
+	// the controller, like pinctrl-rp1
+	Name  string
+	Label string
+	// Lines is the number of GPIOs of the chip
+	Lines int
+}
+
+// Open opens the chip called name, like gpiochip0, or at the path name
+func Open(name string) (*Chip, error) {
+	path := name
+	if !strings.ContainsRune(name, '/') {
+		path = "/dev/" + name
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	var info chipInfo
+	if err := ioctl(f.Fd(), getChipInfo, unsafe.Pointer(&info)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Chip{f: f, Name: cString(info.name[:]), Label: cString(info.label[:]), Lines: int(info.lines)}, nil
+}
+
+// headerLabels are the labels of the chips of the pin header of the
+// Raspberry Pis, whose lines are numbered like the BCM pins
+var headerLabels = []string{"pinctrl-rp1", "pinctrl-bcm2712", "pinctrl-bcm2711", "pinctrl-bcm2835"}
+
+// Find returns the name of the chip of the Raspberry Pi's pin header. Its
+// number differs between models and kernels
+func Find() (string, error) {
+	paths, err := filepath.Glob("/dev/gpiochip*")
+	if err != nil {
+		return "", err
+	}
+	found := map[string]string{}
+	for _, path := range paths {
+		c, err := Open(path)
+		if err != nil {
+			continue
+		}
+		found[c.Label] = filepath.Base(path)
+		c.Close()
+	}
+	for _, label := range headerLabels {
+		if name, ok := found[label]; ok {
+			return name, nil
+		}
+	}
+	return "", errors.New("no GPIO chip of a Raspberry Pi pin header found")
+}
+
+func (c *Chip) Close() error {
+	return c.f.Close()
+}
+
+// Line is a requested GPIO. Its values are logical: active low lines read
+// as set while low
+type Line struct {
+	f *os.File
+}
+
+// LineConfig configures a requested line
+type LineConfig struct {
+	Output    bool
+	ActiveLow bool
+	// Value is the initial value of an output
+	Value bool
+}
+
+// RequestLine requests exclusive use of the line at offset, on behalf of
+// consumer, which is shown by tools like gpioinfo
+func (c *Chip) RequestLine(offset int, consumer string, cfg LineConfig) (*Line, error) {
+	if offset < 0 || offset >= c.Lines {
+		return nil, fmt.Errorf("%s has no line %d", c.Name, offset)
+	}
+	var req lineRequest
+	req.offsets[0] = uint32(offset)
+	req.numLines = 1
+	copy(req.consumer[:maxNameSize-1], consumer)
+	req.config.flags = flagInput
+	if cfg.Output {
+		req.config.flags = flagOutput
+		if cfg.Value {
+			req.config.attrs[0] = lineConfigAttribute{attr: lineAttribute{id: attrOutputValues, value: 1}, mask: 1}
+			req.config.numAttrs = 1
+		}
+	}
+	if cfg.ActiveLow {
+		req.config.flags |= flagActiveLow
+	}
+	if err := ioctl(c.f.Fd(), getLine, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("%s line %d: %w", c.Name, offset, err)
+	}
+	return &Line{f: os.NewFile(uintptr(req.fd), fmt.Sprintf("%s line %d", c.Name, offset))}, nil
+}
+
+// Set drives an output line to its active level if active is set, else to
+// its inactive level
+func (l *Line) Set(active bool) error {
+	v := lineValues{mask: 1}
+	if active {
+		v.bits = 1
+	}
+	return ioctl(l.f.Fd(), setLineValues, unsafe.Pointer(&v))
+}
+
+// Get reports whether the line is at its active level
+func (l *Line) Get() (bool, error) {
+	v := lineValues{mask: 1}
+	if err := ioctl(l.f.Fd(), getLineValues, unsafe.Pointer(&v)); err != nil {
+		return false, err
+	}
+	return v.bits&1 != 0, nil
+}
+
+// Close releases the line. Outputs keep their value, depending on the
+// driver
+func (l *Line) Close() error {
+	return l.f.Close()
+}
+
+// Events are input lines reporting their edges, in the order the kernel
+// saw them
+type Events struct {
+	f *os.File
+}
+
+// EventConfig configures requested event lines
+type EventConfig struct {
+	Rising, Falling bool
+	PullUp          bool
+}
+
+// Event is an edge of a line
+type Event struct {
+	Offset int
+	Rising bool
+	// Time is the kernel's monotonic timestamp of the edge
+	Time time.Duration
+	// Seqno numbers the events of all lines. A gap means that the kernel's
+	// buffer overflowed and events were lost
+	Seqno uint32
+}
+
+// RequestEvents requests exclusive use of the input lines at offsets, and
+// edge detection on them
+func (c *Chip) RequestEvents(offsets []int, consumer string, cfg EventConfig) (*Events, error) {
+	if len(offsets) == 0 || len(offsets) > linesMax {
+		return nil, fmt.Errorf("can't request %d lines", len(offsets))
+	}
+	var req lineRequest
+	for i, offset := range offsets {
+		if offset < 0 || offset >= c.Lines {
+			return nil, fmt.Errorf("%s has no line %d", c.Name, offset)
+		}
+		req.offsets[i] = uint32(offset)
+	}
+	req.numLines = uint32(len(offsets))
+	copy(req.consumer[:maxNameSize-1], consumer)
+	req.config.flags = flagInput
+	if cfg.Rising {
+		req.config.flags |= flagRising
+	}
+	if cfg.Falling {
+		req.config.flags |= flagFalling
+	}
+	if cfg.PullUp {
+		req.config.flags |= flagPullUp
+	}
+	if err := ioctl(c.f.Fd(), getLine, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("%s lines %v: %w", c.Name, offsets, err)
+	}
+	// Non-blocking, the file is read through the runtime's poller, which
+	// supports deadlines
+	if err := unix.SetNonblock(int(req.fd), true); err != nil {
+		unix.Close(int(req.fd))
+		return nil, err
+	}
+	return &Events{f: os.NewFile(uintptr(req.fd), fmt.Sprintf("%s lines %v", c.Name, offsets))}, nil
+}
+
+// Read waits for the next event until deadline, returning an error
+// wrapping os.ErrDeadlineExceeded if there was none. The zero deadline
+// waits forever
+func (e *Events) Read(deadline time.Time) (Event, error) {
+	if err := e.f.SetReadDeadline(deadline); err != nil {
+		return Event{}, err
+	}
+	var ev lineEvent
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&ev)), unsafe.Sizeof(ev))
+	if _, err := io.ReadFull(e.f, buf); err != nil {
+		return Event{}, err
+	}
+	return Event{
+		Offset: int(ev.offset),
+		Rising: ev.id == 1,
+		Time:   time.Duration(ev.timestamp),
+		Seqno:  ev.seqno,
+	}, nil
+}
+
+// Close releases the lines
+func (e *Events) Close() error {
+	return e.f.Close()
+}
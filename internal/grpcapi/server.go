@@ -0,0 +1,257 @@
+// Package grpcapi serves the gRPC control API for other services of the
+// space. Clients must present a TLS certificate signed by the configured CA
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/grpcapi/wishbonepb"
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Config configures the gRPC server. All options are required, as the API
+// is only served with mutual TLS
+type Config struct {
+	// Addr is the address to listen on, e.g. :8002
+	Addr        string
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+}
+
+// Validate checks that TLS is configured
+func (c Config) Validate() error {
+	if c.TLSCert == "" || c.TLSKey == "" || c.TLSClientCA == "" {
+		return errors.New("grpc-listen requires grpc-tls-cert, grpc-tls-key and grpc-client-ca")
+	}
+	return nil
+}
+
+// Door is a sphincter controlled by the daemon
+type Door struct {
+	Name     string
+	Actuator *actuator.Watched
+}
+
+// Backend is what the gRPC API controls and reports on
+type Backend struct {
+	// Doors are the sphincters by name. Requests not naming a door are for
+	// the first one
+	Doors  []Door
+	Events *events.Hub
+	// Command runs an open, keep-open or close command for door on behalf
+	// of user and records it, giving up when ctx is done
+	Command func(ctx context.Context, door, source, user, cmd string) error
+}
+
+// Server is the gRPC server of the daemon
+type Server struct {
+	srv *grpc.Server
+	cfg Config
+}
+
+// New returns a server for the API, requiring client certificates signed by
+// cfg.TLSClientCA
+func New(cfg Config, b Backend) (*Server, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(cfg.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + cfg.TLSClientCA)
+	}
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	srv := grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(recoverUnary), grpc.StreamInterceptor(recoverStream))
+	wishbonepb.RegisterSphincterServer(srv, &service{b: b})
+	return &Server{srv: srv, cfg: cfg}, nil
+}
+
+// ListenAndServe serves the API until Shutdown
+func (s *Server) ListenAndServe() error {
+	lis, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.srv.Serve(lis)
+}
+
+// Shutdown waits for running calls to end until ctx is done, then cancels
+// the remaining ones, e.g. event streams
+func (s *Server) Shutdown(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.srv.Stop()
+	}
+}
+
+// recoverUnary fails calls whose handler panicked, counting the crash like
+// those of other workers
+func recoverUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	if supervisor.Run("grpc", func() { resp, err = handler(ctx, req) }) {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return resp, err
+}
+
+func recoverStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	if supervisor.Run("grpc", func() { err = handler(srv, ss) }) {
+		return status.Error(codes.Internal, "internal error")
+	}
+	return err
+}
+
+type service struct {
+	wishbonepb.UnimplementedSphincterServer
+	b Backend
+}
+
+// door returns the door called name, or the first one if name is empty
+func (s *service) door(name string) (Door, error) {
+	if name == "" {
+		return s.b.Doors[0], nil
+	}
+	for _, d := range s.b.Doors {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return Door{}, status.Errorf(codes.NotFound, "unknown door %q", name)
+}
+
+// clientName returns the common name of the client certificate, which
+// identifies the calling service
+func clientName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return ""
+	}
+	return info.State.PeerCertificates[0].Subject.CommonName
+}
+
+func state(d Door) *wishbonepb.State {
+	st := &wishbonepb.State{Door: d.Name, Status: d.Actuator.Status().String()}
+	if t := d.Actuator.LastChange(); !t.IsZero() {
+		st.LastChange = timestamppb.New(t)
+	}
+	return st
+}
+
+// control runs cmd on the door, unless it already is in the target status
+// or reports FAILURE
+func (s *service) control(ctx context.Context, name, cmd string, target actuator.Status) (*wishbonepb.State, error) {
+	d, err := s.door(name)
+	if err != nil {
+		return nil, err
+	}
+	switch d.Actuator.Status() {
+	case actuator.StatusFailure:
+		return nil, status.Error(codes.Unavailable, "sphincter reports FAILURE")
+	case target:
+		return nil, status.Error(codes.FailedPrecondition, "sphincter already "+target.String())
+	}
+	if err := s.b.Command(ctx, d.Name, "grpc", clientName(ctx), cmd); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return state(d), nil
+}
+
+func (s *service) Unlock(ctx context.Context, req *wishbonepb.UnlockRequest) (*wishbonepb.State, error) {
+	cmd := "open"
+	if req.KeepOpen {
+		cmd = "keep-open"
+	}
+	return s.control(ctx, req.Door, cmd, actuator.StatusUnlocked)
+}
+
+func (s *service) Lock(ctx context.Context, req *wishbonepb.LockRequest) (*wishbonepb.State, error) {
+	return s.control(ctx, req.Door, "close", actuator.StatusLocked)
+}
+
+func (s *service) GetState(ctx context.Context, req *wishbonepb.GetStateRequest) (*wishbonepb.State, error) {
+	d, err := s.door(req.Door)
+	if err != nil {
+		return nil, err
+	}
+	return state(d), nil
+}
+
+func (s *service) StreamEvents(req *wishbonepb.StreamEventsRequest, stream wishbonepb.Sphincter_StreamEventsServer) error {
+	d, err := s.door(req.Door)
+	if err != nil {
+		return err
+	}
+	c := s.b.Events.Subscribe()
+	defer s.b.Events.Unsubscribe(c)
+
+	err = stream.Send(toProto(events.Event{Type: events.TypeState, Time: time.Now(), Door: d.Name, Status: d.Actuator.Status().String()}))
+	for err == nil {
+		select {
+		case e := <-c:
+			if e.Concerns(d.Name) {
+				err = stream.Send(toProto(e))
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+	return err
+}
+
+func toProto(e events.Event) *wishbonepb.Event {
+	pe := &wishbonepb.Event{
+		Id:      e.ID,
+		Type:    e.Type,
+		Time:    timestamppb.New(e.Time),
+		Door:    e.Door,
+		Status:  e.Status,
+		Message: e.Message,
+	}
+	if a := e.Access; a != nil {
+		pe.Access = &wishbonepb.Access{
+			Source:    a.Source,
+			Door:      a.Door,
+			Reader:    a.Reader,
+			Action:    a.Action,
+			TokenHash: a.TokenHash,
+			User:      a.User,
+			Result:    a.Result,
+			Reason:    a.Reason,
+			DryRun:    a.DryRun,
+		}
+	}
+	return pe
+}
@@ -0,0 +1,4 @@
+// Package wishbonepb holds the generated code of the gRPC control API
+package wishbonepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative wishbone.proto
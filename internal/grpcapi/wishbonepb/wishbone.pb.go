@@ -0,0 +1,588 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: wishbone.proto
+
+// The control API of wishbone for other services of the space. Clients
+// authenticate with a TLS client certificate; its common name is recorded as
+// the user of their commands.
+
+package wishbonepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Requests name a door; the first one is used if door is empty.
+type UnlockRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Door  string                 `protobuf:"bytes,1,opt,name=door,proto3" json:"door,omitempty"`
+	// keep_open unlocks without starting the auto-lock timer.
+	KeepOpen      bool `protobuf:"varint,2,opt,name=keep_open,json=keepOpen,proto3" json:"keep_open,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnlockRequest) Reset() {
+	*x = UnlockRequest{}
+	mi := &file_wishbone_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnlockRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnlockRequest) ProtoMessage() {}
+
+func (x *UnlockRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wishbone_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnlockRequest.ProtoReflect.Descriptor instead.
+func (*UnlockRequest) Descriptor() ([]byte, []int) {
+	return file_wishbone_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *UnlockRequest) GetDoor() string {
+	if x != nil {
+		return x.Door
+	}
+	return ""
+}
+
+func (x *UnlockRequest) GetKeepOpen() bool {
+	if x != nil {
+		return x.KeepOpen
+	}
+	return false
+}
+
+type LockRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Door          string                 `protobuf:"bytes,1,opt,name=door,proto3" json:"door,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LockRequest) Reset() {
+	*x = LockRequest{}
+	mi := &file_wishbone_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LockRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LockRequest) ProtoMessage() {}
+
+func (x *LockRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wishbone_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LockRequest.ProtoReflect.Descriptor instead.
+func (*LockRequest) Descriptor() ([]byte, []int) {
+	return file_wishbone_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LockRequest) GetDoor() string {
+	if x != nil {
+		return x.Door
+	}
+	return ""
+}
+
+type GetStateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Door          string                 `protobuf:"bytes,1,opt,name=door,proto3" json:"door,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStateRequest) Reset() {
+	*x = GetStateRequest{}
+	mi := &file_wishbone_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStateRequest) ProtoMessage() {}
+
+func (x *GetStateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wishbone_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStateRequest.ProtoReflect.Descriptor instead.
+func (*GetStateRequest) Descriptor() ([]byte, []int) {
+	return file_wishbone_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetStateRequest) GetDoor() string {
+	if x != nil {
+		return x.Door
+	}
+	return ""
+}
+
+type StreamEventsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Door          string                 `protobuf:"bytes,1,opt,name=door,proto3" json:"door,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_wishbone_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wishbone_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_wishbone_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StreamEventsRequest) GetDoor() string {
+	if x != nil {
+		return x.Door
+	}
+	return ""
+}
+
+type State struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Door  string                 `protobuf:"bytes,1,opt,name=door,proto3" json:"door,omitempty"`
+	// status is UNKNOWN, LOCKED, UNLOCKED or FAILURE.
+	Status string `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	// last_change is unset if the status didn't change since startup.
+	LastChange    *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=last_change,json=lastChange,proto3" json:"last_change,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *State) Reset() {
+	*x = State{}
+	mi := &file_wishbone_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *State) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*State) ProtoMessage() {}
+
+func (x *State) ProtoReflect() protoreflect.Message {
+	mi := &file_wishbone_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use State.ProtoReflect.Descriptor instead.
+func (*State) Descriptor() ([]byte, []int) {
+	return file_wishbone_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *State) GetDoor() string {
+	if x != nil {
+		return x.Door
+	}
+	return ""
+}
+
+func (x *State) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *State) GetLastChange() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastChange
+	}
+	return nil
+}
+
+// Event mirrors the events served on /sphincter/ws.
+type Event struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// type is state, access or alert.
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	Door          string                 `protobuf:"bytes,4,opt,name=door,proto3" json:"door,omitempty"`
+	Status        string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	Access        *Access                `protobuf:"bytes,6,opt,name=access,proto3" json:"access,omitempty"`
+	Message       string                 `protobuf:"bytes,7,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_wishbone_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_wishbone_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_wishbone_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Event) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetDoor() string {
+	if x != nil {
+		return x.Door
+	}
+	return ""
+}
+
+func (x *Event) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Event) GetAccess() *Access {
+	if x != nil {
+		return x.Access
+	}
+	return nil
+}
+
+func (x *Event) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type Access struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Source    string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Door      string                 `protobuf:"bytes,2,opt,name=door,proto3" json:"door,omitempty"`
+	Reader    string                 `protobuf:"bytes,3,opt,name=reader,proto3" json:"reader,omitempty"`
+	Action    string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
+	TokenHash string                 `protobuf:"bytes,5,opt,name=token_hash,json=tokenHash,proto3" json:"token_hash,omitempty"`
+	User      string                 `protobuf:"bytes,6,opt,name=user,proto3" json:"user,omitempty"`
+	// result is granted, denied, unknown_token or failure.
+	Result        string `protobuf:"bytes,7,opt,name=result,proto3" json:"result,omitempty"`
+	Reason        string `protobuf:"bytes,8,opt,name=reason,proto3" json:"reason,omitempty"`
+	DryRun        bool   `protobuf:"varint,9,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Access) Reset() {
+	*x = Access{}
+	mi := &file_wishbone_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Access) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Access) ProtoMessage() {}
+
+func (x *Access) ProtoReflect() protoreflect.Message {
+	mi := &file_wishbone_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Access.ProtoReflect.Descriptor instead.
+func (*Access) Descriptor() ([]byte, []int) {
+	return file_wishbone_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Access) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Access) GetDoor() string {
+	if x != nil {
+		return x.Door
+	}
+	return ""
+}
+
+func (x *Access) GetReader() string {
+	if x != nil {
+		return x.Reader
+	}
+	return ""
+}
+
+func (x *Access) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Access) GetTokenHash() string {
+	if x != nil {
+		return x.TokenHash
+	}
+	return ""
+}
+
+func (x *Access) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *Access) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *Access) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Access) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+var File_wishbone_proto protoreflect.FileDescriptor
+
+const file_wishbone_proto_rawDesc = "" +
+	"\n" +
+	"\x0ewishbone.proto\x12\vwishbone.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"@\n" +
+	"\rUnlockRequest\x12\x12\n" +
+	"\x04door\x18\x01 \x01(\tR\x04door\x12\x1b\n" +
+	"\tkeep_open\x18\x02 \x01(\bR\bkeepOpen\"!\n" +
+	"\vLockRequest\x12\x12\n" +
+	"\x04door\x18\x01 \x01(\tR\x04door\"%\n" +
+	"\x0fGetStateRequest\x12\x12\n" +
+	"\x04door\x18\x01 \x01(\tR\x04door\")\n" +
+	"\x13StreamEventsRequest\x12\x12\n" +
+	"\x04door\x18\x01 \x01(\tR\x04door\"p\n" +
+	"\x05State\x12\x12\n" +
+	"\x04door\x18\x01 \x01(\tR\x04door\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12;\n" +
+	"\vlast_change\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"lastChange\"\xce\x01\n" +
+	"\x05Event\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12.\n" +
+	"\x04time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x12\n" +
+	"\x04door\x18\x04 \x01(\tR\x04door\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12+\n" +
+	"\x06access\x18\x06 \x01(\v2\x13.wishbone.v1.AccessR\x06access\x12\x18\n" +
+	"\amessage\x18\a \x01(\tR\amessage\"\xe0\x01\n" +
+	"\x06Access\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x12\n" +
+	"\x04door\x18\x02 \x01(\tR\x04door\x12\x16\n" +
+	"\x06reader\x18\x03 \x01(\tR\x06reader\x12\x16\n" +
+	"\x06action\x18\x04 \x01(\tR\x06action\x12\x1d\n" +
+	"\n" +
+	"token_hash\x18\x05 \x01(\tR\ttokenHash\x12\x12\n" +
+	"\x04user\x18\x06 \x01(\tR\x04user\x12\x16\n" +
+	"\x06result\x18\a \x01(\tR\x06result\x12\x16\n" +
+	"\x06reason\x18\b \x01(\tR\x06reason\x12\x17\n" +
+	"\adry_run\x18\t \x01(\bR\x06dryRun2\x81\x02\n" +
+	"\tSphincter\x128\n" +
+	"\x06Unlock\x12\x1a.wishbone.v1.UnlockRequest\x1a\x12.wishbone.v1.State\x124\n" +
+	"\x04Lock\x12\x18.wishbone.v1.LockRequest\x1a\x12.wishbone.v1.State\x12<\n" +
+	"\bGetState\x12\x1c.wishbone.v1.GetStateRequest\x1a\x12.wishbone.v1.State\x12F\n" +
+	"\fStreamEvents\x12 .wishbone.v1.StreamEventsRequest\x1a\x12.wishbone.v1.Event0\x01B;Z9github.com/craftamap/wishbone/internal/grpcapi/wishbonepbb\x06proto3"
+
+var (
+	file_wishbone_proto_rawDescOnce sync.Once
+	file_wishbone_proto_rawDescData []byte
+)
+
+func file_wishbone_proto_rawDescGZIP() []byte {
+	file_wishbone_proto_rawDescOnce.Do(func() {
+		file_wishbone_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_wishbone_proto_rawDesc), len(file_wishbone_proto_rawDesc)))
+	})
+	return file_wishbone_proto_rawDescData
+}
+
+var file_wishbone_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_wishbone_proto_goTypes = []any{
+	(*UnlockRequest)(nil),         // 0: wishbone.v1.UnlockRequest
+	(*LockRequest)(nil),           // 1: wishbone.v1.LockRequest
+	(*GetStateRequest)(nil),       // 2: wishbone.v1.GetStateRequest
+	(*StreamEventsRequest)(nil),   // 3: wishbone.v1.StreamEventsRequest
+	(*State)(nil),                 // 4: wishbone.v1.State
+	(*Event)(nil),                 // 5: wishbone.v1.Event
+	(*Access)(nil),                // 6: wishbone.v1.Access
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_wishbone_proto_depIdxs = []int32{
+	7, // 0: wishbone.v1.State.last_change:type_name -> google.protobuf.Timestamp
+	7, // 1: wishbone.v1.Event.time:type_name -> google.protobuf.Timestamp
+	6, // 2: wishbone.v1.Event.access:type_name -> wishbone.v1.Access
+	0, // 3: wishbone.v1.Sphincter.Unlock:input_type -> wishbone.v1.UnlockRequest
+	1, // 4: wishbone.v1.Sphincter.Lock:input_type -> wishbone.v1.LockRequest
+	2, // 5: wishbone.v1.Sphincter.GetState:input_type -> wishbone.v1.GetStateRequest
+	3, // 6: wishbone.v1.Sphincter.StreamEvents:input_type -> wishbone.v1.StreamEventsRequest
+	4, // 7: wishbone.v1.Sphincter.Unlock:output_type -> wishbone.v1.State
+	4, // 8: wishbone.v1.Sphincter.Lock:output_type -> wishbone.v1.State
+	4, // 9: wishbone.v1.Sphincter.GetState:output_type -> wishbone.v1.State
+	5, // 10: wishbone.v1.Sphincter.StreamEvents:output_type -> wishbone.v1.Event
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_wishbone_proto_init() }
+func file_wishbone_proto_init() {
+	if File_wishbone_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_wishbone_proto_rawDesc), len(file_wishbone_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_wishbone_proto_goTypes,
+		DependencyIndexes: file_wishbone_proto_depIdxs,
+		MessageInfos:      file_wishbone_proto_msgTypes,
+	}.Build()
+	File_wishbone_proto = out.File
+	file_wishbone_proto_goTypes = nil
+	file_wishbone_proto_depIdxs = nil
+}
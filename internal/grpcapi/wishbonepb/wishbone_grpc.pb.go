@@ -0,0 +1,253 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: wishbone.proto
+
+// The control API of wishbone for other services of the space. Clients
+// authenticate with a TLS client certificate; its common name is recorded as
+// the user of their commands.
+
+package wishbonepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Sphincter_Unlock_FullMethodName       = "/wishbone.v1.Sphincter/Unlock"
+	Sphincter_Lock_FullMethodName         = "/wishbone.v1.Sphincter/Lock"
+	Sphincter_GetState_FullMethodName     = "/wishbone.v1.Sphincter/GetState"
+	Sphincter_StreamEvents_FullMethodName = "/wishbone.v1.Sphincter/StreamEvents"
+)
+
+// SphincterClient is the client API for Sphincter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SphincterClient interface {
+	// Unlock opens a door. Fails with FAILED_PRECONDITION if it already is
+	// unlocked and UNAVAILABLE if it reports FAILURE.
+	Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*State, error)
+	// Lock closes a door, failing like Unlock.
+	Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*State, error)
+	GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*State, error)
+	// StreamEvents sends the state of a door and then its status changes and
+	// access attempts until the client cancels.
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type sphincterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSphincterClient(cc grpc.ClientConnInterface) SphincterClient {
+	return &sphincterClient{cc}
+}
+
+func (c *sphincterClient) Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*State, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(State)
+	err := c.cc.Invoke(ctx, Sphincter_Unlock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sphincterClient) Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*State, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(State)
+	err := c.cc.Invoke(ctx, Sphincter_Lock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sphincterClient) GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*State, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(State)
+	err := c.cc.Invoke(ctx, Sphincter_GetState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sphincterClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Sphincter_ServiceDesc.Streams[0], Sphincter_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sphincter_StreamEventsClient = grpc.ServerStreamingClient[Event]
+
+// SphincterServer is the server API for Sphincter service.
+// All implementations must embed UnimplementedSphincterServer
+// for forward compatibility.
+type SphincterServer interface {
+	// Unlock opens a door. Fails with FAILED_PRECONDITION if it already is
+	// unlocked and UNAVAILABLE if it reports FAILURE.
+	Unlock(context.Context, *UnlockRequest) (*State, error)
+	// Lock closes a door, failing like Unlock.
+	Lock(context.Context, *LockRequest) (*State, error)
+	GetState(context.Context, *GetStateRequest) (*State, error)
+	// StreamEvents sends the state of a door and then its status changes and
+	// access attempts until the client cancels.
+	StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedSphincterServer()
+}
+
+// UnimplementedSphincterServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSphincterServer struct{}
+
+func (UnimplementedSphincterServer) Unlock(context.Context, *UnlockRequest) (*State, error) {
+	return nil, status.Error(codes.Unimplemented, "method Unlock not implemented")
+}
+func (UnimplementedSphincterServer) Lock(context.Context, *LockRequest) (*State, error) {
+	return nil, status.Error(codes.Unimplemented, "method Lock not implemented")
+}
+func (UnimplementedSphincterServer) GetState(context.Context, *GetStateRequest) (*State, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetState not implemented")
+}
+func (UnimplementedSphincterServer) StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Error(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedSphincterServer) mustEmbedUnimplementedSphincterServer() {}
+func (UnimplementedSphincterServer) testEmbeddedByValue()                   {}
+
+// UnsafeSphincterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SphincterServer will
+// result in compilation errors.
+type UnsafeSphincterServer interface {
+	mustEmbedUnimplementedSphincterServer()
+}
+
+func RegisterSphincterServer(s grpc.ServiceRegistrar, srv SphincterServer) {
+	// If the following call panics, it indicates UnimplementedSphincterServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Sphincter_ServiceDesc, srv)
+}
+
+func _Sphincter_Unlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SphincterServer).Unlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sphincter_Unlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SphincterServer).Unlock(ctx, req.(*UnlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sphincter_Lock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SphincterServer).Lock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sphincter_Lock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SphincterServer).Lock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sphincter_GetState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SphincterServer).GetState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sphincter_GetState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SphincterServer).GetState(ctx, req.(*GetStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sphincter_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SphincterServer).StreamEvents(m, &grpc.GenericServerStream[StreamEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sphincter_StreamEventsServer = grpc.ServerStreamingServer[Event]
+
+// Sphincter_ServiceDesc is the grpc.ServiceDesc for Sphincter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Sphincter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "wishbone.v1.Sphincter",
+	HandlerType: (*SphincterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Unlock",
+			Handler:    _Sphincter_Unlock_Handler,
+		},
+		{
+			MethodName: "Lock",
+			Handler:    _Sphincter_Lock_Handler,
+		},
+		{
+			MethodName: "GetState",
+			Handler:    _Sphincter_GetState_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _Sphincter_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wishbone.proto",
+}
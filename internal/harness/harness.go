@@ -0,0 +1,396 @@
+// Package harness runs the access pipeline in-process, so regressions in
+// deciding on swipes and requests are caught in CI rather than at the door.
+// A Daemon wires internal/access, a serial reader on a fake port and an
+// actuator on fake pins to the HTTP API like wishbone does, scripts swipes
+// and HTTP requests, and records the relay pulses and events it sees
+package harness
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/access"
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/httpapi"
+	"github.com/craftamap/wishbone/internal/latency"
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// doorName is the name of the door of a Daemon
+const doorName = "main"
+
+// openPin and closePin are the BCM numbers of the fake relays
+const (
+	openPin  = 22
+	closePin = 27
+)
+
+// commandTimeout is how long driving the door may take, like the default
+// of -command-timeout
+const commandTimeout = 30 * time.Second
+
+// Config configures a daemon started by Start
+type Config struct {
+	// List is written to list.txt, the credential store of the daemon
+	List string
+	// Args are flags of wishbone the harness understands, with the same
+	// defaults: -pulse, -auto-lock, -debounce and -double-swipe
+	Args []string
+}
+
+// options are the flags of Config.Args
+type options struct {
+	pulse, autoLock, debounce, doubleSwipe time.Duration
+}
+
+func parseArgs(args []string) (options, error) {
+	var o options
+	fs := flag.NewFlagSet("harness", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.DurationVar(&o.pulse, "pulse", time.Second, "")
+	fs.DurationVar(&o.autoLock, "auto-lock", 30*time.Second, "")
+	fs.DurationVar(&o.debounce, "debounce", 5*time.Second, "")
+	fs.DurationVar(&o.doubleSwipe, "double-swipe", 0, "")
+	if err := fs.Parse(args); err != nil {
+		return o, err
+	}
+	if fs.NArg() > 0 {
+		return o, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	return o, nil
+}
+
+// Pulse is a relay, open or close, energized by the daemon
+type Pulse struct {
+	Relay string
+	Time  time.Time
+}
+
+// Daemon is the access pipeline of wishbone running in-process for one
+// door, reading tokens from Reader and serving the HTTP API to Request. Its
+// credentials are kept in a temporary directory, removed by Stop
+type Daemon struct {
+	Reader *Port
+
+	dir      string
+	door     *door
+	pipeline *access.Pipeline
+	serial   *reader.Serial
+	handler  http.Handler
+	hub      *events.Hub
+	swipes   chan reader.Swipe
+	done     chan struct{}
+	// stopped is done once the main loop returned
+	stopped sync.WaitGroup
+
+	mu     sync.Mutex
+	levels []actuator.Level
+	pulses []Pulse
+	events []events.Event
+	// changed is closed and replaced whenever something is recorded
+	changed chan struct{}
+	// seenPulses and seenEvents count those returned by NextPulse and
+	// NextEvent
+	seenPulses, seenEvents int
+}
+
+// door drives the fake actuator for the access pipeline and the API
+type door struct {
+	*actuator.Watched
+	autoLock *actuator.AutoLocker
+}
+
+func (d *door) Name() string {
+	return doorName
+}
+
+// Unlock opens the door, arming the auto-lock unless action is keep-open
+func (d *door) Unlock(ctx context.Context, action string) error {
+	if action == "keep-open" {
+		d.autoLock.Disarm()
+		return d.Open(ctx)
+	}
+	if err := d.Open(ctx); err != nil {
+		return err
+	}
+	d.autoLock.Arm()
+	return nil
+}
+
+// Lock closes the door and disarms the auto-lock
+func (d *door) Lock(ctx context.Context) error {
+	d.autoLock.Disarm()
+	return d.Close(ctx)
+}
+
+// Start starts the daemon configured by cfg
+func Start(cfg Config) (*Daemon, error) {
+	opts, err := parseArgs(cfg.Args)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "wishbone-harness")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(path, []byte(cfg.List), 0o600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	list, err := store.OpenList(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	d := &Daemon{
+		Reader:  NewPort(),
+		dir:     dir,
+		hub:     events.NewHub(0),
+		swipes:  make(chan reader.Swipe),
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	timing := actuator.Timing{OpenPulse: opts.pulse, ClosePulse: opts.pulse}
+	gpio := actuator.NewFake(actuator.GPIOConfig{Open: actuator.Pin{Number: openPin}, Close: actuator.Pin{Number: closePin}, Timing: timing}, d.recordLevel)
+	d.door = &door{Watched: &actuator.Watched{DoorActuator: gpio}}
+	d.door.OnChange(func(status actuator.Status) {
+		d.publish(events.Event{Type: events.TypeState, Door: doorName, Status: status.String()})
+	})
+	if opts.autoLock > 0 {
+		d.door.autoLock = actuator.NewAutoLocker(d.door, opts.autoLock, 0, func(err error) {
+			event := events.Access{Source: "auto-lock", Door: doorName, Action: "close", Result: events.ResultGranted}
+			if err != nil {
+				event.Result = events.ResultFailure
+				event.Reason = err.Error()
+			}
+			d.recordAccess(event)
+		})
+	}
+
+	permissions := store.Permissions{}
+	d.pipeline = &access.Pipeline{
+		Store:          list,
+		StoreKind:      "list",
+		LookupTimeout:  5 * time.Second,
+		Debounce:       opts.debounce,
+		DoubleSwipe:    opts.doubleSwipe,
+		CommandTimeout: commandTimeout,
+		Go:             func(f func()) { go f() },
+		Permit:         permissions.Check,
+		Record:         d.recordAccess,
+		RecordLatency:  func(*latency.Trace) {},
+	}
+	d.handler, err = httpapi.NewHandler(httpapi.Config{}, httpapi.Backend{
+		Doors:         []httpapi.Door{{Name: doorName, Actuator: d.door.Watched}},
+		Store:         list,
+		Events:        d.hub,
+		LookupTimeout: 5 * time.Second,
+		Swipes:        d.swipes,
+		Command:       d.command,
+		Record:        d.recordAccess,
+		Permissions:   func() store.Permissions { return permissions },
+		FailPolicy:    func() store.FailPolicy { return store.FailPolicy{} },
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	d.serial = &reader.Serial{Device: "harness", OpenPort: d.Reader.open}
+	if err := d.serial.Open(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	d.stopped.Add(1)
+	go d.run()
+	d.serial.Run(d.swipes, make(chan error, 1), d.done)
+	return d, nil
+}
+
+// run is the main loop, handing the swipes to the pipeline
+func (d *Daemon) run() {
+	defer d.stopped.Done()
+	for {
+		select {
+		case sw := <-d.swipes:
+			d.pipeline.Swipe(d.door, sw)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// command runs an open, keep-open or close command of the API and records
+// it
+func (d *Daemon) command(ctx context.Context, name, source, user, cmd string) error {
+	event := events.Access{Source: source, Door: doorName, User: user, Action: cmd, Result: events.ResultGranted}
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	var err error
+	switch cmd {
+	case "open", "keep-open":
+		err = d.door.Unlock(ctx, cmd)
+	case "close":
+		err = d.door.Lock(ctx)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		event.Result = events.ResultFailure
+		event.Reason = err.Error()
+	}
+	d.recordAccess(event)
+	return err
+}
+
+// Stop stops the daemon, releases the fake pins and removes its directory
+func (d *Daemon) Stop() error {
+	close(d.done)
+	d.serial.Close()
+	d.Reader.Close()
+	d.stopped.Wait()
+	d.door.autoLock.Disarm()
+	err := d.door.Release()
+	os.RemoveAll(d.dir)
+	return err
+}
+
+// record adds to what the daemon did and wakes up those waiting for it
+func (d *Daemon) record(f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f()
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// recordLevel records a level driven on a relay. A relay driven active
+// starts a pulse
+func (d *Daemon) recordLevel(l actuator.Level) {
+	d.record(func() {
+		d.levels = append(d.levels, l)
+		if l.Active {
+			d.pulses = append(d.pulses, Pulse{Relay: l.Relay, Time: l.Time})
+		}
+	})
+}
+
+// recordAccess records an access attempt and publishes it like wishbone
+func (d *Daemon) recordAccess(e events.Access) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	d.publish(events.Event{Type: events.TypeAccess, Time: e.Time, Access: &e})
+}
+
+// publish records e and publishes it to the API's event streams
+func (d *Daemon) publish(e events.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	d.hub.Publish(e)
+	d.record(func() { d.events = append(d.events, e) })
+}
+
+// Levels returns the levels driven on the relays so far, in order
+func (d *Daemon) Levels() []actuator.Level {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]actuator.Level(nil), d.levels...)
+}
+
+// Swipe sends token from the reader, framed by STX and ETX
+func (d *Daemon) Swipe(token string) error {
+	_, err := d.Reader.Write([]byte("\x02" + token + "\x03"))
+	return err
+}
+
+// Request serves a request with body, which is sent as JSON if it starts
+// with { and as form otherwise, and returns the status and body of the
+// reply
+func (d *Daemon) Request(method, path, body string) (int, string) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if strings.HasPrefix(body, "{") {
+		req.Header.Set("Content-Type", "application/json")
+	} else if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	d.handler.ServeHTTP(rec, req)
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+// errTimeout is returned when waiting for a pulse or event in vain
+var errTimeout = errors.New("timed out")
+
+// wait calls f until it reports true, whenever something was recorded, for
+// at most timeout
+func (d *Daemon) wait(timeout time.Duration, f func() bool) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		d.mu.Lock()
+		done, changed := f(), d.changed
+		d.mu.Unlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return errTimeout
+		}
+	}
+}
+
+// NextPulse returns the pulse after the one returned last, waiting for it
+// for at most timeout
+func (d *Daemon) NextPulse(timeout time.Duration) (Pulse, error) {
+	var p Pulse
+	err := d.wait(timeout, func() bool {
+		if d.seenPulses == len(d.pulses) {
+			return false
+		}
+		p = d.pulses[d.seenPulses]
+		d.seenPulses++
+		return true
+	})
+	if err != nil {
+		return p, fmt.Errorf("no pulse within %v", timeout)
+	}
+	return p, nil
+}
+
+// NextEvent returns the first event matching match after the one returned
+// last, waiting for it for at most timeout. Events before it are skipped
+func (d *Daemon) NextEvent(match func(events.Event) bool, timeout time.Duration) (events.Event, error) {
+	var e events.Event
+	err := d.wait(timeout, func() bool {
+		for d.seenEvents < len(d.events) {
+			e = d.events[d.seenEvents]
+			d.seenEvents++
+			if match(e) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return e, fmt.Errorf("no such event within %v", timeout)
+	}
+	return e, nil
+}
@@ -0,0 +1,65 @@
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestScripts runs the scripts in e2e
+func TestScripts(t *testing.T) {
+	paths, err := filepath.Glob("../../e2e/*.txt")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no scripts found: %v", err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			t.Parallel()
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			script, err := ParseScript(path, f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := script.Run(5 * time.Second); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestRelayLevels checks the levels the fake pins are driven to for an
+// unlock
+func TestRelayLevels(t *testing.T) {
+	d, err := Start(Config{List: "AAAA1111 Ann\n", Args: []string{"-pulse", "200ms", "-auto-lock", "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	if err := d.Swipe("AAAA1111"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := d.NextPulse(5 * time.Second); err != nil || p.Relay != "open" {
+		t.Fatalf("pulse %+v, %v, want open", p, err)
+	}
+	err = d.wait(5*time.Second, func() bool { return len(d.levels) >= 2 })
+	levels := d.Levels()
+	if err != nil {
+		t.Fatalf("relay not released, levels %+v", levels)
+	}
+	on, off := levels[0], levels[1]
+	if on.Relay != "open" || !on.Active || off.Relay != "open" || off.Active {
+		t.Fatalf("levels %+v, want the open relay driven active and inactive", levels)
+	}
+	if pulse := off.Time.Sub(on.Time); pulse < 200*time.Millisecond || pulse > time.Second {
+		t.Fatalf("open relay active for %v, want 200ms", pulse)
+	}
+	if err := d.wait(time.Second, func() bool { return len(d.levels) > 2 }); err == nil {
+		t.Fatalf("more levels than one pulse: %+v", d.Levels())
+	}
+}
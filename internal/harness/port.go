@@ -0,0 +1,65 @@
+package harness
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Port is a fake serial port in memory. The reader of a Daemon opens it
+// like the device of a serial reader, reading what is written to the port
+type Port struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+
+	mu   sync.Mutex
+	sent bytes.Buffer
+}
+
+// NewPort returns a fake serial port
+func NewPort() *Port {
+	r, w := io.Pipe()
+	return &Port{r: r, w: w}
+}
+
+// Write sends b as if received by the reader. It blocks until the reader
+// read it
+func (p *Port) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+// Sent returns what the daemon sent to the reader, e.g. feedback commands
+func (p *Port) Sent() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return bytes.Clone(p.sent.Bytes())
+}
+
+// Close ends what the reader reads
+func (p *Port) Close() error {
+	return p.w.Close()
+}
+
+// open returns the end of p opened by the reader
+func (p *Port) open() (io.ReadWriteCloser, error) {
+	return portDevice{p}, nil
+}
+
+// portDevice is the end of a Port the reader reads from and writes to
+type portDevice struct {
+	p *Port
+}
+
+func (d portDevice) Read(b []byte) (int, error) {
+	return d.p.r.Read(b)
+}
+
+func (d portDevice) Write(b []byte) (int, error) {
+	d.p.mu.Lock()
+	defer d.p.mu.Unlock()
+	return d.p.sent.Write(b)
+}
+
+func (d portDevice) Close() error {
+	return d.p.r.Close()
+}
@@ -0,0 +1,198 @@
+package harness
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+// Script is a scripted run of the daemon. Scripts are text, one statement
+// per line, # starting comments:
+//
+//	list TOKEN NAME; groups GROUPS   a line of list.txt
+//	flag -auto-lock 2s               flags of the daemon, see Config.Args
+//	swipe TOKEN                      swipe TOKEN at the reader
+//	request METHOD PATH STATUS [BODY]
+//	                                 send a request, expecting STATUS
+//	expect pulse open|close          the next relay pulsed is the one given
+//	expect no-pulse DURATION         no relay is pulsed for DURATION
+//	expect event TYPE [KEY=VALUE...] an event of TYPE follows, with access
+//	                                 fields like result=granted or user=Ann
+//	sleep DURATION                   wait
+//
+// The daemon is started with the list and flags before the first other
+// statement
+type Script struct {
+	Name  string
+	List  []string
+	Args  []string
+	steps []step
+}
+
+type step struct {
+	line   int
+	fields []string
+	// rest is the text after the first fields, for request bodies
+	rest string
+}
+
+// ParseScript reads the script called name from r
+func ParseScript(name string, r io.Reader) (*Script, error) {
+	s := &Script{Name: name}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		keyword, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		switch keyword {
+		case "list":
+			if len(s.steps) > 0 {
+				return nil, fmt.Errorf("%s:%d: list after the daemon started", name, n)
+			}
+			s.List = append(s.List, rest)
+			continue
+		case "flag":
+			if len(s.steps) > 0 {
+				return nil, fmt.Errorf("%s:%d: flag after the daemon started", name, n)
+			}
+			s.Args = append(s.Args, fields[1:]...)
+			continue
+		}
+		st := step{line: n, fields: fields}
+		if err := st.check(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		if keyword == "request" {
+			st.rest = afterFields(line, 4)
+		}
+		s.steps = append(s.steps, st)
+	}
+	return s, scanner.Err()
+}
+
+// afterFields returns line without its first n fields, e.g. a request body
+// with its spaces
+func afterFields(line string, n int) string {
+	for i := 0; i < n; i++ {
+		_, line, _ = strings.Cut(strings.TrimSpace(line), " ")
+	}
+	return strings.TrimSpace(line)
+}
+
+// check checks the arguments of the step
+func (st step) check() error {
+	f := st.fields
+	switch {
+	case f[0] == "swipe" && len(f) == 2:
+	case f[0] == "request" && len(f) >= 4:
+		if _, err := strconv.Atoi(f[3]); err != nil {
+			return fmt.Errorf("invalid status %q", f[3])
+		}
+	case f[0] == "expect" && len(f) == 3 && f[1] == "pulse":
+		if f[2] != "open" && f[2] != "close" {
+			return fmt.Errorf("unknown relay %q", f[2])
+		}
+	case f[0] == "expect" && len(f) == 3 && f[1] == "no-pulse", f[0] == "sleep" && len(f) == 2:
+		if _, err := time.ParseDuration(f[len(f)-1]); err != nil {
+			return err
+		}
+	case f[0] == "expect" && len(f) >= 3 && f[1] == "event":
+		for _, kv := range f[3:] {
+			if !strings.Contains(kv, "=") {
+				return fmt.Errorf("invalid field %q, expected KEY=VALUE", kv)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid statement %q", strings.Join(f, " "))
+	}
+	return nil
+}
+
+// Run starts the daemon and runs the script against it, waiting at most
+// timeout for each expectation. The error tells the statement that failed
+func (s *Script) Run(timeout time.Duration) error {
+	d, err := Start(Config{List: strings.Join(s.List, "\n") + "\n", Args: s.Args})
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.Name, err)
+	}
+	defer d.Stop()
+	for _, st := range s.steps {
+		if err := st.run(d, timeout); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", s.Name, st.line, strings.Join(st.fields, " "), err)
+		}
+	}
+	return nil
+}
+
+func (st step) run(d *Daemon, timeout time.Duration) error {
+	f := st.fields
+	switch f[0] {
+	case "swipe":
+		return d.Swipe(f[1])
+	case "sleep":
+		wait, _ := time.ParseDuration(f[1])
+		time.Sleep(wait)
+		return nil
+	case "request":
+		status, body := d.Request(f[1], f[2], st.rest)
+		if want, _ := strconv.Atoi(f[3]); status != want {
+			return fmt.Errorf("got %d: %s", status, body)
+		}
+		return nil
+	}
+
+	switch f[1] {
+	case "pulse":
+		p, err := d.NextPulse(timeout)
+		if err != nil {
+			return err
+		}
+		if p.Relay != f[2] {
+			return fmt.Errorf("%s relay pulsed", p.Relay)
+		}
+	case "no-pulse":
+		within, _ := time.ParseDuration(f[2])
+		if p, err := d.NextPulse(within); err == nil {
+			return fmt.Errorf("%s relay pulsed", p.Relay)
+		}
+	case "event":
+		_, err := d.NextEvent(func(e events.Event) bool { return matchEvent(e, f[2], f[3:]) }, timeout)
+		return err
+	}
+	return nil
+}
+
+// matchEvent reports whether e is of type typ and has the fields given as
+// KEY=VALUE. Keys are those of the event and of its access
+func matchEvent(e events.Event, typ string, fields []string) bool {
+	if e.Type != typ {
+		return false
+	}
+	var a events.Access
+	if e.Access != nil {
+		a = *e.Access
+	}
+	values := map[string]string{
+		"door": e.Door, "status": e.Status, "position": e.Position, "message": e.Message,
+		"source": a.Source, "reader": a.Reader, "action": a.Action, "user": a.User, "result": a.Result, "reason": a.Reason,
+	}
+	if a.Door != "" {
+		values["door"] = a.Door
+	}
+	for _, kv := range fields {
+		key, value, _ := strings.Cut(kv, "=")
+		if values[key] != value {
+			return false
+		}
+	}
+	return true
+}
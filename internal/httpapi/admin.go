@@ -0,0 +1,407 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// adminToken is a token allowed to use the admin API. Like in list.txt, it
+// can be stored as a salted hash
+type adminToken struct {
+	Token string
+	Name  string
+}
+
+func parseAdminTokens(path string) ([]adminToken, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []adminToken
+	for _, line := range strings.Split(string(bytes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 {
+			tokens = append(tokens, adminToken{Token: fields[0], Name: strings.Join(fields[1:], " ")})
+		}
+	}
+	return tokens, nil
+}
+
+// adminAuth authenticates admins by their API token, or by a dashboard
+// session started with it. With an identity provider, admins may also log
+// in there or present its access tokens. API keys are let in for the scopes
+// they grant
+type adminAuth struct {
+	tokens   []adminToken
+	keys     apiKeys
+	sessions *sessions
+	// oidc is nil without an identity provider
+	oidc *oidcProvider
+}
+
+// authenticate returns the name of the admin token presented as bearer token
+func authenticate(tokens []adminToken, r *http.Request) (string, bool) {
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if presented == "" || presented == r.Header.Get("Authorization") {
+		return "", false
+	}
+	return matchAdminToken(tokens, presented)
+}
+
+// matchAdminToken returns the name of the admin token presented
+func matchAdminToken(tokens []adminToken, presented string) (string, bool) {
+	for _, t := range tokens {
+		if store.IsTokenHash(t.Token) {
+			if store.MatchTokenHash(t.Token, presented) {
+				return t.Name, true
+			}
+		} else if subtle.ConstantTimeCompare([]byte(t.Token), []byte(presented)) == 1 {
+			return t.Name, true
+		}
+	}
+	return "", false
+}
+
+// requireAdmin only passes requests with a valid admin token or dashboard
+// session, or an API key granting ScopeAdmin or scope, to next. Clients
+// failing too often are locked out. If the admin action is restricted to
+// groups, the owner of the admin token or key also needs a credential in one
+// of them; admins of the identity provider were checked against its groups
+// instead, and keys only granting scope aren't checked
+func requireAdmin(auth *adminAuth, b Backend, scope string, next func(w http.ResponseWriter, r *http.Request, admin string)) http.HandlerFunc {
+	lockout := b.Lockout
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddr(r)
+		if lockout.Blocked(client, time.Now()) {
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+		admin, ok := authenticate(auth.tokens, r)
+		sso, scoped := false, false
+		if !ok {
+			var k *apiKey
+			if k, ok = auth.keys.match(presentedKey(r)); ok {
+				if !k.allows(scope) {
+					if scope == "" {
+						scope = ScopeAdmin
+					}
+					http.Error(w, errMissingScope.Error()+" "+scope, http.StatusForbidden)
+					return
+				}
+				if !k.use(w) {
+					return
+				}
+				admin, scoped = k.Name, !k.Scopes[ScopeAdmin]
+			}
+		}
+		if !ok {
+			admin, ok = auth.oidc.bearer(r, time.Now())
+			sso = ok
+		}
+		if !ok {
+			var sess session
+			sess, ok = auth.sessions.lookup(r, time.Now())
+			admin, sso = sess.admin, sess.sso
+			// Browsers send the cookie along with requests from other
+			// sites, which can't set custom headers though
+			if ok && r.Method != http.MethodGet && r.Header.Get(csrfHeader) != csrfValue {
+				http.Error(w, "missing "+csrfHeader+" header", http.StatusForbidden)
+				return
+			}
+		}
+		if !ok {
+			lockout.Fail(client, time.Now())
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !sso && !scoped {
+			if err := adminPermitted(b, admin); err != nil {
+				if err != store.ErrNotPermitted {
+					slog.Error("Could not look up admin", "admin", admin, "err", err)
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+				slog.Warn("Admin not permitted", "admin", admin)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		if scoped {
+			r = r.WithContext(context.WithValue(r.Context(), scopedKey{}, true))
+		}
+		next(w, r, admin)
+	}
+}
+
+type scopedKey struct{}
+
+// scopedKeyUsed reports whether the request passed by requireAdmin came with
+// an API key only granting the scope of the route, rather than from an admin
+func scopedKeyUsed(r *http.Request) bool {
+	scoped, _ := r.Context().Value(scopedKey{}).(bool)
+	return scoped
+}
+
+// adminPermitted checks the admin permission of the user named like an
+// admin token's owner
+func adminPermitted(b Backend, admin string) error {
+	permissions := b.Permissions()
+	if len(permissions[store.ActionAdmin]) == 0 {
+		return nil
+	}
+	creds, err := b.Store.List()
+	if err != nil {
+		return err
+	}
+	for _, c := range creds {
+		if c.User == admin && !c.Disabled && permissions.Check(c, store.ActionAdmin) == nil {
+			return nil
+		}
+	}
+	return store.ErrNotPermitted
+}
+
+// apiUser is a credential as exchanged with the admin API
+type apiUser struct {
+	Token    string `json:"token"`
+	User     string `json:"user"`
+	Schedule string `json:"schedule,omitempty"`
+	// Expires is a date like 2027-01-01
+	Expires  string `json:"expires,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+	// PIN is required on the keypad after the token. It is always stored
+	// hashed, a PIN given as hash, like another store sends it, as is
+	PIN    string   `json:"pin,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// Hash stores a salted hash instead of the token when adding
+	Hash bool `json:"hash,omitempty"`
+}
+
+// credential validates u, returning the credential to add, or the status to
+// reply with and why it is invalid
+func (u apiUser) credential() (store.Credential, int, error) {
+	var expires time.Time
+	if u.Expires != "" {
+		var err error
+		expires, err = time.ParseInLocation("2006-01-02", u.Expires, time.Local)
+		if err != nil {
+			return store.Credential{}, http.StatusBadRequest, errors.New("invalid expiry date")
+		}
+	}
+	c, err := store.NewCredential(u.Token, u.User, u.Schedule, expires)
+	if err != nil {
+		return c, http.StatusBadRequest, err
+	}
+	if c.Groups, err = store.ParseGroups(strings.Join(u.Groups, ",")); err != nil {
+		return c, http.StatusBadRequest, err
+	}
+	if u.Hash {
+		if c.Token, err = store.NewTokenHash(c.Token); err != nil {
+			return c, http.StatusInternalServerError, err
+		}
+	}
+	switch {
+	case store.IsTokenHash(u.PIN):
+		c.PIN = u.PIN
+	case u.PIN != "":
+		if c.PIN, err = store.NewPINHash(u.PIN); err != nil {
+			return c, http.StatusBadRequest, err
+		}
+	}
+	return c, http.StatusOK, nil
+}
+
+// addCredential adds c to the store on behalf of admin, replying with an
+// error unless it succeeds
+func addCredential(w http.ResponseWriter, b Backend, admin string, c store.Credential) bool {
+	err := b.Store.Add(c)
+	switch {
+	case err == store.ErrTokenExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return false
+	case err == store.ErrReadOnly:
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return false
+	case err != nil:
+		slog.Error("Could not add token", "user", c.User, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	slog.Info("Added token", "admin", admin, "user", c.User)
+	return true
+}
+
+// Limits of GET /api/v1/events
+const (
+	defaultEventLimit = 50
+	maxEventLimit     = 500
+)
+
+func registerAdminAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/users", Summary: "List the credentials", Legacy: true,
+		Status: http.StatusOK, Response: []apiUser{},
+		Errors: map[int]string{http.StatusInternalServerError: "credentials couldn't be listed"},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		creds, err := b.Store.List()
+		if err != nil {
+			slog.Error("Could not list users", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		list := []apiUser{}
+		for _, c := range creds {
+			u := apiUser{Token: c.Token, User: c.User, Schedule: c.Schedule, Disabled: c.Disabled, PIN: c.PIN, Groups: c.Groups}
+			if !c.Expires.IsZero() {
+				u.Expires = c.Expires.Format("2006-01-02")
+			}
+			list = append(list, u)
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	api.handleAdmin(route{
+		Method: "POST", Path: apiPrefix + "/users", Summary: "Add a credential", Legacy: true,
+		Request: apiUser{}, Status: http.StatusCreated, Response: apiUser{},
+		Errors: map[int]string{
+			http.StatusBadRequest:          "invalid credential",
+			http.StatusConflict:            "token exists",
+			http.StatusMethodNotAllowed:    "credential store is read-only",
+			http.StatusInternalServerError: "credential couldn't be added",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		var u apiUser
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, status, err := u.credential()
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if !addCredential(w, b, admin, c) {
+			return
+		}
+		u.Token, u.User, u.PIN, u.Groups, u.Hash = c.Token, c.User, c.PIN, c.Groups, false
+		writeJSON(w, http.StatusCreated, u)
+	})
+
+	api.handleAdmin(route{
+		Method: "DELETE", Path: apiPrefix + "/users/{token}", Summary: "Remove a credential", Legacy: true,
+		Status: http.StatusNoContent,
+		Errors: map[int]string{
+			http.StatusNotFound:            "unknown token",
+			http.StatusMethodNotAllowed:    "credential store is read-only",
+			http.StatusInternalServerError: "credential couldn't be removed",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		err := b.Store.Remove(r.PathValue("token"))
+		switch {
+		case errors.Is(err, store.ErrUnknownToken):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err == store.ErrReadOnly:
+			http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+			return
+		case err != nil:
+			slog.Error("Could not remove token", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Removed token", "admin", admin)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Events are paginated by passing the ID of the last event received as
+	// before
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/events", Summary: "Recent status changes, access attempts and alerts, newest first", Legacy: true,
+		Scope: ScopeEventsRead,
+		Query: []string{"limit", "before"}, Status: http.StatusOK, Response: []events.Event{},
+		Errors: map[int]string{http.StatusBadRequest: "invalid limit or before"},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		limit := defaultEventLimit
+		if v := r.FormValue("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxEventLimit {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		var before uint64
+		if v := r.FormValue("before"); v != "" {
+			var err error
+			if before, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "invalid before", http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, b.Events.History(before, limit))
+	})
+
+	if b.LogLevel != nil {
+		api.handleAdmin(route{
+			Method: "GET", Path: apiPrefix + "/log-level", Summary: "Minimum level logged", Legacy: true,
+			Status: http.StatusOK, Response: logLevel{},
+		}, func(w http.ResponseWriter, r *http.Request, admin string) {
+			writeJSON(w, http.StatusOK, logLevel{Level: b.LogLevel.Level().String()})
+		})
+		api.handleAdmin(route{
+			Method: "PUT", Path: apiPrefix + "/log-level", Summary: "Change the minimum level logged until the next restart", Legacy: true,
+			Request: logLevel{}, Status: http.StatusOK, Response: logLevel{},
+			Errors: map[int]string{http.StatusBadRequest: "invalid level"},
+		}, func(w http.ResponseWriter, r *http.Request, admin string) {
+			var req logLevel
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var l slog.Level
+			if err := l.UnmarshalText([]byte(req.Level)); err != nil {
+				http.Error(w, "invalid level", http.StatusBadRequest)
+				return
+			}
+			b.LogLevel.Set(l)
+			slog.Info("Changed log level", "admin", admin, "level", l)
+			writeJSON(w, http.StatusOK, logLevel{Level: l.String()})
+		})
+	}
+}
+
+// logLevel is the minimum level logged, e.g. DEBUG or INFO
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// clientAddr returns the IP address of the client, without the port
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Could not write response", "err", err)
+	}
+}
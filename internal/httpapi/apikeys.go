@@ -0,0 +1,190 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/ratelimit"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// Scopes of API keys
+const (
+	// ScopeStateRead reads the status of the doors
+	ScopeStateRead = "state:read"
+	// ScopeEventsRead follows the event streams and reads the event history
+	ScopeEventsRead = "events:read"
+	// ScopeDoorUnlock unlocks and locks the doors
+	ScopeDoorUnlock = "door:unlock"
+	// ScopeTokensCheck checks which tokens are authorized, without learning
+	// their users
+	ScopeTokensCheck = "tokens:check"
+	// ScopeAdmin uses the admin API like an admin token, and implies all
+	// other scopes
+	ScopeAdmin = "admin"
+)
+
+var errMissingScope = errors.New("API key lacks the scope")
+
+// apiKey is a key for the API restricted to its scopes and rate. Like admin
+// tokens, it can be stored as a salted hash
+type apiKey struct {
+	Key    string
+	Name   string
+	Scopes map[string]bool
+	// limit is nil if the key isn't rate limited
+	limit *ratelimit.Window
+}
+
+// allows reports whether k grants scope
+func (k *apiKey) allows(scope string) bool {
+	return k.Scopes[scope] || k.Scopes[ScopeAdmin]
+}
+
+// apiKeys are the API keys from the file at Config.APIKeys
+type apiKeys []*apiKey
+
+// parseAPIKeys reads the API keys at path, one "key scopes limit name" per
+// line. Scopes are comma separated, the limit is a number of requests per
+// s, m or h like 60/m, or - for none
+func parseAPIKeys(path string) (apiKeys, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys apiKeys
+	for i, line := range strings.Split(string(bytes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%s:%d: expected key, scopes, limit and name", path, i+1)
+		}
+		k := &apiKey{Key: fields[0], Name: strings.Join(fields[3:], " "), Scopes: map[string]bool{}}
+		for _, scope := range strings.Split(fields[1], ",") {
+			switch scope {
+			case ScopeStateRead, ScopeEventsRead, ScopeDoorUnlock, ScopeTokensCheck, ScopeAdmin:
+				k.Scopes[scope] = true
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown scope %q", path, i+1, scope)
+			}
+		}
+		if k.limit, err = parseKeyLimit(fields[2]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// parseKeyLimit parses a limit like 60/m, returning nil for -
+func parseKeyLimit(text string) (*ratelimit.Window, error) {
+	if text == "-" {
+		return nil, nil
+	}
+	count, unit, _ := strings.Cut(text, "/")
+	n, err := strconv.Atoi(count)
+	per, ok := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if err != nil || n < 1 || !ok {
+		return nil, fmt.Errorf("invalid limit %q, expected a number of requests per s, m or h like 60/m, or -", text)
+	}
+	return ratelimit.NewWindow(n, per), nil
+}
+
+// presentedKey returns the key presented by r as bearer token, or for GET
+// requests in the key query parameter, as browsers can't set headers on
+// WebSockets and event streams. App tokens aren't API keys
+func presentedKey(r *http.Request) string {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && r.Method == http.MethodGet {
+		key = r.URL.Query().Get("key")
+	}
+	if store.IsAppToken(key) {
+		return ""
+	}
+	return key
+}
+
+// match returns the API key presented
+func (ks apiKeys) match(presented string) (*apiKey, bool) {
+	for _, k := range ks {
+		if store.IsTokenHash(k.Key) {
+			if store.MatchTokenHash(k.Key, presented) {
+				return k, true
+			}
+		} else if subtle.ConstantTimeCompare([]byte(k.Key), []byte(presented)) == 1 {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// use counts a request with k against its limit, replying 429 if it is
+// exceeded
+func (k *apiKey) use(w http.ResponseWriter) bool {
+	retry, ok := k.limit.Allow(time.Now())
+	if !ok {
+		slog.Info("API key rate limited", "key", k.Name)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	}
+	return ok
+}
+
+// requireScope passes requests to the public endpoint h if they present an
+// API key granting scope, or none unless reads are private. Invalid keys
+// count as failures for the lockout
+func (a *router) requireScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	if len(a.keys) == 0 && !a.privateReads {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddr(r)
+		if a.b.Lockout.Blocked(client, time.Now()) {
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+		presented := presentedKey(r)
+		if presented == "" {
+			if a.privateReads {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "API key required", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+			return
+		}
+		k, ok := a.keys.match(presented)
+		if !ok {
+			a.b.Lockout.Fail(client, time.Now())
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if !k.allows(scope) {
+			http.Error(w, errMissingScope.Error()+" "+scope, http.StatusForbidden)
+			return
+		}
+		if k.use(w) {
+			h(w, r.WithContext(context.WithValue(r.Context(), apiKeyUsed{}, true)))
+		}
+	}
+}
+
+type apiKeyUsed struct{}
+
+// anonymous reports whether r was passed by requireScope without an API key,
+// so the events it is sent must not name users
+func anonymous(r *http.Request) bool {
+	return r.Context().Value(apiKeyUsed{}) == nil
+}
@@ -0,0 +1,62 @@
+'use strict';
+
+// The app token is kept in the browser's local storage. A link ending in
+// #<token> saves it, so it can be handed out without typing it. Paths are
+// relative to /app/, so it works under the path prefix of a proxy
+const $ = (id) => document.getElementById(id);
+
+function show() {
+  const token = localStorage.getItem('wishbone-token');
+  $('setup').hidden = !!token;
+  $('unlock').hidden = !token;
+  $('open').textContent = 'Unlock ' + (localStorage.getItem('wishbone-door') || '');
+}
+
+async function unlock() {
+  const door = localStorage.getItem('wishbone-door');
+  $('open').disabled = true;
+  $('status').className = '';
+  $('status').textContent = 'Unlocking…';
+  try {
+    const resp = await fetch('../api/v1/unlock', {
+      method: 'POST',
+      headers: {
+        'Authorization': 'Bearer ' + localStorage.getItem('wishbone-token'),
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify(door ? {door} : {}),
+    });
+    const body = await resp.json().catch(() => ({}));
+    if (!resp.ok) {
+      throw new Error(body.error || resp.statusText);
+    }
+    $('status').textContent = 'Unlocked ' + body.door;
+  } catch (err) {
+    $('status').className = 'error';
+    $('status').textContent = err.message;
+  }
+  $('open').disabled = false;
+}
+
+$('setup').addEventListener('submit', (e) => {
+  e.preventDefault();
+  localStorage.setItem('wishbone-token', $('token').value.trim());
+  localStorage.setItem('wishbone-door', $('door').value.trim());
+  $('token').value = '';
+  show();
+});
+
+$('forget').addEventListener('click', () => {
+  localStorage.removeItem('wishbone-token');
+  localStorage.removeItem('wishbone-door');
+  $('status').textContent = '';
+  show();
+});
+
+$('open').addEventListener('click', unlock);
+
+if (location.hash.length > 1) {
+  localStorage.setItem('wishbone-token', decodeURIComponent(location.hash.slice(1)));
+  history.replaceState(null, '', location.pathname);
+}
+show();
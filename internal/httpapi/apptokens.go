@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// appFiles is the page members unlock with on their phone, using an app
+// token kept in the browser
+//
+//go:embed app
+var appFiles embed.FS
+
+// appTokenRequest is the body of POST /api/v1/app-tokens
+type appTokenRequest struct {
+	User string `json:"user"`
+	// Name describes the device, like "Alice's phone"
+	Name string `json:"name,omitempty"`
+}
+
+// appTokenResponse holds a new app token, which can't be shown again
+type appTokenResponse struct {
+	Token string `json:"token"`
+	store.AppToken
+}
+
+// Members unlock with an app token on the page served under /app/, or by
+// sending it as bearer token to /api/v1/unlock
+func registerAppTokensAPI(api *router) error {
+	mux, b := api.mux, api.b
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/app-tokens", Summary: "App tokens of all members, or of one",
+		Query: []string{"user"}, Status: http.StatusOK, Response: []store.AppToken{},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		writeJSON(w, http.StatusOK, b.AppTokens.List(r.FormValue("user")))
+	})
+
+	// Replies 201 with the token, 400 if the request is invalid and 404 if
+	// the user has no credential
+	api.handleAdmin(route{
+		Method: "POST", Path: apiPrefix + "/app-tokens", Summary: "Issue an app token unlocking on behalf of a member",
+		Request: appTokenRequest{}, Status: http.StatusCreated, Response: appTokenResponse{},
+		Errors: map[int]string{
+			http.StatusBadRequest:          "invalid request",
+			http.StatusNotFound:            "user has no credential",
+			http.StatusInternalServerError: "credentials couldn't be listed, or app token couldn't be saved",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		var req appTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		req.User = strings.Join(strings.Fields(req.User), " ")
+		req.Name = strings.TrimSpace(req.Name)
+		if req.User == "" {
+			http.Error(w, "invalid user", http.StatusBadRequest)
+			return
+		}
+		// The member may not be allowed to unlock right now, but must exist
+		_, err := store.LookupUser(b.Store, req.User, time.Now())
+		switch {
+		case errors.Is(err, store.ErrUnknownToken):
+			http.Error(w, "user has no credential", http.StatusNotFound)
+			return
+		case err != nil && !errors.Is(err, store.ErrDisabled) && !errors.Is(err, store.ErrNotYetValid) &&
+			!errors.Is(err, store.ErrExpired) && !errors.Is(err, store.ErrOutsideSchedule):
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token, t, err := b.AppTokens.Issue(req.User, req.Name, time.Now())
+		if err != nil {
+			slog.Error("Could not issue app token", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Issued app token", "admin", admin, "user", t.User, "id", t.ID, "name", t.Name)
+		writeJSON(w, http.StatusCreated, appTokenResponse{Token: token, AppToken: t})
+	})
+
+	// Replies 204, or 404 if there is no app token with the ID
+	api.handleAdmin(route{
+		Method: "DELETE", Path: apiPrefix + "/app-tokens/{id}", Summary: "Revoke an app token",
+		Status: http.StatusNoContent,
+		Errors: map[int]string{
+			http.StatusNotFound:            "unknown app token",
+			http.StatusInternalServerError: "app token couldn't be saved",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		t, err := b.AppTokens.Revoke(r.PathValue("id"))
+		switch {
+		case err == store.ErrUnknownAppToken:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Revoked app token", "admin", admin, "user", t.User, "id", t.ID, "name", t.Name)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Unlike the dashboard, the page doesn't use the admin API
+	files, err := fs.Sub(appFiles, "app")
+	if err != nil {
+		return err
+	}
+	static := http.StripPrefix("/app/", http.FileServerFS(files))
+	mux.Handle("GET /app/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		static.ServeHTTP(w, r)
+	}))
+	// Redirect relative to the page, like the dashboard
+	mux.HandleFunc("GET /app", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "app/")
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+	return nil
+}
@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// Defaults and limits of POST /api/v1/codes
+const (
+	defaultCodeValidity = 24 * time.Hour
+	maxCodeUses         = 10
+)
+
+// codeRequest is the body of POST /api/v1/codes. The member authenticates
+// with their token, and PIN if they have one
+type codeRequest struct {
+	Token string `json:"token"`
+	PIN   string `json:"pin,omitempty"`
+	// Uses defaults to 1
+	Uses int `json:"uses,omitempty"`
+	// ValidFor is a duration like 2h, defaulting to 24h
+	ValidFor string `json:"valid_for,omitempty"`
+	// Door defaults to the first one
+	Door string `json:"door,omitempty"`
+}
+
+// serveIssueCode lets members hand out one-time codes unlocking on their
+// behalf. It replies 201 with the code, 400 for invalid requests, 401 for
+// tokens of non-members, 403 for members who may not unlock themselves, 404
+// for unknown doors, 429 for locked out clients
+// and 409 if the member has too many active codes
+func serveIssueCode(b Backend, maxValidity time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddr(r)
+		if b.Lockout.Blocked(client, time.Now()) {
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		req := codeRequest{Uses: 1}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		validFor := defaultCodeValidity
+		if req.ValidFor != "" {
+			var err error
+			if validFor, err = time.ParseDuration(req.ValidFor); err != nil || validFor <= 0 {
+				http.Error(w, "invalid valid_for", http.StatusBadRequest)
+				return
+			}
+		}
+		if validFor > maxValidity {
+			validFor = maxValidity
+		}
+		if req.Uses < 1 || req.Uses > maxCodeUses {
+			http.Error(w, "invalid uses", http.StatusBadRequest)
+			return
+		}
+		door, ok := b.door(req.Door)
+		if !ok {
+			http.Error(w, "unknown door", http.StatusNotFound)
+			return
+		}
+
+		// Members may hand out codes outside of their own schedule, e.g.
+		// for a delivery during the day
+		cred, err := b.lookup(r, req.Token)
+		if err == nil || errors.Is(err, store.ErrOutsideSchedule) {
+			err = nil
+			if cred.PIN != "" && !cred.CheckPIN(req.PIN) {
+				err = store.ErrWrongPIN
+			}
+		}
+		// A code unlocks on behalf of its issuer
+		if err == nil {
+			err = b.Permissions().Check(cred, store.ActionUnlock)
+		}
+		switch {
+		case errors.Is(err, store.ErrUnknownToken), errors.Is(err, store.ErrDisabled), errors.Is(err, store.ErrNotYetValid),
+			errors.Is(err, store.ErrExpired), errors.Is(err, store.ErrWrongPIN):
+			b.Lockout.Fail(client, time.Now())
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case err == store.ErrNotPermitted:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			slog.Error("Could not look up token", "err", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		code, err := b.Codes.Issue(cred.User, door.Name, req.Uses, time.Now().Add(validFor))
+		switch {
+		case err == store.ErrTooManyCodes:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Issued one-time code", "door", door.Name, "user", cred.User, "uses", code.Uses, "expires", code.Expires)
+		writeJSON(w, http.StatusCreated, code)
+	}
+}
@@ -0,0 +1,368 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/decision"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/lockdown"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// controlRequest is the body of POST /api/v1/unlock and /api/v1/lock. Token
+// is a credential from the store, subject to its schedule like a swipe.
+// Instead of a token, a one-time code can be given to unlock. With an app
+// token or an API key granting door:unlock as bearer token, the body may be
+// empty and gives no token or code
+type controlRequest struct {
+	Token string `json:"token,omitempty"`
+	Code  string `json:"code,omitempty"`
+	// KeepOpen unlocks without starting the auto-lock timer
+	KeepOpen bool `json:"keep_open,omitempty"`
+	// PIN is required for credentials having one
+	PIN string `json:"pin,omitempty"`
+	// Door defaults to the first one
+	Door string `json:"door,omitempty"`
+}
+
+type controlResponse struct {
+	Door   string `json:"door,omitempty"`
+	Status string `json:"status"`
+	User   string `json:"user,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// controlFormat reads control requests and writes their replies, as JSON or
+// as the plain text of a legacy profile
+type controlFormat struct {
+	// decode returns io.EOF for requests without a token or code
+	decode func(r *http.Request) (controlRequest, error)
+	write  func(w http.ResponseWriter, status int, resp controlResponse)
+}
+
+// jsonControl is the format of /api/v1/unlock and /api/v1/lock
+var jsonControl = controlFormat{
+	decode: func(r *http.Request) (controlRequest, error) {
+		var req controlRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		return req, err
+	},
+	write: func(w http.ResponseWriter, status int, resp controlResponse) {
+		writeJSON(w, status, resp)
+	},
+}
+
+// commandAction maps commands to the actions permissions refer to
+var commandAction = map[string]string{
+	"open":      store.ActionUnlock,
+	"keep-open": store.ActionKeepOpen,
+	"close":     store.ActionLock,
+}
+
+// bearerAppToken returns the app token presented as bearer token by r
+func bearerAppToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && store.IsAppToken(token)
+}
+
+// lookupAppToken returns the entry of an app token and a credential of its
+// member that may unlock now
+func (b Backend) lookupAppToken(token string) (store.AppToken, store.Credential, error) {
+	t, err := b.AppTokens.Lookup(token, time.Now())
+	if err != nil {
+		return t, store.Credential{}, err
+	}
+	cred, err := store.LookupUser(b.Store, t.User, time.Now())
+	cred.User = t.User
+	return t, cred, err
+}
+
+// lookup looks up token in the store, giving up after the lookup timeout or
+// when the client of r goes away
+func (b Backend) lookup(r *http.Request, token string) (store.Credential, error) {
+	ctx := r.Context()
+	if b.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.LookupTimeout)
+		defer cancel()
+	}
+	return b.Store.Lookup(ctx, token)
+}
+
+func registerControlAPI(api *router) {
+	lockErrors := map[int]string{
+		http.StatusBadRequest:         "invalid request",
+		http.StatusUnauthorized:       "token may not unlock, wrong PIN or invalid API key",
+		http.StatusForbidden:          "the user's groups don't permit it, or the API key lacks door:unlock",
+		http.StatusNotFound:           "unknown door",
+		http.StatusConflict:           "sphincter already in the target status",
+		http.StatusTooManyRequests:    "client locked out, API key rate limited, the relay is resting or too many commands are waiting",
+		http.StatusServiceUnavailable: "denied by the fail policy, or the command failed",
+	}
+	unlockErrors := maps.Clone(lockErrors)
+	unlockErrors[http.StatusLocked] = "door in lockdown"
+	api.handle(route{
+		Method: "POST", Path: apiPrefix + "/unlock", Summary: "Unlock a door with a token, one-time code or app token",
+		Request: controlRequest{}, Status: http.StatusOK, Response: controlResponse{}, Errors: unlockErrors,
+	}, serveControl(api.b, api.keys, "open", actuator.StatusUnlocked, jsonControl))
+	api.handle(route{
+		Method: "POST", Path: apiPrefix + "/lock", Summary: "Lock a door with a token or app token",
+		Request: controlRequest{}, Status: http.StatusOK, Response: controlResponse{}, Errors: lockErrors,
+	}, serveControl(api.b, api.keys, "close", actuator.StatusLocked, jsonControl))
+}
+
+// serveControl runs cmd for the owner of the token in the request, or the
+// API key presented. It replies 401 for tokens that may not unlock, a wrong
+// PIN or invalid keys, 403 if the groups of the token's user don't permit
+// cmd or the key lacks door:unlock, 404 for unknown doors, 423 during a
+// lockdown, 429 for locked out clients and rate limited keys, 409 if the
+// sphincter already is in the target status and 503 if the fail policy
+// denies unlocking or the command failed. Requests and replies are in the
+// format f
+func serveControl(b Backend, keys apiKeys, cmd string, target actuator.Status, f controlFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		door := b.Doors[0]
+		reply := func(status int, user string, err error) {
+			resp := controlResponse{Door: door.Name, Status: door.Actuator.Status().String(), User: user}
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			f.write(w, status, resp)
+		}
+
+		client := clientAddr(r)
+		if b.Lockout.Blocked(client, time.Now()) {
+			reply(http.StatusTooManyRequests, "", errors.New("too many failed attempts"))
+			return
+		}
+
+		appToken, isApp := bearerAppToken(r)
+		var key *apiKey
+		if presented := presentedKey(r); !isApp && presented != "" && len(keys) > 0 {
+			k, ok := keys.match(presented)
+			if !ok {
+				b.Lockout.Fail(client, time.Now())
+				reply(http.StatusUnauthorized, "", errors.New("invalid API key"))
+				return
+			}
+			if !k.allows(ScopeDoorUnlock) {
+				reply(http.StatusForbidden, k.Name, fmt.Errorf("%w %s", errMissingScope, ScopeDoorUnlock))
+				return
+			}
+			if !k.use(w) {
+				return
+			}
+			key = k
+		}
+		// The bearer token stands in for the token or code
+		bearer := isApp || key != nil
+		req, err := f.decode(r)
+		if bearer && err == io.EOF {
+			err = nil
+		}
+		if err != nil || !bearer && (req.Token == "") == (req.Code == "") || bearer && (req.Token != "" || req.Code != "") {
+			reply(http.StatusBadRequest, "", errors.New("invalid request"))
+			return
+		}
+		if req.Code != "" {
+			serveCode(b, w, r, req, cmd, target, f)
+			return
+		}
+		d, ok := b.door(req.Door)
+		if !ok {
+			reply(http.StatusNotFound, "", errors.New("unknown door"))
+			return
+		}
+		door = d
+		if cmd == "open" && req.KeepOpen {
+			cmd = "keep-open"
+		}
+
+		event := events.Access{Source: "http", Door: door.Name, Action: cmd, TokenHash: events.HashToken(req.Token)}
+		var cred store.Credential
+		if key != nil {
+			// The scope of the key stands in for the permissions
+			cred = store.Credential{User: key.Name}
+			event.Source, event.TokenHash = "api", ""
+			slog.Info("API key used", "key", key.Name, "command", cmd)
+		} else if isApp {
+			// The app token stands in for the card and PIN
+			var t store.AppToken
+			t, cred, err = b.lookupAppToken(appToken)
+			event.Source, event.TokenHash = "app", events.HashToken(appToken)
+			if err == nil {
+				slog.Info("App token used", "user", t.User, "id", t.ID, "name", t.Name, "command", cmd)
+			}
+		} else {
+			cred, err = b.lookup(r, req.Token)
+			if err == nil && cred.PIN != "" && !cred.CheckPIN(req.PIN) {
+				err = store.ErrWrongPIN
+			}
+		}
+		user := cred.User
+		event.User = user
+		if err == nil && key == nil {
+			if err = b.Permissions().Check(cred, commandAction[cmd]); err != nil {
+				slog.Info("Denied", "user", user, "command", cmd, "reason", err)
+				event.Result = events.ResultDenied
+				event.Reason = err.Error()
+				b.Record(event)
+				reply(http.StatusForbidden, user, err)
+				return
+			}
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrUnknownAppToken):
+				event.Result = events.ResultUnknown
+			case errors.Is(err, store.ErrUnknownToken) && isApp:
+				// The member of the app token has no credential anymore
+				event.Result = events.ResultDenied
+				event.Reason = err.Error()
+			case errors.Is(err, store.ErrUnknownToken):
+				event.Result = events.ResultUnknown
+			case errors.Is(err, store.ErrDisabled), errors.Is(err, store.ErrNotYetValid),
+				errors.Is(err, store.ErrExpired), errors.Is(err, store.ErrOutsideSchedule),
+				errors.Is(err, store.ErrWrongPIN):
+				event.Result = events.ResultDenied
+				event.Reason = err.Error()
+			default:
+				slog.Error("Could not look up token", "err", err)
+				reply(http.StatusServiceUnavailable, "", err)
+				return
+			}
+			b.Record(event)
+			b.Lockout.Fail(client, time.Now())
+			reply(http.StatusUnauthorized, "", err)
+			return
+		}
+
+		status := door.Actuator.Status()
+		ctx := r.Context()
+		if cmd != "close" {
+			if err := b.FailPolicy().Check(cred, status); err != nil {
+				slog.Info("Denied", "user", user, "command", cmd, "reason", err)
+				event.Result = events.ResultDenied
+				event.Reason = err.Error()
+				b.Record(event)
+				reply(http.StatusServiceUnavailable, user, err)
+				return
+			}
+			if err := b.Lockdown.Check(cred, door.Name); err != nil {
+				slog.Info("Denied", "user", user, "command", cmd, "reason", err)
+				event.Result = events.ResultDenied
+				event.Reason = err.Error()
+				b.Record(event)
+				reply(http.StatusLocked, user, err)
+				return
+			}
+			reason, err := b.AccessHook.Check(ctx, decision.Request{
+				Door: door.Name, Source: event.Source, Action: cmd, User: user, Groups: cred.Groups, TokenHash: event.TokenHash,
+			})
+			if err != nil {
+				slog.Info("Denied", "user", user, "command", cmd, "reason", err, "hook_reason", reason)
+				event.Result = events.ResultDenied
+				event.Reason = err.Error()
+				b.Record(event)
+				reply(http.StatusForbidden, user, err)
+				return
+			}
+			// Members of the emergency groups may unlock during a lockdown
+			if b.Lockdown.Exempts(cred) {
+				ctx = lockdown.Exempt(ctx)
+			}
+		}
+		if status == target {
+			reply(http.StatusConflict, user, errors.New("sphincter already "+target.String()))
+			return
+		}
+
+		if err := b.Command(ctx, door.Name, event.Source, user, cmd); err != nil {
+			reply(commandStatus(err), user, err)
+			return
+		}
+		reply(http.StatusOK, user, nil)
+	}
+}
+
+// serveCode unlocks for a one-time code on behalf of the member who issued
+// it, replying like serveControl. A code is only used up by a successful
+// unlock
+func serveCode(b Backend, w http.ResponseWriter, r *http.Request, req controlRequest, cmd string, target actuator.Status, f controlFormat) {
+	reply := func(status int, door Door, user string, err error) {
+		resp := controlResponse{Door: door.Name, Status: door.Actuator.Status().String(), User: user}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		f.write(w, status, resp)
+	}
+
+	client := clientAddr(r)
+	if b.Codes == nil || cmd != "open" || req.KeepOpen {
+		reply(http.StatusBadRequest, b.Doors[0], "", errors.New("codes can only unlock"))
+		return
+	}
+	// Without a door, the code opens the one it was issued for
+	code, err := b.Codes.Lookup(req.Code, time.Now())
+	if req.Door == "" {
+		req.Door = code.Door
+	}
+	door, ok := b.door(req.Door)
+	if !ok {
+		reply(http.StatusNotFound, b.Doors[0], "", errors.New("unknown door"))
+		return
+	}
+	if err == nil && door.Name != code.Door {
+		err = store.ErrUnknownCode
+	}
+	if err != nil {
+		b.Record(events.Access{Source: "code", Door: door.Name, Action: cmd, TokenHash: events.HashToken(req.Code), Result: events.ResultUnknown})
+		b.Lockout.Fail(client, time.Now())
+		reply(http.StatusUnauthorized, door, "", err)
+		return
+	}
+
+	// Guests aren't keyholders, whoever issued their code
+	status := door.Actuator.Status()
+	if err := b.FailPolicy().Check(store.Credential{User: code.Inviter}, status); err != nil {
+		b.Record(events.Access{Source: "code", Door: door.Name, User: code.Inviter, Action: cmd, TokenHash: events.HashToken(req.Code), Result: events.ResultDenied, Reason: err.Error()})
+		reply(http.StatusServiceUnavailable, door, code.Inviter, err)
+		return
+	}
+	if status == target {
+		reply(http.StatusConflict, door, code.Inviter, errors.New("sphincter already "+target.String()))
+		return
+	}
+	if code, err = b.Codes.Redeem(req.Code, door.Name, time.Now()); err != nil {
+		// Used up concurrently
+		reply(http.StatusUnauthorized, door, "", err)
+		return
+	}
+	if err := b.Command(r.Context(), door.Name, "code", code.Inviter, cmd); err != nil {
+		reply(commandStatus(err), door, code.Inviter, err)
+		return
+	}
+	reply(http.StatusOK, door, code.Inviter, nil)
+}
+
+// ErrBusy is returned by Backend.Command for commands refused as too many
+// are waiting for the door
+var ErrBusy = errors.New("door busy")
+
+// commandStatus returns the HTTP status for a failed command
+func commandStatus(err error) int {
+	if errors.Is(err, actuator.ErrRelayResting) || errors.Is(err, ErrBusy) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusServiceUnavailable
+}
@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// dashboardFiles is the admin web UI. It only uses the admin API, so all it
+// shows can be fetched with curl as well
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+type loginRequest struct {
+	Token string `json:"token"`
+}
+
+type loginResponse struct {
+	Admin string `json:"admin"`
+}
+
+// dashboardCommands are the commands the dashboard buttons send
+var dashboardCommands = map[string]bool{"open": true, "keep-open": true, "close": true}
+
+func registerDashboard(api *router) error {
+	mux, auth, b := api.mux, api.auth, api.b
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		return err
+	}
+	static := http.StripPrefix("/dashboard/", http.FileServerFS(files))
+	mux.Handle("GET /dashboard/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The buttons must not be clickable through a frame of another site
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		static.ServeHTTP(w, r)
+	}))
+	// The mux would redirect to /dashboard/, losing the path prefix of a
+	// proxy, so redirect relative to the page instead
+	mux.HandleFunc("GET /dashboard", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "dashboard/")
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("POST /dashboard/login", serveLogin(auth, b))
+	mux.HandleFunc("POST /dashboard/logout", func(w http.ResponseWriter, r *http.Request) {
+		auth.sessions.end(w, r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	// Tells the page whether it is logged in. Unlike the admin API, this
+	// doesn't count towards the lockout, as every visit starts with it
+	if auth.oidc != nil {
+		registerOIDC(api)
+	}
+	mux.HandleFunc("GET /dashboard/session", func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := auth.sessions.lookup(r, time.Now())
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, http.StatusOK, loginResponse{Admin: sess.admin})
+	})
+
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/doors", Summary: "Status of the doors", Legacy: true,
+		Status: http.StatusOK, Response: []doorStatus{},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		list := []doorStatus{}
+		for _, d := range b.Doors {
+			list = append(list, statusOf(d))
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	// Replies with the status of the door after the command, 404 for
+	// unknown doors or commands and 503 if the command failed
+	api.handleAdmin(route{
+		Method: "POST", Path: apiPrefix + "/doors/{door}/{command}", Summary: "Open, keep open or close a door", Legacy: true,
+		Status: http.StatusOK, Response: doorStatus{},
+		Errors: map[int]string{
+			http.StatusNotFound:           "unknown door or command",
+			http.StatusServiceUnavailable: "command failed or refused",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		d, ok := b.door(r.PathValue("door"))
+		cmd := r.PathValue("command")
+		if !ok || !dashboardCommands[cmd] {
+			http.NotFound(w, r)
+			return
+		}
+		if err := b.Command(r.Context(), d.Name, "admin", admin, cmd); err != nil {
+			http.Error(w, err.Error(), commandStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, statusOf(d))
+	})
+	return nil
+}
+
+// serveLogin starts a dashboard session for a valid admin token. Failures
+// count towards the lockout like those of the admin API
+func serveLogin(auth *adminAuth, b Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddr(r)
+		if b.Lockout.Blocked(client, time.Now()) {
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+		var req loginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		admin, ok := matchAdminToken(auth.tokens, req.Token)
+		if !ok {
+			b.Lockout.Fail(client, time.Now())
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := adminPermitted(b, admin); err != nil {
+			if err != store.ErrNotPermitted {
+				slog.Error("Could not look up admin", "admin", admin, "err", err)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err := auth.sessions.start(w, admin, false, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Admin logged in to dashboard", "admin", admin, "client", client)
+		writeJSON(w, http.StatusOK, loginResponse{Admin: admin})
+	}
+}
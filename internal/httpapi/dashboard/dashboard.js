@@ -0,0 +1,184 @@
+'use strict';
+
+// The dashboard polls the admin API while logged in. Requests carry the
+// header the API requires of cookie authenticated requests. Paths are
+// relative to /dashboard/, so it works under the path prefix of a proxy
+const pollInterval = 5000;
+let timer = null;
+
+const $ = (id) => document.getElementById(id);
+
+async function api(method, path, body) {
+  const resp = await fetch(path, {
+    method,
+    headers: {'X-Requested-With': 'wishbone', 'Content-Type': 'application/json'},
+    body: body === undefined ? undefined : JSON.stringify(body),
+    credentials: 'same-origin',
+  });
+  if (resp.status === 401) {
+    showLogin();
+    throw new Error('logged out');
+  }
+  if (!resp.ok) {
+    throw new Error((await resp.text()).trim() || resp.statusText);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text || '';
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function formatTime(t) {
+  return t ? new Date(t).toLocaleString() : '';
+}
+
+function button(td, label, door, command) {
+  const b = document.createElement('button');
+  b.textContent = label;
+  b.addEventListener('click', async () => {
+    b.disabled = true;
+    try {
+      await api('POST', '../api/v1/doors/' + encodeURIComponent(door) + '/' + command);
+      $('error').textContent = '';
+    } catch (err) {
+      $('error').textContent = err.message;
+    }
+    b.disabled = false;
+    refresh();
+  });
+  td.appendChild(b);
+}
+
+function renderDoors(doors) {
+  const body = $('doors');
+  body.replaceChildren();
+  for (const d of doors) {
+    const row = body.insertRow();
+    cell(row, d.door);
+    cell(row, d.status + (d.position ? ', ' + d.position : ''), d.status);
+    cell(row, formatTime(d.last_change));
+    const td = cell(row);
+    button(td, 'Unlock', d.door, 'open');
+    button(td, 'Keep open', d.door, 'keep-open');
+    button(td, 'Lock', d.door, 'close');
+  }
+}
+
+function renderComponents(health) {
+  const list = $('components');
+  list.replaceChildren();
+  for (const name of Object.keys(health.components).sort()) {
+    const c = health.components[name];
+    const li = document.createElement('li');
+    li.textContent = name + ': ' + (c.ok ? 'OK' : 'FAILING') + (c.detail ? ' (' + c.detail + ')' : '');
+    li.className = c.ok ? 'ok' : 'failed';
+    list.appendChild(li);
+  }
+}
+
+function describe(e) {
+  if (e.access) {
+    return e.access.action + ' via ' + e.access.source + (e.access.reader ? ' at ' + e.access.reader : '');
+  }
+  if (e.type === 'position') {
+    return 'door ' + e.position.toLowerCase();
+  }
+  if (e.status) {
+    return 'now ' + e.status + (e.position ? ', ' + e.position : '');
+  }
+  return e.message || e.type;
+}
+
+function renderEvents(events) {
+  const body = $('events');
+  body.replaceChildren();
+  for (const e of events) {
+    const row = body.insertRow();
+    const a = e.access || {};
+    cell(row, formatTime(e.time));
+    cell(row, e.door || a.door);
+    cell(row, describe(e));
+    cell(row, a.user);
+    const result = a.result ? a.result + (a.reason ? ': ' + a.reason : '') : '';
+    cell(row, result, a.result);
+  }
+}
+
+async function refresh() {
+  try {
+    const [doors, events] = await Promise.all([api('GET', '../api/v1/doors'), api('GET', '../api/v1/events?limit=20')]);
+    renderDoors(doors);
+    renderEvents(events);
+    // /healthz replies 503 if a component fails, with the details anyway
+    const health = await fetch('../healthz');
+    renderComponents(await health.json());
+  } catch (err) {
+    if (err.message !== 'logged out') {
+      $('error').textContent = err.message;
+    }
+  }
+}
+
+function showLogin() {
+  clearInterval(timer);
+  timer = null;
+  $('dashboard').hidden = true;
+  $('admin').hidden = true;
+  $('logout').hidden = true;
+  $('login').hidden = false;
+  // The single sign-on link is only offered if wishbone has a provider
+  fetch('oidc').then((resp) => { $('sso').hidden = !resp.ok; }, () => {});
+}
+
+function showDashboard(admin) {
+  $('login').hidden = true;
+  $('admin').textContent = admin;
+  $('admin').hidden = false;
+  $('logout').hidden = false;
+  $('dashboard').hidden = false;
+  refresh();
+  if (timer === null) {
+    timer = setInterval(refresh, pollInterval);
+  }
+}
+
+$('login').addEventListener('submit', async (ev) => {
+  ev.preventDefault();
+  const resp = await fetch('login', {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify({token: $('token').value}),
+  });
+  $('token').value = '';
+  if (!resp.ok) {
+    $('login-error').textContent = (await resp.text()).trim();
+    return;
+  }
+  $('login-error').textContent = '';
+  showDashboard((await resp.json()).admin);
+});
+
+$('logout').addEventListener('click', async () => {
+  await fetch('logout', {method: 'POST'});
+  showLogin();
+});
+
+(async () => {
+  // A failed single sign-on comes back with the reason
+  const params = new URLSearchParams(location.search);
+  if (params.has('sso_error')) {
+    $('login-error').textContent = params.get('sso_error');
+    history.replaceState(null, '', location.pathname);
+  }
+  try {
+    showDashboard((await api('GET', 'session')).admin);
+  } catch (err) {
+    showLogin();
+  }
+})();
@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/doorsensor"
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+// Door is a sphincter controlled by the daemon
+type Door struct {
+	Name     string
+	Actuator *actuator.Watched
+	// Sensor is nil unless a door sensor is wired up
+	Sensor *doorsensor.Sensor
+}
+
+// position returns the position of the door, or "" without a door sensor
+func (d Door) position() string {
+	if d.Sensor == nil {
+		return ""
+	}
+	return d.Sensor.Position().String()
+}
+
+// state returns a state event of the current status of the door, sent to
+// new subscribers
+func (d Door) state() events.Event {
+	return events.Event{Type: events.TypeState, Time: time.Now(), Door: d.Name, Status: d.Actuator.Status().String(), Position: d.position()}
+}
+
+// door returns the door called name, or the first one if name is empty
+func (b Backend) door(name string) (Door, bool) {
+	if name == "" {
+		return b.Doors[0], true
+	}
+	for _, d := range b.Doors {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Door{}, false
+}
+
+type doorStatus struct {
+	Door   string `json:"door"`
+	Status string `json:"status"`
+	// Position is OPEN or CLOSED for doors with a door sensor
+	Position   string     `json:"position,omitempty"`
+	LastChange *time.Time `json:"last_change,omitempty"`
+}
+
+// serveDoorStatus replies with the status of the door in the path
+func serveDoorStatus(b Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := b.door(r.PathValue("door"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, statusOf(d))
+	}
+}
+
+func statusOf(d Door) doorStatus {
+	resp := doorStatus{Door: d.Name, Status: d.Actuator.Status().String(), Position: d.position()}
+	if t := d.Actuator.LastChange(); !t.IsZero() {
+		resp.LastChange = &t
+	}
+	return resp
+}
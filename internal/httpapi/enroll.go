@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// enrollTimeout is how long POST /api/v1/enroll waits for a card
+const enrollTimeout = 60 * time.Second
+
+// ErrEnrollmentRunning is returned while another card is being enrolled
+var ErrEnrollmentRunning = errors.New("another enrollment is running")
+
+// enrollRequest is the body of POST /api/v1/enroll: the user the next
+// unknown card swiped is added for, like with POST /api/v1/users but without
+// the token.
+// With Door, only cards swiped at that door are taken
+type enrollRequest struct {
+	apiUser
+	Door string `json:"door,omitempty"`
+}
+
+// enrollResponse is the credential added, and where its card was swiped
+type enrollResponse struct {
+	apiUser
+	Reader string `json:"reader,omitempty"`
+}
+
+// Replies 201, 400 for invalid users, 404 for unknown doors, 408 if no card
+// was swiped in time and 409 while another enrollment runs or if the card
+// was added meanwhile
+func registerEnrollAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "POST", Path: apiPrefix + "/enroll", Summary: "Add a credential for the next unknown card swiped", Legacy: true,
+		Request: enrollRequest{}, Status: http.StatusCreated, Response: enrollResponse{},
+		Errors: map[int]string{
+			http.StatusBadRequest:     "invalid user",
+			http.StatusNotFound:       "unknown door",
+			http.StatusRequestTimeout: "no card swiped",
+			http.StatusConflict:       "another enrollment is running, or the card was added meanwhile",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		var req enrollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Door != "" {
+			if _, ok := b.door(req.Door); !ok {
+				http.Error(w, "unknown door", http.StatusNotFound)
+				return
+			}
+		}
+		// Check the user before anyone swipes, with a stand-in token
+		u := req.apiUser
+		u.Token, u.Hash = "00", false
+		if _, status, err := u.credential(); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		slog.Info("Waiting for a card to enroll", "admin", admin, "user", req.User, "door", req.Door)
+		ctx, cancel := context.WithTimeout(r.Context(), enrollTimeout)
+		defer cancel()
+		token, reader, err := b.Enroll(ctx, req.Door)
+		switch {
+		case err == ErrEnrollmentRunning:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			slog.Info("No card enrolled", "admin", admin, "user", req.User, "err", err)
+			http.Error(w, "no card swiped", http.StatusRequestTimeout)
+			return
+		}
+
+		u = req.apiUser
+		u.Token = token
+		c, status, err := u.credential()
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if !addCredential(w, b, admin, c) {
+			return
+		}
+		u.Token, u.User, u.PIN, u.Groups, u.Hash = c.Token, c.User, c.PIN, c.Groups, false
+		writeJSON(w, http.StatusCreated, enrollResponse{apiUser: u, Reader: reader})
+	})
+}
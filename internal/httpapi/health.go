@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+)
+
+// Check is the health of one component on /healthz
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type health struct {
+	OK         bool             `json:"ok"`
+	Components map[string]Check `json:"components"`
+}
+
+// serveHealth replies with the health of the doors, the credential store and
+// the components reported by b.Health. The status is 200 if all are healthy,
+// 503 otherwise, for uptime monitors and liveness probes
+func serveHealth(b Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		components := map[string]Check{}
+		if b.Health != nil {
+			for name, c := range b.Health() {
+				components[name] = c
+			}
+		}
+		for _, d := range b.Doors {
+			status := d.Actuator.Status()
+			components["door:"+d.Name] = Check{OK: status != actuator.StatusFailure, Detail: status.String()}
+		}
+		if err := b.Store.Check(); err != nil {
+			components["store"] = Check{Detail: err.Error()}
+		} else {
+			components["store"] = Check{OK: true}
+		}
+
+		resp := health{OK: true, Components: components}
+		for _, c := range components {
+			resp.OK = resp.OK && c.OK
+		}
+		code := http.StatusOK
+		if !resp.OK {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, resp)
+	}
+}
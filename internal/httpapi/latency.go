@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+)
+
+// latencyReport is the timing of the recent swipes, as served by
+// GET /api/v1/debug/latency. Durations are in milliseconds
+type latencyReport struct {
+	// Stages summarizes every stage, in the order they are passed
+	Stages []stageLatency `json:"stages"`
+	// Swipes are the recent swipes, newest first
+	Swipes []swipeLatency `json:"swipes"`
+}
+
+type stageLatency struct {
+	Stage  string  `json:"stage"`
+	Count  int     `json:"count"`
+	Median float64 `json:"median_ms"`
+	P90    float64 `json:"p90_ms"`
+	Max    float64 `json:"max_ms"`
+}
+
+// swipeLatency is the timing of a swipe. Stages holds only the stages it
+// passed, e.g. no actuator for rejected tokens
+type swipeLatency struct {
+	Time   time.Time          `json:"time"`
+	Door   string             `json:"door"`
+	Reader string             `json:"reader,omitempty"`
+	Store  string             `json:"store"`
+	Result string             `json:"result"`
+	Stages map[string]float64 `json:"stages_ms"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func registerLatencyAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/debug/latency", Summary: "Time taken by the stages of the recent swipes, from reading the token to opening",
+		Status: http.StatusOK, Response: latencyReport{},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		report := latencyReport{Stages: []stageLatency{}, Swipes: []swipeLatency{}}
+		for _, s := range b.Latency.Summarize() {
+			report.Stages = append(report.Stages, stageLatency{
+				Stage: s.Stage.String(), Count: s.Count,
+				Median: milliseconds(s.Median), P90: milliseconds(s.P90), Max: milliseconds(s.Max),
+			})
+		}
+		for _, t := range b.Latency.Recent() {
+			swipe := swipeLatency{Time: t.Start, Door: t.Door, Reader: t.Reader, Store: t.Store, Result: t.Result, Stages: map[string]float64{}}
+			for stage, d := range t.Stages {
+				swipe.Stages[stage.String()] = milliseconds(d)
+			}
+			report.Swipes = append(report.Swipes, swipe)
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+}
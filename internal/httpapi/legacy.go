@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+)
+
+// LegacyProfile shapes the plain text replies of /sphincter/?action= to what
+// clients of the Python sphincter expect, down to the exact text. Texts may
+// contain {status}, the text of the door's status, {user} and {error}
+type LegacyProfile struct {
+	// Case is upper or lower to change the case of the replies, or empty to
+	// keep it
+	Case string `yaml:"case"`
+	// States are the texts of the statuses, like LOCKED. Statuses missing
+	// are replied as they are
+	States map[string]string `yaml:"states"`
+	// Replies are by the status the JSON API replies with. Default is
+	// replied for the statuses missing
+	Replies map[int]LegacyReply `yaml:"replies"`
+	Default LegacyReply         `yaml:"default"`
+}
+
+// LegacyReply is the reply of a legacy profile for a status
+type LegacyReply struct {
+	Text string `yaml:"text"`
+	// Status is the HTTP status replied instead of the one of the JSON API,
+	// unless it is 0
+	Status int `yaml:"status"`
+}
+
+// DefaultLegacyProfile replies the status after a success, like UNLOCKED,
+// and the error otherwise, with the statuses of the JSON API
+func DefaultLegacyProfile() LegacyProfile {
+	return LegacyProfile{
+		Replies: map[int]LegacyReply{http.StatusOK: {Text: "{status}"}},
+		Default: LegacyReply{Text: "{error}"},
+	}
+}
+
+func (p LegacyProfile) validate() error {
+	if p.Case != "" && p.Case != "upper" && p.Case != "lower" {
+		return fmt.Errorf("invalid case %q of the legacy profile, expected upper or lower", p.Case)
+	}
+	for status, reply := range p.Replies {
+		if status < 100 || status > 599 || reply.Status != 0 && (reply.Status < 100 || reply.Status > 599) {
+			return fmt.Errorf("invalid status in reply %d of the legacy profile", status)
+		}
+	}
+	if s := p.Default.Status; s != 0 && (s < 100 || s > 599) {
+		return fmt.Errorf("invalid status %d of the default reply of the legacy profile", s)
+	}
+	return nil
+}
+
+// write replies to a legacy request with the text of the profile for status
+func (p LegacyProfile) write(w http.ResponseWriter, status int, resp controlResponse) {
+	reply, ok := p.Replies[status]
+	if !ok {
+		reply = p.Default
+	}
+	state, ok := p.States[resp.Status]
+	if !ok {
+		state = resp.Status
+	}
+	text := strings.NewReplacer("{status}", state, "{user}", resp.User, "{error}", resp.Error).Replace(reply.Text)
+	switch p.Case {
+	case "upper":
+		text = strings.ToUpper(text)
+	case "lower":
+		text = strings.ToLower(text)
+	}
+	if reply.Status != 0 {
+		status = reply.Status
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, text)
+}
+
+// decodeLegacyControl reads a control request from the query or form, as
+// token, code, pin, door and keep_open
+func decodeLegacyControl(r *http.Request) (controlRequest, error) {
+	req := controlRequest{Token: r.FormValue("token"), Code: r.FormValue("code"), PIN: r.FormValue("pin"), Door: r.FormValue("door")}
+	if keepOpen := r.FormValue("keep_open"); keepOpen != "" {
+		var err error
+		if req.KeepOpen, err = strconv.ParseBool(keepOpen); err != nil {
+			return req, err
+		}
+	}
+	if req.Token == "" && req.Code == "" {
+		return req, io.EOF
+	}
+	return req, nil
+}
+
+// registerLegacyAPI serves the actions of the Python sphincter on
+// /sphincter/?action=, state, open and close, replying plain text as given
+// by p. Opening and closing are served like /api/v1/unlock and /lock
+func registerLegacyAPI(api *router, p LegacyProfile) {
+	b := api.b
+	f := controlFormat{decode: decodeLegacyControl, write: p.write}
+	unlock := serveControl(b, api.keys, "open", actuator.StatusUnlocked, f)
+	lock := serveControl(b, api.keys, "close", actuator.StatusLocked, f)
+	state := api.requireScope(ScopeStateRead, func(w http.ResponseWriter, r *http.Request) {
+		d, ok := b.door(r.FormValue("door"))
+		if !ok {
+			p.write(w, http.StatusNotFound, controlResponse{Status: actuator.StatusUnknown.String(), Error: "unknown door"})
+			return
+		}
+		p.write(w, http.StatusOK, controlResponse{Door: d.Name, Status: d.Actuator.Status().String()})
+	})
+	h := func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("action") {
+		case "state":
+			state(w, r)
+		case "open":
+			unlock(w, r)
+		case "close":
+			lock(w, r)
+		default:
+			p.write(w, http.StatusBadRequest, controlResponse{Status: b.Doors[0].Actuator.Status().String(), Error: "unknown action"})
+		}
+	}
+	for _, method := range []string{"GET", "POST"} {
+		// Only /sphincter/ itself, the status of doors is served below it
+		api.mux.HandleFunc(method+" /sphincter/{$}", h)
+		api.routes = append(api.routes, route{
+			Method: method, Path: "/sphincter/", Summary: "State, open or close a door like the Python sphincter, in plain text",
+			Query: []string{"action", "token", "pin", "code", "door", "keep_open"}, Status: http.StatusOK, ContentType: "text/plain",
+			Errors: map[int]string{
+				http.StatusBadRequest: "unknown action or invalid request; the other statuses are those of /api/v1/unlock and /api/v1/lock, unless the profile replaces them",
+			},
+		})
+	}
+}
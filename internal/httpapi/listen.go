@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// unixPrefix marks listen addresses that are Unix domain sockets
+const unixPrefix = "unix:"
+
+// socketMode lets the group of the daemon connect to its Unix sockets, e.g.
+// a reverse proxy
+const socketMode = 0660
+
+// SplitAddrs splits a comma separated list of listen addresses
+func SplitAddrs(addrs string) []string {
+	var list []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			list = append(list, addr)
+		}
+	}
+	return list
+}
+
+// SocketPath returns the path of a Unix domain socket address like
+// unix:/run/wishbone.sock
+func SocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixPrefix), true
+}
+
+// listen opens a listener for each of addrs: host:port, with IPv6 hosts in
+// brackets, INTERFACE:port for every address of a network interface, or
+// unix:PATH. Listeners opened before an error are closed
+func listen(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, addr := range addrs {
+		expanded, err := expandInterface(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		for _, addr := range expanded {
+			l, err := listenOne(addr)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			listeners = append(listeners, l)
+		}
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no address to listen on")
+	}
+	return listeners, nil
+}
+
+func listenOne(addr string) (net.Listener, error) {
+	path, ok := SocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	// A socket left behind by a crash would make listening fail
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// expandInterface returns an address for each IP address of the network
+// interface named by the host of addr, or addr itself if its host is no
+// interface
+func expandInterface(addr string) ([]string, error) {
+	if _, ok := SocketPath(addr); ok {
+		return []string{addr}, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		// A host name
+		return []string{addr}, nil
+	}
+	ifaddrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, a := range ifaddrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP.String()
+		if ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+			ip += "%" + iface.Name
+		}
+		list = append(list, net.JoinHostPort(ip, port))
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("interface %s has no addresses", iface.Name)
+	}
+	return list, nil
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/craftamap/wishbone/internal/lockdown"
+)
+
+// lockdownRequest is the body of POST /api/v1/lockdown. Without a door, all
+// doors are locked down
+type lockdownRequest struct {
+	Door   string `json:"door,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Replies 204, 404 for unknown doors or lockdowns that aren't engaged, and
+// 503 if a door couldn't be closed, which leaves the lockdown engaged
+func registerLockdownAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/lockdown", Summary: "Engaged lockdowns", Legacy: true,
+		Status: http.StatusOK, Response: []lockdown.Lockdown{},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		writeJSON(w, http.StatusOK, b.Lockdown.List())
+	})
+
+	api.handleAdmin(route{
+		Method: "POST", Path: apiPrefix + "/lockdown", Summary: "Lock down a door, or all doors, and close them", Legacy: true,
+		Request: lockdownRequest{}, Status: http.StatusNoContent,
+		Errors: map[int]string{
+			http.StatusBadRequest:         "invalid request",
+			http.StatusNotFound:           "unknown door",
+			http.StatusServiceUnavailable: "a door couldn't be closed, the lockdown is engaged anyway",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		var req lockdownRequest
+		// An empty body locks down all doors
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Door != "" {
+			if _, ok := b.door(req.Door); !ok {
+				http.Error(w, "unknown door", http.StatusNotFound)
+				return
+			}
+		}
+		if err := b.EngageLockdown(r.Context(), req.Door, admin, req.Reason); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	lift := func(w http.ResponseWriter, r *http.Request, admin string) {
+		door := r.PathValue("door")
+		if door != "" {
+			if _, ok := b.door(door); !ok {
+				http.Error(w, "unknown door", http.StatusNotFound)
+				return
+			}
+		}
+		err := b.LiftLockdown(door, admin)
+		switch {
+		case errors.Is(err, lockdown.ErrNotEngaged):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	liftErrors := map[int]string{http.StatusNotFound: "unknown door, or no lockdown engaged"}
+	api.handleAdmin(route{
+		Method: "DELETE", Path: apiPrefix + "/lockdown", Summary: "Lift the lockdown of all doors", Legacy: true,
+		Status: http.StatusNoContent, Errors: liftErrors,
+	}, lift)
+	api.handleAdmin(route{
+		Method: "DELETE", Path: apiPrefix + "/lockdown/{door}", Summary: "Lift the lockdown of a door", Legacy: true,
+		Status: http.StatusNoContent, Errors: liftErrors,
+	}, lift)
+}
@@ -0,0 +1,251 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the prefix of the versioned API
+const apiPrefix = "/api/v1"
+
+// route describes an endpoint for the OpenAPI document
+type route struct {
+	// Method and Path, like GET and /api/v1/users/{token}
+	Method string
+	Path   string
+	// Summary is the first line of the endpoint's documentation
+	Summary string
+	// Query are the names of the optional query parameters
+	Query []string
+	// Request is a value of the type of the JSON body, nil without a body
+	Request interface{}
+	// Status is the status of a success. It comes with a JSON encoded value
+	// of the type of Response, unless it is nil
+	Status   int
+	Response interface{}
+	// ContentType of the response, if it isn't JSON
+	ContentType string
+	// Errors describes the error statuses
+	Errors map[int]string
+	// Legacy routes are served under /api as well, as they were before the
+	// API was versioned
+	Legacy bool
+	// Scope lets API keys granting it use an admin route besides those
+	// granting ScopeAdmin
+	Scope string
+
+	admin bool
+}
+
+// router registers the endpoints on a mux and keeps their routes for the
+// OpenAPI document
+type router struct {
+	mux  *http.ServeMux
+	auth *adminAuth
+	keys apiKeys
+	// privateReads requires API keys for the public status and event
+	// endpoints
+	privateReads bool
+	b            Backend
+	routes       []route
+}
+
+// handle serves rt with h
+func (a *router) handle(rt route, h http.HandlerFunc) {
+	a.mux.HandleFunc(rt.Method+" "+rt.Path, h)
+	if rt.Legacy {
+		a.mux.HandleFunc(rt.Method+" /api"+strings.TrimPrefix(rt.Path, apiPrefix), h)
+	}
+	a.routes = append(a.routes, rt)
+}
+
+// handleAdmin serves rt with h to admins, see requireAdmin
+func (a *router) handleAdmin(rt route, h func(w http.ResponseWriter, r *http.Request, admin string)) {
+	rt.admin = true
+	// Copied, as routes may share their errors
+	errs := map[int]string{}
+	for status, desc := range rt.Errors {
+		errs[status] = desc
+	}
+	rt.Errors = errs
+	rt.Errors[http.StatusUnauthorized] = "invalid admin token"
+	rt.Errors[http.StatusForbidden] = "admin not permitted"
+	rt.Errors[http.StatusTooManyRequests] = "client locked out"
+	if len(a.keys) > 0 {
+		rt.Errors[http.StatusUnauthorized] = "invalid admin token or API key"
+		rt.Errors[http.StatusForbidden] = "admin not permitted, or API key lacks the scope"
+		rt.Errors[http.StatusTooManyRequests] = "client locked out, or API key rate limited"
+	}
+	a.handle(rt, requireAdmin(a.auth, a.b, rt.Scope, h))
+}
+
+// serveOpenAPI serves the OpenAPI document of the routes registered so far
+func (a *router) serveOpenAPI() error {
+	doc, err := json.MarshalIndent(openAPIDocument(a.routes), "", "  ")
+	if err != nil {
+		return err
+	}
+	a.mux.HandleFunc("GET "+apiPrefix+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+	return nil
+}
+
+// object is a JSON object of the OpenAPI document
+type object = map[string]interface{}
+
+// openAPIDocument returns an OpenAPI 3 document describing routes, with the
+// schemas of their bodies generated from the Go types
+func openAPIDocument(routes []route) object {
+	schemas := object{}
+	paths := object{}
+	for _, rt := range routes {
+		op := object{"summary": rt.Summary}
+		var params []object
+		for _, name := range pathParams(rt.Path) {
+			params = append(params, object{"name": name, "in": "path", "required": true, "schema": object{"type": "string"}})
+		}
+		for _, name := range rt.Query {
+			params = append(params, object{"name": name, "in": "query", "schema": object{"type": "string"}})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = object{
+				"required": true,
+				"content":  object{"application/json": object{"schema": schemaOf(reflect.TypeOf(rt.Request), schemas)}},
+			}
+		}
+		ok := object{"description": http.StatusText(rt.Status)}
+		switch {
+		case rt.ContentType != "":
+			ok["content"] = object{rt.ContentType: object{}}
+			if rt.Response != nil {
+				ok["content"] = object{rt.ContentType: object{"schema": schemaOf(reflect.TypeOf(rt.Response), schemas)}}
+			}
+		case rt.Response != nil:
+			ok["content"] = object{"application/json": object{"schema": schemaOf(reflect.TypeOf(rt.Response), schemas)}}
+		}
+		responses := object{strconv.Itoa(rt.Status): ok}
+		for status, desc := range rt.Errors {
+			responses[strconv.Itoa(status)] = object{"description": desc}
+		}
+		op["responses"] = responses
+		if rt.admin {
+			op["security"] = []object{{"bearer": []string{}}}
+		}
+
+		item, _ := paths[rt.Path].(object)
+		if item == nil {
+			item = object{}
+			paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "wishbone",
+			"version":     "1",
+			"description": "Controls the sphincters of a wishbone daemon and reports on them",
+		},
+		// Relative to the document, so a path prefix of a proxy is kept
+		"servers": []object{{"url": "../.."}},
+		"paths":   paths,
+		"components": object{
+			"schemas": schemas,
+			"securitySchemes": object{
+				"bearer": object{"type": "http", "scheme": "bearer", "description": "admin token, see -admin-tokens"},
+			},
+		},
+	}
+}
+
+// pathParams returns the names of the wildcards in path
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, strings.TrimSuffix(segment[1:], "}"))
+		}
+	}
+	return names
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of values of t encoded as JSON. Structs are
+// added to schemas by name and referred to
+func schemaOf(t reflect.Type, schemas object) object {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return object{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		if _, ok := schemas[name]; !ok {
+			// Placed before the properties, so recursive types end
+			schemas[name] = object{}
+			schemas[name] = structSchema(t, schemas)
+		}
+		return object{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return object{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return object{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.String:
+		return object{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return object{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return object{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return object{"type": "number"}
+	}
+	return object{}
+}
+
+// structSchema returns the schema of a struct, with the fields of embedded
+// structs inlined like encoding/json does. No field is marked required, as
+// requests and responses share types
+func structSchema(t reflect.Type, schemas object) object {
+	props := object{}
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.Anonymous && tag == "" {
+				add(f.Type)
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOf(f.Type, schemas)
+		}
+	}
+	add(t)
+	return object{"type": "object", "properties": props}
+}
+
+// schemaName names the schema of t like an exported Go type
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Object"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrNoOpenHours is returned when overriding the open hours of a door that
+// has none
+var ErrNoOpenHours = errors.New("door has no open hours")
+
+// OpenHoursStatus is the state of the open hours of a door
+type OpenHoursStatus struct {
+	Door string `json:"door"`
+	// Hours are the time windows the door is open to the public
+	Hours string `json:"hours"`
+	// Open is whether the door is open to the public right now, ignoring
+	// the override
+	Open    bool `json:"open"`
+	Holiday bool `json:"holiday,omitempty"`
+	// Override is open or closed while the schedule is suspended
+	Override string `json:"override,omitempty"`
+}
+
+// openHoursRequest is the body of PUT /api/v1/open-hours/{door}. An empty
+// override resumes the schedule
+type openHoursRequest struct {
+	Override string `json:"override"`
+}
+
+func registerOpenHoursAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/open-hours", Summary: "Open hours of the doors having them", Legacy: true,
+		Status: http.StatusOK, Response: []OpenHoursStatus{},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		list := b.OpenHours()
+		if list == nil {
+			list = []OpenHoursStatus{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	// Replies 204, 400 for invalid overrides, 404 for doors without open
+	// hours and 503 if the door couldn't be driven
+	api.handleAdmin(route{
+		Method: "PUT", Path: apiPrefix + "/open-hours/{door}", Summary: "Override the open hours of a door, or resume them", Legacy: true,
+		Request: openHoursRequest{}, Status: http.StatusNoContent,
+		Errors: map[int]string{
+			http.StatusBadRequest:         "invalid override",
+			http.StatusNotFound:           "door has no open hours",
+			http.StatusServiceUnavailable: "door couldn't be driven",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		var req openHoursRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Override != "" && req.Override != "open" && req.Override != "closed" {
+			http.Error(w, "invalid override, expected open, closed or empty", http.StatusBadRequest)
+			return
+		}
+		err := b.OverrideOpenHours(r.Context(), r.PathValue("door"), req.Override, admin)
+		switch {
+		case err == ErrNoOpenHours:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/craftamap/wishbone/internal/passback"
+)
+
+// Replies to resets with 204, or 404 if the user isn't inside
+func registerPassbackAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/passback", Summary: "Users inside, longest inside first", Legacy: true,
+		Status: http.StatusOK, Response: []passback.Presence{},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		writeJSON(w, http.StatusOK, b.Passback.Inside())
+	})
+
+	// Lets everyone enter again, e.g. every night
+	api.handleAdmin(route{
+		Method: "DELETE", Path: apiPrefix + "/passback", Summary: "Let everyone enter again", Legacy: true,
+		Status: http.StatusNoContent,
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		n := b.Passback.Reset("")
+		slog.Info("Reset anti-passback", "admin", admin, "tokens", n)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Lets a user who left without swiping enter again
+	api.handleAdmin(route{
+		Method: "DELETE", Path: apiPrefix + "/passback/{user}", Summary: "Let a user who left without swiping enter again", Legacy: true,
+		Status: http.StatusNoContent, Errors: map[int]string{http.StatusNotFound: "user not inside"},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		user := r.PathValue("user")
+		n := b.Passback.Reset(user)
+		if n == 0 {
+			http.Error(w, "user not inside", http.StatusNotFound)
+			return
+		}
+		slog.Info("Reset anti-passback", "admin", admin, "user", user, "tokens", n)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/gorilla/websocket"
+)
+
+// ErrUnknownReader is returned for passthroughs to readers that don't exist
+// or aren't serial readers
+var ErrUnknownReader = errors.New("unknown serial reader")
+
+// wsWriter sends what is written to it as binary WebSocket messages. Until
+// the connection is set, writes are dropped
+type wsWriter struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return len(p), nil
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *wsWriter) setConn(conn *websocket.Conn) {
+	w.mu.Lock()
+	w.conn = conn
+	w.mu.Unlock()
+}
+
+// The bytes received from the reader are sent as binary messages; text and
+// binary messages from the client are sent to the reader as is. The reader
+// reads no tokens until the connection is closed
+func registerPassthroughAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/passthrough", Summary: "WebSocket bridging raw bytes to and from a serial reader in maintenance mode",
+		Query: []string{"reader"}, Status: http.StatusSwitchingProtocols,
+		Errors: map[int]string{
+			http.StatusNotFound: "unknown serial reader, or none given and there are several",
+			http.StatusConflict: "another passthrough to the reader is running",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		name := r.FormValue("reader")
+		out := &wsWriter{}
+		pt, err := b.Passthrough(name, out)
+		switch {
+		case errors.Is(err, ErrUnknownReader):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, reader.ErrPassthroughRunning):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer pt.Close()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade already replied with an error
+			return
+		}
+		defer conn.Close()
+		out.setConn(conn)
+		slog.Warn("Reader passthrough started", "admin", admin, "reader", name, "client", r.RemoteAddr)
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ping := time.NewTicker(30 * time.Second)
+			defer ping.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ping.C:
+					conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+				}
+			}
+		}()
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			if _, err := pt.Write(data); err != nil {
+				slog.Warn("Could not write to reader", "reader", name, "err", err)
+				msg := websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error())
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				break
+			}
+		}
+		slog.Info("Reader passthrough ended", "admin", admin, "reader", name)
+	})
+}
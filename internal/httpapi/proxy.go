@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies parses comma separated IP addresses and CIDR ranges,
+// like 127.0.0.1,10.0.0.0/8
+func ParseTrustedProxies(text string) ([]netip.Prefix, error) {
+	var list []netip.Prefix
+	for _, s := range strings.Split(text, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", s)
+			}
+			list = append(list, p.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", s)
+		}
+		list = append(list, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+	}
+	return list, nil
+}
+
+// checkPathPrefix checks that prefix, if set, is a path like /door
+func checkPathPrefix(prefix string) error {
+	if prefix != "" && (!strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/")) {
+		return errors.New("path-prefix must start and must not end with /")
+	}
+	return nil
+}
+
+// trusted reports whether ip is one of the proxies
+func trusted(ip netip.Addr, proxies []netip.Prefix) bool {
+	ip = ip.Unmap()
+	for _, p := range proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient returns the client a proxy forwarded r for: the last
+// address in X-Forwarded-For not of a trusted proxy, or X-Real-IP
+func forwardedClient(r *http.Request, proxies []netip.Prefix) (netip.Addr, bool) {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	if len(hops) == 0 {
+		ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+		return ip.Unmap(), err == nil
+	}
+	// Proxies append the address they were connected from, so the hops
+	// before the first untrusted one from the right may be forged
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = ip.Unmap()
+		if !trusted(client, proxies) {
+			break
+		}
+	}
+	return client, client.IsValid()
+}
+
+// realClient sets the remote address of requests from trusted proxies to the
+// client they were forwarded for, so it is logged and locked out instead of
+// the proxy. Peers on Unix sockets are trusted, as only proxies allowed by
+// the socket's permissions can connect
+func realClient(h http.Handler, proxies []netip.Prefix) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil || trusted(peer.Addr(), proxies) {
+			if client, ok := forwardedClient(r, proxies); ok {
+				r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrInvalidConfig is returned by reloads of a config that doesn't
+	// validate
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrRestartRequired is returned by reloads of a config changing
+	// options that only take effect when the daemon restarts
+	ErrRestartRequired = errors.New("options changed that need a restart")
+)
+
+// ReloadReport tells what a reload changed
+type ReloadReport struct {
+	// Changed are the options changed, with their new values
+	Changed map[string]string `json:"changed"`
+	// Credentials counts the credentials changed, unless the store can't
+	// list them
+	Credentials *CredentialChanges `json:"credentials,omitempty"`
+}
+
+// CredentialChanges counts the credentials changed by a reload, by token
+type CredentialChanges struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+// Replies 200, 400 if the config is invalid, 409 if it changes options that
+// need a restart and 500 if the credentials couldn't be read. Nothing is
+// applied unless it replies 200
+func registerReloadAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "POST", Path: apiPrefix + "/reload", Summary: "Re-read the config file and the credentials, applying them if they are valid", Legacy: true,
+		Status: http.StatusOK, Response: ReloadReport{},
+		Errors: map[int]string{
+			http.StatusBadRequest:          "invalid config, nothing was applied",
+			http.StatusConflict:            "options changed that need a restart, nothing was applied",
+			http.StatusInternalServerError: "config or credentials couldn't be read, nothing was applied",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		report, err := b.Reload(r.Context(), admin)
+		switch {
+		case errors.Is(err, ErrInvalidConfig):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrRestartRequired):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+}
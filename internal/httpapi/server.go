@@ -0,0 +1,406 @@
+// Package httpapi serves metrics, live events, the admin API and the
+// SpaceAPI document over HTTP
+package httpapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/alarm"
+	"github.com/craftamap/wishbone/internal/decision"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/latency"
+	"github.com/craftamap/wishbone/internal/lockdown"
+	"github.com/craftamap/wishbone/internal/passback"
+	"github.com/craftamap/wishbone/internal/ratelimit"
+	"github.com/craftamap/wishbone/internal/reader"
+	"github.com/craftamap/wishbone/internal/store"
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config configures the HTTP server. Optional endpoints are disabled by
+// leaving their options empty
+type Config struct {
+	// Addr is a comma separated list of addresses to listen on, e.g.
+	// 127.0.0.1:8001,[::1]:8001, eth0:8001 for the addresses of an
+	// interface or unix:/run/wishbone/http.sock
+	Addr string
+	// TLSCert and TLSKey switch to HTTPS. With TLSClientCA, clients must
+	// present a certificate signed by that CA
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+	// AdminTokens is the file holding the admin API tokens
+	AdminTokens string
+	// APIKeys is the file holding the API keys, restricted to their scopes
+	// and rate limits
+	APIKeys string
+	// PrivateReads requires an API key for the status of the doors and the
+	// event streams
+	PrivateReads bool
+	// Simulate enables POST /simulate/swipe
+	Simulate bool
+	SpaceAPI SpaceAPI
+	// CodeMaxValidity caps how long one-time codes are valid
+	CodeMaxValidity time.Duration
+	// TrustedProxies are the comma separated addresses and CIDR ranges of
+	// reverse proxies whose X-Forwarded-For and X-Real-IP are believed
+	TrustedProxies string
+	// PathPrefix, like /door, is stripped from the paths of all requests,
+	// for proxies forwarding a path of their own
+	PathPrefix string
+	// OIDC lets admins log in through an identity provider, which enables
+	// the admin API even without AdminTokens
+	OIDC OIDCConfig
+	// LegacyAPI serves the actions of the Python sphincter on
+	// /sphincter/?action=, replying as given by the profile, unless it is
+	// nil
+	LegacyAPI *LegacyProfile
+}
+
+// Validate checks that the TLS options are consistent, and the proxy options
+// valid
+func (c Config) Validate() error {
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+	if err := checkPathPrefix(c.PathPrefix); err != nil {
+		return err
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tls-cert and tls-key must be set together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		return errors.New("tls-client-ca requires tls-cert")
+	}
+	if c.PrivateReads && c.APIKeys == "" {
+		return errors.New("private-reads requires api-keys")
+	}
+	if c.LegacyAPI != nil {
+		if err := c.LegacyAPI.validate(); err != nil {
+			return err
+		}
+	}
+	return c.OIDC.validate()
+}
+
+// Backend is what the HTTP API controls and reports on
+type Backend struct {
+	// Doors are the sphincters by name. Requests not naming a door are
+	// for the first one
+	Doors  []Door
+	Store  store.CredentialStore
+	Events *events.Hub
+	// LookupTimeout limits looking up tokens in Store; unlimited if zero
+	LookupTimeout time.Duration
+	// Swipes receives simulated swipes
+	Swipes chan<- reader.Swipe
+	// Keys receives simulated keys, if a keypad is set up
+	Keys chan<- rune
+	// Command runs an open, keep-open or close command for door on behalf
+	// of user and records it. It gives up when ctx is done, e.g. because the
+	// client went away
+	Command func(ctx context.Context, door, source, user, cmd string) error
+	// Record records access attempts rejected by the API
+	Record func(events.Access)
+	// Lockout blocks clients by address after too many failed attempts; may
+	// be nil
+	Lockout *ratelimit.Lockout
+	// LogLevel is changed on /api/v1/log-level; the endpoint is disabled if
+	// nil
+	LogLevel *slog.LevelVar
+	// Codes are the one-time codes members hand out on /api/v1/codes, which
+	// is disabled if nil
+	Codes *store.Codes
+	// Health reports the components /healthz can't check itself, like
+	// readers, by name; may be nil
+	Health func() map[string]Check
+	// Permissions return the groups unlocking, locking, keeping open and
+	// the admin API are restricted to, which may change on reloads
+	Permissions func() store.Permissions
+	// FailPolicy returns what restricts unlocking while a door's status is
+	// UNKNOWN or FAILURE, which may change on reloads
+	FailPolicy func() store.FailPolicy
+	// OpenHours reports the open hours of the doors having them, and
+	// OverrideOpenHours overrides them on behalf of admin. Their admin
+	// endpoints are disabled if nil
+	OpenHours         func() []OpenHoursStatus
+	OverrideOpenHours func(ctx context.Context, door, override, admin string) error
+	// Enroll waits for the next unknown token swiped at door, or at any
+	// door if it is empty, until ctx is done. POST /api/v1/enroll is
+	// disabled if nil
+	Enroll func(ctx context.Context, door string) (token, reader string, err error)
+	// Stats counts the unlocks and locks for /api/v1/stats, which is
+	// disabled if nil
+	Stats *events.Stats
+	// Passback tracks the tokens inside for /api/v1/passback, which is
+	// disabled if nil
+	Passback *passback.Tracker
+	// Lockdown holds the engaged lockdowns, which the unlock API checks
+	// tokens against; may be nil. EngageLockdown and LiftLockdown engage
+	// and lift a lockdown of a door, or of all doors if door is empty, on
+	// behalf of admin. /api/v1/lockdown is disabled if they are nil
+	Lockdown       *lockdown.State
+	EngageLockdown func(ctx context.Context, door, admin, reason string) error
+	LiftLockdown   func(door, admin string) error
+	// AccessHook has the final say on unlocks by the unlock API; may be nil
+	AccessHook *decision.Hook
+	// AppTokens are the members' app tokens, which unlock on
+	// /api/v1/unlock and are managed on /api/v1/app-tokens. Both are
+	// disabled if nil
+	AppTokens *store.AppTokens
+	// Latency keeps the timing of the recent swipes for
+	// /api/v1/debug/latency, which is disabled if nil
+	Latency *latency.Recorder
+	// Passthrough puts the serial reader called name, or the only one if
+	// name is empty, in maintenance mode, handing what it receives to
+	// received until the returned passthrough is closed. Writes to it go to
+	// the reader. /api/v1/passthrough is disabled if nil
+	Passthrough func(name string, received io.Writer) (io.WriteCloser, error)
+	// Slack serves the slash command of the Slack app on POST
+	// /slack/command, which is disabled if nil. It checks the signatures
+	// of the requests itself
+	Slack http.Handler
+	// Alarm holds unlocks while the burglar alarm is armed. When
+	// simulating, its state is set on POST /simulate/alarm, which is
+	// disabled if nil
+	Alarm *alarm.Interlock
+	// Reload re-reads the config file and the credentials on behalf of
+	// admin, applying them all or, if it fails, none.
+	// /api/v1/reload is disabled if nil
+	Reload func(ctx context.Context, admin string) (ReloadReport, error)
+}
+
+// Server is the HTTP server of the daemon. Until SetReady is called, it
+// replies 503 to every request, so nothing is unlocked before the daemon
+// finished starting
+type Server struct {
+	srv   *http.Server
+	cfg   Config
+	ready atomic.Bool
+}
+
+// NewHandler returns the handler for all endpoints enabled in cfg. The API
+// is served under /api/v1, described by /api/v1/openapi.json
+func NewHandler(cfg Config, b Backend) (http.Handler, error) {
+	mux := http.NewServeMux()
+	api := &router{mux: mux, b: b, privateReads: cfg.PrivateReads}
+	if cfg.APIKeys != "" {
+		var err error
+		if api.keys, err = parseAPIKeys(cfg.APIKeys); err != nil {
+			return nil, err
+		}
+	}
+	mux.Handle("/metrics", promhttp.Handler())
+	api.handle(route{
+		Method: "GET", Path: "/healthz", Summary: "Health of the doors, the credential store and the readers",
+		Status: http.StatusOK, Response: health{},
+		Errors: map[int]string{http.StatusServiceUnavailable: "a component is unhealthy, with the same body"},
+	}, serveHealth(b))
+	mux.Handle("GET /sphincter/ws", api.requireScope(ScopeEventsRead, serveWebSocket(b.Doors[0], b.Events, false)))
+	api.handle(route{
+		Method: "GET", Path: "/sphincter/{door}", Summary: "Status of a door",
+		Status: http.StatusOK, Response: doorStatus{},
+		Errors: map[int]string{http.StatusNotFound: "unknown door"},
+	}, api.requireScope(ScopeStateRead, serveDoorStatus(b)))
+	mux.Handle("GET /sphincter/{door}/ws", api.requireScope(ScopeEventsRead, serveDoorWebSocket(b)))
+	api.handle(route{
+		Method: "GET", Path: "/sphincter/events", Summary: "Server-Sent Events of all doors, named by their type",
+		Status: http.StatusOK, ContentType: "text/event-stream", Response: events.Event{},
+	}, api.requireScope(ScopeEventsRead, serveEvents(b.Doors[0], b.Events, false)))
+	api.handle(route{
+		Method: "GET", Path: "/sphincter/{door}/events", Summary: "Server-Sent Events of a door, named by their type",
+		Status: http.StatusOK, ContentType: "text/event-stream", Response: events.Event{},
+		Errors: map[int]string{http.StatusNotFound: "unknown door"},
+	}, api.requireScope(ScopeEventsRead, serveDoorEvents(b)))
+	registerControlAPI(api)
+	if cfg.LegacyAPI != nil {
+		registerLegacyAPI(api, *cfg.LegacyAPI)
+	}
+	if b.Codes != nil {
+		api.handle(route{
+			Method: "POST", Path: apiPrefix + "/codes", Summary: "Issue a one-time code unlocking on behalf of a member",
+			Request: codeRequest{}, Status: http.StatusCreated, Response: store.Code{},
+			Errors: map[int]string{
+				http.StatusBadRequest:      "invalid request",
+				http.StatusUnauthorized:    "token of no member, or wrong PIN",
+				http.StatusForbidden:       "member may not unlock",
+				http.StatusNotFound:        "unknown door",
+				http.StatusConflict:        "member has too many active codes",
+				http.StatusTooManyRequests: "client locked out",
+			},
+		}, serveIssueCode(b, cfg.CodeMaxValidity))
+	}
+	if cfg.Simulate {
+		mux.Handle("POST /simulate/swipe", serveSimulatedSwipe(b.Swipes))
+		mux.Handle("POST /simulate/door", serveSimulatedPosition(b))
+		if b.Keys != nil {
+			mux.Handle("POST /simulate/key", serveSimulatedKeys(b.Keys))
+		}
+		if b.Alarm != nil {
+			mux.Handle("POST /simulate/alarm", serveSimulatedAlarm(b.Alarm))
+		}
+	}
+	if b.Slack != nil {
+		mux.Handle("POST /slack/command", b.Slack)
+	}
+	if cfg.SpaceAPI.Space != "" {
+		mux.Handle("GET /spaceapi.json", serveSpaceAPI(cfg.SpaceAPI, b.Doors[0].Actuator))
+	}
+	if cfg.AdminTokens != "" || cfg.OIDC.Issuer != "" || len(api.keys) > 0 {
+		var tokens []adminToken
+		if cfg.AdminTokens != "" {
+			var err error
+			if tokens, err = parseAdminTokens(cfg.AdminTokens); err != nil {
+				return nil, err
+			}
+		}
+		api.auth = &adminAuth{tokens: tokens, keys: api.keys, sessions: newSessions(cfg.TLSCert != "")}
+		if cfg.OIDC.Issuer != "" {
+			api.auth.oidc = newOIDCProvider(cfg.OIDC)
+		}
+		registerAdminAPI(api)
+		registerTokenCheckAPI(api)
+		if err := registerDashboard(api); err != nil {
+			return nil, err
+		}
+		if b.OpenHours != nil {
+			registerOpenHoursAPI(api)
+		}
+		if b.Enroll != nil {
+			registerEnrollAPI(api)
+		}
+		if b.Stats != nil {
+			registerStatsAPI(api)
+		}
+		if b.EngageLockdown != nil && b.LiftLockdown != nil {
+			registerLockdownAPI(api)
+		}
+		if b.Passback != nil {
+			registerPassbackAPI(api)
+		}
+		if b.Latency != nil {
+			registerLatencyAPI(api)
+		}
+		if b.AppTokens != nil {
+			if err := registerAppTokensAPI(api); err != nil {
+				return nil, err
+			}
+		}
+		if b.Passthrough != nil {
+			registerPassthroughAPI(api)
+		}
+		if b.Reload != nil {
+			registerReloadAPI(api)
+		}
+	}
+	if err := api.serveOpenAPI(); err != nil {
+		return nil, err
+	}
+	proxies, err := ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPathPrefix(cfg.PathPrefix); err != nil {
+		return nil, err
+	}
+	var h http.Handler = recoverPanics(mux)
+	if cfg.PathPrefix != "" {
+		h = http.StripPrefix(cfg.PathPrefix, h)
+	}
+	return realClient(h, proxies), nil
+}
+
+// recoverPanics replies with an error to requests whose handler panicked,
+// and counts the crash like those of other workers
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if supervisor.Run("http", func() { h.ServeHTTP(w, r) }) {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+	})
+}
+
+// New returns a server for the endpoints enabled in cfg, see NewHandler
+func New(cfg Config, b Backend) (*Server, error) {
+	handler, err := NewHandler(cfg, b)
+	if err != nil {
+		return nil, err
+	}
+	// Requests are canceled on shutdown, ending event streams, which would
+	// otherwise keep it waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{cfg: cfg}
+	srv := &http.Server{Addr: cfg.Addr, Handler: s.gate(handler), BaseContext: func(net.Listener) context.Context { return ctx }}
+	srv.RegisterOnShutdown(cancel)
+	if cfg.TLSClientCA != "" {
+		pem, err := ioutil.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + cfg.TLSClientCA)
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+	s.srv = srv
+	return s, nil
+}
+
+// gate replies 503 to requests until the server is ready
+func (s *Server) gate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// SetReady starts handling requests. A nil Server is never ready
+func (s *Server) SetReady() {
+	if s != nil {
+		s.ready.Store(true)
+	}
+}
+
+// ListenAndServe serves HTTPS if a certificate is configured, plain HTTP
+// otherwise, on all addresses. It returns the first error of any of them,
+// which is http.ErrServerClosed after Shutdown
+func (s *Server) ListenAndServe() error {
+	listeners, err := listen(SplitAddrs(s.cfg.Addr))
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			if s.cfg.TLSCert != "" {
+				errs <- s.srv.ServeTLS(l, s.cfg.TLSCert, s.cfg.TLSKey)
+			} else {
+				errs <- s.srv.Serve(l)
+			}
+		}(l)
+	}
+	return <-errs
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
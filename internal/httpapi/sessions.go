@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionTTL is how long a dashboard login lasts
+const sessionTTL = 12 * time.Hour
+
+const sessionCookie = "wishbone_session"
+
+// Requests authenticated by the session cookie have to carry this header,
+// which forms on other sites can't set
+const (
+	csrfHeader = "X-Requested-With"
+	csrfValue  = "wishbone"
+)
+
+// sessions are the dashboard logins, kept in memory, so restarting
+// wishbone logs everyone out. A nil *sessions knows no sessions
+type sessions struct {
+	// secure marks the cookies as HTTPS only
+	secure bool
+
+	mu   sync.Mutex
+	byID map[string]session
+}
+
+type session struct {
+	admin string
+	// sso sessions were started through the identity provider, which
+	// already checked the admin's groups
+	sso     bool
+	expires time.Time
+}
+
+func newSessions(secure bool) *sessions {
+	return &sessions{secure: secure, byID: map[string]session{}}
+}
+
+// start logs admin in, setting the session cookie on w
+func (s *sessions) start(w http.ResponseWriter, admin string, sso bool, now time.Time) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	// Drop expired sessions, so abandoned ones don't pile up
+	for id, sess := range s.byID {
+		if !now.Before(sess.expires) {
+			delete(s.byID, id)
+		}
+	}
+	s.byID[id] = session{admin: admin, sso: sso, expires: now.Add(sessionTTL)}
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  now.Add(sessionTTL),
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// lookup returns the session of the session cookie of r
+func (s *sessions) lookup(r *http.Request, now time.Time) (session, bool) {
+	if s == nil {
+		return session{}, false
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.byID[c.Value]
+	if !ok || !now.Before(sess.expires) {
+		return session{}, false
+	}
+	return sess, true
+}
+
+// end logs out the session of r and clears its cookie
+func (s *sessions) end(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.mu.Lock()
+		delete(s.byID, c.Value)
+		s.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: s.secure, SameSite: http.SameSiteStrictMode})
+}
@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/alarm"
+	"github.com/craftamap/wishbone/internal/doorsensor"
+	"github.com/craftamap/wishbone/internal/keypad"
+	"github.com/craftamap/wishbone/internal/reader"
+)
+
+// serveSimulatedSwipe injects the token given in the request, optionally
+// attributed to a reader
+func serveSimulatedSwipe(c chan<- reader.Swipe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.FormValue("token")
+		if token == "" {
+			http.Error(w, "token missing", http.StatusBadRequest)
+			return
+		}
+		label := r.FormValue("reader")
+		if label == "" {
+			label = "http"
+		}
+		c <- reader.Swipe{Reader: label, Token: token, Time: time.Now()}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// serveSimulatedKeys presses the keys given in the request, in order
+func serveSimulatedKeys(c chan<- rune) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := r.FormValue("keys")
+		if keys == "" {
+			http.Error(w, "keys missing", http.StatusBadRequest)
+			return
+		}
+		for _, key := range keys {
+			if !keypad.IsKey(key) {
+				http.Error(w, "invalid key "+strconv.QuoteRune(key), http.StatusBadRequest)
+				return
+			}
+		}
+		for _, key := range keys {
+			c <- key
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// serveSimulatedPosition sets the position read by the door sensor of the
+// door given in the request, the first one by default
+func serveSimulatedPosition(b Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := b.door(r.FormValue("door"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if d.Sensor == nil {
+			http.Error(w, "door has no door sensor", http.StatusConflict)
+			return
+		}
+		p, err := doorsensor.ParsePosition(r.FormValue("position"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		d.Sensor.Set(p)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// serveSimulatedAlarm sets the state of the alarm given in the request
+func serveSimulatedAlarm(i *alarm.Interlock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := alarm.ParseState(r.FormValue("state"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		i.Set(s)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+)
+
+// SpaceAPI describes the space in the SpaceAPI document. It is served if
+// Space is set
+type SpaceAPI struct {
+	Space   string
+	Logo    string
+	URL     string
+	Address string
+	Lat     float64
+	Lon     float64
+	Email   string
+}
+
+// serveSpaceAPI serves a SpaceAPI v15 document (https://spaceapi.io), where
+// the space is open while the sphincter is unlocked
+func serveSpaceAPI(space SpaceAPI, door *actuator.Watched) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := map[string]interface{}{
+			"open": door.Status() == actuator.StatusUnlocked,
+		}
+		if t := door.LastChange(); !t.IsZero() {
+			state["lastchange"] = t.Unix()
+		}
+		location := map[string]interface{}{
+			"lat": space.Lat,
+			"lon": space.Lon,
+		}
+		if space.Address != "" {
+			location["address"] = space.Address
+		}
+		contact := map[string]interface{}{}
+		if space.Email != "" {
+			contact["email"] = space.Email
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"api_compatibility": []string{"15"},
+			"space":             space.Space,
+			"logo":              space.Logo,
+			"url":               space.URL,
+			"location":          location,
+			"contact":           contact,
+			"state":             state,
+		})
+	}
+}
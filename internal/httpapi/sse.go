@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+// serveDoorEvents is serveEvents for the door in the path
+func serveDoorEvents(b Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := b.door(r.PathValue("door"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		serveEvents(d, b.Events, true)(w, r)
+	}
+}
+
+const (
+	// maxReplay is the most events replayed to a reconnecting client, and
+	// maxReplayAge how old they may be
+	maxReplay    = 100
+	maxReplayAge = 10 * time.Minute
+)
+
+// serveEvents is the Server-Sent Events counterpart of serveWebSocket, for
+// clients that can't use WebSockets. Events are sent with their type as
+// event name and their ID, so clients reconnecting with Last-Event-ID get
+// the events they missed within maxReplay and maxReplayAge instead of the
+// current status
+func serveEvents(door Door, hub *events.Hub, only bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		anon := anonymous(r)
+		rc := http.NewResponseController(w)
+		c := hub.Subscribe()
+		defer hub.Unsubscribe(c)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		var err error
+		// sent is the ID of the last event sent, so replayed events aren't
+		// sent again when they arrive from the subscription
+		var sent uint64
+		if last, perr := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); perr == nil && last > 0 {
+			missed := missedEvents(hub, last, time.Now())
+			for i := len(missed) - 1; i >= 0 && err == nil; i-- {
+				if e := missed[i]; !only || e.Concerns(door.Name) {
+					err = writeEvent(w, e, anon)
+					sent = e.ID
+				}
+			}
+		} else {
+			err = writeEvent(w, door.state(), anon)
+		}
+
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+		for err == nil {
+			if err = rc.Flush(); err != nil {
+				break
+			}
+			select {
+			case e := <-c:
+				if e.ID <= sent || only && !e.Concerns(door.Name) {
+					continue
+				}
+				err = writeEvent(w, e, anon)
+			case <-ping.C:
+				// Comments keep proxies from closing idle connections
+				_, err = fmt.Fprint(w, ": ping\n\n")
+			case <-r.Context().Done():
+				return
+			}
+		}
+		slog.Debug("Event stream client disconnected", "client", r.RemoteAddr, "err", err)
+	}
+}
+
+// missedEvents returns the events with an ID above last, newest first, but
+// at most maxReplay and none older than maxReplayAge
+func missedEvents(hub *events.Hub, last uint64, now time.Time) []events.Event {
+	var missed []events.Event
+	for _, e := range hub.History(0, maxReplay) {
+		if e.ID <= last || now.Sub(e.Time) > maxReplayAge {
+			break
+		}
+		missed = append(missed, e)
+	}
+	return missed
+}
+
+// writeEvent writes e in the text/event-stream format, anonymized if anon
+// is set
+func writeEvent(w http.ResponseWriter, e events.Event, anon bool) error {
+	if anon {
+		e = e.Anonymized()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if e.ID > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", e.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
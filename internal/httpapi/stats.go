@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+// defaultStatsDays is the period reported without from
+const defaultStatsDays = 30
+
+// statsReport summarizes the unlocks of a period, as served by
+// GET /api/v1/stats
+type statsReport struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Unlocks int    `json:"unlocks"`
+	Locks   int    `json:"locks"`
+	// Days are the days with unlocks or locks
+	Days []dayCount `json:"days"`
+	// Hours are the unlocks by local hour of the day
+	Hours   [24]int        `json:"hours"`
+	Users   []userCount    `json:"users"`
+	Sources map[string]int `json:"sources"`
+}
+
+type dayCount struct {
+	Date    string `json:"date"`
+	Unlocks int    `json:"unlocks"`
+	Locks   int    `json:"locks"`
+}
+
+// userCount is the number of unlocks by a user; User is empty for unlocks
+// not tied to a user, e.g. by MQTT
+type userCount struct {
+	User    string `json:"user"`
+	Unlocks int    `json:"unlocks"`
+}
+
+// statsPeriod parses the from and to dates of r. to defaults to today and
+// from to defaultStatsDays before
+func statsPeriod(r *http.Request) (from, to string, ok bool) {
+	now := time.Now()
+	from = r.FormValue("from")
+	to = r.FormValue("to")
+	if to == "" {
+		to = now.Format("2006-01-02")
+	}
+	if from == "" {
+		from = now.AddDate(0, 0, -defaultStatsDays+1).Format("2006-01-02")
+	}
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return "", "", false
+		}
+	}
+	return from, to, from <= to
+}
+
+func summarize(from, to string, rows []events.StatsRow) statsReport {
+	report := statsReport{From: from, To: to, Days: []dayCount{}, Users: []userCount{}, Sources: map[string]int{}}
+	users := map[string]int{}
+	for _, row := range rows {
+		if len(report.Days) == 0 || report.Days[len(report.Days)-1].Date != row.Date {
+			report.Days = append(report.Days, dayCount{Date: row.Date})
+		}
+		day := &report.Days[len(report.Days)-1]
+		if row.Action == events.StatsLock {
+			report.Locks += row.Count
+			day.Locks += row.Count
+			continue
+		}
+		report.Unlocks += row.Count
+		day.Unlocks += row.Count
+		report.Hours[row.Hour] += row.Count
+		users[row.User] += row.Count
+		report.Sources[row.Source] += row.Count
+	}
+	for user, n := range users {
+		report.Users = append(report.Users, userCount{User: user, Unlocks: n})
+	}
+	// Most frequent first
+	sort.Slice(report.Users, func(i, j int) bool {
+		a, b := report.Users[i], report.Users[j]
+		if a.Unlocks != b.Unlocks {
+			return a.Unlocks > b.Unlocks
+		}
+		return a.User < b.User
+	})
+	return report
+}
+
+func registerStatsAPI(api *router) {
+	b := api.b
+	periodErrors := map[int]string{http.StatusBadRequest: "invalid period"}
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/stats", Summary: "Unlocks and locks from one day through another", Legacy: true,
+		Query: []string{"from", "to"}, Status: http.StatusOK, Response: statsReport{}, Errors: periodErrors,
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		from, to, ok := statsPeriod(r)
+		if !ok {
+			http.Error(w, "invalid period, expected from and to as YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, summarize(from, to, b.Stats.Rows(from, to)))
+	})
+
+	// The counts by hour, door, user, source and action, for spreadsheets
+	api.handleAdmin(route{
+		Method: "GET", Path: apiPrefix + "/stats.csv", Summary: "Unlocks and locks by hour, door, user and source as CSV", Legacy: true,
+		Query: []string{"from", "to"}, Status: http.StatusOK, ContentType: "text/csv", Errors: periodErrors,
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		from, to, ok := statsPeriod(r)
+		if !ok {
+			http.Error(w, "invalid period, expected from and to as YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"wishbone-"+from+"-"+to+".csv\"")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"date", "hour", "door", "user", "source", "action", "count"})
+		for _, row := range b.Stats.Rows(from, to) {
+			cw.Write([]string{row.Date, strconv.Itoa(row.Hour), row.Door, row.User, row.Source, row.Action, strconv.Itoa(row.Count)})
+		}
+		cw.Flush()
+	})
+}
@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// maxTokenChecks is the most token hashes checked by one request
+const maxTokenChecks = 1000
+
+// tokenCheckRequest is the body of POST /api/v1/tokens/check. Hashes are the
+// tokens hashed by events.HashToken, like token_hash in the audit log
+type tokenCheckRequest struct {
+	Hashes []string `json:"hashes"`
+}
+
+type tokenCheckResponse struct {
+	Tokens []tokenCheck `json:"tokens"`
+}
+
+// tokenCheck reports whether the token with Hash may unlock now, with its
+// schedule and groups, and if not, why. User is only told to admins
+type tokenCheck struct {
+	Hash       string `json:"hash"`
+	Authorized bool   `json:"authorized"`
+	Reason     string `json:"reason,omitempty"`
+	User       string `json:"user,omitempty"`
+}
+
+// registerTokenCheckAPI lets membership systems reconcile their tokens with
+// the store, without sending the tokens themselves. Tokens stored as salted
+// hash can't be found by their token hash and are reported as unknown
+func registerTokenCheckAPI(api *router) {
+	b := api.b
+	api.handleAdmin(route{
+		Method: "POST", Path: apiPrefix + "/tokens/check", Summary: "Check which tokens may unlock now, by their token hash", Legacy: true,
+		Scope: ScopeTokensCheck, Request: tokenCheckRequest{}, Status: http.StatusOK, Response: tokenCheckResponse{},
+		Errors: map[int]string{
+			http.StatusBadRequest:         "invalid request, or too many hashes",
+			http.StatusServiceUnavailable: "the store can't be listed",
+		},
+	}, func(w http.ResponseWriter, r *http.Request, admin string) {
+		var req tokenCheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Hashes) > maxTokenChecks {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		creds, err := b.Store.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		byHash := tokensByHash(creds)
+
+		now := time.Now()
+		showUsers := !scopedKeyUsed(r)
+		resp := tokenCheckResponse{Tokens: []tokenCheck{}}
+		for _, hash := range req.Hashes {
+			hash = strings.ToLower(strings.TrimSpace(hash))
+			check := tokenCheck{Hash: hash}
+			c, ok := byHash[hash]
+			if !ok {
+				check.Reason = store.ErrUnknownToken.Error()
+				resp.Tokens = append(resp.Tokens, check)
+				continue
+			}
+			err := c.Valid(now)
+			if err == nil {
+				err = b.Permissions().Check(c, store.ActionUnlock)
+			}
+			if err != nil {
+				check.Reason = err.Error()
+			} else {
+				check.Authorized = true
+			}
+			if showUsers {
+				check.User = c.User
+			}
+			resp.Tokens = append(resp.Tokens, check)
+		}
+		slog.Info("Tokens checked", "admin", admin, "tokens", len(req.Hashes))
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// tokensByHash maps the token hashes of creds to them. Tokens are hashed in
+// their normalized form, like those swiped, so hashes from the audit log
+// find tokens stored in another notation
+func tokensByHash(creds []store.Credential) map[string]store.Credential {
+	byHash := map[string]store.Credential{}
+	for _, c := range creds {
+		if !store.IsTokenHash(c.Token) {
+			byHash[events.HashToken(store.NormalizeToken(c.Token))] = c
+		}
+	}
+	return byHash
+}
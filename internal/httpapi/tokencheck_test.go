@@ -0,0 +1,22 @@
+package httpapi
+
+import (
+	"testing"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+func TestTokensByHash(t *testing.T) {
+	// Stored in lower case with separators, but swiped and logged as
+	// normalized
+	creds := []store.Credential{{Token: "0a:1b:2c:3d", User: "alice"}}
+	byHash := tokensByHash(creds)
+	c, ok := byHash[events.HashToken(store.NormalizeToken("0A1B2C3D"))]
+	if !ok || c.User != "alice" {
+		t.Fatalf("token not found by the hash of its normalized form: %v", byHash)
+	}
+	if _, ok := byHash[events.HashToken("0a:1b:2c:3d")]; ok {
+		t.Fatal("token found by the hash of its stored form")
+	}
+}
@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// serveDoorWebSocket is serveWebSocket for the door in the path
+func serveDoorWebSocket(b Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := b.door(r.PathValue("door"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		serveWebSocket(d, b.Events, true)(w, r)
+	}
+}
+
+// serveWebSocket pushes the current status of door and then every event as
+// JSON to the client until it disconnects. If only is set, events of other
+// doors are left out. Clients without an API key don't get users and token
+// hashes
+func serveWebSocket(door Door, hub *events.Hub, only bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		anon := anonymous(r)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade already replied with an error
+			return
+		}
+		defer conn.Close()
+
+		c := hub.Subscribe()
+		defer hub.Unsubscribe(c)
+
+		// Read until the client goes away, so we notice closed connections
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+
+		err = conn.WriteJSON(door.state())
+		for err == nil {
+			select {
+			case e := <-c:
+				if only && !e.Concerns(door.Name) {
+					continue
+				}
+				if anon {
+					e = e.Anonymized()
+				}
+				err = conn.WriteJSON(e)
+			case <-ping.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			case <-closed:
+				return
+			}
+		}
+		slog.Debug("WebSocket client disconnected", "client", r.RemoteAddr, "err", err)
+	}
+}
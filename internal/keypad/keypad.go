@@ -0,0 +1,150 @@
+// Package keypad reads keys from a keypad used to enter PINs
+package keypad
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"go.bug.st/serial"
+)
+
+// Keypad sends every key pressed as a rune: digits, '*' and '#', and A-D on
+// keypads having them
+type Keypad interface {
+	Open() error
+	Close() error
+	// Run sends keys to c until done is closed
+	Run(c chan<- rune, done <-chan struct{})
+	String() string
+}
+
+// Parse returns the keypad given as a serial device like /dev/ttyUSB2, or as
+// matrix:ROWS/COLS with the BCM pins of a matrix keypad's rows and columns,
+// e.g. matrix:5,6,13,19/26,16,20
+func Parse(spec string) (Keypad, error) {
+	pins := strings.TrimPrefix(spec, "matrix:")
+	if pins == spec {
+		return &Serial{Device: spec}, nil
+	}
+
+	parts := strings.Split(pins, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid keypad %q, expected matrix:ROWS/COLS", spec)
+	}
+	m := &Matrix{}
+	var err error
+	if m.Rows, err = parsePins(parts[0]); err != nil {
+		return nil, err
+	}
+	if m.Cols, err = parsePins(parts[1]); err != nil {
+		return nil, err
+	}
+	if len(m.Rows) != 4 || (len(m.Cols) != 3 && len(m.Cols) != 4) {
+		return nil, fmt.Errorf("unsupported %dx%d keypad, expected 4x3 or 4x4", len(m.Rows), len(m.Cols))
+	}
+	return m, nil
+}
+
+func parsePins(s string) ([]int, error) {
+	var pins []int
+	for _, p := range strings.Split(s, ",") {
+		pin, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("invalid keypad pin %q", p)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// Serial is a keypad sending each key as an ASCII character
+type Serial struct {
+	Device string
+
+	port serial.Port
+}
+
+func (k *Serial) Open() error {
+	port, err := serial.Open(k.Device, &serial.Mode{BaudRate: 9600})
+	if err != nil {
+		return err
+	}
+	k.port = port
+	return nil
+}
+
+func (k *Serial) Close() error {
+	return k.port.Close()
+}
+
+func (k *Serial) String() string {
+	return k.Device
+}
+
+func (k *Serial) Run(c chan<- rune, done <-chan struct{}) {
+	supervisor.Go("keypad", func() {
+		rd := bufio.NewReader(k.port)
+		for {
+			key, _, err := rd.ReadRune()
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					slog.Warn("Could not read from keypad", "keypad", k.String(), "err", err)
+				}
+				return
+			}
+			if IsKey(key) {
+				c <- key
+			}
+		}
+	})
+}
+
+// IsKey reports whether r is a key of some keypad
+func IsKey(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'A' && r <= 'D') || r == '*' || r == '#'
+}
+
+// Matrix is a keypad whose keys connect a row to a column. Rows are driven
+// low one at a time while the columns are read with pull-ups
+type Matrix struct {
+	Rows []int
+	Cols []int
+
+	stopped chan struct{}
+}
+
+// matrixKeys are the labels of 4x3 and 4x4 keypads, by row
+var matrixKeys = map[int][]string{
+	3: {"123", "456", "789", "*0#"},
+	4: {"123A", "456B", "789C", "*0#D"},
+}
+
+func (m *Matrix) String() string {
+	return fmt.Sprintf("%dx%d matrix keypad", len(m.Rows), len(m.Cols))
+}
+
+// Simulated stands in for a keypad whose GPIO isn't driven, as scanning a
+// matrix keypad drives its rows. It reads no keys itself
+type Simulated struct {
+	Keypad Keypad
+}
+
+func (k *Simulated) Open() error {
+	return nil
+}
+
+func (k *Simulated) Close() error {
+	return nil
+}
+
+func (k *Simulated) Run(c chan<- rune, done <-chan struct{}) {}
+
+func (k *Simulated) String() string {
+	return k.Keypad.String() + " (simulated)"
+}
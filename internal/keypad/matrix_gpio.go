@@ -0,0 +1,88 @@
+//go:build !nogpio
+// +build !nogpio
+
+package keypad
+
+import (
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// matrixScanInterval is short enough not to miss a key press, and long
+// enough to debounce it
+const matrixScanInterval = 20 * time.Millisecond
+
+func (m *Matrix) Open() error {
+	if err := rpio.Open(); err != nil {
+		return err
+	}
+	for _, row := range m.Rows {
+		rpio.Pin(row).Output()
+		rpio.Pin(row).High()
+	}
+	for _, col := range m.Cols {
+		rpio.Pin(col).Input()
+		rpio.Pin(col).PullUp()
+	}
+	return nil
+}
+
+// Close waits for Run to stop, so its done channel must be closed before
+func (m *Matrix) Close() error {
+	if m.stopped != nil {
+		<-m.stopped
+	}
+	for _, row := range m.Rows {
+		rpio.Pin(row).Input()
+	}
+	return nil
+}
+
+// Run sends a key once when it is pressed, not while it is held
+func (m *Matrix) Run(c chan<- rune, done <-chan struct{}) {
+	m.stopped = make(chan struct{})
+	go func() {
+		defer close(m.stopped)
+		supervisor.Restart("keypad", func() { m.run(c, done) })
+	}()
+}
+
+func (m *Matrix) run(c chan<- rune, done <-chan struct{}) {
+	scan := time.NewTicker(matrixScanInterval)
+	defer scan.Stop()
+
+	var held rune
+	for {
+		select {
+		case <-done:
+			return
+		case <-scan.C:
+		}
+		key := m.scan()
+		if key != 0 && key != held {
+			select {
+			case c <- key:
+			case <-done:
+				return
+			}
+		}
+		held = key
+	}
+}
+
+// scan returns the first key pressed, or 0
+func (m *Matrix) scan() rune {
+	for i, row := range m.Rows {
+		rpio.Pin(row).Low()
+		for j, col := range m.Cols {
+			if rpio.Pin(col).Read() == rpio.Low {
+				rpio.Pin(row).High()
+				return rune(matrixKeys[len(m.Cols)][i][j])
+			}
+		}
+		rpio.Pin(row).High()
+	}
+	return 0
+}
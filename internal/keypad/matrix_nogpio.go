@@ -0,0 +1,16 @@
+//go:build nogpio
+// +build nogpio
+
+package keypad
+
+import "errors"
+
+func (m *Matrix) Open() error {
+	return errors.New("matrix keypads need GPIO support, which was not built in")
+}
+
+func (m *Matrix) Close() error {
+	return nil
+}
+
+func (m *Matrix) Run(c chan<- rune, done <-chan struct{}) {}
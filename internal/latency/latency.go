@@ -0,0 +1,188 @@
+// Package latency measures how long the unlock path takes, from reading a
+// token to pulsing the relay, broken down by stage
+package latency
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+)
+
+// Stage is a step of handling a swiped token
+type Stage int
+
+const (
+	// Queue is the time from reading a token until it is handled, e.g.
+	// while the swipe before is still handled
+	Queue Stage = iota
+	// Lookup is looking up the token in the credential store
+	Lookup
+	// Policy is checking the permissions, the fail policy, lockdowns and
+	// anti-passback
+	Policy
+	// Actuator is the time from opening the door until the relay is
+	// energized, e.g. waiting out the gap after the previous pulse
+	Actuator
+	// Total is the time from reading the token until the relay is
+	// energized, or the token was rejected
+	Total
+)
+
+// Stages are all stages, in the order they are passed
+var Stages = []Stage{Queue, Lookup, Policy, Actuator, Total}
+
+var stageNames = []string{"queue", "lookup", "policy", "actuator", "total"}
+
+func (s Stage) String() string {
+	if s < 0 || int(s) >= len(stageNames) {
+		return "unknown"
+	}
+	return stageNames[s]
+}
+
+// Trace is the timing of a swiped token. A nil *Trace measures nothing
+type Trace struct {
+	// Start is when the token was read
+	Start  time.Time
+	Door   string
+	Reader string
+	// Store is the kind of credential store the token was looked up in
+	Store string
+	// Result is the result of the access attempt, see events.Access
+	Result string
+	// Stages holds the stages passed; tokens rejected by the store never
+	// reach the policy or the actuator
+	Stages map[Stage]time.Duration
+}
+
+// NewTrace returns a trace of a token read at start
+func NewTrace(start time.Time) *Trace {
+	return &Trace{Start: start, Stages: map[Stage]time.Duration{}}
+}
+
+// Measure notes that stage took from since until now
+func (t *Trace) Measure(stage Stage, since time.Time) {
+	if t == nil {
+		return
+	}
+	t.Stages[stage] = time.Since(since)
+}
+
+// TimeActuator returns a ctx for opening the door, which measures the
+// actuator stage and ends the total once the relay is energized
+func (t *Trace) TimeActuator(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+	start := time.Now()
+	return actuator.OnEnergized(ctx, func() {
+		// Only the first pulse counts, not the retries of a verified actuator
+		if _, ok := t.Stages[Actuator]; !ok {
+			t.Stages[Actuator] = time.Since(start)
+			t.Stages[Total] = time.Since(t.Start)
+		}
+	})
+}
+
+// Finish notes the result of handling the token, ending the total unless
+// the relay was energized before
+func (t *Trace) Finish(result string) {
+	if t == nil {
+		return
+	}
+	t.Result = result
+	if _, ok := t.Stages[Total]; !ok {
+		t.Stages[Total] = time.Since(t.Start)
+	}
+}
+
+// Summary is the distribution of the durations of a stage among the
+// recent traces
+type Summary struct {
+	Stage  Stage
+	Count  int
+	Median time.Duration
+	P90    time.Duration
+	Max    time.Duration
+}
+
+// Recorder keeps the most recent traces in memory. A nil *Recorder keeps
+// nothing
+type Recorder struct {
+	mu     sync.Mutex
+	traces []Trace
+	// next is the index the next trace is stored at, once traces is full
+	next int
+	size int
+}
+
+// NewRecorder returns a Recorder keeping the last size traces
+func NewRecorder(size int) *Recorder {
+	return &Recorder{size: size}
+}
+
+// Record keeps t, dropping the oldest trace if the recorder is full
+func (r *Recorder) Record(t Trace) {
+	if r == nil || r.size <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.traces) < r.size {
+		r.traces = append(r.traces, t)
+		return
+	}
+	r.traces[r.next] = t
+	r.next = (r.next + 1) % r.size
+}
+
+// Recent returns the kept traces, newest first
+func (r *Recorder) Recent() []Trace {
+	if r == nil {
+		return []Trace{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recent := make([]Trace, 0, len(r.traces))
+	for i := len(r.traces) - 1; i >= 0; i-- {
+		recent = append(recent, r.traces[(r.next+i)%len(r.traces)])
+	}
+	return recent
+}
+
+// Summarize returns the distribution of every stage among the kept traces,
+// in the order of Stages. Stages no trace passed have a Count of zero
+func (r *Recorder) Summarize() []Summary {
+	traces := r.Recent()
+	summaries := make([]Summary, 0, len(Stages))
+	for _, stage := range Stages {
+		var durations []time.Duration
+		for _, t := range traces {
+			if d, ok := t.Stages[stage]; ok {
+				durations = append(durations, d)
+			}
+		}
+		s := Summary{Stage: stage, Count: len(durations)}
+		if len(durations) > 0 {
+			sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+			s.Median = percentile(durations, 50)
+			s.P90 = percentile(durations, 90)
+			s.Max = durations[len(durations)-1]
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// percentile returns the p-th percentile of the sorted durations, by the
+// nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,172 @@
+// Package lockdown keeps doors closed in an emergency: while a door is in
+// lockdown, only members of the emergency groups may unlock it
+package lockdown
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+var (
+	// ErrLockdown is returned for unlocks refused during a lockdown
+	ErrLockdown = errors.New("door in lockdown")
+	// ErrNotEngaged is returned when lifting a lockdown that isn't engaged
+	ErrNotEngaged = errors.New("no lockdown engaged")
+)
+
+// Lockdown is an engaged lockdown of a door, or of all doors if Door is
+// empty
+type Lockdown struct {
+	Door   string    `json:"door,omitempty"`
+	By     string    `json:"by"`
+	Source string    `json:"source"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// State holds the engaged lockdowns. They are saved to a file, if given,
+// so a restart doesn't lift them. A nil State never locks down
+type State struct {
+	path   string
+	groups []string
+
+	mu      sync.Mutex
+	engaged map[string]Lockdown
+}
+
+// Open returns the lockdowns saved at path, which may be empty to keep them
+// in memory only. Members of groups may still unlock during a lockdown
+func Open(path string, groups []string) (*State, error) {
+	s := &State{path: path, groups: groups, engaged: map[string]Lockdown{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Lockdown
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, l := range list {
+		s.engaged[l.Door] = l
+	}
+	return s, nil
+}
+
+// save writes the lockdowns atomically. It is called with s.mu held
+func (s *State) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Engage engages l, replacing a lockdown of the same door. It is engaged
+// even if it can't be saved
+func (s *State) Engage(l Lockdown) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engaged[l.Door] = l
+	return s.save()
+}
+
+// Lift lifts the lockdown of door, or the one of all doors if door is
+// empty, returning it. Lifting the lockdown of all doors leaves those of
+// single doors engaged
+func (s *State) Lift(door string) (Lockdown, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.engaged[door]
+	if !ok {
+		return l, ErrNotEngaged
+	}
+	delete(s.engaged, door)
+	return l, s.save()
+}
+
+// Active returns the lockdown door is in, either its own or the one of
+// all doors
+func (s *State) Active(door string) (Lockdown, bool) {
+	if s == nil {
+		return Lockdown{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.engaged[""]; ok {
+		return l, true
+	}
+	l, ok := s.engaged[door]
+	return l, ok
+}
+
+// List returns the engaged lockdowns, the one of all doors first
+func (s *State) List() []Lockdown {
+	if s == nil {
+		return []Lockdown{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+func (s *State) list() []Lockdown {
+	list := []Lockdown{}
+	for _, l := range s.engaged {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Door < list[j].Door
+	})
+	return list
+}
+
+// Exempts reports whether c is in one of the emergency groups
+func (s *State) Exempts(c store.Credential) bool {
+	return s != nil && len(s.groups) > 0 && c.InGroup(s.groups)
+}
+
+// Check returns ErrLockdown if door is in lockdown, unless c is exempt
+func (s *State) Check(c store.Credential, door string) error {
+	if _, ok := s.Active(door); ok && !s.Exempts(c) {
+		return ErrLockdown
+	}
+	return nil
+}
+
+type exemptKey struct{}
+
+// Exempt marks ctx as belonging to a command of an exempt credential, which
+// CheckCommand lets through
+func Exempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exemptKey{}, true)
+}
+
+// CheckCommand returns ErrLockdown if door is in lockdown, unless ctx was
+// marked by Exempt. Remote commands don't carry a credential, so they are
+// refused unless their sender was checked before
+func (s *State) CheckCommand(ctx context.Context, door string) error {
+	if _, ok := s.Active(door); ok && ctx.Value(exemptKey{}) == nil {
+		return ErrLockdown
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+// Package outbox delivers notifications, like webhook calls and chat
+// messages, retrying with backoff until they go through. Pending
+// notifications are journaled to a file, so events happening while the
+// network is down are delivered once it is back, even across restarts
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	minBackoff = 5 * time.Second
+	maxBackoff = 5 * time.Minute
+	// expireInterval is how often notifications are checked for their age
+	// while none is due
+	expireInterval = time.Minute
+	// minCompaction is how many records the journal has at least before it
+	// is compacted, which it is once they are twice the items
+	minCompaction = 100
+)
+
+var (
+	pending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wishbone_notifications_pending",
+		Help: "Notifications waiting to be delivered or retried.",
+	})
+	droppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wishbone_notifications_dropped_total",
+		Help: "Notifications given up on by channel and reason: rejected, expired, full or unhandled.",
+	}, []string{"channel", "reason"})
+)
+
+// Item is a notification
+type Item struct {
+	ID uint64 `json:"id"`
+	// Channel selects the handler delivering the item, like webhook, and
+	// Dest where to, like its URL. Items of the same channel and dest are
+	// delivered in order
+	Channel string `json:"channel"`
+	Dest    string `json:"dest,omitempty"`
+	Payload string `json:"payload"`
+	// Meta holds what else the handler needs, like the signature of a
+	// webhook call
+	Meta     map[string]string `json:"meta,omitempty"`
+	Created  time.Time         `json:"created"`
+	Attempts int               `json:"attempts,omitempty"`
+}
+
+func (i Item) key() string {
+	return i.Channel + " " + i.Dest
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error of a handler as not worth retrying, like a
+// webhook replying 404. The item is dropped
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// record is a line of the journal: an item added, or the ID of an item
+// removed or attempted once more
+type record struct {
+	Add     *Item  `json:"add,omitempty"`
+	Remove  uint64 `json:"remove,omitempty"`
+	Attempt uint64 `json:"attempt,omitempty"`
+}
+
+// dest is the delivery state of the items of a channel and dest
+type dest struct {
+	// inflight is the ID of the item being delivered, or 0
+	inflight uint64
+	failures int
+	next     time.Time
+}
+
+// Queue holds the notifications until they are delivered. Handlers must be
+// registered with Handle before Run
+type Queue struct {
+	path   string
+	size   int
+	maxAge time.Duration
+
+	handlers map[string]func(Item) error
+	wake     chan struct{}
+
+	mu     sync.Mutex
+	items  []Item
+	lastID uint64
+	dests  map[string]*dest
+	// journal is appended a record for every change, records counts them
+	journal *os.File
+	records int
+}
+
+// Open returns a queue of at most size items, journaled at path, or kept in
+// memory only if it is empty. Items older than maxAge are dropped, unless it
+// is 0
+func Open(path string, size int, maxAge time.Duration) (*Queue, error) {
+	q := &Queue{
+		path:     path,
+		size:     size,
+		maxAge:   maxAge,
+		handlers: map[string]func(Item) error{},
+		wake:     make(chan struct{}, 1),
+		dests:    map[string]*dest{},
+	}
+	if path == "" {
+		return q, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("[")) {
+		// Saved as a whole by earlier versions
+		if err := json.Unmarshal(data, &q.items); err != nil {
+			return nil, err
+		}
+	} else {
+		q.replay(data)
+	}
+	for _, i := range q.items {
+		q.lastID = max(q.lastID, i.ID)
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	pending.Set(float64(len(q.items)))
+	return q, nil
+}
+
+// replay applies the records of a journal. A line cut short by a crash
+// while it was appended is skipped
+func (q *Queue) replay(data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			slog.Warn("Skipping unreadable notification queue record", "path", q.path, "err", err)
+			continue
+		}
+		switch {
+		case r.Add != nil:
+			q.items = append(q.items, *r.Add)
+		case r.Remove != 0:
+			q.drop(r.Remove)
+		case r.Attempt != 0:
+			q.attempted(r.Attempt)
+		}
+	}
+}
+
+// Handle registers f to deliver the items of channel
+func (q *Queue) Handle(channel string, f func(Item) error) {
+	q.handlers[channel] = f
+}
+
+// Len returns the number of items not delivered yet
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Add queues i, dropping the oldest item if the queue is full
+func (q *Queue) Add(i Item) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.add(i)
+	q.changed()
+}
+
+// Put queues i in place of the items of its channel and dest not being
+// delivered yet, for notifications of which only the latest matters, like
+// a retained MQTT status
+func (q *Queue) Put(i Item) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var inflight uint64
+	if d := q.dests[i.key()]; d != nil {
+		inflight = d.inflight
+	}
+	kept := q.items[:0]
+	for _, other := range q.items {
+		if other.key() != i.key() || other.ID == inflight {
+			kept = append(kept, other)
+			continue
+		}
+		q.write(record{Remove: other.ID})
+	}
+	q.items = kept
+	q.add(i)
+	q.changed()
+}
+
+// add appends i. The caller must hold mu
+func (q *Queue) add(i Item) {
+	q.lastID++
+	i.ID = q.lastID
+	if i.Created.IsZero() {
+		i.Created = time.Now()
+	}
+	if q.size > 0 && len(q.items) >= q.size {
+		dropped := q.items[0]
+		slog.Warn("Notification queue full, dropping the oldest", "channel", dropped.Channel, "dest", dropped.Dest, "created", dropped.Created)
+		droppedTotal.WithLabelValues(dropped.Channel, "full").Inc()
+		q.items = q.items[1:]
+		q.write(record{Remove: dropped.ID})
+	}
+	q.items = append(q.items, i)
+	q.write(record{Add: &i})
+}
+
+// remove drops the item with the ID id. The caller must hold mu
+func (q *Queue) remove(id uint64) {
+	q.drop(id)
+	q.write(record{Remove: id})
+}
+
+// drop drops the item with the ID id without journaling it. The caller must
+// hold mu
+func (q *Queue) drop(id uint64) {
+	for n, i := range q.items {
+		if i.ID == id {
+			q.items = append(q.items[:n], q.items[n+1:]...)
+			return
+		}
+	}
+}
+
+// attempted counts an attempt to deliver the item with the ID id without
+// journaling it. The caller must hold mu
+func (q *Queue) attempted(id uint64) {
+	for n := range q.items {
+		if q.items[n].ID == id {
+			q.items[n].Attempts++
+		}
+	}
+}
+
+// changed compacts the journal once it has twice as many records as there
+// are items, or if it couldn't be written, and wakes Run. The caller must
+// hold mu
+func (q *Queue) changed() {
+	pending.Set(float64(len(q.items)))
+	if q.path != "" && (q.journal == nil || q.records >= minCompaction && q.records > 2*len(q.items)) {
+		if err := q.compact(); err != nil {
+			slog.Error("Could not compact notification queue", "path", q.path, "err", err)
+		}
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// write appends r to the journal. The caller must hold mu
+func (q *Queue) write(r record) {
+	if q.journal == nil {
+		return
+	}
+	data, err := json.Marshal(r)
+	if err == nil {
+		_, err = q.journal.Write(append(data, '\n'))
+	}
+	if err == nil {
+		err = q.journal.Sync()
+	}
+	q.records++
+	if err != nil {
+		slog.Error("Could not save notification queue", "path", q.path, "err", err)
+	}
+}
+
+// compact replaces the journal atomically with one adding the items, and
+// opens it for appending. The caller must hold mu
+func (q *Queue) compact() error {
+	var buf bytes.Buffer
+	for _, i := range q.items {
+		data, err := json.Marshal(record{Add: &i})
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+	if q.journal != nil {
+		q.journal.Close()
+	}
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		q.journal = nil
+		return err
+	}
+	q.journal, q.records = f, len(q.items)
+	return nil
+}
+
+// Run delivers the items until done is closed. Items of channels without a
+// handler, e.g. of a bot disabled since they were saved, are dropped
+func (q *Queue) Run(done <-chan struct{}) {
+	q.mu.Lock()
+	kept := q.items[:0]
+	for _, i := range q.items {
+		if q.handlers[i.Channel] != nil {
+			kept = append(kept, i)
+			continue
+		}
+		slog.Warn("Dropping notification of a disabled channel", "channel", i.Channel, "dest", i.Dest)
+		droppedTotal.WithLabelValues(i.Channel, "unhandled").Inc()
+		q.write(record{Remove: i.ID})
+	}
+	q.items = kept
+	q.changed()
+	q.mu.Unlock()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		timer.Reset(q.dispatch(time.Now()))
+		select {
+		case <-done:
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// dispatch drops expired items and starts delivering the first item of
+// every dest that is due. It returns how long to wait for the next one
+func (q *Queue) dispatch(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxAge > 0 {
+		kept := q.items[:0]
+		for _, i := range q.items {
+			if now.Sub(i.Created) < q.maxAge || q.dests[i.key()] != nil && q.dests[i.key()].inflight == i.ID {
+				kept = append(kept, i)
+				continue
+			}
+			slog.Warn("Dropping notification not delivered in time", "channel", i.Channel, "dest", i.Dest, "created", i.Created, "attempts", i.Attempts)
+			droppedTotal.WithLabelValues(i.Channel, "expired").Inc()
+			q.write(record{Remove: i.ID})
+		}
+		if len(kept) != len(q.items) {
+			q.items = kept
+			q.changed()
+		}
+	}
+
+	wait := expireInterval
+	seen := map[string]bool{}
+	for _, i := range q.items {
+		key := i.key()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		d := q.dests[key]
+		if d == nil {
+			d = &dest{}
+			q.dests[key] = d
+		}
+		if d.inflight != 0 {
+			continue
+		}
+		if now.Before(d.next) {
+			wait = min(wait, d.next.Sub(now))
+			continue
+		}
+		d.inflight = i.ID
+		go q.deliver(i, q.handlers[i.Channel])
+	}
+	return wait
+}
+
+func (q *Queue) deliver(i Item, h func(Item) error) {
+	err := errors.New("handler panicked")
+	supervisor.Run("notifications", func() { err = h(i) })
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d := q.dests[i.key()]
+	d.inflight = 0
+	var perm permanentError
+	switch {
+	case err == nil:
+		d.failures, d.next = 0, time.Time{}
+		q.remove(i.ID)
+	case errors.As(err, &perm):
+		slog.Warn("Notification rejected, dropping it", "channel", i.Channel, "dest", i.Dest, "err", err)
+		droppedTotal.WithLabelValues(i.Channel, "rejected").Inc()
+		q.remove(i.ID)
+	default:
+		d.failures++
+		backoff := maxBackoff
+		if d.failures < 8 {
+			backoff = min(minBackoff<<(d.failures-1), maxBackoff)
+		}
+		d.next = time.Now().Add(backoff)
+		q.attempted(i.ID)
+		q.write(record{Attempt: i.ID})
+		slog.Warn("Could not deliver notification, retrying", "channel", i.Channel, "dest", i.Dest, "attempt", i.Attempts+1, "retry_in", backoff, "err", err)
+	}
+	q.changed()
+}
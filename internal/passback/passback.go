@@ -0,0 +1,111 @@
+// Package passback detects cards passed back through the door to someone
+// else, or tailgating, by tracking which side of the door tokens are on
+package passback
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/events"
+)
+
+// ErrNotExited is returned for entries of tokens that entered before and
+// never exited
+var ErrNotExited = errors.New("token entered before and never exited")
+
+// Presence is a token that entered and didn't exit yet
+type Presence struct {
+	User string `json:"user,omitempty"`
+	Door string `json:"door,omitempty"`
+	// Reader is the entry reader it was swiped at
+	Reader string    `json:"reader"`
+	Since  time.Time `json:"since"`
+}
+
+// Tracker keeps track of the tokens inside, by the granted swipes at the
+// entry and exit readers, in memory. A nil *Tracker lets every token enter
+type Tracker struct {
+	// entry and exit are the labels of the readers outside and inside
+	entry, exit map[string]bool
+
+	mu sync.Mutex
+	// inside maps the token hashes inside to where they entered
+	inside map[string]Presence
+}
+
+// New returns a Tracker for swipes at the entry readers, outside the door,
+// and the exit readers, inside
+func New(entry, exit []string) *Tracker {
+	t := &Tracker{entry: map[string]bool{}, exit: map[string]bool{}, inside: map[string]Presence{}}
+	for _, label := range entry {
+		t.entry[label] = true
+	}
+	for _, label := range exit {
+		t.exit[label] = true
+	}
+	return t
+}
+
+// Check returns ErrNotExited if a is an entry of a token that is inside
+func (t *Tracker) Check(a events.Access) error {
+	if t == nil || !t.entry[a.Reader] || a.TokenHash == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.inside[a.TokenHash]; ok {
+		return ErrNotExited
+	}
+	return nil
+}
+
+// Record notes the token of a inside after a granted entry, and outside
+// after a granted exit. Locking by swipe lets nobody through
+func (t *Tracker) Record(a events.Access) {
+	if t == nil || a.Source != "rfid" || a.Result != events.ResultGranted || a.TokenHash == "" || a.Action == "close" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.entry[a.Reader]:
+		t.inside[a.TokenHash] = Presence{User: a.User, Door: a.Door, Reader: a.Reader, Since: a.Time}
+	case t.exit[a.Reader]:
+		delete(t.inside, a.TokenHash)
+	}
+}
+
+// Inside returns the tokens inside, longest inside first
+func (t *Tracker) Inside() []Presence {
+	list := []Presence{}
+	if t == nil {
+		return list
+	}
+	t.mu.Lock()
+	for _, p := range t.inside {
+		list = append(list, p)
+	}
+	t.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+// Reset lets the tokens of user, or all tokens if user is empty, enter again,
+// e.g. after leaving without swiping. It returns how many were inside
+func (t *Tracker) Reset(user string) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for hash, p := range t.inside {
+		if user == "" || p.User == user {
+			delete(t.inside, hash)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,86 @@
+// Package ratelimit slows down brute forcing of tokens and limits request
+// rates
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout blocks a key, e.g. a reader or a client address, for a cooldown
+// after too many failures within a window. A nil *Lockout never blocks
+type Lockout struct {
+	max      int
+	window   time.Duration
+	cooldown time.Duration
+	locked   func(key string)
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	blocked  map[string]time.Time
+}
+
+// New returns a Lockout blocking a key after max failures within window.
+// locked is called whenever a key gets blocked
+func New(max int, window, cooldown time.Duration, locked func(key string)) *Lockout {
+	return &Lockout{
+		max:      max,
+		window:   window,
+		cooldown: cooldown,
+		locked:   locked,
+		failures: map[string][]time.Time{},
+		blocked:  map[string]time.Time{},
+	}
+}
+
+// Blocked reports whether key is blocked at now
+func (l *Lockout) Blocked(key string, now time.Time) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, ok := l.blocked[key]
+	if ok && !now.Before(until) {
+		delete(l.blocked, key)
+		return false
+	}
+	return ok
+}
+
+// Fail records a failure of key at now
+func (l *Lockout) Fail(key string, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	// Forget failures outside the window, so the map doesn't grow with
+	// every address ever seen
+	for k, times := range l.failures {
+		for len(times) > 0 && now.Sub(times[0]) >= l.window {
+			times = times[1:]
+		}
+		if len(times) == 0 {
+			delete(l.failures, k)
+		} else {
+			l.failures[k] = times
+		}
+	}
+	for k, until := range l.blocked {
+		if !now.Before(until) {
+			delete(l.blocked, k)
+		}
+	}
+
+	times := append(l.failures[key], now)
+	if len(times) < l.max {
+		l.failures[key] = times
+		l.mu.Unlock()
+		return
+	}
+	delete(l.failures, key)
+	l.blocked[key] = now.Add(l.cooldown)
+	l.mu.Unlock()
+
+	l.locked(key)
+}
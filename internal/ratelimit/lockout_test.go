@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+func TestLockout(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var locked []string
+	l := New(3, time.Minute, 5*time.Minute, func(key string) { locked = append(locked, key) })
+
+	l.Fail("reader a", fake.Now())
+	fake.Advance(30 * time.Second)
+	l.Fail("reader a", fake.Now())
+	// The first failure falls out of the window
+	fake.Advance(31 * time.Second)
+	l.Fail("reader a", fake.Now())
+	if l.Blocked("reader a", fake.Now()) || len(locked) != 0 {
+		t.Fatal("blocked after failures spread over more than the window")
+	}
+
+	l.Fail("reader a", fake.Now())
+	if !l.Blocked("reader a", fake.Now()) || len(locked) != 1 {
+		t.Fatal("not blocked after 3 failures within the window")
+	}
+	if l.Blocked("reader b", fake.Now()) {
+		t.Fatal("blocked another key")
+	}
+
+	fake.Advance(5*time.Minute - time.Second)
+	if !l.Blocked("reader a", fake.Now()) {
+		t.Fatal("unblocked before the cooldown")
+	}
+	fake.Advance(time.Second)
+	if l.Blocked("reader a", fake.Now()) {
+		t.Fatal("still blocked after the cooldown")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWindow(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, ok := w.Allow(fake.Now()); !ok {
+			t.Fatalf("event %d refused", i)
+		}
+		fake.Advance(10 * time.Second)
+	}
+	retry, ok := w.Allow(fake.Now())
+	if ok || retry != 40*time.Second {
+		t.Fatalf("Allow over the limit = %v, %v, want refused for 40s", retry, ok)
+	}
+	fake.Advance(retry)
+	if _, ok := w.Allow(fake.Now()); !ok {
+		t.Fatal("refused after the retry delay")
+	}
+}
+
+func TestNilLockout(t *testing.T) {
+	var l *Lockout
+	l.Fail("reader a", time.Now())
+	if l.Blocked("reader a", time.Now()) {
+		t.Fatal("nil Lockout blocked")
+	}
+}
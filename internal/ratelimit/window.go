@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Window lets through at most max events within a sliding window, e.g. the
+// requests of an API key. A nil *Window lets everything through
+type Window struct {
+	max    int
+	window time.Duration
+
+	mu sync.Mutex
+	// times are when the events within the window happened, oldest first
+	times []time.Time
+}
+
+// NewWindow returns a Window letting through max events per window
+func NewWindow(max int, window time.Duration) *Window {
+	return &Window{max: max, window: window}
+}
+
+// Allow records an event at now if it is within the limit. Otherwise it
+// returns how long until the next one is
+func (w *Window) Allow(now time.Time) (time.Duration, bool) {
+	if w == nil {
+		return 0, true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.times) > 0 && now.Sub(w.times[0]) >= w.window {
+		w.times = w.times[1:]
+	}
+	if len(w.times) >= w.max {
+		return w.window - now.Sub(w.times[0]), false
+	}
+	w.times = append(w.times, now)
+	return 0, true
+}
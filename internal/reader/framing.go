@@ -0,0 +1,137 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// FramingMode is how a serial reader delimits the tokens it sends
+type FramingMode int
+
+const (
+	// FramingSTX is ASCII between STX and ETX
+	FramingSTX FramingMode = iota
+	// FramingLine is ASCII terminated by CR, LF or both
+	FramingLine
+	// FramingBinary is a fixed number of raw bytes, decoded as hex
+	FramingBinary
+)
+
+// binaryFrameGap is the pause after which the bytes received so far are
+// dropped as an incomplete frame, so a lost byte doesn't shift every later
+// frame
+const binaryFrameGap = 100 * time.Millisecond
+
+// Framing configures the port of a serial reader and how tokens are read
+// from it. The zero value is STX/ETX at 9600 baud without parity
+type Framing struct {
+	Mode FramingMode
+	// Length is the number of bytes of a binary frame
+	Length   int
+	BaudRate int
+	Parity   serial.Parity
+}
+
+// ParseFraming parses a framing mode like stx, line or binary:5 for frames
+// of 5 bytes, and a parity of none, even or odd
+func ParseFraming(mode string, baudRate int, parity string) (Framing, error) {
+	f := Framing{BaudRate: baudRate}
+	if baudRate <= 0 {
+		return f, fmt.Errorf("invalid baud rate %d", baudRate)
+	}
+
+	switch {
+	case mode == "stx":
+		f.Mode = FramingSTX
+	case mode == "line":
+		f.Mode = FramingLine
+	case strings.HasPrefix(mode, "binary:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(mode, "binary:"))
+		if err != nil || n <= 0 || n > 64 {
+			return f, fmt.Errorf("invalid binary frame length in %q", mode)
+		}
+		f.Mode, f.Length = FramingBinary, n
+	default:
+		return f, fmt.Errorf("invalid framing %q, expected stx, line or binary:LENGTH", mode)
+	}
+
+	switch parity {
+	case "none":
+		f.Parity = serial.NoParity
+	case "even":
+		f.Parity = serial.EvenParity
+	case "odd":
+		f.Parity = serial.OddParity
+	default:
+		return f, fmt.Errorf("invalid parity %q, expected none, even or odd", parity)
+	}
+	return f, nil
+}
+
+func (f Framing) mode() *serial.Mode {
+	mode := &serial.Mode{BaudRate: f.BaudRate, Parity: f.Parity}
+	if mode.BaudRate == 0 {
+		mode.BaudRate = 9600
+	}
+	return mode
+}
+
+// readToken reads the next token from rd and decodes it
+func (f Framing) readToken(rd *bufio.Reader) (string, error) {
+	switch f.Mode {
+	case FramingLine:
+		return readLine(rd)
+	case FramingBinary:
+		return readBinary(rd, f.Length)
+	}
+	res, err := rd.ReadBytes('\x03')
+	if err != nil {
+		return "", err
+	}
+	s := strings.Replace(string(res), "\x03", "", -1)
+	return strings.Replace(s, "\x02", "", -1), nil
+}
+
+// readLine returns the next non-empty line, ended by CR or LF
+func readLine(rd *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		b, err := rd.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b != '\r' && b != '\n' {
+			line = append(line, b)
+			continue
+		}
+		if s := strings.TrimSpace(string(line)); s != "" {
+			return s, nil
+		}
+		line = line[:0]
+	}
+}
+
+// readBinary returns the next frame of length bytes as upper case hex
+func readBinary(rd *bufio.Reader, length int) (string, error) {
+	frame := make([]byte, 0, length)
+	var last time.Time
+	for len(frame) < length {
+		b, err := rd.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		now := time.Now()
+		if len(frame) > 0 && now.Sub(last) > binaryFrameGap {
+			frame = frame[:0]
+		}
+		last = now
+		frame = append(frame, b)
+	}
+	return strings.ToUpper(hex.EncodeToString(frame)), nil
+}
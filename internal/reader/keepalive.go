@@ -0,0 +1,87 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// keepalive checks the reader every r.Keepalive until done is closed. A
+// reader failing the check is closed, which fails the read in Run, so the
+// port is reopened like after a read error
+func (r *Serial) keepalive(done <-chan struct{}) {
+	ticker := time.NewTicker(r.Keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		err := r.probe()
+		if err != nil && r.port != nil {
+			keepaliveFailuresTotal.Inc()
+			slog.Warn("Reader failed keepalive, reopening", "reader", r.String(), "err", err)
+			r.port.Close()
+			r.port = nil
+			r.setProblem(err.Error())
+		}
+		r.mu.Unlock()
+	}
+}
+
+// probe checks that the device node still exists, as it disappears when a
+// USB reader is unplugged, and that the last ping was answered before
+// sending the next one. Pings are paused during a passthrough. It is called
+// with r.mu held
+func (r *Serial) probe() error {
+	if r.port == nil {
+		// Being reopened
+		return nil
+	}
+	if filepath.IsAbs(r.Device) {
+		if _, err := os.Stat(r.Device); err != nil {
+			return fmt.Errorf("device %s disappeared", r.Device)
+		}
+	}
+	if r.Ping == nil || r.passthrough != nil {
+		return nil
+	}
+	if r.PingReply != "" && !r.pinged.IsZero() {
+		r.silent = true
+		return fmt.Errorf("no reply to ping within %v", time.Since(r.pinged).Round(time.Second))
+	}
+	if _, err := r.port.Write(r.Ping); err != nil {
+		return errors.New("could not send ping: " + err.Error())
+	}
+	if r.PingReply != "" {
+		r.pinged = time.Now()
+	}
+	return nil
+}
+
+// answered records that the reader sent something, so it is alive
+func (r *Serial) answered() {
+	r.mu.Lock()
+	r.pinged = time.Time{}
+	if r.silent {
+		r.silent = false
+		r.setProblem("")
+	}
+	r.mu.Unlock()
+}
+
+// setProblem records why the reader is unhealthy, or "" if it is healthy.
+// It is called with r.mu held
+func (r *Serial) setProblem(problem string) {
+	r.problem = problem
+	up := 0.0
+	if problem == "" {
+		up = 1
+	}
+	readerUp.WithLabelValues(r.String()).Set(up)
+}
@@ -0,0 +1,23 @@
+package reader
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ReadLines sends every non-empty line of r as a swipe at the reader label,
+// e.g. to simulate swipes from stdin
+func ReadLines(r io.Reader, label string, c chan<- Swipe) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if token := strings.TrimSpace(scanner.Text()); token != "" {
+			c <- Swipe{Reader: label, Token: token, Time: time.Now()}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("Could not read swipes", "reader", label, "err", err)
+	}
+}
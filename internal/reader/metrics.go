@@ -0,0 +1,29 @@
+package reader
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	serialReadErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wishbone_serial_read_errors_total",
+		Help: "Errors while reading from the RFID reader.",
+	})
+	serialReconnectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wishbone_serial_reconnects_total",
+		Help: "Attempts to reopen an RFID reader after an error.",
+	})
+	keepaliveFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wishbone_serial_keepalive_failures_total",
+		Help: "Serial readers reopened because they were unplugged or didn't answer the keepalive ping.",
+	})
+	readerUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wishbone_reader_up",
+		Help: "Whether a serial reader is open and answering the keepalive.",
+	}, []string{"reader"})
+	desfireFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wishbone_desfire_auth_failures_total",
+		Help: "Cards on PC/SC readers failing DESFire authentication.",
+	})
+)
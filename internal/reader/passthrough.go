@@ -0,0 +1,87 @@
+package reader
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// ErrPassthroughRunning is returned when a passthrough to a reader is
+// started while another one runs
+var ErrPassthroughRunning = errors.New("another passthrough to the reader is running")
+
+// Passthrough is a raw connection to a serial reader in maintenance mode,
+// e.g. to change the settings of its firmware. Meanwhile, no tokens are read
+// from the reader and it isn't pinged
+type Passthrough struct {
+	r        *Serial
+	received io.Writer
+}
+
+// StartPassthrough hands everything received from the reader to received
+// instead of reading tokens from it, until the passthrough is closed.
+// received is called from the goroutine of Run, which it blocks while
+// writing
+func (r *Serial) StartPassthrough(received io.Writer) (*Passthrough, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.passthrough != nil {
+		return nil, ErrPassthroughRunning
+	}
+	p := &Passthrough{r: r, received: received}
+	r.passthrough = p
+	r.pinged = time.Time{}
+	slog.Warn("Reader in maintenance mode, not reading tokens", "reader", r.String())
+	return p, nil
+}
+
+// Write sends b to the reader as is
+func (p *Passthrough) Write(b []byte) (int, error) {
+	if err := p.r.Send(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close ends the passthrough, so tokens are read again
+func (p *Passthrough) Close() error {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+	if p.r.passthrough == p {
+		p.r.passthrough = nil
+		// Replies to the passthrough didn't answer a ping
+		p.r.pinged = time.Time{}
+		slog.Info("Reader back from maintenance mode", "reader", p.r.String())
+	}
+	return nil
+}
+
+// current returns the running passthrough, or nil
+func (r *Serial) current() *Passthrough {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.passthrough
+}
+
+// portReader reads from the port of a reader, handing what it reads to the
+// running passthrough instead of returning it
+type portReader struct {
+	r    *Serial
+	port io.Reader
+}
+
+func (p portReader) Read(b []byte) (int, error) {
+	for {
+		n, err := p.port.Read(b)
+		pt := p.r.current()
+		if pt == nil || n == 0 {
+			return n, err
+		}
+		// The client having gone away doesn't concern reading tokens
+		pt.received.Write(b[:n])
+		if err != nil {
+			return 0, err
+		}
+	}
+}
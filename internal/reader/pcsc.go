@@ -0,0 +1,174 @@
+package reader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// pcscPollInterval is how often Run checks whether it was stopped while
+// waiting for a card
+const pcscPollInterval = time.Second
+
+// PCSC is a USB NFC reader like the ACR122U, accessed through the PC/SC
+// daemon. Tokens are the card UIDs in hex. If DESFire is set, cards must
+// also prove they know the application key, so a copied UID isn't enough
+type PCSC struct {
+	Label string
+	// Name selects the first PC/SC reader whose name contains it; empty
+	// selects the first reader
+	Name    string
+	DESFire *DESFire
+
+	conn    pcscConn
+	stopped chan struct{}
+}
+
+// parsePCSC parses a reader given as pcsc:NAME
+func parsePCSC(label, name string, desfire *DESFire) *PCSC {
+	return &PCSC{Label: label, Name: name, DESFire: desfire}
+}
+
+func (r *PCSC) String() string {
+	if r.Name == "" {
+		return fmt.Sprintf("%s (PC/SC)", r.Label)
+	}
+	return fmt.Sprintf("%s (PC/SC %s)", r.Label, r.Name)
+}
+
+// getUID is the PC/SC pseudo APDU returning the UID of the card
+var getUID = []byte{0xFF, 0xCA, 0x00, 0x00, 0x00}
+
+// readUID returns the UID of the card in hex
+func readUID(transmit func([]byte) ([]byte, error)) (string, error) {
+	res, err := transmit(getUID)
+	if err != nil {
+		return "", err
+	}
+	if len(res) < 3 || res[len(res)-2] != 0x90 || res[len(res)-1] != 0x00 {
+		return "", fmt.Errorf("could not read UID, card replied % X", res)
+	}
+	return strings.ToUpper(hex.EncodeToString(res[:len(res)-2])), nil
+}
+
+// DESFire is the application and AES key MIFARE DESFire EV1 cards have to
+// authenticate with
+type DESFire struct {
+	AID   [3]byte
+	KeyNo byte
+	Key   []byte
+}
+
+// LoadDESFire returns the DESFire application aid (6 hex digits) with key
+// keyNo, read hex encoded from keyPath
+func LoadDESFire(aid string, keyNo int, keyPath string) (*DESFire, error) {
+	d := &DESFire{}
+	b, err := hex.DecodeString(aid)
+	if err != nil || len(b) != 3 {
+		return nil, fmt.Errorf("invalid DESFire application %q, expected 6 hex digits", aid)
+	}
+	copy(d.AID[:], b)
+	if keyNo < 0 || keyNo > 13 {
+		return nil, fmt.Errorf("invalid DESFire key number %d", keyNo)
+	}
+	d.KeyNo = byte(keyNo)
+	text, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if d.Key, err = hex.DecodeString(strings.TrimSpace(string(text))); err != nil || len(d.Key) != 16 {
+		return nil, fmt.Errorf("%s: expected a hex encoded 16 byte AES key", keyPath)
+	}
+	return d, nil
+}
+
+// DESFire native commands and status codes
+const (
+	desfireSelectApplication = 0x5A
+	desfireAuthenticateAES   = 0xAA
+	desfireAdditionalFrame   = 0xAF
+	desfireOK                = 0x00
+)
+
+// errNotAuthenticated is returned for cards not knowing the key
+var errNotAuthenticated = errors.New("card failed DESFire authentication")
+
+// desfireCommand sends a native DESFire command wrapped in an ISO 7816
+// APDU and returns the response data and status
+func desfireCommand(transmit func([]byte) ([]byte, error), cmd byte, data []byte) ([]byte, byte, error) {
+	apdu := []byte{0x90, cmd, 0x00, 0x00}
+	if len(data) > 0 {
+		apdu = append(apdu, byte(len(data)))
+		apdu = append(apdu, data...)
+	}
+	apdu = append(apdu, 0x00)
+	res, err := transmit(apdu)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(res) < 2 || res[len(res)-2] != 0x91 {
+		return nil, 0, fmt.Errorf("not a DESFire card, it replied % X", res)
+	}
+	return res[:len(res)-2], res[len(res)-1], nil
+}
+
+// Authenticate selects the application and runs the AES mutual
+// authentication with the card. The card and the reader each prove they
+// know the key by encrypting a random challenge of the other
+func (d *DESFire) Authenticate(transmit func([]byte) ([]byte, error)) error {
+	// The AID is sent least significant byte first
+	_, status, err := desfireCommand(transmit, desfireSelectApplication, []byte{d.AID[2], d.AID[1], d.AID[0]})
+	if err != nil {
+		return err
+	}
+	if status != desfireOK {
+		return fmt.Errorf("could not select DESFire application %X: status %02X", d.AID, status)
+	}
+
+	block, err := aes.NewCipher(d.Key)
+	if err != nil {
+		return err
+	}
+	encRndB, status, err := desfireCommand(transmit, desfireAuthenticateAES, []byte{d.KeyNo})
+	if err != nil {
+		return err
+	}
+	if status != desfireAdditionalFrame || len(encRndB) != aes.BlockSize {
+		return errNotAuthenticated
+	}
+	iv := make([]byte, aes.BlockSize)
+	rndB := make([]byte, aes.BlockSize)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(rndB, encRndB)
+
+	rndA := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(rndA); err != nil {
+		return err
+	}
+	challenge := append(append([]byte{}, rndA...), rotate(rndB)...)
+	cipher.NewCBCEncrypter(block, encRndB).CryptBlocks(challenge, challenge)
+	encRndA, status, err := desfireCommand(transmit, desfireAdditionalFrame, challenge)
+	if err != nil {
+		return err
+	}
+	if status != desfireOK || len(encRndA) != aes.BlockSize {
+		return errNotAuthenticated
+	}
+	rotatedA := make([]byte, aes.BlockSize)
+	cipher.NewCBCDecrypter(block, challenge[aes.BlockSize:]).CryptBlocks(rotatedA, encRndA)
+	if !bytes.Equal(rotatedA, rotate(rndA)) {
+		return errNotAuthenticated
+	}
+	return nil
+}
+
+// rotate returns b rotated left by one byte
+func rotate(b []byte) []byte {
+	return append(append([]byte{}, b[1:]...), b[0])
+}
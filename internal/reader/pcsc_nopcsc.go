@@ -0,0 +1,20 @@
+//go:build !pcsc
+// +build !pcsc
+
+package reader
+
+import "errors"
+
+var errNoPCSC = errors.New("PC/SC readers need PC/SC support, which was not built in; build with -tags pcsc")
+
+type pcscConn struct{}
+
+func (r *PCSC) Open() error {
+	return errNoPCSC
+}
+
+func (r *PCSC) Close() error {
+	return nil
+}
+
+func (r *PCSC) Run(c chan<- Swipe, errs chan<- error, done <-chan struct{}) {}
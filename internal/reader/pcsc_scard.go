@@ -0,0 +1,129 @@
+//go:build pcsc
+// +build pcsc
+
+package reader
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"github.com/ebfe/scard"
+)
+
+// pcscConn is the PC/SC context and the full name of the reader
+type pcscConn struct {
+	ctx    *scard.Context
+	reader string
+}
+
+func (r *PCSC) Open() error {
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		return err
+	}
+	names, err := ctx.ListReaders()
+	if err != nil {
+		ctx.Release()
+		return err
+	}
+	for _, name := range names {
+		if strings.Contains(name, r.Name) {
+			r.conn = pcscConn{ctx: ctx, reader: name}
+			return nil
+		}
+	}
+	ctx.Release()
+	return fmt.Errorf("no PC/SC reader matching %q", r.Name)
+}
+
+// Close releases the PC/SC context. If Run was started, its done channel
+// must be closed before
+func (r *PCSC) Close() error {
+	if r.stopped != nil {
+		<-r.stopped
+	}
+	if r.conn.ctx == nil {
+		return nil
+	}
+	err := r.conn.ctx.Release()
+	r.conn.ctx = nil
+	return err
+}
+
+func (r *PCSC) Run(c chan<- Swipe, errs chan<- error, done <-chan struct{}) {
+	r.stopped = make(chan struct{})
+	go func() {
+		defer close(r.stopped)
+		supervisor.Restart("reader "+r.String(), func() { r.run(c, done) })
+	}()
+}
+
+// run waits for cards to be put on the reader and reads each once
+func (r *PCSC) run(c chan<- Swipe, done <-chan struct{}) {
+	states := []scard.ReaderState{{Reader: r.conn.reader, CurrentState: scard.StateUnaware}}
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		err := r.conn.ctx.GetStatusChange(states, pcscPollInterval)
+		if err == scard.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			slog.Warn("Could not read from reader", "reader", r.String(), "err", err)
+			serialReadErrorsTotal.Inc()
+			select {
+			case <-done:
+				return
+			case <-time.After(pcscPollInterval):
+			}
+			continue
+		}
+		arrived := states[0].EventState&scard.StatePresent != 0 && states[0].CurrentState&scard.StatePresent == 0
+		states[0].CurrentState = states[0].EventState
+		if !arrived {
+			continue
+		}
+
+		token, err := r.read()
+		if err == errNotAuthenticated {
+			slog.Warn("Card failed DESFire authentication, it may be a clone", "reader", r.String())
+			desfireFailuresTotal.Inc()
+			continue
+		}
+		if err != nil {
+			slog.Warn("Could not read from reader", "reader", r.String(), "err", err)
+			serialReadErrorsTotal.Inc()
+			continue
+		}
+		c <- Swipe{Reader: r.Label, Token: token, Time: time.Now()}
+	}
+}
+
+// read returns the UID of the card on the reader, authenticating it first
+// if DESFire is set
+func (r *PCSC) read() (string, error) {
+	card, err := r.conn.ctx.Connect(r.conn.reader, scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		return "", err
+	}
+	// Resetting the card drops the authentication
+	defer card.Disconnect(scard.ResetCard)
+
+	token, err := readUID(card.Transmit)
+	if err != nil {
+		return "", err
+	}
+	if r.DESFire != nil {
+		if err := r.DESFire.Authenticate(card.Transmit); err != nil {
+			return "", err
+		}
+	}
+	return token, nil
+}
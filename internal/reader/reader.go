@@ -0,0 +1,264 @@
+// Package reader reads tokens from RFID readers
+package reader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/supervisor"
+	"go.bug.st/serial"
+)
+
+// Backoff between attempts to reopen a failed serial port
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 1 * time.Minute
+)
+
+// Reader is an RFID reader. Its label, e.g. the side of the door it is
+// mounted on, is recorded with every swipe
+type Reader interface {
+	Open() error
+	Close() error
+	// Run reads tokens into c until done is closed. If the reader fails for
+	// good, the error is sent to errs and Run stops. The reader must have
+	// been opened before
+	Run(c chan<- Swipe, errs chan<- error, done <-chan struct{})
+	String() string
+}
+
+// Serial is a reader attached to a serial port, sending tokens framed as
+// configured by Framing
+type Serial struct {
+	Label   string
+	Device  string
+	Framing Framing
+	// MaxFailures is how many consecutive errors Run tolerates before giving
+	// up; 0 retries forever
+	MaxFailures int
+	// Keepalive is how often Run checks that the device still exists and
+	// sends Ping, unless it is nil. If PingReply is set, a reader not
+	// answering with that token until the next check is reopened. 0
+	// disables the checks
+	Keepalive time.Duration
+	Ping      []byte
+	PingReply string
+	// OpenPort opens the port instead of Device, e.g. a fake one in tests
+	OpenPort func() (io.ReadWriteCloser, error)
+
+	mu   sync.Mutex
+	port io.ReadWriteCloser
+	// problem is why the reader is unhealthy; "" if it isn't
+	problem string
+	// pinged is when the unanswered ping was sent; zero if none is
+	pinged time.Time
+	// silent is set when the reader was reopened for not answering, so it
+	// stays unhealthy until it does
+	silent bool
+	// passthrough is nil unless the reader is in maintenance mode
+	passthrough *Passthrough
+}
+
+// Swipe is a token read by a reader
+type Swipe struct {
+	Reader string
+	Token  string
+	// Time is when the token was read
+	Time time.Time
+}
+
+// Parse returns the readers given as comma separated label=device pairs, or
+// a single unlabeled serial reader at device if spec is empty. Devices like
+// wiegand:17:18 are Wiegand readers with D0 and D1 at the given BCM pins,
+// devices like pcsc:ACR122 PC/SC readers. maxFailures and framing are passed
+// on to the serial readers, desfire to the PC/SC readers
+func Parse(spec, device string, maxFailures int, framing Framing, desfire *DESFire) ([]Reader, error) {
+	if spec == "" {
+		return []Reader{&Serial{Device: device, Framing: framing, MaxFailures: maxFailures}}, nil
+	}
+
+	var readers []Reader
+	labels := map[string]bool{}
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid reader %q, expected label=device", pair)
+		}
+		if labels[parts[0]] {
+			return nil, fmt.Errorf("duplicate reader label %q", parts[0])
+		}
+		labels[parts[0]] = true
+		if pins := strings.TrimPrefix(parts[1], "wiegand:"); pins != parts[1] {
+			w, err := parseWiegand(parts[0], pins)
+			if err != nil {
+				return nil, err
+			}
+			readers = append(readers, w)
+			continue
+		}
+		if name := strings.TrimPrefix(parts[1], "pcsc:"); name != parts[1] {
+			readers = append(readers, parsePCSC(parts[0], name, desfire))
+			continue
+		}
+		readers = append(readers, &Serial{Label: parts[0], Device: parts[1], Framing: framing, MaxFailures: maxFailures})
+	}
+	return readers, nil
+}
+
+func (r *Serial) String() string {
+	if r.Label == "" {
+		return r.Device
+	}
+	return fmt.Sprintf("%s (%s)", r.Label, r.Device)
+}
+
+func (r *Serial) Open() error {
+	var port io.ReadWriteCloser
+	var err error
+	if r.OpenPort != nil {
+		port, err = r.OpenPort()
+	} else {
+		port, err = serial.Open(r.Device, r.Framing.mode())
+	}
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.port = port
+	r.pinged = time.Time{}
+	if !r.silent {
+		r.setProblem("")
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Serial) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.port == nil {
+		return nil
+	}
+	err := r.port.Close()
+	r.port = nil
+	r.setProblem("port closed, reconnecting")
+	return err
+}
+
+// Check returns why the reader is unhealthy: its port being reopened after
+// an error, it not answering the keepalive, or a passthrough running
+func (r *Serial) Check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.passthrough != nil {
+		return errors.New("in maintenance mode, not reading tokens")
+	}
+	if r.problem != "" {
+		return errors.New(r.problem)
+	}
+	return nil
+}
+
+// Send writes p to the port, e.g. a command making the reader beep
+func (r *Serial) Send(p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.port == nil {
+		return errors.New("port not open")
+	}
+	_, err := r.port.Write(p)
+	return err
+}
+
+func (r *Serial) newBufferedReader() *bufio.Reader {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return bufio.NewReader(portReader{r: r, port: r.port})
+}
+
+// Run reads tokens from the port into c until done is closed. If reading
+// fails, the port is reopened with exponential backoff
+func (r *Serial) Run(c chan<- Swipe, errs chan<- error, done <-chan struct{}) {
+	if r.Keepalive > 0 {
+		supervisor.Go("reader keepalive "+r.String(), func() { r.keepalive(done) })
+	}
+	supervisor.Go("reader "+r.String(), func() {
+		failures := 0
+		rd := r.newBufferedReader()
+		for {
+			token, err := r.Framing.readToken(rd)
+			if err != nil {
+				select {
+				case <-done:
+					// The port was closed during shutdown
+					return
+				default:
+				}
+				serialReadErrorsTotal.Inc()
+				slog.Warn("Could not read from reader", "reader", r.String(), "err", err)
+				failures++
+				if err := r.reconnect(&failures, done); err != nil {
+					if err != errDone {
+						errs <- err
+					}
+					return
+				}
+				rd = r.newBufferedReader()
+				continue
+			}
+			failures = 0
+			// Any token shows the reader is alive
+			r.answered()
+			if r.PingReply != "" && token == r.PingReply {
+				continue
+			}
+			c <- Swipe{Reader: r.Label, Token: token, Time: time.Now()}
+		}
+	})
+}
+
+// errDone is returned by reconnect if done was closed while waiting
+var errDone = errors.New("reader stopped")
+
+// reconnect reopens the port until it succeeds, counting failed attempts in
+// failures. Returns an error if there are too many
+func (r *Serial) reconnect(failures *int, done <-chan struct{}) error {
+	r.Close()
+	for {
+		if r.MaxFailures > 0 && *failures >= r.MaxFailures {
+			return fmt.Errorf("giving up on %v after %d failures", r, *failures)
+		}
+
+		delay := maxReconnectDelay
+		if *failures < 8 {
+			delay = minReconnectDelay << (*failures - 1)
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+		select {
+		case <-done:
+			return errDone
+		case <-time.After(delay):
+		}
+
+		serialReconnectsTotal.Inc()
+		slog.Info("Reconnecting to reader", "reader", r.String(), "attempt", *failures)
+		err := r.Open()
+		if err == nil {
+			slog.Info("Reconnected to reader", "reader", r.String())
+			return nil
+		}
+		slog.Warn("Could not reopen reader", "reader", r.String(), "err", err)
+		r.mu.Lock()
+		r.setProblem("could not reopen: " + err.Error())
+		r.mu.Unlock()
+		*failures++
+	}
+}
@@ -0,0 +1,99 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wiegandFrameGap ends a frame
+const wiegandFrameGap = 25 * time.Millisecond
+
+var errWiegandLost = errors.New("missed bits of Wiegand frame")
+
+// Wiegand is a reader sending Wiegand 26 or 34 frames over two GPIO data
+// lines: a pulse on D0 is a 0 bit, a pulse on D1 a 1 bit. Tokens are the
+// frame's data bits in hex, without the parity bits
+type Wiegand struct {
+	Label string
+	// D0 and D1 are BCM pin numbers, that is lines of Chip, the one of the
+	// Raspberry Pi's pin header if empty
+	D0, D1 int
+	Chip   string
+
+	events  wiegandEvents
+	stopped chan struct{}
+}
+
+// parseWiegand parses the pins of a reader given as wiegand:D0:D1
+func parseWiegand(label, pins string) (*Wiegand, error) {
+	parts := strings.Split(pins, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid Wiegand reader %q, expected wiegand:D0:D1", label)
+	}
+	w := &Wiegand{Label: label}
+	var err error
+	if w.D0, err = strconv.Atoi(parts[0]); err != nil {
+		return nil, fmt.Errorf("invalid Wiegand D0 pin %q", parts[0])
+	}
+	if w.D1, err = strconv.Atoi(parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid Wiegand D1 pin %q", parts[1])
+	}
+	if w.D0 == w.D1 {
+		return nil, fmt.Errorf("Wiegand reader %q uses GPIO %d for D0 and D1", label, w.D0)
+	}
+	return w, nil
+}
+
+func (w *Wiegand) String() string {
+	return fmt.Sprintf("%s (Wiegand on GPIO %d/%d)", w.Label, w.D0, w.D1)
+}
+
+// decodeWiegand checks the parity bits of a frame of n bits, where the
+// first bit is the even parity of the first half and the last bit the odd
+// parity of the second half, and returns the data bits in hex
+func decodeWiegand(frame uint64, n int) (string, error) {
+	if n != 26 && n != 34 {
+		return "", fmt.Errorf("unsupported Wiegand frame of %d bits", n)
+	}
+	half := n / 2
+	if bits.OnesCount64(frame>>half)%2 != 0 || bits.OnesCount64(frame&(1<<half-1))%2 != 1 {
+		return "", fmt.Errorf("parity error in Wiegand frame %0*b", n, frame)
+	}
+	data := (frame >> 1) & (1<<(n-2) - 1)
+	return fmt.Sprintf("%0*X", (n-2)/4, data), nil
+}
+
+// wiegandFrame assembles a frame from the pulses on the data lines
+type wiegandFrame struct {
+	bits  uint64
+	n     int
+	lost  bool
+	seqno uint32
+}
+
+// add appends a bit, given the sequence number of its edge event. Frames
+// with a gap in the sequence numbers are rejected
+func (f *wiegandFrame) add(one bool, seqno uint32) {
+	if f.n > 0 && seqno != f.seqno+1 {
+		f.lost = true
+	}
+	f.seqno = seqno
+	f.bits <<= 1
+	if one {
+		f.bits |= 1
+	}
+	f.n++
+}
+
+// end decodes the frame and starts the next one
+func (f *wiegandFrame) end() (string, error) {
+	defer func() { *f = wiegandFrame{} }()
+	if f.lost {
+		return "", errWiegandLost
+	}
+	return decodeWiegand(f.bits, f.n)
+}
@@ -0,0 +1,90 @@
+//go:build linux && !nogpio
+// +build linux,!nogpio
+
+package reader
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/gpiod"
+	"github.com/craftamap/wishbone/internal/supervisor"
+)
+
+type wiegandEvents = *gpiod.Events
+
+// Open requests the data lines from the GPIO character device. The kernel
+// timestamps and queues each pulse, so none are lost while the daemon is
+// busy
+func (w *Wiegand) Open() error {
+	name := w.Chip
+	if name == "" {
+		var err error
+		if name, err = gpiod.Find(); err != nil {
+			return err
+		}
+	}
+	chip, err := gpiod.Open(name)
+	if err != nil {
+		return err
+	}
+	defer chip.Close()
+	// The data lines idle high and pulse low
+	w.events, err = chip.RequestEvents([]int{w.D0, w.D1}, "wishbone", gpiod.EventConfig{Falling: true, PullUp: true})
+	return err
+}
+
+// Close releases the data lines. If Run was started, its done channel must
+// be closed before
+func (w *Wiegand) Close() error {
+	if w.stopped != nil {
+		<-w.stopped
+	}
+	if w.events == nil {
+		return nil
+	}
+	return w.events.Close()
+}
+
+func (w *Wiegand) Run(c chan<- Swipe, errs chan<- error, done <-chan struct{}) {
+	w.stopped = make(chan struct{})
+	go func() {
+		defer close(w.stopped)
+		supervisor.Restart("reader "+w.String(), func() { w.run(c, errs, done) })
+	}()
+}
+
+func (w *Wiegand) run(c chan<- Swipe, errs chan<- error, done <-chan struct{}) {
+	var frame wiegandFrame
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		// Waiting no longer than the gap between frames both ends them and
+		// notices done
+		e, err := w.events.Read(time.Now().Add(wiegandFrameGap))
+		switch {
+		case err == nil:
+			frame.add(e.Offset == w.D1, e.Seqno)
+			continue
+		case !errors.Is(err, os.ErrDeadlineExceeded):
+			errs <- err
+			return
+		case frame.n == 0:
+			continue
+		}
+
+		token, err := frame.end()
+		if err != nil {
+			slog.Warn("Could not read from reader", "reader", w.String(), "err", err)
+			serialReadErrorsTotal.Inc()
+			continue
+		}
+		c <- Swipe{Reader: w.Label, Token: token, Time: time.Now()}
+	}
+}
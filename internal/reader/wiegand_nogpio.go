@@ -0,0 +1,20 @@
+//go:build nogpio || !linux
+// +build nogpio !linux
+
+package reader
+
+import "errors"
+
+type wiegandEvents struct{}
+
+var errNoGPIO = errors.New("Wiegand readers need the Linux GPIO character device, whose support was not built in")
+
+func (w *Wiegand) Open() error {
+	return errNoGPIO
+}
+
+func (w *Wiegand) Close() error {
+	return nil
+}
+
+func (w *Wiegand) Run(c chan<- Swipe, errs chan<- error, done <-chan struct{}) {}
@@ -0,0 +1,82 @@
+package reader
+
+import (
+	"errors"
+	"math/bits"
+	"testing"
+)
+
+// wiegandBits frames data of n-2 bits with its parity bits
+func wiegandBits(data uint64, n int) uint64 {
+	half := n/2 - 1
+	even := uint64(bits.OnesCount64(data>>half) % 2)
+	odd := uint64(1 - bits.OnesCount64(data&(1<<half-1))%2)
+	return even<<(n-1) | data<<1 | odd
+}
+
+func TestDecodeWiegand(t *testing.T) {
+	for _, tc := range []struct {
+		data uint64
+		n    int
+		want string
+	}{
+		{0x1234AB, 26, "1234AB"},
+		{0x000001, 26, "000001"},
+		{0xDEADBEEF, 34, "DEADBEEF"},
+	} {
+		got, err := decodeWiegand(wiegandBits(tc.data, tc.n), tc.n)
+		if err != nil || got != tc.want {
+			t.Errorf("decodeWiegand(%X, %d) = %q, %v, want %q", tc.data, tc.n, got, err, tc.want)
+		}
+	}
+}
+
+func TestDecodeWiegandRejects(t *testing.T) {
+	frame := wiegandBits(0x1234AB, 26)
+	for name, tc := range map[string]struct {
+		frame uint64
+		n     int
+	}{
+		"short":       {frame >> 1, 25},
+		"long":        {frame<<1 | 1, 27},
+		"even parity": {frame ^ 1<<25, 26},
+		"odd parity":  {frame ^ 1, 26},
+		"data bit":    {frame ^ 1<<5, 26},
+	} {
+		if token, err := decodeWiegand(tc.frame, tc.n); err == nil {
+			t.Errorf("%s: decoded %q", name, token)
+		}
+	}
+}
+
+func TestWiegandFrame(t *testing.T) {
+	frame := wiegandBits(0xC0FFEE, 26)
+	var f wiegandFrame
+	for i := 25; i >= 0; i-- {
+		f.add(frame>>i&1 == 1, uint32(100+25-i))
+	}
+	if token, err := f.end(); err != nil || token != "C0FFEE" {
+		t.Fatalf("end() = %q, %v", token, err)
+	}
+
+	// A gap in the sequence numbers is a lost pulse, even if the bit count
+	// and parity happen to fit
+	for i := 25; i >= 0; i-- {
+		seqno := uint32(200 + 25 - i)
+		if i < 10 {
+			seqno++
+		}
+		f.add(frame>>i&1 == 1, seqno)
+	}
+	if _, err := f.end(); !errors.Is(err, errWiegandLost) {
+		t.Fatalf("end() with lost event = %v", err)
+	}
+
+	// The next frame starts over
+	for i := 25; i >= 0; i-- {
+		f.add(frame>>i&1 == 1, uint32(300+25-i))
+	}
+	if token, err := f.end(); err != nil || token != "C0FFEE" {
+		t.Fatalf("end() after lost frame = %q, %v", token, err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package rules maps events to site specific actions, like calling a webhook
+// for unknown tokens swiped at night, as configured by the operators
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+	"github.com/craftamap/wishbone/internal/events"
+	"github.com/craftamap/wishbone/internal/feedback"
+	"github.com/craftamap/wishbone/internal/store"
+)
+
+// Kinds of events rules can be triggered by
+var Kinds = map[string]bool{
+	"unlock":        true,
+	"lock":          true,
+	"denied":        true,
+	"unknown_token": true,
+	"failure":       true,
+	"alert":         true,
+}
+
+// Verbs of the actions
+const (
+	// Webhook posts the event to the URL given, signed with the secret
+	// given after it, if any
+	Webhook = "webhook"
+	// Feedback signals the outcome given on the feedback outputs of the
+	// door, instead of the usual one for swipes
+	Feedback = "feedback"
+	// Alert publishes the message given as an alert, e.g. to Telegram
+	Alert = "alert"
+	// Log logs the message given as a warning
+	Log = "log"
+)
+
+// Kind returns the kind of e, or "" if rules can't be triggered by it
+func Kind(e events.Event) string {
+	switch {
+	case e.Type == events.TypeState && e.Status == actuator.StatusFailure.String():
+		return "failure"
+	case e.Type == events.TypeAlert:
+		return "alert"
+	case e.Type != events.TypeAccess:
+		return ""
+	case e.Access.Result == events.ResultDenied:
+		return "denied"
+	case e.Access.Result == events.ResultUnknown:
+		return "unknown_token"
+	case e.Access.Result == events.ResultFailure:
+		return "failure"
+	case e.Access.Result == events.ResultGranted && e.Access.Action == "close":
+		return "lock"
+	case e.Access.Result == events.ResultGranted:
+		return "unlock"
+	}
+	return ""
+}
+
+// Config is a rule as written in the config file. All but on and do are
+// optional; lists are comma separated
+type Config struct {
+	Name string `yaml:"name"`
+	// On are the kinds of events triggering the rule
+	On      string `yaml:"on"`
+	Doors   string `yaml:"doors"`
+	Readers string `yaml:"readers"`
+	// Sources are the sources of access attempts, like rfid or mqtt
+	Sources string `yaml:"sources"`
+	// Between is a schedule like "daily 22:00-06:00"
+	Between string `yaml:"between"`
+	// Do are the actions, each a verb followed by its argument, like
+	// "feedback error"
+	Do []string `yaml:"do"`
+}
+
+// Action is a verb and its argument
+type Action struct {
+	Verb string
+	Arg  string
+}
+
+// Rule runs its actions for the events it matches
+type Rule struct {
+	Name string
+	// Kinds, Doors, Readers and Sources the events must have; empty sets
+	// match any
+	Kinds   map[string]bool
+	Doors   map[string]bool
+	Readers map[string]bool
+	Sources map[string]bool
+	// Between is when the events must happen; empty for any time
+	Between store.Schedule
+	Actions []Action
+}
+
+// Parse returns the rules of configs. Rules are named by their position if
+// they have no name
+func Parse(configs []Config) ([]Rule, error) {
+	var rules []Rule
+	for i, c := range configs {
+		r := Rule{Name: c.Name}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if err := r.parse(c); err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (r *Rule) parse(c Config) error {
+	r.Kinds, r.Doors, r.Readers, r.Sources = set(c.On), set(c.Doors), set(c.Readers), set(c.Sources)
+	if len(r.Kinds) == 0 {
+		return fmt.Errorf("on must not be empty")
+	}
+	for kind := range r.Kinds {
+		if !Kinds[kind] {
+			return fmt.Errorf("unknown event %q", kind)
+		}
+	}
+	var err error
+	if r.Between, err = store.ParseSchedule(c.Between); err != nil {
+		return fmt.Errorf("between: %w", err)
+	}
+	if len(c.Do) == 0 {
+		return fmt.Errorf("do must not be empty")
+	}
+	for _, text := range c.Do {
+		verb, arg, _ := strings.Cut(strings.TrimSpace(text), " ")
+		a := Action{Verb: strings.ToLower(verb), Arg: strings.TrimSpace(arg)}
+		switch a.Verb {
+		case Webhook, Alert, Log:
+			if a.Arg == "" {
+				return fmt.Errorf("%s requires an argument", a.Verb)
+			}
+		case Feedback:
+			if _, err := feedback.ParseOutcome(a.Arg); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown action %q, expected webhook, feedback, alert or log", a.Verb)
+		}
+		// Alerts of a rule would trigger it again
+		if a.Verb == Alert && r.Kinds["alert"] {
+			return fmt.Errorf("rules on alerts can't raise alerts")
+		}
+		r.Actions = append(r.Actions, a)
+	}
+	return nil
+}
+
+// set returns the items of a comma separated list
+func set(list string) map[string]bool {
+	s := map[string]bool{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			s[item] = true
+		}
+	}
+	return s
+}
+
+// Matches reports whether r is triggered by e
+func (r Rule) Matches(e events.Event) bool {
+	if !r.Kinds[Kind(e)] || !r.Between.Allows(e.Time) {
+		return false
+	}
+	door, reader, source := e.Door, "", ""
+	if e.Access != nil {
+		door, reader, source = e.Access.Door, e.Access.Reader, e.Access.Source
+	}
+	return matches(r.Doors, door) && matches(r.Readers, reader) && matches(r.Sources, source)
+}
+
+func matches(s map[string]bool, value string) bool {
+	return len(s) == 0 || s[value]
+}
+
+// Signals reports whether r gives feedback, replacing the usual signal
+func (r Rule) Signals() bool {
+	for _, a := range r.Actions {
+		if a.Verb == Feedback {
+			return true
+		}
+	}
+	return false
+}
+
+// Matching returns the rules of rules triggered by e
+func Matching(rules []Rule, e events.Event) []Rule {
+	var matching []Rule
+	for _, r := range rules {
+		if r.Matches(e) {
+			matching = append(matching, r)
+		}
+	}
+	return matching
+}
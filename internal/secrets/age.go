@@ -0,0 +1,42 @@
+package secrets
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ageIntro starts the header of files encrypted with age
+const ageIntro = "age-encryption.org/v1\n"
+
+// isAge reports whether data was encrypted with age
+// (https://age-encryption.org/v1), armored or not, so secrets files can be
+// encrypted with the age tool or with sops
+func isAge(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageIntro)) || isArmored(data)
+}
+
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header))
+}
+
+// decryptAge decrypts data with one of ids
+func decryptAge(data []byte, ids []age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if isArmored(data) {
+		r = armor.NewReader(r)
+	}
+	plain, err := age.Decrypt(r, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(plain)
+}
+
+// parseIdentities parses an age identity file, as written by age-keygen
+func parseIdentities(text string) ([]age.Identity, error) {
+	return age.ParseIdentities(strings.NewReader(text))
+}
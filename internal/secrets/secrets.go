@@ -0,0 +1,123 @@
+// Package secrets resolves references to secrets in the configuration, so
+// tokens and passwords needn't be written into it in plaintext
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefixes of the values referring to secrets instead of being one
+const (
+	envPrefix    = "env:"
+	secretPrefix = "secret:"
+)
+
+// sopsTimeout limits decrypting a file with sops, which may ask a key server
+const sopsTimeout = 30 * time.Second
+
+// Secrets are the named secrets of a secrets file. A nil Secrets has none,
+// but environment variables can still be referenced
+type Secrets map[string]string
+
+// Load reads the secrets file at path: a YAML mapping of names to values,
+// either in plaintext, encrypted with age for one of the identities in the
+// file at identity, or encrypted with sops, which is run to decrypt it
+func Load(path, identity string) (Secrets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isAge(data) {
+		if identity == "" {
+			return nil, errors.New("file is encrypted with age, but no identity is given")
+		}
+		text, err := os.ReadFile(identity)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := parseIdentities(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", identity, err)
+		}
+		if data, err = decryptAge(data, ids); err != nil {
+			return nil, err
+		}
+	} else if isSops(data) {
+		if data, err = decryptSops(path, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	var s Secrets
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("expected a mapping of names to values: %w", err)
+	}
+	return s, nil
+}
+
+// isSops reports whether data is a YAML or JSON file encrypted by sops,
+// which keeps its metadata under the sops key
+func isSops(data []byte) bool {
+	var doc map[string]interface{}
+	if yaml.Unmarshal(data, &doc) != nil {
+		return false
+	}
+	_, ok := doc["sops"]
+	return ok
+}
+
+// decryptSops runs sops to decrypt the file at path. An age identity is
+// passed on to it
+func decryptSops(path, identity string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sopsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sops", "--decrypt", "--output-type", "yaml", path)
+	if identity != "" {
+		cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+identity)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sops: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("sops: %w", err)
+	}
+	return out, nil
+}
+
+// Resolve returns the secret value refers to: env:NAME for the environment
+// variable NAME, or secret:NAME for the secret NAME of s. Other values are
+// returned as they are. Secrets referred to must not be empty
+func (s Secrets) Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, envPrefix):
+		name := strings.TrimPrefix(value, envPrefix)
+		v := os.Getenv(name)
+		if v == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, secretPrefix):
+		name := strings.TrimPrefix(value, secretPrefix)
+		if s == nil {
+			return "", fmt.Errorf("secret %s referred to without a secrets file", name)
+		}
+		v := s[name]
+		if v == "" {
+			return "", fmt.Errorf("secret %s is not in the secrets file", name)
+		}
+		return v, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// appTokenPrefix starts every app token, so they can be told apart from the
+// tokens of cards and admins
+const appTokenPrefix = "wba_"
+
+var ErrUnknownAppToken = errors.New("unknown app token")
+
+// AppToken is a long-lived token a member unlocks with remotely, e.g. from
+// their phone. It unlocks on behalf of the member as long as they have a
+// credential that may unlock
+type AppToken struct {
+	// ID is part of the token and names it for revoking
+	ID   string `json:"id"`
+	User string `json:"user"`
+	// Name describes the device, like "Alice's phone"
+	Name     string     `json:"name,omitempty"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// storedAppToken is an app token as saved, with a salted hash of its
+// secret
+type storedAppToken struct {
+	AppToken
+	Hash string `json:"hash"`
+}
+
+// AppTokens holds the app tokens, saved to a file. A nil *AppTokens knows no
+// token
+type AppTokens struct {
+	path string
+
+	mu     sync.Mutex
+	tokens map[string]storedAppToken
+}
+
+// OpenAppTokens returns the app tokens saved at path, which is created by
+// the first token issued
+func OpenAppTokens(path string) (*AppTokens, error) {
+	a := &AppTokens{path: path, tokens: map[string]storedAppToken{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []storedAppToken
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		a.tokens[t.ID] = t
+	}
+	return a, nil
+}
+
+// save writes the tokens atomically. It is called with a.mu held
+func (a *AppTokens) save() error {
+	list := []storedAppToken{}
+	for _, t := range a.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Issue returns a new app token for user, and its entry. Only a hash of the
+// token is kept, so it can't be shown again
+func (a *AppTokens) Issue(user, name string, now time.Time) (string, AppToken, error) {
+	id, err := randomHex(4)
+	if err != nil {
+		return "", AppToken{}, err
+	}
+	secret, err := randomHex(16)
+	if err != nil {
+		return "", AppToken{}, err
+	}
+	hash, err := NewTokenHash(secret)
+	if err != nil {
+		return "", AppToken{}, err
+	}
+	t := storedAppToken{AppToken: AppToken{ID: id, User: user, Name: name, Created: now}, Hash: hash}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.tokens[id]; ok {
+		return "", AppToken{}, errors.New("app token ID already taken, try again")
+	}
+	a.tokens[id] = t
+	if err := a.save(); err != nil {
+		delete(a.tokens, id)
+		return "", AppToken{}, err
+	}
+	return appTokenPrefix + id + "_" + secret, t.AppToken, nil
+}
+
+// IsAppToken reports whether token looks like an app token
+func IsAppToken(token string) bool {
+	return strings.HasPrefix(token, appTokenPrefix)
+}
+
+// Lookup returns the entry of token, noting it was used at now
+func (a *AppTokens) Lookup(token string, now time.Time) (AppToken, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, appTokenPrefix), "_")
+	if a == nil || !IsAppToken(token) || !ok {
+		return AppToken{}, ErrUnknownAppToken
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.tokens[id]
+	if !ok || !MatchTokenHash(t.Hash, secret) {
+		return AppToken{}, ErrUnknownAppToken
+	}
+	t.LastUsed = &now
+	a.tokens[id] = t
+	// Only the last use would be lost
+	a.save()
+	return t.AppToken, nil
+}
+
+// List returns the app tokens of user, or all if it is empty, oldest first
+func (a *AppTokens) List(user string) []AppToken {
+	list := []AppToken{}
+	if a == nil {
+		return list
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range a.tokens {
+		if user == "" || t.User == user {
+			list = append(list, t.AppToken)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Revoke removes the app token with the ID id, returning it
+func (a *AppTokens) Revoke(id string) (AppToken, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.tokens[id]
+	if !ok {
+		return AppToken{}, ErrUnknownAppToken
+	}
+	delete(a.tokens, id)
+	if err := a.save(); err != nil {
+		a.tokens[id] = t
+		return AppToken{}, err
+	}
+	return t.AppToken, nil
+}
+
+// LookupUser returns a credential of user that may unlock at now, for
+// unlocking on their behalf without their token. If none may, the error of
+// one of them is returned, ErrUnknownToken if user has none
+func LookupUser(s CredentialStore, user string, now time.Time) (Credential, error) {
+	creds, err := s.List()
+	if err != nil {
+		return Credential{}, err
+	}
+	var found Credential
+	err = ErrUnknownToken
+	for _, c := range creds {
+		if c.User != user {
+			continue
+		}
+		found = c
+		if c.Disabled {
+			err = ErrDisabled
+			continue
+		}
+		// Listed credentials don't all come with their schedule parsed
+		if c.schedule, err = ParseSchedule(c.Schedule); err != nil {
+			continue
+		}
+		if err = c.check(now); err == nil {
+			return c, nil
+		}
+	}
+	return found, err
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// CodeDigits is the length of one-time codes
+const CodeDigits = 8
+
+// MaxCodesPerInviter limits the codes a member can have active at once, so
+// the codes can't be guessed by issuing lots of them
+const MaxCodesPerInviter = 10
+
+var (
+	ErrUnknownCode  = errors.New("unknown or expired code")
+	ErrTooManyCodes = fmt.Errorf("at most %d codes may be active per member", MaxCodesPerInviter)
+)
+
+// Code is a one-time code a member handed out, e.g. to a courier or guest.
+// It unlocks on behalf of the member, regardless of their schedule
+type Code struct {
+	Code    string    `json:"code"`
+	Inviter string    `json:"inviter"`
+	Door    string    `json:"door"`
+	Expires time.Time `json:"expires"`
+	// Uses is how often the code can still be used
+	Uses int `json:"uses"`
+}
+
+// Codes holds the active one-time codes. They are only kept in memory, so
+// a restart revokes them
+type Codes struct {
+	mu    sync.Mutex
+	codes map[string]*Code
+}
+
+func NewCodes() *Codes {
+	return &Codes{codes: map[string]*Code{}}
+}
+
+// prune drops expired codes. The caller must hold mu
+func (c *Codes) prune(now time.Time) {
+	for k, code := range c.codes {
+		if !now.Before(code.Expires) {
+			delete(c.codes, k)
+		}
+	}
+}
+
+// Issue returns a new random code for inviter, valid uses times on door
+// until expires
+func (c *Codes) Issue(inviter, door string, uses int, expires time.Time) (Code, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(time.Now())
+
+	active := 0
+	for _, code := range c.codes {
+		if code.Inviter == inviter {
+			active++
+		}
+	}
+	if active >= MaxCodesPerInviter {
+		return Code{}, ErrTooManyCodes
+	}
+
+	max := big.NewInt(1)
+	for i := 0; i < CodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	for {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return Code{}, err
+		}
+		text := fmt.Sprintf("%0*d", CodeDigits, n)
+		if _, taken := c.codes[text]; taken {
+			continue
+		}
+		code := &Code{Code: text, Inviter: inviter, Door: door, Expires: expires, Uses: uses}
+		c.codes[text] = code
+		return *code, nil
+	}
+}
+
+// Lookup returns the active code text without using it up
+func (c *Codes) Lookup(text string, now time.Time) (Code, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+
+	code, ok := c.codes[text]
+	if !ok {
+		return Code{}, ErrUnknownCode
+	}
+	return *code, nil
+}
+
+// Redeem uses up one use of code on door, or on the door the code was
+// issued for if door is empty
+func (c *Codes) Redeem(text, door string, now time.Time) (Code, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+
+	code, ok := c.codes[text]
+	if !ok || door != "" && door != code.Door {
+		return Code{}, ErrUnknownCode
+	}
+	code.Uses--
+	if code.Uses <= 0 {
+		delete(c.codes, text)
+	}
+	return *code, nil
+}
@@ -0,0 +1,289 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+	_ "modernc.org/sqlite"
+)
+
+// dbMigrations are applied in order; PRAGMA user_version holds the number
+// of migrations already applied
+var dbMigrations = []string{`
+CREATE TABLE users (
+	id      INTEGER PRIMARY KEY,
+	name    TEXT NOT NULL UNIQUE,
+	enabled INTEGER NOT NULL DEFAULT 1
+);
+CREATE TABLE tokens (
+	token       TEXT PRIMARY KEY,
+	user_id     INTEGER NOT NULL REFERENCES users(id),
+	valid_from  INTEGER,
+	valid_until INTEGER,
+	enabled     INTEGER NOT NULL DEFAULT 1
+);
+`, `
+ALTER TABLE tokens ADD COLUMN schedule TEXT NOT NULL DEFAULT '';
+`, `
+ALTER TABLE tokens ADD COLUMN pin TEXT NOT NULL DEFAULT '';
+`, `
+ALTER TABLE users ADD COLUMN group_names TEXT NOT NULL DEFAULT '';
+`}
+
+// DB is a SQLite backed credential store. Validity windows are stored as
+// unix timestamps; NULL means unbounded. Schedules use the same syntax as
+// list.txt. Groups belong to users and are stored comma separated
+type DB struct {
+	db *sql.DB
+	// Clock is the real clock if nil
+	Clock clock.Clock
+}
+
+// OpenDB opens the database at path, creating or upgrading the schema if
+// necessary. A newly created database is populated once from the user list
+// at listPath
+func OpenDB(path, listPath string) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &DB{db: db}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version < len(dbMigrations) {
+		if err := c.migrate(version, listPath); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *DB) migrate(version int, listPath string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, migration := range dbMigrations[version:] {
+		if _, err := tx.Exec(migration); err != nil {
+			return err
+		}
+	}
+
+	if version == 0 {
+		if err := importUserList(tx, listPath); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(dbMigrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func importUserList(tx *sql.Tx, path string) error {
+	listUsers, err := parseUserList(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, c := range listUsers.All() {
+		if err := insertCredential(tx, c); err != nil {
+			return err
+		}
+	}
+	if listUsers.Len() > 0 {
+		slog.Info("Migrated tokens", "tokens", listUsers.Len(), "path", path)
+	}
+	return nil
+}
+
+func (c *DB) Count() (int, error) {
+	var n int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM tokens").Scan(&n)
+	return n, err
+}
+
+func (c *DB) Check() error {
+	_, err := c.Count()
+	return err
+}
+
+// storedToken returns how token is stored in the database, which is the
+// token in some form, one of the salted hashes or, if given as stored, the
+// token itself. Like with list.txt, all tokens are compared, so the time
+// taken doesn't depend on whether or where token is found
+func (c *DB) storedToken(ctx context.Context, token string) (string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT token FROM tokens")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	variants := tokenVariants(token)
+	found := ""
+	for rows.Next() {
+		var stored string
+		if err := rows.Scan(&stored); err != nil {
+			return "", err
+		}
+		if (stored == token || matchToken(stored, variants)) && found == "" {
+			found = stored
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", ErrUnknownToken
+	}
+	return found, nil
+}
+
+func (c *DB) Lookup(ctx context.Context, token string) (Credential, error) {
+	var cred Credential
+	stored, err := c.storedToken(ctx, token)
+	if err != nil {
+		return cred, err
+	}
+
+	var (
+		userEnabled, enabled  bool
+		validFrom, validUntil sql.NullInt64
+		groups                string
+	)
+	err = c.db.QueryRowContext(ctx, `
+		SELECT t.token, u.name, u.enabled, t.enabled, t.valid_from, t.valid_until, t.schedule, t.pin, u.group_names
+		FROM tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token = ?`, stored).Scan(&cred.Token, &cred.User, &userEnabled, &enabled, &validFrom, &validUntil, &cred.Schedule, &cred.PIN, &groups)
+	if err != nil {
+		return cred, err
+	}
+	if cred.Groups, err = ParseGroups(groups); err != nil {
+		return cred, err
+	}
+	if validUntil.Valid {
+		cred.Expires = time.Unix(validUntil.Int64, 0)
+	}
+	cred.Disabled = !userEnabled || !enabled
+
+	sched, err := ParseSchedule(cred.Schedule)
+	if err != nil {
+		return cred, err
+	}
+
+	now := clock.Or(c.Clock).Now()
+	switch {
+	case cred.Disabled:
+		return cred, ErrDisabled
+	case validFrom.Valid && now.Unix() < validFrom.Int64:
+		return cred, ErrNotYetValid
+	case validUntil.Valid && now.Unix() >= validUntil.Int64:
+		return cred, ErrExpired
+	case !sched.Allows(now):
+		return cred, ErrOutsideSchedule
+	}
+	return cred, nil
+}
+
+// Watch only waits for done, as lookups query the database and see changes
+// made by other processes right away
+func (c *DB) Watch(done <-chan struct{}, changed func()) {
+	<-done
+}
+
+func (c *DB) List() ([]Credential, error) {
+	rows, err := c.db.Query(`
+		SELECT t.token, u.name, t.schedule, t.valid_until, u.enabled AND t.enabled, t.pin, u.group_names
+		FROM tokens t JOIN users u ON u.id = t.user_id
+		ORDER BY u.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []Credential
+	for rows.Next() {
+		var (
+			cred       Credential
+			validUntil sql.NullInt64
+			enabled    bool
+			groups     string
+		)
+		if err := rows.Scan(&cred.Token, &cred.User, &cred.Schedule, &validUntil, &enabled, &cred.PIN, &groups); err != nil {
+			return nil, err
+		}
+		cred.Groups, _ = ParseGroups(groups)
+		if validUntil.Valid {
+			cred.Expires = time.Unix(validUntil.Int64, 0)
+		}
+		cred.Disabled = !enabled
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
+}
+
+func (c *DB) Add(cred Credential) error {
+	if _, err := c.storedToken(context.Background(), cred.Token); err != ErrUnknownToken {
+		if err == nil {
+			return ErrTokenExists
+		}
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertCredential(tx, cred); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertCredential adds a token, and its user if they don't exist yet. The
+// groups of an existing user are only replaced if c has any. A disabled c
+// disables the token, not the user
+func insertCredential(tx *sql.Tx, c Credential) error {
+	if _, err := tx.Exec("INSERT OR IGNORE INTO users (name) VALUES (?)", c.User); err != nil {
+		return err
+	}
+	if len(c.Groups) > 0 {
+		if _, err := tx.Exec("UPDATE users SET group_names = ? WHERE name = ?", strings.Join(c.Groups, ","), c.User); err != nil {
+			return err
+		}
+	}
+	var validUntil sql.NullInt64
+	if !c.Expires.IsZero() {
+		validUntil = sql.NullInt64{Int64: c.Expires.Unix(), Valid: true}
+	}
+	_, err := tx.Exec(`
+		INSERT INTO tokens (token, user_id, valid_until, schedule, pin, enabled)
+		SELECT ?, id, ?, ?, ?, ? FROM users WHERE name = ?`, c.Token, validUntil, c.Schedule, c.PIN, !c.Disabled, c.User)
+	return err
+}
+
+func (c *DB) Remove(token string) error {
+	stored, err := c.storedToken(context.Background(), token)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("DELETE FROM tokens WHERE token = ?", stored)
+	return err
+}
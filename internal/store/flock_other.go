@@ -0,0 +1,9 @@
+//go:build !unix
+// +build !unix
+
+package store
+
+// lockFile is a no-op where flock isn't available
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
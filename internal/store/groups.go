@@ -0,0 +1,61 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Actions that can be restricted to groups
+const (
+	ActionUnlock   = "unlock"
+	ActionLock     = "lock"
+	ActionKeepOpen = "keep-open"
+	ActionAdmin    = "admin"
+)
+
+// ErrNotPermitted is returned for a valid credential whose groups don't
+// allow an action
+var ErrNotPermitted = errors.New("not permitted")
+
+// ParseGroups parses a comma separated list of group names. Empty names are
+// skipped
+func ParseGroups(text string) ([]string, error) {
+	var groups []string
+	for _, g := range strings.Split(text, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		if strings.ContainsAny(g, " \t\r\n;") {
+			return nil, fmt.Errorf("invalid group %q", g)
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// InGroup reports whether c belongs to one of groups
+func (c Credential) InGroup(groups []string) bool {
+	for _, g := range groups {
+		for _, own := range c.Groups {
+			if g == own {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Permissions restricts actions to the members of some groups. Actions that
+// aren't restricted are allowed to every valid credential
+type Permissions map[string][]string
+
+// Check returns ErrNotPermitted unless c may perform action
+func (p Permissions) Check(c Credential, action string) error {
+	groups := p[action]
+	if len(groups) == 0 || c.InGroup(groups) {
+		return nil
+	}
+	return ErrNotPermitted
+}
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+// maxUsersSize limits the user lists fetched, so a misbehaving server can't
+// fill the memory
+const maxUsersSize = 10 << 20
+
+// errNotFetched is returned by lookups before the credentials were fetched
+// for the first time
+var errNotFetched = errors.New("credentials not fetched yet")
+
+// HTTPConfig configures where an HTTP store fetches the credentials
+type HTTPConfig struct {
+	// URL is the base URL of the admin API, e.g. https://door.example.org
+	URL string
+	// Token is the admin token sent as bearer token
+	Token string
+	// Interval is how often Watch fetches the credentials
+	Interval time.Duration
+	// Timeout limits each request
+	Timeout time.Duration
+}
+
+// HTTP is a CredentialStore kept on another wishbone, which is asked through
+// its admin API. The credentials are fetched periodically and looked up
+// locally, so swipes don't wait for the server and still work while it
+// can't be reached
+type HTTP struct {
+	cfg    HTTPConfig
+	client *http.Client
+	// Clock is the real clock if nil
+	Clock clock.Clock
+
+	mu      sync.Mutex
+	users   *userList
+	body    []byte
+	lastErr error
+}
+
+// httpUser is a credential as the admin API exchanges it
+type httpUser struct {
+	Token    string   `json:"token"`
+	User     string   `json:"user"`
+	Schedule string   `json:"schedule,omitempty"`
+	Expires  string   `json:"expires,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+	PIN      string   `json:"pin,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// NewHTTP returns a store asking the admin API described by cfg. It holds no
+// credentials until Refresh succeeds
+func NewHTTP(cfg HTTPConfig) (*HTTP, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid store URL %q", cfg.URL)
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("store token must be set")
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &HTTP{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+// call sends a request with body encoded as JSON, unless it is nil, and
+// returns the response for the status codes in ok
+func (s *HTTP) call(method, path string, body interface{}, ok ...int) (*http.Response, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, s.cfg.URL+path, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	switch resp.StatusCode {
+	case http.StatusConflict:
+		return nil, ErrTokenExists
+	case http.StatusNotFound:
+		if method == http.MethodDelete {
+			return nil, ErrUnknownToken
+		}
+	case http.StatusMethodNotAllowed:
+		return nil, ErrReadOnly
+	}
+	return nil, err
+}
+
+// Refresh fetches the credentials, reporting whether they changed. If that
+// fails, the previous ones stay in use
+func (s *HTTP) Refresh() (bool, error) {
+	changed, err := s.fetch()
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+	return changed, err
+}
+
+func (s *HTTP) fetch() (bool, error) {
+	resp, err := s.call(http.MethodGet, "/api/v1/users", nil, http.StatusOK)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUsersSize+1))
+	if err != nil {
+		return false, err
+	}
+	if len(body) > maxUsersSize {
+		return false, errors.New("user list too large")
+	}
+	s.mu.Lock()
+	unchanged := s.users != nil && bytes.Equal(body, s.body)
+	s.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var list []httpUser
+	if err := json.Unmarshal(body, &list); err != nil {
+		return false, err
+	}
+	users := &userList{}
+	for _, u := range list {
+		c := Credential{Token: u.Token, User: u.User, Schedule: u.Schedule, Disabled: u.Disabled, PIN: u.PIN, Groups: u.Groups}
+		if u.Expires != "" {
+			if c.Expires, err = time.ParseInLocation("2006-01-02", u.Expires, time.Local); err != nil {
+				return false, fmt.Errorf("user %s: invalid expiry date %q", u.User, u.Expires)
+			}
+		}
+		if c.schedule, err = ParseSchedule(c.Schedule); err != nil {
+			return false, fmt.Errorf("user %s: %w", u.User, err)
+		}
+		users.creds = append(users.creds, c)
+	}
+	s.mu.Lock()
+	s.users, s.body = users, body
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Watch fetches the credentials every interval until done is closed
+func (s *HTTP) Watch(done <-chan struct{}, changed func()) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		ok, err := s.Refresh()
+		if err != nil {
+			slog.Error("Could not fetch credentials", "url", s.cfg.URL, "err", err)
+			continue
+		}
+		if ok {
+			changed()
+		}
+	}
+}
+
+// Check returns the error of the last fetch, if it failed
+func (s *HTTP) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Len returns the number of credentials fetched last
+func (s *HTTP) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		return 0
+	}
+	return s.users.Len()
+}
+
+// Lookup doesn't block, as the credentials were fetched before, so it
+// ignores ctx
+func (s *HTTP) Lookup(ctx context.Context, token string) (Credential, error) {
+	s.mu.Lock()
+	users := s.users
+	s.mu.Unlock()
+	if users == nil {
+		return Credential{}, errNotFetched
+	}
+	c, ok := users.Lookup(token)
+	switch {
+	case !ok:
+		return c, ErrUnknownToken
+	case c.Disabled:
+		return c, ErrDisabled
+	}
+	return c, c.check(clock.Or(s.Clock).Now())
+}
+
+// List fetches the credentials, so edits made elsewhere are listed
+func (s *HTTP) List() ([]Credential, error) {
+	if _, err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users.All(), nil
+}
+
+// Add adds c on the server. Its token and PIN are sent as stored, so they
+// stay hashed
+func (s *HTTP) Add(c Credential) error {
+	u := httpUser{Token: c.Token, User: c.User, Schedule: c.Schedule, Disabled: c.Disabled, PIN: c.PIN, Groups: c.Groups}
+	if !c.Expires.IsZero() {
+		u.Expires = c.Expires.Format("2006-01-02")
+	}
+	resp, err := s.call(http.MethodPost, "/api/v1/users", u, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	_, err = s.Refresh()
+	return err
+}
+
+func (s *HTTP) Remove(token string) error {
+	resp, err := s.call(http.MethodDelete, "/api/v1/users/"+url.PathEscape(token), nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	_, err = s.Refresh()
+	return err
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrReadOnly is returned when editing a store that is managed elsewhere
+var ErrReadOnly = errors.New("credential store is read-only")
+
+// LDAPConfig configures where an LDAP store finds the tokens of members
+type LDAPConfig struct {
+	// URL is the server, e.g. ldaps://ldap.example.org
+	URL string
+	// BindDN and BindPassword authenticate the searches; anonymous if empty
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// Filter selects the entries of members, e.g. (objectClass=inetOrgPerson)
+	Filter string
+	// TokenAttr holds the tokens of an entry and UserAttr the name recorded
+	// for its swipes. ScheduleAttr optionally holds a schedule like in
+	// list.txt. GroupsAttr optionally holds the groups of a member, either
+	// as names or as DNs like cn=keyholders,ou=groups,dc=example,dc=org
+	TokenAttr    string
+	UserAttr     string
+	ScheduleAttr string
+	GroupsAttr   string
+	// CacheTTL is how long credentials found are used without asking the
+	// server again
+	CacheTTL time.Duration
+	// Timeout limits connecting to and searching the server
+	Timeout time.Duration
+	// Offline keeps the credentials found for when the server can't be
+	// reached; may be nil
+	Offline *OfflineCache
+}
+
+// LDAP is a read-only CredentialStore looking up tokens in an LDAP directory. Tokens
+// not found there are looked up in the fallback store, if any. While the
+// server can't be reached, the offline cache is tried before the fallback
+type LDAP struct {
+	cfg      LDAPConfig
+	fallback CredentialStore
+	// Clock ages the cache and checks validity and schedules; the real
+	// clock if nil
+	Clock clock.Clock
+
+	mu    sync.Mutex
+	cache map[string]ldapCacheEntry
+}
+
+type ldapCacheEntry struct {
+	cred    Credential
+	fetched time.Time
+}
+
+// NewLDAP returns a store searching the directory described by cfg. fallback
+// may be nil
+func NewLDAP(cfg LDAPConfig, fallback CredentialStore) (*LDAP, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" || cfg.TokenAttr == "" || cfg.UserAttr == "" {
+		return nil, errors.New("LDAP URL, base DN, token and user attribute must be set")
+	}
+	if cfg.Filter == "" {
+		cfg.Filter = "(objectClass=*)"
+	}
+	if _, err := ldap.CompileFilter(cfg.Filter); err != nil {
+		return nil, fmt.Errorf("invalid LDAP filter: %w", err)
+	}
+	return &LDAP{cfg: cfg, fallback: fallback, cache: map[string]ldapCacheEntry{}}, nil
+}
+
+// search runs a search for filter, returning the token, user and schedule
+// attributes of the matching entries
+func (s *LDAP) search(ctx context.Context, filter string) ([]*ldap.Entry, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	attrs := []string{s.cfg.TokenAttr, s.cfg.UserAttr}
+	if s.cfg.ScheduleAttr != "" {
+		attrs = append(attrs, s.cfg.ScheduleAttr)
+	}
+	if s.cfg.GroupsAttr != "" {
+		attrs = append(attrs, s.cfg.GroupsAttr)
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(s.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(s.cfg.Timeout/time.Second), false, filter, attrs, nil))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.Entries, nil
+}
+
+// connect dials the server and binds if configured. Dialing gives up at the
+// deadline of ctx, if it has one, and the connection is closed when ctx is
+// done, aborting the requests in flight
+func (s *LDAP) connect(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout}
+	dialer.Deadline, _ = ctx.Deadline()
+	conn, err := ldap.DialURL(s.cfg.URL, ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(s.cfg.Timeout)
+	context.AfterFunc(ctx, func() { conn.Close() })
+
+	if s.cfg.BindDN != "" {
+		if err := conn.Bind(s.cfg.BindDN, s.cfg.BindPassword); err != nil {
+			conn.Close()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Check reads the base DN. The server being unreachable is reported even
+// though the offline cache and the fallback are used meanwhile
+func (s *LDAP) Check() error {
+	conn, err := s.connect(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Search(ldap.NewSearchRequest(s.cfg.BaseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, int(s.cfg.Timeout/time.Second), false, "(objectClass=*)", []string{"1.1"}, nil))
+	return err
+}
+
+// credential returns the credential of token in entry
+func (s *LDAP) credential(entry *ldap.Entry, token string) (Credential, error) {
+	c := Credential{Token: token, User: entry.GetAttributeValue(s.cfg.UserAttr)}
+	if c.User == "" {
+		c.User = entry.DN
+	}
+	if s.cfg.ScheduleAttr != "" {
+		c.Schedule = entry.GetAttributeValue(s.cfg.ScheduleAttr)
+	}
+	if s.cfg.GroupsAttr != "" {
+		for _, g := range entry.GetAttributeValues(s.cfg.GroupsAttr) {
+			c.Groups = append(c.Groups, groupName(g))
+		}
+	}
+	var err error
+	if c.schedule, err = ParseSchedule(c.Schedule); err != nil {
+		return c, fmt.Errorf("%s: %w", entry.DN, err)
+	}
+	return c, nil
+}
+
+// groupName returns the value of the first RDN of a group given as a DN,
+// e.g. keyholders for cn=keyholders,ou=groups,dc=example,dc=org
+func groupName(value string) string {
+	dn, err := ldap.ParseDN(value)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return value
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+// Lookup gives up searching the directory when ctx is done, falling back to
+// the offline cache and the fallback store like when the server can't be
+// reached
+func (s *LDAP) Lookup(ctx context.Context, token string) (Credential, error) {
+	now := clock.Or(s.Clock).Now()
+	key := NormalizeToken(token)
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && now.Sub(cached.fetched) < s.cfg.CacheTTL {
+		return cached.cred, cached.cred.check(now)
+	}
+
+	// The directory may hold the token in any of its forms
+	var alternatives strings.Builder
+	for _, v := range tokenVariants(token) {
+		fmt.Fprintf(&alternatives, "(%s=%s)", s.cfg.TokenAttr, ldap.EscapeFilter(v))
+	}
+	filter := fmt.Sprintf("(&%s(|%s))", s.cfg.Filter, alternatives.String())
+	entries, err := s.search(ctx, filter)
+	if err != nil {
+		if c, ok := s.cfg.Offline.Lookup(token, now); ok {
+			slog.Warn("Could not search LDAP, using offline cache", "err", err)
+			return c, c.check(now)
+		}
+	}
+	switch {
+	case err != nil && s.fallback != nil:
+		slog.Warn("Could not search LDAP, using fallback", "err", err)
+		return s.fallback.Lookup(ctx, token)
+	case err != nil:
+		return Credential{}, err
+	case len(entries) == 0:
+		if err := s.cfg.Offline.Remove(token); err != nil {
+			slog.Error("Could not update offline cache", "err", err)
+		}
+		if s.fallback != nil {
+			return s.fallback.Lookup(ctx, token)
+		}
+		return Credential{}, ErrUnknownToken
+	}
+
+	c, err := s.credential(entries[0], key)
+	if err != nil {
+		return c, err
+	}
+	s.mu.Lock()
+	s.cache[key] = ldapCacheEntry{cred: c, fetched: now}
+	// Drop expired entries, so revoked tokens don't pile up
+	for t, e := range s.cache {
+		if now.Sub(e.fetched) >= s.cfg.CacheTTL {
+			delete(s.cache, t)
+		}
+	}
+	s.mu.Unlock()
+	if err := s.cfg.Offline.Put(c, now); err != nil {
+		slog.Error("Could not update offline cache", "err", err)
+	}
+	return c, c.check(now)
+}
+
+// List returns the credentials of all members, followed by those of the
+// fallback store
+func (s *LDAP) List() ([]Credential, error) {
+	entries, err := s.search(context.Background(), fmt.Sprintf("(&%s(%s=*))", s.cfg.Filter, s.cfg.TokenAttr))
+	if err != nil {
+		return nil, err
+	}
+	var creds []Credential
+	for _, entry := range entries {
+		for _, token := range entry.GetAttributeValues(s.cfg.TokenAttr) {
+			c, _ := s.credential(entry, token)
+			creds = append(creds, c)
+		}
+	}
+	if s.fallback != nil {
+		local, err := s.fallback.List()
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, local...)
+	}
+	return creds, nil
+}
+
+// Watch watches the fallback store, if any. Changes in the directory are
+// picked up as the cache expires
+func (s *LDAP) Watch(done <-chan struct{}, changed func()) {
+	if s.fallback == nil {
+		<-done
+		return
+	}
+	s.fallback.Watch(done, changed)
+}
+
+// Add fails, as members are managed in the directory
+func (s *LDAP) Add(c Credential) error {
+	return ErrReadOnly
+}
+
+// Remove fails, as members are managed in the directory
+func (s *LDAP) Remove(token string) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/craftamap/wishbone/internal/clock"
+)
+
+// userList holds the credentials read from list.txt
+type userList struct {
+	creds []Credential
+}
+
+func (l *userList) Len() int {
+	return len(l.creds)
+}
+
+// All returns all credentials, sorted by user
+func (l *userList) All() []Credential {
+	all := append([]Credential{}, l.creds...)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].User < all[j].User
+	})
+	return all
+}
+
+// Lookup compares token with every credential rather than indexing them, so
+// the time taken doesn't depend on whether or where it is found
+func (l *userList) Lookup(token string) (Credential, bool) {
+	variants := tokenVariants(token)
+	var (
+		found Credential
+		ok    bool
+	)
+	for _, c := range l.creds {
+		if matchToken(c.Token, variants) && !ok {
+			found, ok = c, true
+		}
+	}
+	return found, ok
+}
+
+func (l *userList) hasHash(stored string) bool {
+	for _, c := range l.creds {
+		if IsTokenHash(c.Token) && c.Token == stored {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUserList reads list.txt. Each line holds a token, or its salted hash
+// as printed by "wishbone hash", and the name of its user, optionally
+// followed by clauses separated by ";": time windows like
+// "weekdays 08:00-20:00", an expiry date like "expires 2027-01-01", a
+// PIN like "pin 1234", which may be hashed as well, and the user's groups
+// like "groups members,keyholders"
+func parseUserList(path string) (*userList, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &userList{}, err
+	}
+	return parseUserListData(bytes, path)
+}
+
+// parseUserListData parses the contents of a user list, naming it path in
+// errors
+func parseUserListData(data []byte, path string) (*userList, error) {
+	users := &userList{}
+	var err error
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		clauses := strings.Split(line, ";")
+		fields := strings.Fields(clauses[0])
+		if len(fields) < 2 {
+			continue
+		}
+		c := Credential{Token: fields[0], User: strings.Join(fields[1:], " ")}
+
+		var windows []string
+		for _, clause := range clauses[1:] {
+			clause = strings.TrimSpace(clause)
+			if date := strings.TrimPrefix(clause, "expires "); date != clause {
+				c.Expires, err = time.ParseInLocation("2006-01-02", strings.TrimSpace(date), time.Local)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid expiry date %q", path, i+1, date)
+				}
+			} else if pin := strings.TrimPrefix(clause, "pin "); pin != clause {
+				c.PIN = strings.TrimSpace(pin)
+				if !IsTokenHash(c.PIN) && !validPIN(c.PIN) {
+					return nil, fmt.Errorf("%s:%d: invalid PIN", path, i+1)
+				}
+			} else if groups := strings.TrimPrefix(clause, "groups "); groups != clause {
+				if c.Groups, err = ParseGroups(groups); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+				}
+			} else if clause != "" {
+				windows = append(windows, clause)
+			}
+		}
+		c.Schedule = strings.Join(windows, "; ")
+		if c.schedule, err = ParseSchedule(c.Schedule); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+
+		users.creds = append(users.creds, c)
+	}
+
+	return users, nil
+}
+
+// ListStore is a CredentialStore backed by list.txt. Edits are written to the file and
+// it is reloaded afterwards
+type ListStore struct {
+	path string
+	// Clock tells the time validity and schedules are checked at; the real
+	// clock if nil
+	Clock clock.Clock
+	// users holds the current *userList; it is swapped as a whole on
+	// reload
+	users atomic.Value
+	mu    sync.Mutex
+}
+
+// OpenList reads the user list at path
+func OpenList(path string) (*ListStore, error) {
+	s := &ListStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ListStore) list() *userList {
+	return s.users.Load().(*userList)
+}
+
+// Reload re-reads the list. If it can't be parsed, the previous one stays in
+// use
+func (s *ListStore) Reload() error {
+	l, err := parseUserList(s.path)
+	if err != nil {
+		return err
+	}
+	s.users.Store(l)
+	return nil
+}
+
+// Check reports whether the list can still be read, so it can be reloaded
+func (s *ListStore) Check() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// listWatchInterval is how often Watch looks for changes of the list
+const listWatchInterval = 2 * time.Second
+
+// Watch reloads the list whenever its file was modified, e.g. by an editor or
+// "wishbone user add". If it can't be parsed, the previous one stays in use
+func (s *ListStore) Watch(done <-chan struct{}, changed func()) {
+	last, _ := os.Stat(s.path)
+	ticker := time.NewTicker(listWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(s.path)
+		if err != nil || last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		if err := s.Reload(); err != nil {
+			slog.Error("Could not reload user list", "path", s.path, "err", err)
+			continue
+		}
+		changed()
+	}
+}
+
+func (s *ListStore) Len() int {
+	return s.list().Len()
+}
+
+// Lookup doesn't block, so it ignores ctx
+func (s *ListStore) Lookup(ctx context.Context, token string) (Credential, error) {
+	c, ok := s.list().Lookup(token)
+	if !ok {
+		return c, ErrUnknownToken
+	}
+	return c, c.check(clock.Or(s.Clock).Now())
+}
+
+func (s *ListStore) List() ([]Credential, error) {
+	return s.list().All(), nil
+}
+
+func (s *ListStore) Add(c Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	l, err := parseUserList(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, exists := l.Lookup(c.Token); exists || l.hasHash(c.Token) {
+		return ErrTokenExists
+	}
+
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// Don't append to an unterminated last line
+	prefix := ""
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			prefix = "\n"
+		}
+	}
+	_, err = fmt.Fprintf(f, "%s%s\n", prefix, c)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// Replace swaps in data as the new list, e.g. one fetched from a membership
+// system. It is parsed first, so an invalid list doesn't replace a valid
+// one, and written atomically
+func (s *ListStore) Replace(data []byte) error {
+	l, err := parseUserListData(data, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.users.Store(l)
+	return nil
+}
+
+func (s *ListStore) Remove(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	l, err := parseUserList(s.path)
+	if err != nil {
+		return err
+	}
+	if c, ok := l.Lookup(token); ok {
+		token = c.Token
+	}
+
+	bytes, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var kept []string
+	found := false
+	for _, line := range strings.Split(string(bytes), "\n") {
+		fields := strings.Fields(strings.Split(line, ";")[0])
+		if len(fields) > 1 && fields[0] == token {
+			found = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !found {
+		return ErrUnknownToken
+	}
+
+	// Replace the list atomically, so a crash can't leave it half written
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(kept, "\n")), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	return s.Reload()
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"crypto/subtle"
+	"strings"
+)
+
+// NormalizeToken returns the canonical form of a card UID, as reader
+// firmwares render the same card differently: hex digits in upper case,
+// without separators like ":" and without leading zero bytes. Tokens that
+// aren't hex are only trimmed
+func NormalizeToken(token string) string {
+	digits, ok := hexDigits(token)
+	if !ok {
+		return strings.TrimSpace(token)
+	}
+	return trimZeroBytes(digits)
+}
+
+// hexDigits returns token in upper case without separators, padded to whole
+// bytes, if it is hex
+func hexDigits(token string) (string, bool) {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(token) {
+		switch {
+		case r == ':' || r == '-' || r == ' ':
+		case r >= '0' && r <= '9' || r >= 'A' && r <= 'F':
+			b.WriteRune(r)
+		case r >= 'a' && r <= 'f':
+			b.WriteRune(r - 'a' + 'A')
+		default:
+			return "", false
+		}
+	}
+	digits := b.String()
+	if digits == "" {
+		return "", false
+	}
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	return digits, true
+}
+
+// trimZeroBytes drops leading zero bytes, keeping at least one
+func trimZeroBytes(digits string) string {
+	for len(digits) > 2 && digits[:2] == "00" {
+		digits = digits[2:]
+	}
+	return digits
+}
+
+// reverseBytes reverses the byte order of hex digits of whole bytes
+func reverseBytes(digits string) string {
+	reversed := make([]byte, 0, len(digits))
+	for i := len(digits) - 2; i >= 0; i -= 2 {
+		reversed = append(reversed, digits[i], digits[i+1])
+	}
+	return string(reversed)
+}
+
+// tokenVariants returns the forms a presented token may be stored in: as
+// presented, normalized, and normalized with the byte order reversed, for
+// readers sending UIDs least significant byte first
+func tokenVariants(token string) []string {
+	variants := []string{strings.TrimSpace(token)}
+	digits, ok := hexDigits(token)
+	if !ok {
+		return variants
+	}
+	variants = append(variants, trimZeroBytes(digits))
+	if len(digits) > 2 {
+		variants = append(variants, trimZeroBytes(reverseBytes(digits)))
+	}
+	return variants
+}
+
+// matchToken reports whether a stored token, plain or hashed, matches any of
+// the variants of a presented token. Plain tokens are compared in their
+// normalized form and in constant time, and all variants are tried, so
+// neither leaks how much of a token matched
+func matchToken(stored string, variants []string) bool {
+	match := 0
+	if IsTokenHash(stored) {
+		for _, v := range variants {
+			if MatchTokenHash(stored, v) {
+				match = 1
+			}
+		}
+		return match == 1
+	}
+	stored = NormalizeToken(stored)
+	for _, v := range variants {
+		match |= subtle.ConstantTimeCompare([]byte(stored), []byte(NormalizeToken(v)))
+	}
+	return match == 1
+}
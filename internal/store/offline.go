@@ -0,0 +1,203 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// offlineRefresh is how often the validation time of an unchanged credential
+// is written to disk, to spare the SD card
+const offlineRefresh = time.Hour
+
+// OfflineCache keeps the credentials recently found in a remote store in an
+// encrypted file, so members still get in while the store can't be reached.
+// Tokens are only kept as keyed hashes. The methods are no-ops on a nil cache
+type OfflineCache struct {
+	path   string
+	aead   cipher.AEAD
+	macKey []byte
+	// maxAge is how long a credential is used after it was last validated
+	// by the remote store
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	entries map[string]offlineEntry
+}
+
+// offlineEntry is a cached credential, keyed by the hash of its token
+type offlineEntry struct {
+	User      string    `json:"user"`
+	Schedule  string    `json:"schedule,omitempty"`
+	Expires   time.Time `json:"expires,omitempty"`
+	PIN       string    `json:"pin,omitempty"`
+	Groups    []string  `json:"groups,omitempty"`
+	Validated time.Time `json:"validated"`
+}
+
+// OpenOfflineCache opens the cache at path, encrypted with the key in
+// keyPath: 32 random bytes, hex encoded. The file is created on the first
+// credential cached
+func OpenOfflineCache(path, keyPath string, maxAge time.Duration) (*OfflineCache, error) {
+	text, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s: expected 32 hex encoded bytes", keyPath)
+	}
+	// Separate keys for encrypting the file and hashing the tokens
+	block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &OfflineCache{
+		path:    path,
+		aead:    aead,
+		macKey:  deriveKey(key, "token"),
+		maxAge:  maxAge,
+		entries: map[string]offlineEntry{},
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("wishbone offline cache " + purpose))
+	return mac.Sum(nil)
+}
+
+func (c *OfflineCache) id(token string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(NormalizeToken(token)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *OfflineCache) load() error {
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n := c.aead.NonceSize()
+	if len(data) < n {
+		return errors.New("offline cache truncated")
+	}
+	plain, err := c.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return errors.New("could not decrypt offline cache, wrong key?")
+	}
+	return json.Unmarshal(plain, &c.entries)
+}
+
+// save writes the cache to a temporary file first, so a crash doesn't leave
+// a corrupted one. The caller must hold mu
+func (c *OfflineCache) save() error {
+	plain, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := c.aead.Seal(nonce, nonce, plain, nil)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), ".offline-cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Lookup returns the cached credential of token, unless it wasn't validated
+// within the maximum age
+func (c *OfflineCache) Lookup(token string, now time.Time) (Credential, bool) {
+	if c == nil {
+		return Credential{}, false
+	}
+	c.mu.Lock()
+	e, ok := c.entries[c.id(token)]
+	c.mu.Unlock()
+	if !ok || now.Sub(e.Validated) >= c.maxAge {
+		return Credential{}, false
+	}
+	cred := Credential{Token: token, User: e.User, Schedule: e.Schedule, Expires: e.Expires, PIN: e.PIN, Groups: e.Groups}
+	var err error
+	if cred.schedule, err = ParseSchedule(e.Schedule); err != nil {
+		return Credential{}, false
+	}
+	return cred, true
+}
+
+// Put caches cred as validated by the remote store at now
+func (c *OfflineCache) Put(cred Credential, now time.Time) error {
+	if c == nil {
+		return nil
+	}
+	e := offlineEntry{User: cred.User, Schedule: cred.Schedule, Expires: cred.Expires, PIN: cred.PIN, Groups: cred.Groups, Validated: now}
+	id := c.id(cred.Token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.entries[id]
+	if ok && now.Sub(old.Validated) < offlineRefresh &&
+		old.User == e.User && old.Schedule == e.Schedule && old.Expires.Equal(e.Expires) && old.PIN == e.PIN &&
+		strings.Join(old.Groups, ",") == strings.Join(e.Groups, ",") {
+		return nil
+	}
+	c.entries[id] = e
+	// Drop expired entries, so revoked tokens don't pile up
+	for id, e := range c.entries {
+		if now.Sub(e.Validated) >= c.maxAge {
+			delete(c.entries, id)
+		}
+	}
+	return c.save()
+}
+
+// Remove drops token, e.g. when the remote store no longer knows it
+func (c *OfflineCache) Remove(token string) error {
+	if c == nil {
+		return nil
+	}
+	id := c.id(token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[id]; !ok {
+		return nil
+	}
+	delete(c.entries, id)
+	return c.save()
+}
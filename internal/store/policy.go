@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/craftamap/wishbone/internal/actuator"
+)
+
+// Fail policies, deciding who may unlock while it isn't known whether the
+// sphincter works
+const (
+	// FailOpen lets every valid credential unlock
+	FailOpen = "allow"
+	// FailKeyholders only lets the members of the keyholder groups unlock
+	FailKeyholders = "keyholders"
+	// FailSecure denies all unlocks
+	FailSecure = "deny"
+)
+
+// ErrDoorDegraded is returned for unlocks denied by the fail policy
+var ErrDoorDegraded = errors.New("door status is UNKNOWN or FAILURE")
+
+// FailPolicy restricts unlocking while the status of a door is UNKNOWN or
+// FAILURE. The zero value lets everyone unlock
+type FailPolicy struct {
+	Mode string
+	// Keyholders are the groups that may unlock with FailKeyholders
+	Keyholders []string
+}
+
+// ParseFailPolicy parses a policy of allow, keyholders or deny, with the
+// comma separated keyholder groups
+func ParseFailPolicy(mode, keyholders string) (FailPolicy, error) {
+	p := FailPolicy{Mode: mode}
+	switch mode {
+	case FailOpen, FailSecure:
+	case FailKeyholders:
+		groups, err := ParseGroups(keyholders)
+		if err != nil {
+			return p, err
+		}
+		if len(groups) == 0 {
+			return p, errors.New("keyholders policy requires keyholder groups")
+		}
+		p.Keyholders = groups
+	default:
+		return p, fmt.Errorf("invalid policy %q, expected allow, keyholders or deny", mode)
+	}
+	return p, nil
+}
+
+// Check returns ErrDoorDegraded unless c may unlock a door reporting status
+func (p FailPolicy) Check(c Credential, status actuator.Status) error {
+	if status != actuator.StatusUnknown && status != actuator.StatusFailure {
+		return nil
+	}
+	switch {
+	case p.Mode == FailSecure, p.Mode == FailKeyholders && !c.InGroup(p.Keyholders):
+		return ErrDoorDegraded
+	}
+	return nil
+}
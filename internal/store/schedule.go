@@ -0,0 +1,129 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// TimeWindow allows access on some weekdays between two times of the day,
+// given in minutes after midnight. If to is before from, the window lasts
+// until the next day
+type TimeWindow struct {
+	days     [7]bool
+	from, to int
+}
+
+// Allows reports whether t lies within the window
+func (w TimeWindow) Allows(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.to > w.from {
+		return w.days[day] && minute >= w.from && minute < w.to
+	}
+	if minute >= w.from {
+		return w.days[day]
+	}
+	// Past midnight of a window that started the day before
+	return minute < w.to && w.days[(day+6)%7]
+}
+
+// Schedule is a list of windows that allow access; an empty schedule allows
+// access at any time
+type Schedule []TimeWindow
+
+func (s Schedule) Allows(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, w := range s {
+		if w.Allows(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTimeWindow parses windows like "weekdays 08:00-20:00",
+// "mon,wed 18:00-23:00" or "sat-sun 10:00-02:00"
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	var w TimeWindow
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return w, fmt.Errorf("invalid time window %q", s)
+	}
+
+	switch days := strings.ToLower(fields[0]); days {
+	case "daily":
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	case "weekdays":
+		w.days = [7]bool{false, true, true, true, true, true, false}
+	case "weekends":
+		w.days = [7]bool{true, false, false, false, false, false, true}
+	default:
+		for _, part := range strings.Split(days, ",") {
+			bounds := strings.SplitN(part, "-", 2)
+			first, ok := weekdayNames[bounds[0]]
+			last := first
+			if ok && len(bounds) == 2 {
+				last, ok = weekdayNames[bounds[1]]
+			}
+			if !ok {
+				return w, fmt.Errorf("invalid days %q", part)
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	}
+
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid time range %q", fields[1])
+	}
+	var err error
+	if w.from, err = parseTimeOfDay(times[0]); err != nil {
+		return w, err
+	}
+	if w.to, err = parseTimeOfDay(times[1]); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseSchedule parses windows separated by ";"
+func ParseSchedule(s string) (Schedule, error) {
+	var sched Schedule
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		w, err := ParseTimeWindow(part)
+		if err != nil {
+			return nil, err
+		}
+		sched = append(sched, w)
+	}
+	return sched, nil
+}
@@ -0,0 +1,163 @@
+// Package store holds the credentials allowed to unlock the sphincter, in a
+// plain text list, in SQLite, in an LDAP directory or on another wishbone
+// reached over HTTP
+package store
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned by a credential lookup. Everything except ErrUnknownToken
+// means the token is known but currently not allowed to unlock
+var (
+	ErrUnknownToken    = errors.New("unknown token")
+	ErrDisabled        = errors.New("token disabled")
+	ErrNotYetValid     = errors.New("token not yet valid")
+	ErrExpired         = errors.New("token expired")
+	ErrOutsideSchedule = errors.New("outside of access schedule")
+	// ErrWrongPIN is returned when the second factor of a token is wrong
+	ErrWrongPIN = errors.New("wrong PIN")
+)
+
+var ErrTokenExists = errors.New("token already exists")
+
+// CredentialStore is where the credentials allowed to unlock are kept. The
+// unlock decision only relies on this interface, so a new backend just has
+// to implement it
+type CredentialStore interface {
+	// Lookup returns the credential of token, and an error if the token may
+	// not unlock right now. The credential is also returned for most errors.
+	// Stores asking a server give up when ctx is done
+	Lookup(ctx context.Context, token string) (Credential, error)
+	List() ([]Credential, error)
+	Add(c Credential) error
+	// Remove accepts a token or how it is stored, e.g. its hash
+	Remove(token string) error
+	// Check returns an error if the store can't be read, e.g. for health
+	// checks
+	Check() error
+	// Watch picks up changes made to the backend elsewhere, e.g. by editing
+	// a file, until done is closed, calling changed after each. Stores
+	// reading the backend on every lookup just wait
+	Watch(done <-chan struct{}, changed func())
+}
+
+// Credential is a token's entry in a store
+type Credential struct {
+	// Token is the token as stored, either in plain text or as a salted hash
+	Token string
+	User  string
+	// Schedule is the unparsed schedule, as stored in the database
+	Schedule string
+	// Expires is the start of the day access ends; zero if it never does
+	Expires time.Time
+	// Disabled is only used by the database
+	Disabled bool
+	// PIN has to be entered after swiping the token, unless it is empty.
+	// Like Token, it may be stored as a salted hash
+	PIN string
+	// Groups the user belongs to, e.g. "keyholders", which actions can be
+	// restricted to
+	Groups []string
+
+	schedule Schedule
+}
+
+// NewCredential validates the parts of a credential, so it can be written to
+// list.txt. The token is normalized
+func NewCredential(token, user, scheduleText string, expires time.Time) (Credential, error) {
+	c := Credential{Token: NormalizeToken(token), User: strings.Join(strings.Fields(user), " "), Schedule: scheduleText, Expires: expires}
+	if token == "" || strings.ContainsAny(token, " \t\r\n;") {
+		return c, fmt.Errorf("invalid token %q", token)
+	}
+	if c.User == "" || strings.Contains(c.User, ";") {
+		return c, fmt.Errorf("invalid user %q", user)
+	}
+	if strings.ContainsAny(scheduleText, "\r\n") {
+		return c, fmt.Errorf("invalid schedule %q", scheduleText)
+	}
+	var err error
+	c.schedule, err = ParseSchedule(scheduleText)
+	return c, err
+}
+
+// String formats c as a line of list.txt
+func (c Credential) String() string {
+	line := c.Token + " " + c.User
+	if c.Schedule != "" {
+		line += "; " + c.Schedule
+	}
+	if !c.Expires.IsZero() {
+		line += "; expires " + c.Expires.Format("2006-01-02")
+	}
+	if c.PIN != "" {
+		line += "; pin " + c.PIN
+	}
+	if len(c.Groups) > 0 {
+		line += "; groups " + strings.Join(c.Groups, ",")
+	}
+	return line
+}
+
+// CheckPIN reports whether pin is the PIN of c
+func (c Credential) CheckPIN(pin string) bool {
+	if IsTokenHash(c.PIN) {
+		return MatchTokenHash(c.PIN, pin)
+	}
+	return subtle.ConstantTimeCompare([]byte(c.PIN), []byte(pin)) == 1
+}
+
+// NewPINHash validates pin and hashes it for storing it in Credential.PIN
+func NewPINHash(pin string) (string, error) {
+	if !validPIN(pin) {
+		return "", fmt.Errorf("invalid PIN, expected %d to %d digits", minPINLength, maxPINLength)
+	}
+	return NewTokenHash(pin)
+}
+
+// Lengths of PINs, which are entered on a keypad
+const (
+	minPINLength = 4
+	maxPINLength = 12
+)
+
+func validPIN(pin string) bool {
+	if len(pin) < minPINLength || len(pin) > maxPINLength {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Valid returns why c, as listed by a store, may not unlock at now, or nil
+// if it may. Unlike the credentials of Lookup, listed ones don't all come
+// with their schedule parsed
+func (c Credential) Valid(now time.Time) error {
+	if c.Disabled {
+		return ErrDisabled
+	}
+	var err error
+	if c.schedule, err = ParseSchedule(c.Schedule); err != nil {
+		return err
+	}
+	return c.check(now)
+}
+
+func (c Credential) check(now time.Time) error {
+	if !c.Expires.IsZero() && !now.Before(c.Expires) {
+		return ErrExpired
+	}
+	if !c.schedule.Allows(now) {
+		return ErrOutsideSchedule
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// Stored tokens starting with this prefix are salted hashes in the form
+// sha256$<salt>$<hash>, both hex encoded
+const tokenHashPrefix = "sha256$"
+
+// IsTokenHash reports whether a stored token is a salted hash
+func IsTokenHash(stored string) bool {
+	return strings.HasPrefix(stored, tokenHashPrefix)
+}
+
+// NewTokenHash hashes token with a random salt for storing it in the user
+// list or database
+func NewTokenHash(token string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return tokenHashPrefix + hex.EncodeToString(salt) + "$" + hex.EncodeToString(saltedHash(salt, token)), nil
+}
+
+func saltedHash(salt []byte, token string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
+
+// MatchTokenHash reports whether token hashes to stored
+func MatchTokenHash(stored, token string) bool {
+	parts := strings.Split(strings.TrimPrefix(stored, tokenHashPrefix), "$")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(saltedHash(salt, token), want) == 1
+}
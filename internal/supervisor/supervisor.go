@@ -0,0 +1,49 @@
+// Package supervisor keeps a panicking goroutine from taking down the
+// daemon, and with it access to the door
+package supervisor
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// restartDelay keeps a worker that panics right away from spinning
+const restartDelay = time.Second
+
+var crashesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "wishbone_worker_crashes_total",
+	Help: "Panics recovered by worker.",
+}, []string{"worker"})
+
+// Run calls f and recovers if it panics, logging the stack and counting the
+// crash of the worker called name. It reports whether f panicked
+func Run(name string, f func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			slog.Error("Worker panicked", "worker", name, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			crashesTotal.WithLabelValues(name).Inc()
+		}
+	}()
+	f()
+	return false
+}
+
+// Restart calls f until it returns without panicking, waiting a little
+// before each restart
+func Restart(name string, f func()) {
+	for Run(name, f) {
+		slog.Info("Restarting worker", "worker", name)
+		time.Sleep(restartDelay)
+	}
+}
+
+// Go runs Restart on a new goroutine
+func Go(name string, f func()) {
+	go Restart(name, f)
+}
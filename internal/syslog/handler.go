@@ -0,0 +1,76 @@
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Handler is a slog.Handler sending records to a Writer, formatted like the
+// text handler without the time and level, which are part of the syslog
+// header
+type Handler struct {
+	w     *Writer
+	inner slog.Handler
+	// out is shared by the handlers derived with WithAttrs and WithGroup,
+	// as they write to the same buffer
+	out *output
+}
+
+type output struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewHandler returns a handler sending the records of at least level to w
+func NewHandler(w *Writer, level slog.Leveler) *Handler {
+	out := &output{}
+	inner := slog.NewTextHandler(&out.buf, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	return &Handler{w: w, inner: inner, out: out}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	h.w.Send(Daemon, severity(r.Level), r.Time, "", strings.TrimSuffix(h.out.buf.String(), "\n"))
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{w: h.w, inner: h.inner.WithAttrs(attrs), out: h.out}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{w: h.w, inner: h.inner.WithGroup(name), out: h.out}
+}
+
+func severity(l slog.Level) Severity {
+	switch {
+	case l >= slog.LevelError:
+		return Error
+	case l >= slog.LevelWarn:
+		return Warning
+	case l >= slog.LevelInfo:
+		return Info
+	default:
+		return Debug
+	}
+}
@@ -0,0 +1,246 @@
+// Package syslog sends log lines and access events to a remote syslog
+// server in the RFC 5424 format, so they survive the SD card of the Pi
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// queueSize is how many messages are kept while the server can't be
+	// reached. Further messages are dropped, so logging never blocks
+	queueSize = 1000
+	// closeTimeout is how long Close waits for queued messages to be sent
+	closeTimeout = 5 * time.Second
+
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 1 * time.Minute
+	dialTimeout       = 10 * time.Second
+)
+
+var droppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "wishbone_syslog_dropped_total",
+	Help: "Messages for the remote syslog server dropped because the queue was full.",
+})
+
+// Facility is the syslog facility of a message
+type Facility int
+
+const (
+	Daemon   Facility = 3
+	AuthPriv Facility = 10
+)
+
+// Severity is the syslog severity of a message
+type Severity int
+
+const (
+	Error   Severity = 3
+	Warning Severity = 4
+	Notice  Severity = 5
+	Info    Severity = 6
+	Debug   Severity = 7
+)
+
+// Writer sends messages to a syslog server over UDP, TCP or TLS. Messages are
+// queued and sent in the background; on TCP and TLS the connection is
+// reestablished with exponential backoff. The methods are no-ops on a nil
+// Writer
+type Writer struct {
+	network  string
+	addr     string
+	tls      *tls.Config
+	hostname string
+	appName  string
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan []byte
+	// abort stops reconnecting once Close gave up waiting
+	abort   chan struct{}
+	stopped chan struct{}
+}
+
+// New returns a Writer for the server at rawURL, e.g. udp://logs:514 or
+// tls://logs:6514. Servers using TLS are verified against the CA in caFile,
+// or the system roots if empty. Messages are sent as appName
+func New(rawURL, caFile, appName string) (*Writer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog server %q, expected udp://, tcp:// or tls://host:port", rawURL)
+	}
+	w := &Writer{
+		network: u.Scheme,
+		addr:    u.Host,
+		appName: appName,
+		queue:   make(chan []byte, queueSize),
+		abort:   make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		w.tls = &tls.Config{ServerName: u.Hostname()}
+		if caFile != "" {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+			w.tls.RootCAs = x509.NewCertPool()
+			if !w.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", caFile)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid syslog server %q, expected udp://, tcp:// or tls://host:port", rawURL)
+	}
+	if w.hostname, err = os.Hostname(); err != nil || w.hostname == "" {
+		w.hostname = "-"
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *Writer) String() string {
+	return w.network + "://" + w.addr
+}
+
+// Send queues msg, dropping it if the queue is full
+func (w *Writer) Send(f Facility, s Severity, t time.Time, msgID, msg string) {
+	if w == nil {
+		return
+	}
+	if msgID == "" {
+		msgID = "-"
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", int(f)*8+int(s),
+		t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, w.appName, os.Getpid(), msgID, msg)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- []byte(line):
+	default:
+		droppedTotal.Inc()
+	}
+}
+
+// Close sends the queued messages, giving up after a few seconds
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	select {
+	case <-w.stopped:
+		return nil
+	case <-time.After(closeTimeout):
+		close(w.abort)
+		<-w.stopped
+		return fmt.Errorf("could not send %d messages to %v", len(w.queue), w)
+	}
+}
+
+// run sends the queued messages until the queue is closed
+func (w *Writer) run() {
+	defer close(w.stopped)
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	failures := 0
+	for line := range w.queue {
+		for {
+			var err error
+			if conn == nil {
+				conn, err = w.dial()
+			}
+			if err == nil {
+				if err = w.write(conn, line); err != nil {
+					conn.Close()
+					conn = nil
+				}
+			}
+			if err == nil {
+				if failures > 0 {
+					slog.Info("Reconnected to syslog server", "server", w.String())
+				}
+				failures = 0
+				break
+			}
+			if failures == 0 {
+				slog.Warn("Could not send to syslog server", "server", w.String(), "err", err)
+			}
+			failures++
+			if w.network == "udp" {
+				// Datagrams aren't resent
+				break
+			}
+
+			delay := maxReconnectDelay
+			if failures < 8 {
+				delay = minReconnectDelay << (failures - 1)
+				if delay > maxReconnectDelay {
+					delay = maxReconnectDelay
+				}
+			}
+			select {
+			case <-w.abort:
+				return
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+func (w *Writer) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if w.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", w.addr, w.tls)
+	}
+	return dialer.Dial(w.network, w.addr)
+}
+
+// write sends line as a datagram, or prefixed with its length on streams as
+// in RFC 6587
+func (w *Writer) write(conn net.Conn, line []byte) error {
+	if w.network != "udp" {
+		line = append([]byte(fmt.Sprintf("%d ", len(line))), line...)
+	}
+	conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	n, err := conn.Write(line)
+	if err == nil && n < len(line) {
+		err = errors.New("short write")
+	}
+	return err
+}